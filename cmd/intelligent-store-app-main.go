@@ -1,17 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/humanise"
 	"github.com/jamesrr39/goutil/logpkg"
 	"github.com/jamesrr39/goutil/patternmatcher"
 	"github.com/jamesrr39/intelligent-backup-store-app/exporters"
@@ -23,19 +28,27 @@ import (
 	"github.com/jamesrr39/intelligent-backup-store-app/uploaders/localupload"
 	"github.com/jamesrr39/intelligent-backup-store-app/uploaders/remotedownloader"
 	"github.com/jamesrr39/intelligent-backup-store-app/uploaders/webuploadclient"
+	"golang.org/x/term"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	logger        *logpkg.Logger
-	app           *kingpin.Application
-	storeLocation *string
+	logger         *logpkg.Logger
+	app            *kingpin.Application
+	storeLocation  *string
+	passphraseFile *string
+	s3Endpoint     *string
 )
 
+// passphraseEnvVarName is the environment variable the passphrase of an encrypted store can be given in, instead of typing it in
+const passphraseEnvVarName = "INTELLIGENT_STORE_PASSPHRASE"
+
 func main() {
 	logger = logpkg.NewLogger(os.Stderr, logpkg.LogLevelInfo)
 	app = kingpin.New("intelligent-store", "")
-	storeLocation = app.Flag("store-location", "location of the store").Short('C').Default(".").String()
+	storeLocation = app.Flag("store-location", "location of the store. Either a path on the local filesystem, or a location in an S3-compatible object store, like s3://bucket/prefix").Short('C').Default(".").String()
+	s3Endpoint = app.Flag("s3-endpoint", "URL of the S3-compatible server, for stores in object storage. Defaults to the AWS S3 endpoint of the region in AWS_REGION").Envar("INTELLIGENT_STORE_S3_ENDPOINT").String()
+	passphraseFile = app.Flag("passphrase-file", fmt.Sprintf("file containing the passphrase of an encrypted store. If not given, the %s environment variable is used, or the passphrase is asked for", passphraseEnvVarName)).String()
 
 	setupInitCommand()
 	setupInitBucketCommand()
@@ -49,18 +62,46 @@ func main() {
 	setupFuseMountCommand()
 	setupVerifyCommand()
 	setupStatusCommand()
+	setupGarbageCollectCommand()
+	setupSetRetentionPolicyCommand()
+	setupPruneCommand()
+	setupFsckCommand()
+	setupSetChunkingCommand()
+	setupSetCompressionCommand()
+	setupSetPackingCommand()
+	setupSetHashAlgorithmCommand()
+	setupRepackCommand()
+	setupRebuildReferenceIndexCommand()
+	setupReferencesCommand()
+	setupReplicateCommand()
+	setupChangePassphraseCommand()
+	setupLocksCommand()
+	setupUnlockCommand()
 
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 }
 
 func setupInitCommand() {
 	cmd := app.Command("init", "create a new store")
+	encrypt := cmd.Flag("encrypt", "encrypt the objects, revision manifests and bucket metadata in the store with a key protected by a passphrase").Default("False").Bool()
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.CreateIntelligentStoreAndNewConn(*storeLocation)
+		store, err := createStore()
 		if nil != err {
 			return err
 		}
 
+		if *encrypt {
+			passphrase, err := getNewPassphrase(*passphraseFile, true)
+			if nil != err {
+				return err
+			}
+
+			err = store.SetupEncryption(passphrase)
+			if nil != err {
+				return err
+			}
+		}
+
 		fmt.Printf("Created a new store at '%s'\n", store.StoreBasePath)
 		return nil
 	})
@@ -70,7 +111,7 @@ func setupInitBucketCommand() {
 	cmd := app.Command("create-bucket", "create a new bucket")
 	initBucketBucketName := cmd.Arg("bucket name", "name of the bucket").Required().String()
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return err
 		}
@@ -89,10 +130,12 @@ func setupBackupRemoteCommand() {
 	cmd := app.Command("backup-remote", "backup a new version of a remote site into the store")
 	bucketName := cmd.Arg("bucket name", "name of the bucket to back up into").Required().String()
 	configLocation := cmd.Arg("config location", "location to config file").Required().String()
+	message := cmd.Flag("message", "a message about the revision, recorded in its revision info").Short('m').String()
+	tags := cmd.Flag("tag", "a tag for the revision, recorded in its revision info. Can be given more than once").Strings()
 	runAction(cmd, func() errorsx.Error {
 		var err error
 
-		backupStore, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		backupStore, err := connectToStore()
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
@@ -124,7 +167,12 @@ func setupBackupRemoteCommand() {
 			variablesKeyValues[envKey] = val
 		}
 
-		return remotedownloader.DownloadRemote(http.DefaultClient, backupStore, bucket, conf, variablesKeyValues)
+		source := intelligentstore.RevisionSource{
+			Message: *message,
+			Tags:    *tags,
+		}
+
+		return remotedownloader.DownloadRemote(http.DefaultClient, backupStore, bucket, conf, variablesKeyValues, source)
 	})
 }
 
@@ -137,6 +185,12 @@ func setupBackupIntoCommand() {
 	includesMatcherLocation := cmd.Flag("include", "path to a file with glob-style patterns to include files").Default("").String()
 	excludesMatcherLocation := cmd.Flag("exclude", "path to a file with glob-style patterns to exclude files").Default("").String()
 	maxConcurrency := cmd.Flag("max-concurrency", "maximum amount of open files at once").Default("100").Uint()
+	noResume := cmd.Flag("no-resume", "start a new revision, instead of resuming an interrupted backup into the bucket").Default("False").Bool()
+	checksum := cmd.Flag("checksum", "hash every file again and compare it with the previous revision, instead of trusting the modification time and size").Default("False").Bool()
+	rehashOlderThan := cmd.Flag("rehash-older-than", "hash a rotating part of the files again in each backup, so that every file is hashed again at least once in this period (e.g. 720h). 0 turns it off").Default("0").Duration()
+	preserveMetadata := cmd.Flag("preserve-metadata", "record the owner, group and extended attributes (including POSIX ACLs) of the files, so that export can restore them. Only supported on Linux").Default("False").Bool()
+	message := cmd.Flag("message", "a message about the revision, recorded in its revision info").Short('m').String()
+	tags := cmd.Flag("tag", "a tag for the revision, recorded in its revision info. Can be given more than once").Strings()
 	runAction(cmd, func() errorsx.Error {
 		excludeMatcher := &patternmatcher.PatternMatcher{}
 		if *excludesMatcherLocation != "" {
@@ -178,15 +232,30 @@ func setupBackupIntoCommand() {
 			log.Println("not recording profile")
 		}
 
+		transactionOptions := dal.TransactionOptions{
+			Checksum:        *checksum,
+			RehashOlderThan: *rehashOlderThan,
+			Source: intelligentstore.RevisionSource{
+				Message: *message,
+				Tags:    *tags,
+			},
+		}
+
+		// the folder is recorded in the revision info, so it shouldn't be relative to where the backup was run from
+		absoluteFromLocation, err := filepath.Abs(*fromLocation)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+
 		var uploaderClient uploaders.Uploader
 		if strings.HasPrefix(*storeLocation, "http://") || strings.HasPrefix(*storeLocation, "https://") {
-			uploaderClient = webuploadclient.NewWebUploadClient(*storeLocation, *bucketName, *fromLocation, includeMatcher, excludeMatcher, *dryRun, *maxConcurrency)
+			uploaderClient = webuploadclient.NewWebUploadClient(*storeLocation, *bucketName, absoluteFromLocation, includeMatcher, excludeMatcher, *dryRun, *noResume, transactionOptions, *preserveMetadata, *maxConcurrency)
 		} else {
-			backupStore, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+			backupStore, err := connectToStore()
 			if nil != err {
 				return err
 			}
-			uploaderClient = localupload.NewLocalUploader(backupStore, *bucketName, *fromLocation, includeMatcher, excludeMatcher, *dryRun, *maxConcurrency)
+			uploaderClient = localupload.NewLocalUploader(backupStore, *bucketName, absoluteFromLocation, includeMatcher, excludeMatcher, *dryRun, *noResume, transactionOptions, *preserveMetadata, *maxConcurrency)
 		}
 
 		return uploaderClient.UploadToStore()
@@ -195,8 +264,10 @@ func setupBackupIntoCommand() {
 
 func setupListBucketsCommand() {
 	cmd := app.Command("list-buckets", "produce a listing of all the buckets and the last backup time")
+	showStats := cmd.Flag("stats", "also show the revision count, logical size, unique size and how much deleting the bucket would free (reads every revision if the store has no object reference index)").Default("False").Bool()
+
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return err
 		}
@@ -206,7 +277,22 @@ func setupListBucketsCommand() {
 			return err
 		}
 
-		fmt.Println("Bucket Name | Latest Revision")
+		bucketStatsByID := make(map[int]*dal.BucketStats)
+		if *showStats {
+			stats, err := store.GetStats(dal.StatsOptions{})
+			if nil != err {
+				return err
+			}
+
+			for _, bucketStats := range stats.Buckets {
+				bucketStatsByID[bucketStats.BucketID] = bucketStats
+			}
+
+			fmt.Println("Bucket Name | Latest Revision | Revisions | Logical Size | Unique Size | Freed If Deleted")
+		} else {
+			fmt.Println("Bucket Name | Latest Revision")
+		}
+
 		for _, bucket := range buckets {
 			var latestRevDisplay string
 
@@ -222,7 +308,21 @@ func setupListBucketsCommand() {
 				latestRevDisplay = time.Unix(int64(latestRevision.VersionTimestamp), 0).Format(time.ANSIC)
 			}
 
-			fmt.Printf("%s | %s\n", bucket.BucketName, latestRevDisplay)
+			bucketStats, ok := bucketStatsByID[bucket.ID]
+			if !ok {
+				fmt.Printf("%s | %s\n", bucket.BucketName, latestRevDisplay)
+				continue
+			}
+
+			fmt.Printf(
+				"%s | %s | %d | %s | %s | %s\n",
+				bucket.BucketName,
+				latestRevDisplay,
+				bucketStats.RevisionCount,
+				humanise.HumaniseBytes(bucketStats.LogicalBytes),
+				humanise.HumaniseBytes(bucketStats.UniqueBytes),
+				humanise.HumaniseBytes(bucketStats.FreedIfDeletedBytes),
+			)
 		}
 
 		return nil
@@ -232,9 +332,10 @@ func setupListBucketsCommand() {
 func setupListBucketRevisionsCommand() {
 	cmd := app.Command("list-revisions", "produce a listing of all the revisions in a bucket")
 	listBucketRevisionsBucketName := cmd.Arg("bucket name", "name of the bucket to back up into").Required().String()
+	showStats := cmd.Flag("stats", "also show the file count, logical size, size of the new contents and the cumulative unique size of each revision").Default("False").Bool()
 
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 
 		if nil != err {
 			return err
@@ -250,8 +351,44 @@ func setupListBucketRevisionsCommand() {
 			return err
 		}
 
+		// revisions committed before revision infos were recorded don't have one
+		revisionInfos := make(map[intelligentstore.RevisionVersion]*intelligentstore.RevisionInfo)
 		for _, revision := range revisions {
-			fmt.Println(time.Unix(int64(revision.VersionTimestamp), 0).Format(time.ANSIC))
+			revisionInfo, err := store.RevisionDAL.GetRevisionInfo(revision)
+			if nil != err {
+				return err
+			}
+			revisionInfos[revision.VersionTimestamp] = revisionInfo
+		}
+
+		if !*showStats {
+			for _, revision := range revisions {
+				fmt.Printf(
+					"%s | %s\n",
+					time.Unix(int64(revision.VersionTimestamp), 0).Format(time.ANSIC),
+					describeRevisionInfo(revisionInfos[revision.VersionTimestamp]),
+				)
+			}
+
+			return nil
+		}
+
+		bucketStats, err := store.GetBucketStats(bucket)
+		if nil != err {
+			return err
+		}
+
+		fmt.Println("Revision | Files | Logical Size | New | Cumulative Unique Size | Info")
+		for _, revisionStats := range bucketStats.Revisions {
+			fmt.Printf(
+				"%s | %d | %s | %s | %s | %s\n",
+				time.Unix(int64(revisionStats.RevisionVersion), 0).Format(time.ANSIC),
+				revisionStats.FileCount,
+				humanise.HumaniseBytes(revisionStats.LogicalBytes),
+				humanise.HumaniseBytes(revisionStats.NewBytes),
+				humanise.HumaniseBytes(revisionStats.CumulativeUniqueBytes),
+				describeRevisionInfo(revisionInfos[revisionStats.RevisionVersion]),
+			)
 		}
 
 		return nil
@@ -259,6 +396,36 @@ func setupListBucketRevisionsCommand() {
 	})
 }
 
+// describeRevisionInfo describes the info of a revision on one line, for listings
+func describeRevisionInfo(revisionInfo *intelligentstore.RevisionInfo) string {
+	if revisionInfo == nil {
+		return "(no revision info)"
+	}
+
+	description := fmt.Sprintf(
+		"%s from %s:%s | %d new, %d changed, %d unchanged, %d deleted | %s uploaded in %s",
+		revisionInfo.UploaderType,
+		revisionInfo.Hostname,
+		revisionInfo.SourcePath,
+		revisionInfo.NewFiles,
+		revisionInfo.ChangedFiles,
+		revisionInfo.UnchangedFiles,
+		revisionInfo.DeletedFiles,
+		humanise.HumaniseBytes(revisionInfo.BytesUploaded),
+		revisionInfo.Duration.Round(time.Second),
+	)
+
+	if len(revisionInfo.Tags) != 0 {
+		description += " | tags: " + strings.Join(revisionInfo.Tags, ", ")
+	}
+
+	if revisionInfo.Message != "" {
+		description += fmt.Sprintf(" | %q", revisionInfo.Message)
+	}
+
+	return description
+}
+
 func setupExportCommand() {
 	cmd := app.Command("export", "export files from the store to the local file system")
 	exportCommandBucketName := cmd.Arg("bucket name", "name of the bucket to export from").Required().String()
@@ -268,9 +435,10 @@ func setupExportCommand() {
 		"specify a revision version to export. If left blank, the latest revision is used. See the program's help command for information about listing revisions",
 	).Int64()
 	exportCommandFilePathPrefix := cmd.Flag("with-prefix", "prefix of files to be exported").String()
+	exportCommandFallBackToCopies := cmd.Flag("fall-back-to-copies", "export files that were hard links to the same file as separate copies, if hard links can't be created in the export folder").Bool()
 
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 
 		if nil != err {
 			return err
@@ -287,7 +455,7 @@ func setupExportCommand() {
 			prefixMatcher = patternmatcher.NewSimplePrefixMatcher(*exportCommandFilePathPrefix)
 		}
 
-		exporter := exporters.NewLocalExporter(store, *exportCommandBucketName, *exportCommandExportDir, version, prefixMatcher)
+		exporter := exporters.NewLocalExporter(store, *exportCommandBucketName, *exportCommandExportDir, version, prefixMatcher, *exportCommandFallBackToCopies)
 		err = exporter.Export()
 		if nil != err {
 			return err
@@ -303,7 +471,7 @@ func setupStartWebappCommand() {
 	runAction(cmd, func() errorsx.Error {
 		var err error
 
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
@@ -333,10 +501,12 @@ func setupStartWebappCommand() {
 
 func setupStatusCommand() {
 	cmd := app.Command("status", "get store status information")
+	withStats := cmd.Flag("stats", "include statistics of the space used by the store. They are read from every revision if the store has no object reference index, so use --no-stats to skip them on a large store without one").Default("True").Bool()
+
 	runAction(cmd, func() errorsx.Error {
 		var err error
 
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
@@ -346,7 +516,18 @@ func setupStatusCommand() {
 			return errorsx.Wrap(err)
 		}
 
-		err = json.NewEncoder(os.Stdout).Encode(status)
+		var stats *dal.StoreStats
+		if *withStats {
+			stats, err = store.GetStats(dal.StatsOptions{})
+			if nil != err {
+				return errorsx.Wrap(err)
+			}
+		}
+
+		err = json.NewEncoder(os.Stdout).Encode(struct {
+			*intelligentstore.Status
+			Stats *dal.StoreStats `json:"stats,omitempty"`
+		}{status, stats})
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
@@ -358,7 +539,12 @@ func setupStatusCommand() {
 func setupRunMigrationsCommand() {
 	cmd := app.Command(intelligentstore.RunMigrationsCommandName, "run one-off migrations")
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExistingForMigrationUpgrades(*storeLocation)
+		store, err := newStoreConn(dal.NewIntelligentStoreConnToExistingForMigrationUpgrades, &dal.StoreConnOptions{IgnoreMigrationsNotUpToDate: true})
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		err = unlockStore(store)
 		if err != nil {
 			return errorsx.Wrap(err)
 		}
@@ -371,7 +557,7 @@ func setupFuseMountCommand() {
 	cmd := app.Command("mount", "mount the store as a filesystem (experimental, only linux supported)")
 	mountOnPathLocation := cmd.Arg("mount-at", "the path to mount the filesystem at").Required().String()
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return err
 		}
@@ -388,9 +574,11 @@ func setupVerifyCommand() {
 		"revision-version",
 		"specify a revision version to export. If left blank, the latest revision is used. See the program's help command for information about listing revisions",
 	).Int64()
+	deep := cmd.Flag("deep", "decompress and re-hash every object, and check it against the hash and size recorded in the revision").Default("False").Bool()
+	sample := cmd.Flag("sample", "only verify a random sample of the files. Example: '10%'").Default("100%").String()
 
 	runAction(cmd, func() errorsx.Error {
-		store, err := dal.NewIntelligentStoreConnToExisting(*storeLocation)
+		store, err := connectToStore()
 		if nil != err {
 			return err
 		}
@@ -413,7 +601,184 @@ func setupVerifyCommand() {
 			}
 		}
 
-		return store.RevisionDAL.VerifyRevision(bucket, revision)
+		samplePercent, err := parsePercentage(*sample)
+		if err != nil {
+			return err
+		}
+
+		result, err := store.RevisionDAL.VerifyRevision(bucket, revision, dal.VerifyOptions{
+			Deep:          *deep,
+			SamplePercent: samplePercent,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, failure := range result.Failures {
+			fmt.Println(failure.String())
+		}
+
+		fmt.Printf("verified %d files. %d failures\n", result.FilesVerified, len(result.Failures))
+		if len(result.Failures) != 0 {
+			return errorsx.Errorf("verification failed for %d files", len(result.Failures))
+		}
+
+		return nil
+	})
+}
+
+func parsePercentage(value string) (float64, errorsx.Error) {
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, errorsx.Wrap(err, "value", value)
+	}
+
+	if percentage <= 0 || percentage > 100 {
+		return 0, errorsx.Errorf("percentage must be greater than 0 and at most 100, but was %q", value)
+	}
+
+	return percentage, nil
+}
+
+func setupGarbageCollectCommand() {
+	cmd := app.Command("gc", "remove objects from the store that are not referenced by any revision")
+	dryRun := cmd.Flag("dry-run", "only report the unreferenced objects and how much space would be reclaimed").Short('n').Default("False").Bool()
+	quarantine := cmd.Flag("quarantine", "move unreferenced objects into the quarantine folder instead of deleting them").Default("False").Bool()
+	outputJSON := cmd.Flag("json", "output the report as JSON").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		result, err := store.GarbageCollect(dal.GarbageCollectionOptions{
+			DryRun:     *dryRun,
+			Quarantine: *quarantine,
+		})
+		if nil != err {
+			return err
+		}
+
+		if *outputJSON {
+			return errorsx.Wrap(json.NewEncoder(os.Stdout).Encode(result))
+		}
+
+		for _, hash := range result.UnreferencedObjects {
+			fmt.Println(hash)
+		}
+		fmt.Println(result.String())
+
+		return nil
+	})
+}
+
+func setupSetRetentionPolicyCommand() {
+	cmd := app.Command("set-retention-policy", "set the rules deciding which revisions of a bucket are kept when the bucket is pruned")
+	bucketName := cmd.Arg("bucket name", "name of the bucket").Required().String()
+	keepLast := cmd.Flag("keep-last", "keep the most recent N revisions").Int()
+	keepDaily := cmd.Flag("keep-daily", "keep the latest revision of each day for the last N days").Int()
+	keepWeekly := cmd.Flag("keep-weekly", "keep the latest revision of each week for the last N weeks").Int()
+	keepMonthly := cmd.Flag("keep-monthly", "keep the latest revision of each month for the last N months").Int()
+	clearPolicy := cmd.Flag("clear", "remove the retention policy from the bucket").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		bucket, err := store.BucketDAL.GetBucketByName(*bucketName)
+		if nil != err {
+			return err
+		}
+
+		var retentionPolicy *intelligentstore.RetentionPolicy
+		if !*clearPolicy {
+			retentionPolicy = &intelligentstore.RetentionPolicy{
+				KeepLast:             *keepLast,
+				KeepDailyForDays:     *keepDaily,
+				KeepWeeklyForWeeks:   *keepWeekly,
+				KeepMonthlyForMonths: *keepMonthly,
+			}
+
+			if retentionPolicy.IsEmpty() {
+				return errorsx.Errorf("no retention rules given. Use --clear to remove the retention policy")
+			}
+		}
+
+		err = store.BucketDAL.SetRetentionPolicy(bucket, retentionPolicy)
+		if nil != err {
+			return err
+		}
+
+		if retentionPolicy == nil {
+			log.Printf("removed the retention policy from bucket %q\n", bucket.BucketName)
+		} else {
+			log.Printf("set the retention policy of bucket %q to: %s\n", bucket.BucketName, retentionPolicy)
+		}
+
+		return nil
+	})
+}
+
+func setupPruneCommand() {
+	cmd := app.Command("prune", "delete the revisions not kept by the bucket retention policies")
+	bucketName := cmd.Arg("bucket name", "name of the bucket to prune. If left blank, every bucket with a retention policy is pruned").String()
+	dryRun := cmd.Flag("dry-run", "only print the revisions that would be deleted").Short('n').Default("False").Bool()
+	runGC := cmd.Flag("gc", "after pruning, remove the objects that are no longer referenced by any revision").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		var buckets []*intelligentstore.Bucket
+		if *bucketName == "" {
+			allBuckets, err := store.BucketDAL.GetAllBuckets()
+			if nil != err {
+				return err
+			}
+
+			for _, bucket := range allBuckets {
+				if bucket.RetentionPolicy != nil {
+					buckets = append(buckets, bucket)
+				}
+			}
+		} else {
+			bucket, err := store.BucketDAL.GetBucketByName(*bucketName)
+			if nil != err {
+				return err
+			}
+			buckets = append(buckets, bucket)
+		}
+
+		for _, bucket := range buckets {
+			result, err := store.PruneBucket(bucket, dal.PruneOptions{DryRun: *dryRun})
+			if nil != err {
+				return err
+			}
+
+			for _, revision := range result.Pruned {
+				fmt.Printf("%s | prune | %d | %s\n", bucket.BucketName, revision.VersionTimestamp, time.Unix(int64(revision.VersionTimestamp), 0).Format(time.ANSIC))
+			}
+
+			fmt.Printf("%s: keeping %d revisions, pruning %d revisions\n", bucket.BucketName, len(result.Kept), len(result.Pruned))
+		}
+
+		if *dryRun || !*runGC {
+			return nil
+		}
+
+		gcResult, err := store.GarbageCollect(dal.GarbageCollectionOptions{})
+		if nil != err {
+			return err
+		}
+
+		fmt.Println(gcResult.String())
+
+		return nil
 	})
 }
 
@@ -458,3 +823,567 @@ func runAction(cmd *kingpin.CmdClause, fn func() errorsx.Error) {
 		return nil
 	})
 }
+
+func setupFsckCommand() {
+	cmd := app.Command("fsck", "check the whole store (metadata, revision manifests, objects, temp files and locks). Prints a JSON report and exits non-zero if there are problems")
+	repair := cmd.Flag("repair", "fix the problems that are safe to fix: remove stale locks and temp store leftovers, and quarantine empty or corrupt objects").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := newStoreConn(dal.NewIntelligentStoreConnToExistingForFsck, &dal.StoreConnOptions{KeepTempStoreContents: true})
+		if nil != err {
+			return err
+		}
+
+		err = unlockStore(store)
+		if nil != err {
+			return err
+		}
+
+		report, err := store.Fsck(dal.FsckOptions{
+			Repair: *repair,
+		})
+		if nil != err {
+			return err
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = errorsx.Wrap(encoder.Encode(report))
+		if nil != err {
+			return err
+		}
+
+		if report.HasUnrepairedProblems() {
+			return errorsx.Errorf("fsck found %d problem(s)", len(report.Problems))
+		}
+
+		return nil
+	})
+}
+
+func setupSetChunkingCommand() {
+	cmd := app.Command("set-chunking", "store the contents of large files as content-defined chunks, so that slightly-changed large files are deduplicated. Only affects new backups")
+	minFileSize := cmd.Flag("min-file-size", "files of this size or bigger are stored as chunks, e.g. 16MiB").Default("16MiB").Bytes()
+	disable := cmd.Flag("disable", "stop storing new file contents as chunks. Contents already stored as chunks can still be read").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		var settings *intelligentstore.ChunkingSettings
+		if !*disable {
+			settings = &intelligentstore.ChunkingSettings{
+				MinFileSize: int64(*minFileSize),
+			}
+		}
+
+		return store.SetChunkingSettings(settings)
+	})
+}
+
+func setupSetCompressionCommand() {
+	cmd := app.Command("set-compression", "set how new objects are compressed. Objects that don't get smaller when compressed are stored uncompressed. Only affects new backups")
+	encoding := cmd.Flag("encoding", "the encoding new objects are written with").Default(string(intelligentstore.ObjectEncodingGzip)).Enum(
+		string(intelligentstore.ObjectEncodingGzip),
+		string(intelligentstore.ObjectEncodingZstd),
+		string(intelligentstore.ObjectEncodingNone),
+	)
+	level := cmd.Flag("level", "compression level (gzip: 1-9, zstd: 1-22). 0 uses the encoding's default level").Default("0").Int()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		return store.SetCompressionSettings(&intelligentstore.CompressionSettings{
+			Encoding: intelligentstore.ObjectEncoding(*encoding),
+			Level:    *level,
+		})
+	})
+}
+
+func setupSetPackingCommand() {
+	cmd := app.Command("set-packing", "append the objects of small files into larger pack files, instead of storing each as it's own file. Cuts the number of files (and inodes) the store uses. Only affects new backups")
+	maxObjectSize := cmd.Flag("max-object-size", fmt.Sprintf("files of this size or smaller are packed, e.g. 64KiB. At most %d bytes", intelligentstore.MaxPackedObjectSize)).Default("64KiB").Bytes()
+	disable := cmd.Flag("disable", "stop packing new objects. Objects already packed can still be read").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		var settings *intelligentstore.PackingSettings
+		if !*disable {
+			settings = &intelligentstore.PackingSettings{
+				MaxObjectSize: int64(*maxObjectSize),
+			}
+		}
+
+		return store.SetPackingSettings(settings)
+	})
+}
+
+func setupSetHashAlgorithmCommand() {
+	cmd := app.Command("set-hash-algorithm", "set the algorithm new contents are hashed with. Contents already stored keep their hashes and can still be read, but are not deduplicated against contents hashed with another algorithm. Only affects new backups")
+	var hashAlgorithmNames []string
+	for _, hashAlgorithm := range intelligentstore.HashAlgorithms() {
+		hashAlgorithmNames = append(hashAlgorithmNames, string(hashAlgorithm))
+	}
+	hashAlgorithm := cmd.Arg("algorithm", "the algorithm new contents are hashed with").Required().Enum(hashAlgorithmNames...)
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		return store.SetHashAlgorithm(intelligentstore.HashAlgorithm(*hashAlgorithm))
+	})
+}
+
+func setupRepackCommand() {
+	cmd := app.Command("repack", "rewrite packs that have many unreferenced objects (for example after pruning), and merge small packs, so that the space is reclaimed")
+	dryRun := cmd.Flag("dry-run", "only report what would be repacked").Short('n').Default("False").Bool()
+	minUnreferenced := cmd.Flag("min-unreferenced", "rewrite packs where at least this percentage of the bytes are unreferenced objects").Default("25%").String()
+	outputJSON := cmd.Flag("json", "output the report as JSON").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		minUnreferencedPercent, err := parsePercentage(*minUnreferenced)
+		if nil != err {
+			return err
+		}
+
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		result, err := store.Repack(dal.RepackOptions{
+			DryRun:                 *dryRun,
+			MinUnreferencedPercent: minUnreferencedPercent,
+		})
+		if nil != err {
+			return err
+		}
+
+		if *outputJSON {
+			return errorsx.Wrap(json.NewEncoder(os.Stdout).Encode(result))
+		}
+
+		fmt.Println(result.String())
+
+		return nil
+	})
+}
+
+func setupRebuildReferenceIndexCommand() {
+	cmd := app.Command("rebuild-reference-index", "build the index of which revisions reference which contents, by reading every revision. Needed for stores created before the index was introduced")
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		result, err := store.RebuildObjectReferenceIndex()
+		if nil != err {
+			return err
+		}
+
+		fmt.Printf("read %d revision(s); %d distinct object(s) are referenced\n", result.RevisionsRead, result.Objects)
+
+		return nil
+	})
+}
+
+func setupReferencesCommand() {
+	cmd := app.Command("references", "show where contents are used: how many files in which buckets have the contents, and the first and last revisions they were seen in")
+	hash := cmd.Arg("hash", "hash of the contents").Required().String()
+	outputJSON := cmd.Flag("json", "output the references as JSON").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		references, err := store.GetObjectReferences(intelligentstore.Hash(*hash))
+		if nil != err {
+			return err
+		}
+
+		if *outputJSON {
+			return errorsx.Wrap(json.NewEncoder(os.Stdout).Encode(references))
+		}
+
+		buckets, err := store.BucketDAL.GetAllBuckets()
+		if nil != err {
+			return err
+		}
+
+		bucketNames := make(map[int]string)
+		for _, bucket := range buckets {
+			bucketNames[bucket.ID] = bucket.BucketName
+		}
+
+		fmt.Printf("referenced %d time(s)\n", references.Count)
+		if references.Count == 0 {
+			return nil
+		}
+
+		fmt.Printf("size: %s\n", humanise.HumaniseBytes(references.Size))
+		var bucketIDs []int
+		for bucketID := range references.BucketCounts {
+			bucketIDs = append(bucketIDs, bucketID)
+		}
+		sort.Ints(bucketIDs)
+
+		for _, bucketID := range bucketIDs {
+			fmt.Printf("bucket %q: %d reference(s)\n", bucketNames[bucketID], references.BucketCounts[bucketID])
+		}
+		fmt.Printf("first seen: bucket %q, revision %s\n", bucketNames[references.FirstSeen.BucketID], references.FirstSeen.RevisionVersion)
+		fmt.Printf("last seen: bucket %q, revision %s\n", bucketNames[references.LastSeen.BucketID], references.LastSeen.RevisionVersion)
+
+		return nil
+	})
+}
+
+func setupReplicateCommand() {
+	cmd := app.Command("replicate", "copy the revisions another store doesn't have to it, keeping their versions. Only the contents it doesn't have are sent, and they are checked against their hashes first")
+	to := cmd.Flag("to", "the store to replicate to. Either a path on the local filesystem, a location in an S3-compatible object store (s3://bucket/prefix), or the URL of a store web server (http://host:port)").Required().String()
+	bucketNames := cmd.Flag("bucket", "only replicate this bucket. Can be given more than once").Strings()
+	toPassphraseFile := cmd.Flag("to-passphrase-file", "file containing the passphrase of the store to replicate to, if it is encrypted. If not given, the passphrase is asked for").String()
+	outputJSON := cmd.Flag("json", "output the result as JSON").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		destination, err := connectToReplicationDestination(*to, *toPassphraseFile)
+		if nil != err {
+			return err
+		}
+
+		result, err := store.Replicate(destination, dal.ReplicateOptions{BucketNames: *bucketNames})
+		if nil != err {
+			return err
+		}
+
+		if *outputJSON {
+			return errorsx.Wrap(json.NewEncoder(os.Stdout).Encode(result))
+		}
+
+		for _, bucketName := range result.BucketsCreated {
+			fmt.Printf("created bucket %q\n", bucketName)
+		}
+		fmt.Printf(
+			"replicated %d revision(s) (%d already there), sending %d object(s) (%s)\n",
+			result.RevisionsReplicated,
+			result.RevisionsSkipped,
+			result.ObjectsTransferred,
+			humanise.HumaniseBytes(result.BytesTransferred),
+		)
+
+		return nil
+	})
+}
+
+// connectToReplicationDestination connects to the store at the location given, to replicate to it
+func connectToReplicationDestination(location, passphraseFilePath string) (dal.ReplicationDestination, errorsx.Error) {
+	if isWebLocation(location) {
+		return webuploadclient.NewWebReplicationDestination(strings.TrimSuffix(location, "/")), nil
+	}
+
+	store, err := newStoreConnAt(location, dal.NewIntelligentStoreConnToExisting, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	if store.IsEncrypted() {
+		var passphrase string
+		if passphraseFilePath != "" {
+			passphrase, err = readPassphraseFile(passphraseFilePath)
+		} else {
+			passphrase, err = askForPassphrase("Passphrase of the store to replicate to: ")
+		}
+		if nil != err {
+			return nil, err
+		}
+
+		err = store.Unlock(passphrase)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	return dal.NewStoreReplicationDestination(store), nil
+}
+
+func setupChangePassphraseCommand() {
+	cmd := app.Command("change-passphrase", "change the passphrase of an encrypted store. None of the data in the store is rewritten")
+	newPassphraseFile := cmd.Flag("new-passphrase-file", "file containing the new passphrase. If not given, the new passphrase is asked for").String()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := newStoreConn(dal.NewIntelligentStoreConnToExisting, nil)
+		if nil != err {
+			return err
+		}
+
+		if !store.IsEncrypted() {
+			return errorsx.Errorf("the store is not encrypted")
+		}
+
+		oldPassphrase, err := getPassphrase("Current passphrase: ")
+		if nil != err {
+			return err
+		}
+
+		newPassphrase, err := getNewPassphrase(*newPassphraseFile, false)
+		if nil != err {
+			return err
+		}
+
+		err = store.ChangePassphrase(oldPassphrase, newPassphrase)
+		if nil != err {
+			return err
+		}
+
+		log.Println("changed passphrase")
+		return nil
+	})
+}
+
+// connectToStore connects to the store at the store location, and unlocks it if it is encrypted
+func connectToStore() (*dal.IntelligentStoreDAL, errorsx.Error) {
+	store, err := newStoreConn(dal.NewIntelligentStoreConnToExisting, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	err = unlockStore(store)
+	if nil != err {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func unlockStore(store *dal.IntelligentStoreDAL) errorsx.Error {
+	if !store.IsEncrypted() {
+		return nil
+	}
+
+	passphrase, err := getPassphrase("Passphrase: ")
+	if nil != err {
+		return err
+	}
+
+	return store.Unlock(passphrase)
+}
+
+// getPassphrase gets the passphrase from the passphrase file, the environment, or by asking for it (in that order)
+func getPassphrase(prompt string) (string, errorsx.Error) {
+	if *passphraseFile != "" {
+		return readPassphraseFile(*passphraseFile)
+	}
+
+	passphrase, ok := os.LookupEnv(passphraseEnvVarName)
+	if ok {
+		return passphrase, nil
+	}
+
+	return askForPassphrase(prompt)
+}
+
+// getNewPassphrase gets a new passphrase from the file, or by asking for it twice.
+// If useEnv is true, the passphrase environment variable is also looked at.
+func getNewPassphrase(filePath string, useEnv bool) (string, errorsx.Error) {
+	if filePath != "" {
+		return readPassphraseFile(filePath)
+	}
+
+	if useEnv {
+		passphrase, ok := os.LookupEnv(passphraseEnvVarName)
+		if ok {
+			return passphrase, nil
+		}
+	}
+
+	passphrase, err := askForPassphrase("New passphrase: ")
+	if nil != err {
+		return "", err
+	}
+
+	confirmation, err := askForPassphrase("Repeat new passphrase: ")
+	if nil != err {
+		return "", err
+	}
+
+	if passphrase != confirmation {
+		return "", errorsx.Errorf("the passphrases did not match")
+	}
+
+	return passphrase, nil
+}
+
+func readPassphraseFile(filePath string) (string, errorsx.Error) {
+	b, err := os.ReadFile(filePath)
+	if nil != err {
+		return "", errorsx.Wrap(err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func setupLocksCommand() {
+	cmd := app.Command("locks", "list the locks held on the store, and whether they are stale (held by a process that is no longer running)")
+	outputJSON := cmd.Flag("json", "output the locks as JSON").Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		locks, err := store.LockDAL.ListLocks()
+		if nil != err {
+			return err
+		}
+
+		if *outputJSON {
+			return errorsx.Wrap(json.NewEncoder(os.Stdout).Encode(locks))
+		}
+
+		if len(locks) == 0 {
+			fmt.Println("no locks")
+			return nil
+		}
+
+		fmt.Println("Key | Type | Bucket | Host | PID | Acquired | Last Heartbeat | Stale | Text")
+		for _, lock := range locks {
+			fmt.Printf(
+				"%s | %s | %s | %s | %d | %s | %s | %t | %s\n",
+				lock.Key,
+				lock.Type,
+				lock.BucketName,
+				lock.Hostname,
+				lock.Pid,
+				lock.AcquisitionTime.Format(time.ANSIC),
+				formatHeartbeatTime(lock.HeartbeatTime),
+				lock.IsStale,
+				lock.Text,
+			)
+		}
+
+		return nil
+	})
+}
+
+func setupUnlockCommand() {
+	cmd := app.Command("unlock", "force release a lock, for example one left behind by a crashed backup. Stale locks are also released automatically when a conflicting lock is taken")
+	key := cmd.Arg("key", "key of the lock to release, as shown by the locks command").Required().String()
+	yes := cmd.Flag("yes", "release the lock without asking for confirmation").Short('y').Default("False").Bool()
+
+	runAction(cmd, func() errorsx.Error {
+		store, err := connectToStore()
+		if nil != err {
+			return err
+		}
+
+		locks, err := store.LockDAL.ListLocks()
+		if nil != err {
+			return err
+		}
+
+		var lockToRelease *dal.LockInformation
+		for _, lock := range locks {
+			if lock.Key == *key {
+				lockToRelease = lock
+				break
+			}
+		}
+
+		if lockToRelease == nil {
+			return errorsx.Wrap(dal.ErrLockNotFound, "key", *key)
+		}
+
+		if !*yes {
+			fmt.Printf(
+				"lock %q was acquired at %s by process %d on host %q (last heartbeat: %s). Lock text: %q\n",
+				lockToRelease.Key,
+				lockToRelease.AcquisitionTime.Format(time.ANSIC),
+				lockToRelease.Pid,
+				lockToRelease.Hostname,
+				formatHeartbeatTime(lockToRelease.HeartbeatTime),
+				lockToRelease.Text,
+			)
+			if !lockToRelease.IsStale {
+				fmt.Println("WARNING: the process holding the lock looks like it is still running. Releasing the lock could corrupt the store if it is")
+			}
+
+			confirmed, err := askForConfirmation("Release the lock? [y/N]: ")
+			if nil != err {
+				return err
+			}
+
+			if !confirmed {
+				return errorsx.Errorf("not releasing the lock")
+			}
+		}
+
+		err = store.LockDAL.ForceReleaseLock(lockToRelease.Key)
+		if nil != err {
+			return err
+		}
+
+		fmt.Printf("released lock %q\n", lockToRelease.Key)
+		return nil
+	})
+}
+
+// formatHeartbeatTime formats the heartbeat time of a lock. Locks taken before heartbeats were introduced don't have one.
+func formatHeartbeatTime(heartbeatTime time.Time) string {
+	if heartbeatTime.IsZero() {
+		return "none"
+	}
+
+	return heartbeatTime.Format(time.ANSIC)
+}
+
+// askForConfirmation asks a yes/no question on the terminal. Only "y" or "yes" confirm.
+func askForConfirmation(prompt string) (bool, errorsx.Error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errorsx.Errorf("confirmation is needed, but the input is not a terminal. Use --yes to go ahead without confirmation")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if nil != err {
+		return false, errorsx.Wrap(err)
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func askForPassphrase(prompt string) (string, errorsx.Error) {
+	stdinFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdinFd) {
+		return "", errorsx.Errorf("the store is encrypted, but no passphrase was given. Use --passphrase-file or the %s environment variable", passphraseEnvVarName)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(stdinFd)
+	fmt.Fprintln(os.Stderr)
+	if nil != err {
+		return "", errorsx.Wrap(err)
+	}
+
+	return string(b), nil
+}
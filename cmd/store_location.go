@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+)
+
+// isWebLocation returns true for the URL of a store web server (started with the start-webapp command), for example "http://backup-server:8080"
+func isWebLocation(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// s3LocationScheme is the scheme of store locations in an S3-compatible object store, for example "s3://my-bucket/backups"
+const s3LocationScheme = "s3://"
+
+func isS3Location(location string) bool {
+	return strings.HasPrefix(location, s3LocationScheme)
+}
+
+// newS3BackendFromLocation creates a backend for a "s3://bucket/prefix" store location.
+// The credentials and region are taken from the standard AWS environment variables.
+func newS3BackendFromLocation(location string) (*storagebackend.S3Backend, errorsx.Error) {
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "location", location)
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := *s3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	return storagebackend.NewS3Backend(storagebackend.S3Config{
+		Endpoint: endpoint,
+		Region:   region,
+		Bucket:   locationURL.Host,
+		Prefix:   locationURL.Path,
+		Credentials: storagebackend.S3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	})
+}
+
+// newStoreConn connects to the store at the store location.
+// Stores on the local filesystem are connected to with connectToLocalStore; stores in object storage with the options given.
+func newStoreConn(connectToLocalStore func(pathToBase string) (*dal.IntelligentStoreDAL, errorsx.Error), options *dal.StoreConnOptions) (*dal.IntelligentStoreDAL, errorsx.Error) {
+	return newStoreConnAt(*storeLocation, connectToLocalStore, options)
+}
+
+// newStoreConnAt connects to the store at the location given, in the same way as newStoreConn
+func newStoreConnAt(location string, connectToLocalStore func(pathToBase string) (*dal.IntelligentStoreDAL, errorsx.Error), options *dal.StoreConnOptions) (*dal.IntelligentStoreDAL, errorsx.Error) {
+	if !isS3Location(location) {
+		return connectToLocalStore(location)
+	}
+
+	backend, err := newS3BackendFromLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return dal.NewIntelligentStoreConnToBackend(backend, options)
+}
+
+// createStore creates a new store at the store location
+func createStore() (*dal.IntelligentStoreDAL, errorsx.Error) {
+	if !isS3Location(*storeLocation) {
+		return dal.CreateIntelligentStoreAndNewConn(*storeLocation)
+	}
+
+	backend, err := newS3BackendFromLocation(*storeLocation)
+	if err != nil {
+		return nil, err
+	}
+
+	return dal.CreateIntelligentStoreInBackend(backend, nil)
+}
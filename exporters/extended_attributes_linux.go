@@ -0,0 +1,8 @@
+package exporters
+
+import "golang.org/x/sys/unix"
+
+// setExtendedAttribute sets an extended attribute of a file, without following symlinks
+func setExtendedAttribute(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
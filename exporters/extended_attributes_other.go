@@ -0,0 +1,13 @@
+//go:build !linux
+
+package exporters
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setExtendedAttribute is only implemented on Linux
+func setExtendedAttribute(path, name string, value []byte) error {
+	return fmt.Errorf("setting extended attributes is not supported on %s", runtime.GOOS)
+}
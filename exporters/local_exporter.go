@@ -1,10 +1,14 @@
 package exporters
 
 import (
-	"compress/gzip"
 	"fmt"
 	"io"
+	"log"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
 
 	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/goutil/gofs"
@@ -15,29 +19,62 @@ import (
 
 const FilesExportSubDir = "files"
 
+// errSpecialFilesNotSupported is returned by makeSpecialFile on platforms where FIFOs and device files can't be created
+var errSpecialFilesNotSupported = fmt.Errorf("creating FIFOs and device files is not supported on %s", runtime.GOOS)
+
 type LocalExporter struct {
 	Store           *dal.IntelligentStoreDAL
 	BucketName      string
 	RevisionVersion *intelligentstore.RevisionVersion // nil = latest version
 	ExportDir       string
 	Matcher         patternmatcher.Matcher
-	fs              gofs.Fs
+	// FallBackToCopies exports files that were hard links to the same file as separate copies, if the hard links can't be created (for example, on filesystems without hard links)
+	FallBackToCopies bool
+	fs               gofs.Fs
+	// chtimes sets the access and modification times of an exported file or directory
+	chtimes func(name string, atime, mtime time.Time) error
+	// lchown sets the owner and group of an exported file, without following symlinks
+	lchown func(name string, uid, gid int) error
+	// lsetxattr sets an extended attribute of an exported file, without following symlinks
+	lsetxattr func(path, name string, value []byte) error
+	// link creates a hard link to an exported file
+	link func(oldname, newname string) error
+	// makeSpecialFile creates an exported FIFO or device file
+	makeSpecialFile func(path string, fileInfo *intelligentstore.FileInfo) error
 }
 
-func NewLocalExporter(store *dal.IntelligentStoreDAL, bucketName string, exportDir string, revisionVersion *intelligentstore.RevisionVersion, matcher patternmatcher.Matcher) *LocalExporter {
+func NewLocalExporter(store *dal.IntelligentStoreDAL, bucketName string, exportDir string, revisionVersion *intelligentstore.RevisionVersion, matcher patternmatcher.Matcher, fallBackToCopies bool) *LocalExporter {
 	return &LocalExporter{
-		Store:           store,
-		BucketName:      bucketName,
-		RevisionVersion: revisionVersion,
-		ExportDir:       exportDir,
-		Matcher:         matcher,
-		fs:              gofs.NewOsFs(),
+		Store:            store,
+		BucketName:       bucketName,
+		RevisionVersion:  revisionVersion,
+		ExportDir:        exportDir,
+		Matcher:          matcher,
+		FallBackToCopies: fallBackToCopies,
+		fs:               gofs.NewOsFs(),
+		chtimes:          os.Chtimes,
+		lchown:           os.Lchown,
+		lsetxattr:        setExtendedAttribute,
+		link:             os.Link,
+		makeSpecialFile:  makeSpecialFile,
 	}
 }
 
 func (exporter *LocalExporter) Export() errorsx.Error {
 	var err error
 
+	sharedLock, err := exporter.Store.LockDAL.AcquireSharedLock("lock from exporting")
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	defer func() {
+		releaseErr := exporter.Store.LockDAL.ReleaseSharedLock(sharedLock)
+		if releaseErr != nil {
+			log.Printf("failed to release shared lock after exporting. Error: %q\n", releaseErr)
+		}
+	}()
+
 	bucket, err := exporter.Store.BucketDAL.GetBucketByName(exporter.BucketName)
 	if nil != err {
 		return errorsx.Wrap(err)
@@ -64,15 +101,94 @@ func (exporter *LocalExporter) Export() errorsx.Error {
 		return errorsx.Wrap(err)
 	}
 
+	var dirDescriptors []*intelligentstore.DirectoryFileDescriptor
+	// the first exported file of each hard link group. The other files in the group are exported as hard links to it
+	hardLinkGroupFilePaths := make(map[uint64]string)
 	for _, fileInRevision := range filesInRevision {
-		if nil != exporter.Matcher && !exporter.Matcher.Matches(string(fileInRevision.GetFileInfo().RelativePath)) {
+		fileInfo := fileInRevision.GetFileInfo()
+		if nil != exporter.Matcher && !exporter.Matcher.Matches(string(fileInfo.RelativePath)) {
 			continue
 		}
 
+		if fileInfo.HardLinkGroup != 0 {
+			linkedFilePath, ok := hardLinkGroupFilePaths[fileInfo.HardLinkGroup]
+			if ok {
+				err = exporter.writeHardLink(linkedFilePath, fileInRevision)
+				if nil != err {
+					return errorsx.Wrap(err)
+				}
+
+				continue
+			}
+
+			hardLinkGroupFilePaths[fileInfo.HardLinkGroup] = filepath.Join(exporter.ExportDir, FilesExportSubDir, string(fileInfo.RelativePath))
+		}
+
 		err = exporter.writeFileToFs(fileInRevision)
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
+
+		dirDescriptor, ok := fileInRevision.(*intelligentstore.DirectoryFileDescriptor)
+		if ok {
+			dirDescriptors = append(dirDescriptors, dirDescriptor)
+		}
+	}
+
+	return exporter.setDirAttributes(dirDescriptors)
+}
+
+// setDirAttributes sets the owners, extended attributes, modes and modification times of the exported directories.
+// It is done after all the files are written, as writing a file into a directory changes its modification time, and the mode could stop files being written into it.
+// The deepest directories are done first, for the same reasons.
+func (exporter *LocalExporter) setDirAttributes(dirDescriptors []*intelligentstore.DirectoryFileDescriptor) errorsx.Error {
+	sort.Slice(dirDescriptors, func(i, j int) bool {
+		return len(dirDescriptors[i].RelativePath.Fragments()) > len(dirDescriptors[j].RelativePath.Fragments())
+	})
+
+	for _, dirDescriptor := range dirDescriptors {
+		dirPath := filepath.Join(exporter.ExportDir, FilesExportSubDir, string(dirDescriptor.RelativePath))
+
+		err := exporter.setFileAttributes(dirPath, dirDescriptor.FileInfo)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// setFileAttributes sets the owner, extended attributes, mode (with the setuid, setgid and sticky bits) and modification time of an exported file, in that order, as changing the owner clears the setuid and setgid bits.
+// The owner and extended attributes are only set if they were recorded. If they can't be set (for example, when not running as root), a warning is logged and the export carries on.
+// Symlinks only get their owner and extended attributes; their mode isn't used, and setting the mode or modification time would change the file they point to.
+func (exporter *LocalExporter) setFileAttributes(filePath string, fileInfo *intelligentstore.FileInfo) errorsx.Error {
+	if nil != fileInfo.Ownership {
+		err := exporter.lchown(filePath, int(fileInfo.Ownership.UID), int(fileInfo.Ownership.GID))
+		if nil != err {
+			log.Printf("WARNING: couldn't restore the owner and group of %q. Error: %s\n", fileInfo.RelativePath, err)
+		}
+	}
+
+	for name, value := range fileInfo.ExtendedAttributes {
+		err := exporter.lsetxattr(filePath, name, value)
+		if nil != err {
+			log.Printf("WARNING: couldn't restore the extended attribute %q of %q. Error: %s\n", name, fileInfo.RelativePath, err)
+		}
+	}
+
+	if fileInfo.Type == intelligentstore.FileTypeSymlink {
+		return nil
+	}
+
+	fileMode := fileInfo.FileMode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	err := exporter.fs.Chmod(filePath, fileMode)
+	if nil != err {
+		return errorsx.Wrap(err, "filePath", filePath, "fileMode", fileMode)
+	}
+
+	err = exporter.chtimes(filePath, fileInfo.ModTime, fileInfo.ModTime)
+	if nil != err {
+		return errorsx.Wrap(err, "filePath", filePath)
 	}
 
 	return nil
@@ -86,16 +202,24 @@ func (exporter *LocalExporter) writeFileToFs(fileDescriptor intelligentstore.Fil
 		return errorsx.Wrap(err)
 	}
 	switch fileDescriptor.GetFileInfo().Type {
+	case intelligentstore.FileTypeDir:
+		// the attributes are set once everything in the directory is written
+		err = exporter.fs.MkdirAll(filePath, 0700)
+		if nil != err {
+			return errorsx.Wrap(err, "filePath", filePath)
+		}
+
+		return nil
 	case intelligentstore.FileTypeRegular:
 		regularFileDescriptor := fileDescriptor.(*intelligentstore.RegularFileDescriptor)
 		var reader io.ReadCloser
-		reader, err = exporter.Store.GetGzippedObjectByHash(regularFileDescriptor.Hash)
+		reader, err = exporter.Store.GetObjectByHash(regularFileDescriptor.Hash)
 		if nil != err {
 			return errorsx.Wrap(err)
 		}
 		defer reader.Close()
 
-		err = exporter.createNewFileAndCopy(reader, filePath)
+		err = exporter.createNewFileAndCopy(reader, filePath, regularFileDescriptor.Holes)
 		if err != nil {
 			return errorsx.Wrap(err)
 		}
@@ -105,6 +229,26 @@ func (exporter *LocalExporter) writeFileToFs(fileDescriptor intelligentstore.Fil
 		if nil != err {
 			return errorsx.Wrap(err, "filePath", filePath)
 		}
+	case intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice:
+		err = exporter.makeSpecialFile(filePath, fileDescriptor.GetFileInfo())
+		if nil != err {
+			if os.IsPermission(err) {
+				// device files can only be created with privileges, so the export carries on without them
+				log.Printf("WARNING: couldn't create %q (%s), as it needs more privileges. Error: %s\n", fileDescriptor.GetFileInfo().RelativePath, fileDescriptor.GetFileInfo().Type, err)
+				return nil
+			}
+
+			if err == errSpecialFilesNotSupported {
+				// nor can they be created on every platform
+				log.Printf("WARNING: couldn't create %q (%s). Error: %s\n", fileDescriptor.GetFileInfo().RelativePath, fileDescriptor.GetFileInfo().Type, err)
+				return nil
+			}
+
+			return errorsx.Wrap(err, "filePath", filePath)
+		}
+	case intelligentstore.FileTypeSocket:
+		// sockets are created by the programs listening on them, so they are recorded but not restored
+		return nil
 	default:
 		return errorsx.Errorf("file type %d (%s) unsupported when writing file to disk. File descriptor: '%v'",
 			fileDescriptor.GetFileInfo().Type,
@@ -112,30 +256,116 @@ func (exporter *LocalExporter) writeFileToFs(fileDescriptor intelligentstore.Fil
 			fileDescriptor)
 	}
 
-	err = exporter.fs.Chmod(filePath, fileDescriptor.GetFileInfo().FileMode.Perm())
+	return exporter.setFileAttributes(filePath, fileDescriptor.GetFileInfo())
+}
+
+// writeHardLink exports a file as a hard link to the exported file it was hard linked to.
+// The attributes aren't set, as they are shared with the linked file.
+// If the hard link can't be created and FallBackToCopies is set, the file is exported as a separate copy instead.
+func (exporter *LocalExporter) writeHardLink(linkedFilePath string, fileDescriptor intelligentstore.FileDescriptor) errorsx.Error {
+	relativePath := fileDescriptor.GetFileInfo().RelativePath
+	filePath := filepath.Join(exporter.ExportDir, FilesExportSubDir, string(relativePath))
+	err := exporter.fs.MkdirAll(filepath.Dir(filePath), 0700)
 	if nil != err {
-		return errorsx.Wrap(err, "filePath", filePath, "perm", fileDescriptor.GetFileInfo().FileMode.Perm())
+		return errorsx.Wrap(err)
 	}
+
+	err = exporter.link(linkedFilePath, filePath)
+	if nil != err {
+		if !exporter.FallBackToCopies {
+			return errorsx.Wrap(err, "filePath", filePath, "linkedFilePath", linkedFilePath)
+		}
+
+		log.Printf("WARNING: couldn't create %q as a hard link, so it is exported as a separate copy. Error: %s\n", relativePath, err)
+		return exporter.writeFileToFs(fileDescriptor)
+	}
+
 	return nil
 }
 
-func (exporter *LocalExporter) createNewFileAndCopy(reader io.Reader, filePath string) error {
+// createNewFileAndCopy writes the contents of a file to a new file.
+// The holes of a sparse file are skipped over instead of written, so they are left as holes in the new file, on filesystems that support sparse files.
+func (exporter *LocalExporter) createNewFileAndCopy(reader io.Reader, filePath string, holes []*intelligentstore.FileRange) error {
 	newFile, err := exporter.fs.Create(filePath)
 	if nil != err {
 		return fmt.Errorf("couldn't create the export file at '%s'. Error: %s", filePath, err)
 	}
 	defer newFile.Close()
 
-	gzippedReader, err := gzip.NewReader(reader)
-	if err != nil {
-		return err
+	if len(holes) == 0 {
+		_, err = io.Copy(newFile, reader)
+	} else {
+		err = copySparse(newFile, reader, holes)
 	}
-	defer gzippedReader.Close()
-
-	_, err = io.Copy(newFile, gzippedReader)
 	if nil != err {
 		return fmt.Errorf("couldn't write the export file to '%s'. Error: %s", filePath, err)
 	}
 
 	return nil
 }
+
+// copySparse copies the contents into a new file, seeking over the holes instead of writing them.
+// The contents of the holes are still read, and written if they aren't all zeros, so the file has the same contents even if the file changed after its holes were found.
+func copySparse(file gofs.File, reader io.Reader, holes []*intelligentstore.FileRange) error {
+	var offset int64
+	buffer := make([]byte, 32*1024)
+
+	for _, hole := range holes {
+		// the data before the hole
+		n, err := io.CopyN(file, reader, hole.Offset-offset)
+		offset += n
+		if nil != err {
+			if err == io.EOF {
+				return file.Truncate(offset)
+			}
+			return err
+		}
+
+		for offset < hole.Offset+hole.Length {
+			chunk := buffer
+			if remaining := hole.Offset + hole.Length - offset; remaining < int64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+
+			bytesReadCount, readErr := io.ReadFull(reader, chunk)
+			chunk = chunk[:bytesReadCount]
+
+			if isAllZeros(chunk) {
+				_, err = file.Seek(int64(len(chunk)), io.SeekCurrent)
+			} else {
+				_, err = file.Write(chunk)
+			}
+			if nil != err {
+				return err
+			}
+			offset += int64(len(chunk))
+
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				return file.Truncate(offset)
+			}
+			if nil != readErr {
+				return readErr
+			}
+		}
+	}
+
+	// the data after the last hole
+	n, err := io.Copy(file, reader)
+	offset += n
+	if nil != err {
+		return err
+	}
+
+	// the file is extended to its full size, as it could end with a hole that was seeked over
+	return file.Truncate(offset)
+}
+
+func isAllZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,60 @@
+package exporters
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Export_sparseFile(t *testing.T) {
+	var err error
+
+	testStore := dal.NewMockStore(t, dal.MockNowProvider, mockfs.NewMockFs())
+
+	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
+
+	const size = 16 * 1024 * 1024
+	contents := make([]byte, size)
+	copy(contents[4*1024*1024:], bytes.Repeat([]byte("a"), 64*1024))
+
+	sparseFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.img", time.Unix(0, 0), dal.FileMode600, contents)
+	sparseFile.Descriptor.Holes = []*intelligentstore.FileRange{
+		intelligentstore.NewFileRange(0, 4*1024*1024),
+		intelligentstore.NewFileRange(4*1024*1024+64*1024, size-4*1024*1024-64*1024),
+	}
+
+	storetest.CreateRevision(t, testStore.Store, bucket, []*intelligentstore.RegularFileDescriptorWithContents{sparseFile})
+
+	exporter := &LocalExporter{
+		Store:      testStore.Store,
+		BucketName: "docs",
+		ExportDir:  t.TempDir(),
+		fs:         gofs.NewOsFs(),
+		chtimes:    noopChtimes,
+	}
+
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	filePath := filepath.Join(exporter.ExportDir, FilesExportSubDir, "a.img")
+	exportedContents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(contents, exportedContents))
+
+	osFileInfo, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	// the holes weren't written, so the file takes up less space than its size
+	assert.Less(t, osFileInfo.Sys().(*syscall.Stat_t).Blocks*512, int64(size))
+}
@@ -1,12 +1,16 @@
 package exporters
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/goutil/gofs/mockfs"
 	"github.com/jamesrr39/goutil/patternmatcher"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
@@ -38,6 +42,7 @@ func Test_Export(t *testing.T) {
 		RevisionVersion: nil,
 		ExportDir:       "/outDir-1",
 		fs:              testStore.Fs,
+		chtimes:         noopChtimes,
 	}
 
 	err = exporter.Export()
@@ -91,6 +96,7 @@ func Test_writeFileToFs(t *testing.T) {
 		RevisionVersion: nil,
 		ExportDir:       "/outDir",
 		fs:              testStore.Fs,
+		chtimes:         noopChtimes,
 	}
 
 	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
@@ -147,3 +153,326 @@ func Test_writeFileToFs_UnknownFile(t *testing.T) {
 	require.NotNil(t, err)
 	require.True(t, strings.HasPrefix(err.Error(), "file type 0 (UNKNOWN) unsupported when writing file to disk."))
 }
+
+func Test_Export_directories(t *testing.T) {
+	var err error
+
+	testStore := dal.NewMockStore(t, dal.MockNowProvider, mockfs.NewMockFs())
+
+	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
+
+	regularFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "folder-1/a.txt", time.Unix(0, 0), dal.FileMode600, []byte("file a contents"))
+	folderInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, "folder-1", time.Unix(1000, 0), 0, 0750)
+	emptyFolderInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, "folder-1/empty", time.Unix(2000, 0), 0, 0700)
+
+	transactionDAL := testStore.Store.TransactionDAL
+	tx, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{regularFile.Descriptor.FileInfo, folderInfo, emptyFolderInfo})
+	require.NoError(t, err)
+
+	// directories are recorded without anything being uploaded for them
+	assert.Equal(t, []intelligentstore.RelativePath{"folder-1/a.txt"}, tx.GetRelativePathsRequired())
+
+	_, err = transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, []*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash("folder-1/a.txt", regularFile.Descriptor.Hash),
+	})
+	require.NoError(t, err)
+
+	err = transactionDAL.BackupFile(tx, bytes.NewReader(regularFile.Contents))
+	require.NoError(t, err)
+
+	err = transactionDAL.Commit(tx)
+	require.NoError(t, err)
+
+	var chtimesPaths []string
+	modTimes := make(map[string]time.Time)
+	exporter := &LocalExporter{
+		Store:      testStore.Store,
+		BucketName: "docs",
+		ExportDir:  "/outDir",
+		fs:         testStore.Fs,
+		chtimes: func(name string, atime, mtime time.Time) error {
+			chtimesPaths = append(chtimesPaths, name)
+			modTimes[name] = mtime
+			return nil
+		},
+	}
+
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	folderPath := filepath.Join(exporter.ExportDir, FilesExportSubDir, "folder-1")
+	emptyFolderPath := filepath.Join(folderPath, "empty")
+
+	folderFileInfo, err := testStore.Fs.Stat(folderPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), folderFileInfo.Mode().Perm())
+
+	emptyFolderFileInfo, err := testStore.Fs.Stat(emptyFolderPath)
+	require.NoError(t, err)
+	assert.True(t, emptyFolderFileInfo.IsDir())
+	assert.Equal(t, os.FileMode(0700), emptyFolderFileInfo.Mode().Perm())
+
+	contents, err := testStore.Fs.ReadFile(filepath.Join(folderPath, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, regularFile.Contents, contents)
+
+	// the deepest directories are done first, after the files, so setting the times of a directory isn't undone by changes inside it
+	assert.Equal(t, []string{filepath.Join(folderPath, "a.txt"), emptyFolderPath, folderPath}, chtimesPaths)
+	assert.Equal(t, time.Unix(1000, 0), modTimes[folderPath])
+	assert.Equal(t, time.Unix(2000, 0), modTimes[emptyFolderPath])
+}
+
+func Test_Export_fileMetadata(t *testing.T) {
+	var err error
+
+	testStore := dal.NewMockStore(t, dal.MockNowProvider, mockfs.NewMockFs())
+
+	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
+
+	regularFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.sh", time.Unix(1000, 123456789), os.ModeSetuid|0755, []byte("file a contents"))
+	regularFile.Descriptor.Ownership = intelligentstore.NewFileOwnership(0, 50)
+	regularFile.Descriptor.ExtendedAttributes = intelligentstore.ExtendedAttributes{
+		"system.posix_acl_access": []byte{2, 0, 0, 0},
+		"user.comment":            []byte("a comment"),
+	}
+	// recorded without its owner, group or extended attributes
+	otherFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(2000, 0), dal.FileMode600, []byte("file b contents"))
+
+	storetest.CreateRevision(t, testStore.Store, bucket, []*intelligentstore.RegularFileDescriptorWithContents{regularFile, otherFile})
+
+	type ownership struct {
+		path     string
+		uid, gid int
+	}
+	var ownerships []ownership
+	extendedAttributes := make(map[string]string)
+	modTimes := make(map[string]time.Time)
+
+	exporter := &LocalExporter{
+		Store:      testStore.Store,
+		BucketName: "docs",
+		ExportDir:  "/outDir",
+		fs:         testStore.Fs,
+		chtimes: func(name string, atime, mtime time.Time) error {
+			modTimes[name] = mtime
+			return nil
+		},
+		lchown: func(name string, uid, gid int) error {
+			ownerships = append(ownerships, ownership{name, uid, gid})
+			return nil
+		},
+		lsetxattr: func(path, name string, value []byte) error {
+			if strings.HasPrefix(name, "system.") {
+				// not allowed, e.g. on a filesystem without ACLs. The export carries on
+				return errors.New("operation not supported")
+			}
+			extendedAttributes[path+":"+name] = string(value)
+			return nil
+		},
+	}
+
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	aPath := filepath.Join(exporter.ExportDir, FilesExportSubDir, "a.sh")
+	bPath := filepath.Join(exporter.ExportDir, FilesExportSubDir, "b.txt")
+
+	assert.Equal(t, []ownership{{aPath, 0, 50}}, ownerships)
+	assert.Equal(t, map[string]string{aPath + ":user.comment": "a comment"}, extendedAttributes)
+	assert.Equal(t, time.Unix(1000, 123456789), modTimes[aPath])
+	assert.Equal(t, time.Unix(2000, 0), modTimes[bPath])
+
+	aFileInfo, err := testStore.Fs.Stat(aPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, aFileInfo.Mode()&(os.ModePerm|os.ModeSetuid))
+}
+
+func noopChtimes(name string, atime, mtime time.Time) error {
+	return nil
+}
+
+func Test_Export_hardLinks(t *testing.T) {
+	var err error
+
+	testStore := dal.NewMockStore(t, dal.MockNowProvider, mockfs.NewMockFs())
+
+	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
+
+	aFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), dal.FileMode600, []byte("file a contents"))
+	aFile.Descriptor.HardLinkGroup = 1
+	bFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "folder-1/b.txt", time.Unix(0, 0), dal.FileMode600, []byte("file a contents"))
+	bFile.Descriptor.HardLinkGroup = 1
+	cFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", time.Unix(0, 0), dal.FileMode600, []byte("file a contents"))
+
+	storetest.CreateRevision(t, testStore.Store, bucket, []*intelligentstore.RegularFileDescriptorWithContents{aFile, bFile, cFile})
+
+	var links [][2]string
+	linkErr := errors.New("operation not permitted")
+	newExporter := func(exportDir string, fallBackToCopies bool, linkSucceeds bool) *LocalExporter {
+		return &LocalExporter{
+			Store:            testStore.Store,
+			BucketName:       "docs",
+			ExportDir:        exportDir,
+			FallBackToCopies: fallBackToCopies,
+			fs:               testStore.Fs,
+			chtimes:          noopChtimes,
+			link: func(oldname, newname string) error {
+				if !linkSucceeds {
+					return linkErr
+				}
+				links = append(links, [2]string{oldname, newname})
+				return nil
+			},
+		}
+	}
+
+	// b.txt is linked to a.txt. c.txt has the same contents, but wasn't hard linked, so it is written separately
+	exporter := newExporter("/outDir-1", false, true)
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	// whichever of them is exported first is linked to
+	filesDir := filepath.Join(exporter.ExportDir, FilesExportSubDir)
+	require.Len(t, links, 1)
+	assert.ElementsMatch(t, []string{filepath.Join(filesDir, "a.txt"), filepath.Join(filesDir, "folder-1", "b.txt")}, links[0][:])
+
+	_, err = testStore.Fs.Stat(filepath.Join(filesDir, "c.txt"))
+	require.NoError(t, err)
+
+	// when the hard link can't be created, the export fails
+	exporter = newExporter("/outDir-2", false, false)
+	err = exporter.Export()
+	require.Error(t, err)
+	assert.Equal(t, linkErr, errorsx.Cause(err))
+
+	// unless it falls back to copies
+	exporter = newExporter("/outDir-3", true, false)
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	contents, err := testStore.Fs.ReadFile(filepath.Join(exporter.ExportDir, FilesExportSubDir, "folder-1", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, bFile.Contents, contents)
+}
+
+func Test_Export_specialFiles(t *testing.T) {
+	var err error
+
+	testStore := dal.NewMockStore(t, dal.MockNowProvider, mockfs.NewMockFs())
+
+	bucket := storetest.CreateBucket(t, testStore.Store, "docs")
+
+	fifoInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeFIFO, "fifo", time.Unix(1000, 0), 0, 0640)
+	charDeviceInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeCharDevice, "dev/null", time.Unix(1000, 0), 0, 0666)
+	charDeviceInfo.DeviceNumber = intelligentstore.NewDeviceNumber(1, 3)
+	blockDeviceInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeBlockDevice, "dev/sda", time.Unix(1000, 0), 0, 0660)
+	blockDeviceInfo.DeviceNumber = intelligentstore.NewDeviceNumber(8, 0)
+	socketInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeSocket, "socket", time.Unix(1000, 0), 0, 0755)
+
+	transactionDAL := testStore.Store.TransactionDAL
+	tx, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{fifoInfo, charDeviceInfo, blockDeviceInfo, socketInfo})
+	require.NoError(t, err)
+
+	// special files are recorded without anything being uploaded for them
+	assert.Empty(t, tx.GetRelativePathsRequired())
+
+	_, err = transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, nil)
+	require.NoError(t, err)
+
+	err = transactionDAL.Commit(tx)
+	require.NoError(t, err)
+
+	filesDir := filepath.Join("/outDir", FilesExportSubDir)
+	madeSpecialFiles := make(map[string]*intelligentstore.FileInfo)
+	var chtimesPaths []string
+	exporter := &LocalExporter{
+		Store:      testStore.Store,
+		BucketName: "docs",
+		ExportDir:  "/outDir",
+		fs:         testStore.Fs,
+		chtimes: func(name string, atime, mtime time.Time) error {
+			chtimesPaths = append(chtimesPaths, name)
+			return nil
+		},
+		makeSpecialFile: func(path string, fileInfo *intelligentstore.FileInfo) error {
+			if fileInfo.Type == intelligentstore.FileTypeBlockDevice {
+				// not running with privileges. The export carries on
+				return os.ErrPermission
+			}
+			madeSpecialFiles[path] = fileInfo
+			// the mode and modification time are set on the file afterwards
+			return testStore.Fs.WriteFile(path, nil, 0600)
+		},
+	}
+
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	fifoPath := filepath.Join(filesDir, "fifo")
+	charDevicePath := filepath.Join(filesDir, "dev", "null")
+	assert.Equal(t, map[string]*intelligentstore.FileInfo{
+		fifoPath:       fifoInfo,
+		charDevicePath: charDeviceInfo,
+	}, madeSpecialFiles)
+
+	assert.ElementsMatch(t, []string{fifoPath, charDevicePath}, chtimesPaths)
+
+	// sockets are recorded, but not restored
+	_, err = testStore.Fs.Stat(filepath.Join(filesDir, "socket"))
+	assert.True(t, os.IsNotExist(err))
+
+	// on platforms where special files can't be created, they are skipped, and the export carries on
+	chtimesPaths = nil
+	exporter.ExportDir = "/outDir-2"
+	exporter.makeSpecialFile = func(path string, fileInfo *intelligentstore.FileInfo) error {
+		return errSpecialFilesNotSupported
+	}
+
+	err = exporter.Export()
+	require.NoError(t, err)
+
+	assert.Empty(t, chtimesPaths)
+	_, err = testStore.Fs.Stat(filepath.Join("/outDir-2", FilesExportSubDir, "fifo"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+type writeCountingFile struct {
+	gofs.File
+	bytesWritten int
+}
+
+func (f *writeCountingFile) Write(b []byte) (int, error) {
+	f.bytesWritten += len(b)
+	return f.File.Write(b)
+}
+
+func Test_copySparse(t *testing.T) {
+	fs := mockfs.NewMockFs()
+
+	contents := make([]byte, 100*1024)
+	copy(contents[40*1024:], "data")
+	// the layout was found before "changed" was written into the second hole, so that part of the hole is written
+	copy(contents[80*1024:], "changed")
+
+	holes := []*intelligentstore.FileRange{
+		intelligentstore.NewFileRange(0, 40*1024),
+		intelligentstore.NewFileRange(44*1024, 56*1024),
+	}
+
+	file, err := fs.Create("/a.img")
+	require.NoError(t, err)
+
+	countingFile := &writeCountingFile{File: file}
+	err = copySparse(countingFile, bytes.NewReader(contents), holes)
+	require.NoError(t, err)
+
+	err = file.Close()
+	require.NoError(t, err)
+
+	writtenContents, err := fs.ReadFile("/a.img")
+	require.NoError(t, err)
+	assert.Equal(t, contents, writtenContents)
+
+	// the data, and the last 24KB of the hole, which weren't all zeros
+	assert.Equal(t, 4*1024+24*1024, countingFile.bytesWritten)
+}
@@ -0,0 +1,30 @@
+package exporters
+
+import (
+	"fmt"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"golang.org/x/sys/unix"
+)
+
+// makeSpecialFile creates a FIFO or device file. Creating device files needs privileges (CAP_MKNOD)
+func makeSpecialFile(path string, fileInfo *intelligentstore.FileInfo) error {
+	mode := uint32(fileInfo.FileMode.Perm())
+
+	switch fileInfo.Type {
+	case intelligentstore.FileTypeFIFO:
+		return unix.Mkfifo(path, mode)
+	case intelligentstore.FileTypeCharDevice:
+		mode |= unix.S_IFCHR
+	case intelligentstore.FileTypeBlockDevice:
+		mode |= unix.S_IFBLK
+	default:
+		return fmt.Errorf("can't make a special file of type %s", fileInfo.Type)
+	}
+
+	if nil == fileInfo.DeviceNumber {
+		return fmt.Errorf("no device number was recorded for the device file %q", fileInfo.RelativePath)
+	}
+
+	return unix.Mknod(path, mode, int(unix.Mkdev(fileInfo.DeviceNumber.Major, fileInfo.DeviceNumber.Minor)))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package exporters
+
+import (
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// makeSpecialFile is only implemented on Linux. Elsewhere, special files are skipped on export
+func makeSpecialFile(path string, fileInfo *intelligentstore.FileInfo) error {
+	return errSpecialFilesNotSupported
+}
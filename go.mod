@@ -11,8 +11,13 @@ require (
 	github.com/jamesrr39/go-snapshot-testing v0.0.0-20210417135521-a5ca70c087bc
 	github.com/jamesrr39/goutil v0.0.0-20220127172547-37a3bdc40f9a
 	github.com/jamesrr39/semaphore v0.0.0-20180521202200-0d5ddc396086
+	github.com/klauspost/compress v1.15.15
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.1
+	golang.org/x/crypto v0.0.0-20211108221036-ceb1ce70b4fa
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
 
@@ -25,7 +30,6 @@ require (
 	github.com/spf13/afero v1.8.1 // indirect
 	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,81 @@
+package dal
+
+import (
+	"io"
+	"sync"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/pkg/errors"
+)
+
+// backendFileWriter streams what is written to it into a new file in the storage backend.
+// The file is only created if it doesn't exist yet; if it does, writes (or Close) fail with storagebackend.ErrAlreadyExists as the cause.
+type backendFileWriter struct {
+	pipeWriter *io.PipeWriter
+	done       chan struct{}
+	putErr     errorsx.Error
+	closeOnce  sync.Once
+	closeErr   error
+	store      *IntelligentStoreDAL
+	key        string
+}
+
+// createFile creates a new file in the storage backend. The returned writer must be closed (or aborted) for the file to be finished.
+func (s *IntelligentStoreDAL) createFile(key string) *backendFileWriter {
+	pipeReader, pipeWriter := io.Pipe()
+
+	writer := &backendFileWriter{
+		pipeWriter: pipeWriter,
+		done:       make(chan struct{}),
+		store:      s,
+		key:        key,
+	}
+
+	go func() {
+		defer close(writer.done)
+		writer.putErr = s.backend.PutIfAbsent(key, pipeReader)
+		if writer.putErr != nil {
+			// unblock the writer, so that it gets the error
+			pipeReader.CloseWithError(writer.putErr)
+			return
+		}
+		pipeReader.Close()
+	}()
+
+	return writer
+}
+
+func (w *backendFileWriter) Write(p []byte) (int, error) {
+	return w.pipeWriter.Write(p)
+}
+
+// Close finishes the file, and waits for it to be stored. It can be called more than once.
+func (w *backendFileWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.pipeWriter.Close()
+		<-w.done
+		if w.putErr != nil {
+			w.closeErr = errorsx.Wrap(w.putErr)
+		}
+	})
+
+	return w.closeErr
+}
+
+// Abort stops writing the file, and makes sure no incomplete file is left in the store. Calling it after Close is a no-op.
+func (w *backendFileWriter) Abort() {
+	aborted := false
+	w.closeOnce.Do(func() {
+		aborted = true
+		w.pipeWriter.CloseWithError(errAbortedWrite)
+		<-w.done
+		w.closeErr = errAbortedWrite
+	})
+
+	if aborted && w.putErr == nil {
+		// the backend stored everything that was written before the abort
+		w.store.backend.Delete(w.key)
+	}
+}
+
+var errAbortedWrite = errors.New("the write was aborted")
@@ -0,0 +1,51 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_backendFileWriter(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	store := mockStore.Store
+
+	t.Run("close", func(t *testing.T) {
+		writer := store.createFile("objects/ab/written")
+		_, err := writer.Write([]byte("contents"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		b, readErr := store.readFile("objects/ab/written")
+		require.Nil(t, readErr)
+		assert.Equal(t, "contents", string(b))
+
+		// a second Close is a no-op
+		require.NoError(t, writer.Close())
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		writer := store.createFile("objects/ab/written")
+		writer.Write([]byte("other contents"))
+		err := writer.Close()
+		assert.Equal(t, storagebackend.ErrAlreadyExists, errorsx.Cause(err))
+
+		b, readErr := store.readFile("objects/ab/written")
+		require.Nil(t, readErr)
+		assert.Equal(t, "contents", string(b))
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		writer := store.createFile("objects/ab/aborted")
+		_, err := writer.Write([]byte("partial contents"))
+		require.NoError(t, err)
+		writer.Abort()
+
+		_, statErr := store.backend.Stat("objects/ab/aborted")
+		assert.Equal(t, storagebackend.ErrNotFound, errorsx.Cause(statErr))
+	})
+}
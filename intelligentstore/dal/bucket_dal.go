@@ -13,6 +13,7 @@ import (
 	"github.com/jamesrr39/goutil/dirtraversal"
 	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
 )
 
 var (
@@ -31,12 +32,13 @@ func NewBucketDAL(intelligentStoreDAL *IntelligentStoreDAL) *BucketDAL {
 	return &BucketDAL{intelligentStoreDAL}
 }
 
-func (bucketDAL *BucketDAL) bucketPath(bucket *intelligentstore.Bucket) string {
-	return filepath.Join(
-		bucketDAL.StoreBasePath,
-		".backup_data",
-		"buckets",
-		strconv.Itoa(bucket.ID))
+func (bucketDAL *BucketDAL) bucketKey(bucket *intelligentstore.Bucket) string {
+	return "buckets/" + strconv.Itoa(bucket.ID)
+}
+
+// listRevisionManifests lists the manifest files of all the revisions of the bucket
+func (dal *BucketDAL) listRevisionManifests(bucket *intelligentstore.Bucket) ([]*storagebackend.ObjectInfo, errorsx.Error) {
+	return dal.backend.List(dal.bucketKey(bucket) + "/versions/")
 }
 
 func isValidBucketName(name string) error {
@@ -60,8 +62,7 @@ var ErrNoRevisionsForBucket = errors.New("no revisions for this bucket yet")
 // GetLatestRevision returns the latest Revision of this bucket.
 // error could be either ErrNoRevisionsForBucket or an FS-related error.
 func (dal *BucketDAL) GetLatestRevision(bucket *intelligentstore.Bucket) (*intelligentstore.Revision, errorsx.Error) {
-	versionsDirPath := filepath.Join(dal.bucketPath(bucket), "versions")
-	versionsFileInfos, err := dal.IntelligentStoreDAL.fs.ReadDir(versionsDirPath)
+	versionsFileInfos, err := dal.listRevisionManifests(bucket)
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
@@ -92,9 +93,7 @@ func (dal *BucketDAL) GetLatestRevision(bucket *intelligentstore.Bucket) (*intel
 
 // GetRevisions gets all revisions of this bucket
 func (dal *BucketDAL) GetRevisions(bucket *intelligentstore.Bucket) ([]*intelligentstore.Revision, errorsx.Error) {
-	versionsFolderPath := filepath.Join(dal.bucketPath(bucket), "versions")
-
-	versionsFileInfos, err := dal.IntelligentStoreDAL.fs.ReadDir(versionsFolderPath)
+	versionsFileInfos, err := dal.listRevisionManifests(bucket)
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
@@ -128,21 +127,17 @@ func (dal *BucketDAL) GetRevision(bucket *intelligentstore.Bucket, revisionTimeS
 	return revision, nil
 }
 
-func (s *BucketDAL) getBucketsInformationPath() string {
-	return filepath.Join(s.StoreBasePath, ".backup_data", "store_metadata", "buckets-data.json")
-}
-
 func (s *BucketDAL) GetAllBuckets() ([]*intelligentstore.Bucket, errorsx.Error) {
-	file, err := s.fs.Open(s.getBucketsInformationPath())
+	file, err := s.openEncryptedFile(bucketsInformationKey)
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
 	defer file.Close()
 
 	var buckets []*intelligentstore.Bucket
-	err = json.NewDecoder(file).Decode(&buckets)
-	if nil != err {
-		return nil, errorsx.Wrap(err)
+	decodeErr := json.NewDecoder(file).Decode(&buckets)
+	if nil != decodeErr {
+		return nil, errorsx.Wrap(decodeErr)
 	}
 
 	return buckets, nil
@@ -191,24 +186,56 @@ func (s *BucketDAL) CreateBucket(bucketName string) (*intelligentstore.Bucket, e
 
 	buckets = append(buckets, intelligentstore.NewBucket(id, bucketName))
 
-	byteBuffer := bytes.NewBuffer(nil)
-	err = json.NewEncoder(byteBuffer).Encode(buckets)
+	err = s.writeBuckets(buckets)
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
 
-	err = s.fs.WriteFile(s.getBucketsInformationPath(), byteBuffer.Bytes(), 0600)
+	return intelligentstore.NewBucket(id, bucketName), nil
+}
+
+// SetRetentionPolicy sets (or with a nil policy, removes) the retention policy of a bucket
+func (s *BucketDAL) SetRetentionPolicy(bucket *intelligentstore.Bucket, retentionPolicy *intelligentstore.RetentionPolicy) errorsx.Error {
+	buckets, err := s.GetAllBuckets()
 	if nil != err {
-		return nil, errorsx.Wrap(err)
+		return errorsx.Wrap(err)
+	}
+
+	var found bool
+	for _, b := range buckets {
+		if b.ID == bucket.ID {
+			b.RetentionPolicy = retentionPolicy
+			found = true
+		}
 	}
 
-	bucketVersionsPath := filepath.Join(s.StoreBasePath, ".backup_data", "buckets", strconv.Itoa(id), "versions")
-	err = s.fs.MkdirAll(bucketVersionsPath, 0700)
+	if !found {
+		return errorsx.Wrap(ErrBucketDoesNotExist, "bucket ID", bucket.ID)
+	}
+
+	err = s.writeBuckets(buckets)
 	if nil != err {
-		return nil, errorsx.Wrap(err)
+		return errorsx.Wrap(err)
 	}
 
-	return intelligentstore.NewBucket(id, bucketName), nil
+	bucket.RetentionPolicy = retentionPolicy
+
+	return nil
+}
+
+func (s *BucketDAL) writeBuckets(buckets []*intelligentstore.Bucket) errorsx.Error {
+	byteBuffer := bytes.NewBuffer(nil)
+	err := json.NewEncoder(byteBuffer).Encode(buckets)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	err = s.writeEncryptedFile(bucketsInformationKey, byteBuffer.Bytes())
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
 }
 
 func getVersionTsFromFileName(fileName string) (int64, error) {
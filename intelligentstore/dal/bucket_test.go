@@ -11,13 +11,13 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func Test_bucketPath(t *testing.T) {
+func Test_bucketKey(t *testing.T) {
 	fs := mockfs.NewMockFs()
 	mockStoreDAL := NewMockStore(t, MockNowProvider, fs)
 
 	bucket := intelligentstore.NewBucket(0, "test bucket")
 
-	assert.Equal(t, "/test-store/.backup_data/buckets/0", mockStoreDAL.Store.BucketDAL.bucketPath(bucket))
+	assert.Equal(t, "buckets/0", mockStoreDAL.Store.BucketDAL.bucketKey(bucket))
 }
 
 func Test_isValidBucketName(t *testing.T) {
@@ -0,0 +1,297 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// chunkListFileExtension is the extension of the file that lists the chunks a large file's contents are stored as.
+// It is stored next to where the whole-file object would be, so that the contents can be found from the whole-file hash.
+const chunkListFileExtension = ".chunks"
+
+func (s *IntelligentStoreDAL) getChunkListKey(hash intelligentstore.Hash) string {
+	return s.getObjectKeyWithoutExtension(hash) + chunkListFileExtension
+}
+
+// getChunkList reads the list of chunks that the contents with this hash are stored as.
+// If the contents are not stored as chunks, the cause of the returned error satisfies os.IsNotExist.
+func (s *IntelligentStoreDAL) getChunkList(hash intelligentstore.Hash) ([]*intelligentstore.Chunk, errorsx.Error) {
+	chunks, err := s.readChunkList(s.getChunkListKey(hash))
+	if err != nil {
+		return nil, errorsx.Wrap(err, "hash", hash)
+	}
+
+	return chunks, nil
+}
+
+// isObjectComplete returns whether the contents with this hash can be read from the store: either the object is stored, or its chunk list and every chunk in it are.
+// Objects in the open pack are not counted, as they are not in the store until the pack is finished.
+func (s *IntelligentStoreDAL) isObjectComplete(hash intelligentstore.Hash) (bool, errorsx.Error) {
+	_, err := s.findObject(hash)
+	if err == nil {
+		return true, nil
+	}
+
+	if !os.IsNotExist(errorsx.Cause(err)) {
+		return false, errorsx.Wrap(err)
+	}
+
+	chunks, err := s.getChunkList(hash)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return false, nil
+		}
+		return false, errorsx.Wrap(err)
+	}
+
+	for _, chunk := range chunks {
+		_, err = s.findObject(chunk.Hash)
+		if err != nil {
+			if os.IsNotExist(errorsx.Cause(err)) {
+				return false, nil
+			}
+			return false, errorsx.Wrap(err, "hash", hash, "chunkHash", chunk.Hash)
+		}
+	}
+
+	return true, nil
+}
+
+// readChunkList reads the chunk list with this key
+func (s *IntelligentStoreDAL) readChunkList(key string) ([]*intelligentstore.Chunk, errorsx.Error) {
+	b, err := s.readEncryptedFile(key)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var chunks []*intelligentstore.Chunk
+	unmarshalErr := json.Unmarshal(b, &chunks)
+	if unmarshalErr != nil {
+		return nil, errorsx.Wrap(unmarshalErr, "key", key)
+	}
+
+	return chunks, nil
+}
+
+func (s *IntelligentStoreDAL) writeChunkList(hash intelligentstore.Hash, chunks []*intelligentstore.Chunk) errorsx.Error {
+	b, err := json.Marshal(chunks)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	err = s.writeEncryptedFile(s.getChunkListKey(hash), b)
+	if err != nil {
+		return errorsx.Wrap(err, "hash", hash)
+	}
+
+	return nil
+}
+
+// walkChunkLists calls walkFunc for every chunk list in the object store
+func (s *IntelligentStoreDAL) walkChunkLists(walkFunc walkObjectsFunc) errorsx.Error {
+	matchFunc := func(fileName string) (*objectCodec, string, bool) {
+		if !strings.HasSuffix(fileName, chunkListFileExtension) {
+			return nil, "", false
+		}
+		return nil, strings.TrimSuffix(fileName, chunkListFileExtension), true
+	}
+
+	return s.walkObjectFiles(matchFunc, walkFunc)
+}
+
+// getChunkedObject gets a reader that reads through the chunks of a file stored as chunks, one after the other
+func (s *IntelligentStoreDAL) getChunkedObject(hash intelligentstore.Hash) (io.ReadCloser, errorsx.Error) {
+	chunks, err := s.getChunkList(hash)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return &chunkedObjectReader{store: s, chunks: chunks}, nil
+}
+
+// SetChunkingSettings sets whether (and from which file size) new file contents are stored as content-defined chunks.
+// nil turns chunking off. Contents that are already stored are not changed.
+func (s *IntelligentStoreDAL) SetChunkingSettings(settings *intelligentstore.ChunkingSettings) errorsx.Error {
+	if settings != nil {
+		err := settings.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := s.Status()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	status.Chunking = settings
+
+	err = s.UpdateStatus(status)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.chunkingSettings = settings
+
+	return nil
+}
+
+// loadStoreSettings loads the settings kept in the status metadata file
+func (s *IntelligentStoreDAL) loadStoreSettings() errorsx.Error {
+	status, err := s.Status()
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			// store not migrated yet
+			return nil
+		}
+		return errorsx.Wrap(err)
+	}
+
+	s.chunkingSettings = status.Chunking
+	s.compressionSettings = status.Compression
+	s.packingSettings = status.Packing
+	s.hashAlgorithm = status.HashAlgorithm
+
+	return nil
+}
+
+type chunkedObjectReader struct {
+	store   *IntelligentStoreDAL
+	chunks  []*intelligentstore.Chunk
+	current io.ReadCloser
+}
+
+func (r *chunkedObjectReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			object, err := r.store.GetObjectByHash(r.chunks[0].Hash)
+			if err != nil {
+				return 0, err
+			}
+
+			r.current = object
+			r.chunks = r.chunks[1:]
+		}
+
+		bytesRead, err := r.current.Read(p)
+		if err != io.EOF {
+			return bytesRead, err
+		}
+
+		closeErr := r.current.Close()
+		r.current = nil
+		if closeErr != nil {
+			return bytesRead, closeErr
+		}
+
+		if bytesRead > 0 {
+			return bytesRead, nil
+		}
+	}
+}
+
+func (r *chunkedObjectReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+
+	return r.current.Close()
+}
+
+// createChunkedStoreFile splits the contents into content-defined chunks, stores each chunk that isn't in the store yet as an object,
+// and then writes the chunk list for the contents
+func (dal *TransactionDAL) createChunkedStoreFile(sourceFile io.Reader, hash intelligentstore.Hash) errorsx.Error {
+	store := dal.IntelligentStoreDAL
+	chunker := intelligentstore.NewChunker(sourceFile)
+
+	var chunks []*intelligentstore.Chunk
+	for {
+		chunkBytes, err := chunker.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errorsx.Wrap(err, "hash", hash)
+		}
+
+		chunkHash, err := intelligentstore.NewHashWithAlgorithm(bytes.NewReader(chunkBytes), hash.Algorithm())
+		if err != nil {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+
+		chunks = append(chunks, &intelligentstore.Chunk{Hash: chunkHash, Size: int64(len(chunkBytes))})
+
+		isPresent, err := store.IsObjectPresent(chunkHash)
+		if err != nil {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+
+		if isPresent {
+			continue
+		}
+
+		err = store.writeObject(bytes.NewReader(chunkBytes), chunkHash)
+		if err != nil {
+			return errorsx.Wrap(err, "hash", hash, "chunkHash", chunkHash)
+		}
+	}
+
+	return store.writeChunkList(hash, chunks)
+}
+
+// createChunkedStoreFileFromTempFile stores the contents of a temp file as chunks, and then removes the temp file
+func (dal *TransactionDAL) createChunkedStoreFileFromTempFile(tempfile *TempFile) errorsx.Error {
+	fs := dal.IntelligentStoreDAL.fs
+
+	file, err := fs.Open(tempfile.FilePath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer file.Close()
+
+	err = dal.createChunkedStoreFile(file, tempfile.Hash)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	err = fs.Remove(tempfile.FilePath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+// fillChunkLists records the chunk list on the descriptors of files whose contents are stored as chunks.
+// This covers contents chunked during this transaction, and contents that were already in the store as chunks.
+func (dal *TransactionDAL) fillChunkLists(transaction *intelligentstore.Transaction) errorsx.Error {
+	for _, descriptor := range transaction.FilesInVersion {
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if !ok || len(regularFileDescriptor.Chunks) != 0 || regularFileDescriptor.Size <= intelligentstore.MinChunkSize {
+			// files this small are never chunked
+			continue
+		}
+
+		chunks, err := dal.IntelligentStoreDAL.getChunkList(regularFileDescriptor.Hash)
+		if err != nil {
+			if os.IsNotExist(errorsx.Cause(err)) {
+				continue
+			}
+			return errorsx.Wrap(err)
+		}
+
+		regularFileDescriptor.Chunks = chunks
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package dal
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countObjects(t *testing.T, store *IntelligentStoreDAL) int {
+	var count int
+	err := store.walkObjects(func(object *storedObject) errorsx.Error {
+		count++
+		return nil
+	})
+	require.Nil(t, err)
+
+	return count
+}
+
+func Test_ChunkedObjects(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "vm-images")
+
+	err := mockStore.Store.SetChunkingSettings(&intelligentstore.ChunkingSettings{MinFileSize: intelligentstore.MinChunkSize})
+	require.NotNil(t, err)
+
+	err = mockStore.Store.SetChunkingSettings(&intelligentstore.ChunkingSettings{MinFileSize: 1024 * 1024})
+	require.Nil(t, err)
+
+	bigFileContents := make([]byte, 6*1024*1024)
+	_, readErr := rand.New(rand.NewSource(1)).Read(bigFileContents)
+	require.NoError(t, readErr)
+
+	bigFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "disk.img", mockNow, FileMode600, bigFileContents)
+	smallFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "notes.txt", mockNow, FileMode600, []byte("small file"))
+
+	revision1 := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{bigFile, smallFile})
+	mockNow = mockNow.Add(time.Hour)
+
+	_, err = mockStore.Store.findObject(bigFile.Descriptor.Hash)
+	assert.True(t, os.IsNotExist(errorsx.Cause(err)), "big file should not be stored as a single object")
+
+	_, err = mockStore.Store.findObject(smallFile.Descriptor.Hash)
+	assert.Nil(t, err, "small file should be stored as a single object")
+
+	isPresent, err := mockStore.Store.IsObjectPresent(bigFile.Descriptor.Hash)
+	require.Nil(t, err)
+	assert.True(t, isPresent)
+
+	descriptor, statDescriptorErr := mockStore.Store.RevisionDAL.Stat(bucket, revision1, "disk.img")
+	require.Nil(t, statDescriptorErr)
+	chunks := descriptor.(*intelligentstore.RegularFileDescriptor).Chunks
+	require.True(t, len(chunks) > 1)
+
+	var chunksSize int64
+	for _, chunk := range chunks {
+		chunksSize += chunk.Size
+	}
+	assert.Equal(t, int64(len(bigFileContents)), chunksSize)
+
+	object, err := mockStore.Store.GetObjectByHash(bigFile.Descriptor.Hash)
+	require.Nil(t, err)
+	objectContents, readErr := io.ReadAll(object)
+	require.NoError(t, readErr)
+	require.NoError(t, object.Close())
+	assert.Equal(t, bigFileContents, objectContents)
+
+	objectCountAfterRevision1 := countObjects(t, mockStore.Store)
+	assert.Equal(t, len(chunks)+1, objectCountAfterRevision1)
+
+	t.Run("appending to a big file only stores the changed chunk", func(t *testing.T) {
+		appendedContents := append(append([]byte{}, bigFileContents...), []byte("one more line\n")...)
+		appendedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "disk.img", mockNow, FileMode600, appendedContents)
+
+		revision2 := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{appendedFile, smallFile})
+		mockNow = mockNow.Add(time.Hour)
+
+		assert.Equal(t, objectCountAfterRevision1+1, countObjects(t, mockStore.Store))
+
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(bucket, revision2, VerifyOptions{Deep: true, SamplePercent: 100})
+		require.Nil(t, err)
+		assert.Len(t, result.Failures, 0)
+	})
+
+	t.Run("contents already stored as chunks", func(t *testing.T) {
+		copiedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "disk-copy.img", mockNow, FileMode600, bigFileContents)
+
+		revision3 := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{copiedFile})
+		mockNow = mockNow.Add(time.Hour)
+
+		descriptor, err := mockStore.Store.RevisionDAL.Stat(bucket, revision3, "disk-copy.img")
+		require.Nil(t, err)
+		assert.Equal(t, chunks, descriptor.(*intelligentstore.RegularFileDescriptor).Chunks)
+	})
+
+	t.Run("from temp file", func(t *testing.T) {
+		tempFileContents := append([]byte("a new first line\n"), bigFileContents...)
+		tempFileDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "uploaded.img", mockNow, FileMode600, tempFileContents)
+
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{tempFileDescriptor.Descriptor.FileInfo})
+		require.Nil(t, err)
+		mockNow = mockNow.Add(time.Hour)
+
+		hashes, err := tx.ProcessUploadHashesAndGetRequiredHashes([]*intelligentstore.RelativePathWithHash{
+			intelligentstore.NewRelativePathWithHash("uploaded.img", tempFileDescriptor.Descriptor.Hash),
+		})
+		require.Nil(t, err)
+		require.Equal(t, []intelligentstore.Hash{tempFileDescriptor.Descriptor.Hash}, hashes)
+
+		tempFile, err := mockStore.Store.TempStoreDAL.CreateTempFileFromReader(bytes.NewReader(tempFileContents), tempFileDescriptor.Descriptor.Hash)
+		require.Nil(t, err)
+
+		backupErr := mockStore.Store.TransactionDAL.BackupFromTempFile(tx, tempFile)
+		require.Nil(t, backupErr)
+
+		err = mockStore.Store.TransactionDAL.Commit(tx)
+		require.Nil(t, err)
+
+		_, statErr := fs.Stat(tempFile.FilePath)
+		assert.True(t, os.IsNotExist(statErr))
+
+		object, err := mockStore.Store.GetObjectByHash(tempFileDescriptor.Descriptor.Hash)
+		require.Nil(t, err)
+		objectContents, readErr := io.ReadAll(object)
+		require.NoError(t, readErr)
+		require.NoError(t, object.Close())
+		assert.Equal(t, tempFileContents, objectContents)
+	})
+
+	t.Run("garbage collection keeps chunks", func(t *testing.T) {
+		result, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Len(t, result.UnreferencedObjects, 0)
+		assert.Len(t, result.UnreferencedChunkLists, 0)
+
+		report, err := mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Len(t, report.Problems, 0)
+	})
+
+	t.Run("chunking disabled", func(t *testing.T) {
+		err := mockStore.Store.SetChunkingSettings(nil)
+		require.Nil(t, err)
+
+		otherContents := make([]byte, 2*1024*1024)
+		_, readErr := rand.New(rand.NewSource(2)).Read(otherContents)
+		require.NoError(t, readErr)
+
+		otherFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "other.img", mockNow, FileMode600, otherContents)
+		mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{otherFile})
+
+		_, err = mockStore.Store.findObject(otherFile.Descriptor.Hash)
+		assert.Nil(t, err)
+	})
+}
@@ -8,8 +8,41 @@ package dal
   		- versions
   		  - {timestamp}
   	- objects
-  	  - {first 2 characters of file_sha512}
-        - {last 38 characters of file_sha512}
+  	  - {first 2 characters of the hash digest}
+        - {rest of the hash digest}.gz|.zst|.raw (the extension is the object's encoding: gzip, zstd or uncompressed)
+        - {rest of the hash digest}.chunks (for large files stored as chunks: the list of chunk objects)
+      - for hashes made with an algorithm other than SHA-512 (e.g. "sha256:{digest}"), the algorithm is added after the rest of the digest, e.g. {rest of the hash digest}.sha256.gz
+      - in an encrypted store, the HMAC of the hash is used instead of the hash, so that the object names don't reveal the contents
+    - packs (only if packing is turned on: small objects appended into larger files)
+      - {first 2 characters of the pack ID}
+        - {rest of the pack ID}.pack (the objects, each encoded, and in an encrypted store encrypted, on it's own)
+        - {rest of the pack ID}.idx (the object name, encoding, offset and length of every object in the pack. Written after the pack, so a pack without an index is unused)
+    - quarantine
+      - objects (unreferenced objects moved aside by garbage collection, same layout as objects)
+    - locks
+      - store_lock.json (the exclusive lock on the whole store, for pruning, garbage collection, repacking, migrations and fsck repairs)
+      - buckets
+        - {bucket ID}.json (the lock of the transaction open on the bucket)
+      - shared
+        - {pid}-{random ID}.json (one per reader, e.g. replicating, exporting or verifying)
+      - object_reference_index.json (held while the object reference index is updated)
+    - transactions
+      - {bucket ID}.json (the state of the open, or interrupted, transaction on the bucket: its revision version, files, the hashes found so far and the upload status of each content. Removed on commit, so an interrupted transaction can be resumed)
+    - tmp (the temp store. Entries are named "{pid}-..." after the process using them, so several processes can use the store at once)
     - web
       - users
+    - store_metadata
+      - encryption-key.json (only in an encrypted store: the master key, encrypted with a key derived from the passphrase)
+      - object-references.json (the reference count, size, and first and last revisions seen of every referenced hash, including chunks. Updated on commit and prune; stores from before it was introduced need "rebuild-reference-index")
+
+Everything under .backup_data (except the temp store, "tmp") is read and written through a storagebackend.Backend, addressed by its key:
+the path relative to .backup_data, with "/" separators (for example "objects/ab/cdef.gz").
+For a store on the local filesystem, the keys are files under .backup_data; in object storage, they are object names (after the configured prefix).
+Transactions only lock their bucket, so backups into different buckets can run at the same time. Each lock is created atomically, and then the locks it conflicts with are checked for. Locks record the host and pid of the process holding them, and a heartbeat time it refreshes, so stale locks can be detected and removed (see lock_dal.go).
+The web server and the mounted filesystem don't take shared locks, as they run for a long time. Don't run garbage collection or repacking while they are in use.
+On the local filesystem, files are written to a ".incomplete-*" file next to the final path, synced, and then moved into place (and the directory synced), so a crash never leaves a truncated file at a key.
+Incomplete files left behind by a crash are removed by garbage collection once they are a day old.
+Objects are also encoded into the temp store first, and checked to decode to their hash, before they are stored.
+
+In an encrypted store, objects, chunk lists, pack indexes, revision manifests, the buckets data and the object reference index are all encrypted (see encrypted_stream.go).
 */
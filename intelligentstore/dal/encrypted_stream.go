@@ -0,0 +1,274 @@
+package dal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted files are written as a stream of authenticated segments, so that large objects don't have to be held in memory.
+// The layout is:
+//   - encryptedFileMagicBytes
+//   - a random salt, that the key of the file is derived from
+//   - segments of up to encryptedSegmentSize bytes of plaintext, each sealed with AES-GCM.
+//
+// Each file has it's own key, so that nonces can't collide between files, however many files the store has.
+// The nonce of each segment is the segment's counter, and a flag saying if it is the last segment,
+// so segments can't be reordered, dropped or have the end of the file cut off without decryption failing.
+// Each segment is also authenticated with the format version and the name of the file (see encryptedFileAdditionalData),
+// so a file can't be swapped for another file in the store without decryption failing.
+var encryptedFileMagicBytes = []byte("ISENC\x02")
+
+// Files from before each file had it's own key start with legacyEncryptedFileMagicBytes and a random nonce prefix instead of the salt,
+// and are sealed with the contents key itself. They can still be read, but are no longer written.
+var legacyEncryptedFileMagicBytes = []byte("ISENC\x01")
+
+const (
+	encryptedSegmentSize     = 64 * 1024
+	encryptedNoncePrefixSize = 7
+	encryptedFileSaltSize    = 32
+)
+
+// contentsKeys are the keys that encrypted files are sealed with
+type contentsKeys struct {
+	// fileKeyKey is the key that the key of each file is derived from, with the salt in the file's header
+	fileKeyKey []byte
+	// legacyAEAD opens files written before each file had it's own key
+	legacyAEAD cipher.AEAD
+}
+
+func (k *contentsKeys) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	return newAESGCM(deriveKey(k.fileKeyKey, string(salt)))
+}
+
+var (
+	ErrEncryptedFileCorrupt = errors.New("encrypted file is corrupt or has been tampered with")
+)
+
+// encryptedFileAdditionalData is the additional data each segment of a file is authenticated with: the format version, and the name of the file.
+// The name is the key the file is stored at, or for an object, the object's name (see getObjectName), as objects can be moved into and between packs.
+func encryptedFileAdditionalData(name string) []byte {
+	return append(append([]byte{}, encryptedFileMagicBytes...), name...)
+}
+
+func segmentNonce(noncePrefix []byte, counter uint32, isLast bool) []byte {
+	nonce := make([]byte, encryptedNoncePrefixSize+5)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[encryptedNoncePrefixSize:], counter)
+	if isLast {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+type encryptingWriter struct {
+	writer         io.Writer
+	aead           cipher.AEAD
+	additionalData []byte
+	noncePrefix    []byte
+	counter        uint32
+	buf            []byte
+	closed         bool
+}
+
+// newEncryptingWriter creates a writer that encrypts everything written to it, with a new key for the file. The file can only be read with the same name.
+// Close must be called to write the last segment. Close does not close the underlying writer.
+func newEncryptingWriter(writer io.Writer, keys *contentsKeys, name string) (*encryptingWriter, error) {
+	salt := make([]byte, encryptedFileSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := keys.fileAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(append(append([]byte{}, encryptedFileMagicBytes...), salt...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		writer:         writer,
+		aead:           aead,
+		additionalData: encryptedFileAdditionalData(name),
+		// the key is only used for this file, so the nonces only have to be unique within the file
+		noncePrefix: make([]byte, encryptedNoncePrefixSize),
+		buf:         make([]byte, 0, encryptedSegmentSize),
+	}, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+
+	var written int
+	for len(p) > 0 {
+		if len(w.buf) == encryptedSegmentSize {
+			// only written once there is more data, so that the last segment is always written by Close
+			err := w.writeSegment(false)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):encryptedSegmentSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *encryptingWriter) writeSegment(isLast bool) error {
+	sealed := w.aead.Seal(nil, segmentNonce(w.noncePrefix, w.counter, isLast), w.buf, w.additionalData)
+	_, err := w.writer.Write(sealed)
+	if err != nil {
+		return err
+	}
+
+	w.counter++
+	w.buf = w.buf[:0]
+
+	return nil
+}
+
+// Close writes the last segment. It is safe to call more than once.
+func (w *encryptingWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.writeSegment(true)
+}
+
+type decryptingReader struct {
+	reader *bufio.Reader
+	aead   cipher.AEAD
+	// additionalData is nil for legacy files, which were sealed without any
+	additionalData []byte
+	noncePrefix    []byte
+	counter        uint32
+	sealedBuf      []byte
+	plaintext      []byte
+	sawLast        bool
+}
+
+// newDecryptingReader creates a reader that decrypts a file written by an encryptingWriter with the same name, or a legacy file sealed with the contents key itself
+func newDecryptingReader(reader io.Reader, keys *contentsKeys, name string) (*decryptingReader, error) {
+	magicBytes, err := readEncryptedFileHeader(reader, len(encryptedFileMagicBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var aead cipher.AEAD
+	var additionalData []byte
+	var noncePrefix []byte
+	switch {
+	case bytes.Equal(magicBytes, encryptedFileMagicBytes):
+		salt, err := readEncryptedFileHeader(reader, encryptedFileSaltSize)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err = keys.fileAEAD(salt)
+		if err != nil {
+			return nil, err
+		}
+		additionalData = encryptedFileAdditionalData(name)
+		noncePrefix = make([]byte, encryptedNoncePrefixSize)
+	case bytes.Equal(magicBytes, legacyEncryptedFileMagicBytes):
+		noncePrefix, err = readEncryptedFileHeader(reader, encryptedNoncePrefixSize)
+		if err != nil {
+			return nil, err
+		}
+
+		aead = keys.legacyAEAD
+	default:
+		return nil, ErrEncryptedFileCorrupt
+	}
+
+	sealedSegmentSize := encryptedSegmentSize + aead.Overhead()
+
+	return &decryptingReader{
+		reader:         bufio.NewReaderSize(reader, sealedSegmentSize+1),
+		aead:           aead,
+		additionalData: additionalData,
+		noncePrefix:    noncePrefix,
+		sealedBuf:      make([]byte, sealedSegmentSize),
+	}, nil
+}
+
+func readEncryptedFileHeader(reader io.Reader, size int) ([]byte, error) {
+	b := make([]byte, size)
+	_, err := io.ReadFull(reader, b)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrEncryptedFileCorrupt
+		}
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.sawLast {
+			return 0, io.EOF
+		}
+
+		err := r.readSegment()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+
+	return n, nil
+}
+
+func (r *decryptingReader) readSegment() error {
+	n, err := io.ReadFull(r.reader, r.sealedBuf)
+	var isLast bool
+	switch err {
+	case nil:
+		// a full segment. It is the last one if nothing comes after it.
+		_, peekErr := r.reader.Peek(1)
+		if peekErr == io.EOF {
+			isLast = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	case io.ErrUnexpectedEOF:
+		isLast = true
+	case io.EOF:
+		// the last segment is missing
+		return ErrEncryptedFileCorrupt
+	default:
+		return err
+	}
+
+	plaintext, err := r.aead.Open(r.sealedBuf[:0], segmentNonce(r.noncePrefix, r.counter, isLast), r.sealedBuf[:n], r.additionalData)
+	if err != nil {
+		return ErrEncryptedFileCorrupt
+	}
+
+	r.counter++
+	r.plaintext = plaintext
+	r.sawLast = isLast
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package dal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrStoreLocked           = errors.New("the store is encrypted and has not been unlocked. The passphrase is needed to read or write it")
+	ErrWrongPassphrase       = errors.New("wrong passphrase")
+	ErrStoreAlreadyEncrypted = errors.New("the store is already encrypted")
+)
+
+const (
+	encryptionKeyFileVersion = 1
+	masterKeySize            = 32
+	// scrypt parameters for new key files. They are stored in the key file, so they can be raised later without breaking existing stores.
+	defaultScryptN = 1 << 15
+	defaultScryptR = 8
+	defaultScryptP = 1
+)
+
+// masterKeyAdditionalData binds the wrapped master key to it's purpose
+var masterKeyAdditionalData = []byte("intelligent-store master key")
+
+// encryptionKeyFile is the master key, encrypted ("wrapped") with a key derived from the passphrase.
+// Changing the passphrase only re-wraps the master key, so none of the data has to be rewritten.
+type encryptionKeyFile struct {
+	Version          int    `json:"version"`
+	ScryptN          int    `json:"scryptN"`
+	ScryptR          int    `json:"scryptR"`
+	ScryptP          int    `json:"scryptP"`
+	Salt             []byte `json:"salt"`
+	Nonce            []byte `json:"nonce"`
+	WrappedMasterKey []byte `json:"wrappedMasterKey"`
+}
+
+// storeKeys are the keys derived from the master key, once the store has been unlocked
+type storeKeys struct {
+	// contents are the keys that objects, chunk lists, revision manifests and bucket metadata are encrypted with
+	contents *contentsKeys
+	// objectNameKey is the key for the HMAC that object file names are made from, so that file names don't leak content hashes
+	objectNameKey []byte
+}
+
+const encryptionKeyFileKey = "store_metadata/encryption-key.json"
+
+// loadEncryptionState checks if the store is encrypted. The store still has to be unlocked to read encrypted data.
+func (s *IntelligentStoreDAL) loadEncryptionState() errorsx.Error {
+	_, err := s.backend.Stat(encryptionKeyFileKey)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil
+		}
+		return errorsx.Wrap(err)
+	}
+
+	s.isEncrypted = true
+
+	return nil
+}
+
+// IsEncrypted returns true if the data in the store is encrypted at rest
+func (s *IntelligentStoreDAL) IsEncrypted() bool {
+	return s.isEncrypted
+}
+
+// SetupEncryption generates a master key for the store, protected by the passphrase, and unlocks the store with it.
+// It can only be done on a new store, before any buckets are created.
+func (s *IntelligentStoreDAL) SetupEncryption(passphrase string) errorsx.Error {
+	if s.isEncrypted {
+		return errorsx.Wrap(ErrStoreAlreadyEncrypted)
+	}
+
+	buckets, err := s.BucketDAL.GetAllBuckets()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	if len(buckets) != 0 {
+		return errorsx.Errorf("encryption can only be set up on a new store, but this store already has %d bucket(s)", len(buckets))
+	}
+
+	masterKey := make([]byte, masterKeySize)
+	_, randErr := rand.Read(masterKey)
+	if randErr != nil {
+		return errorsx.Wrap(randErr)
+	}
+
+	err = s.writeEncryptionKeyFile(masterKey, passphrase)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.isEncrypted = true
+	err = s.setKeys(masterKey)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	// rewrite the (empty) bucket metadata, now encrypted
+	err = s.BucketDAL.writeBuckets(buckets)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	// and the (empty) object reference index
+	err = s.writeObjectReferenceIndex(newObjectReferenceIndex())
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+// Unlock unlocks an encrypted store with the passphrase. If the passphrase is wrong, the cause of the error returned is ErrWrongPassphrase.
+func (s *IntelligentStoreDAL) Unlock(passphrase string) errorsx.Error {
+	masterKey, err := s.unwrapMasterKey(passphrase)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return s.setKeys(masterKey)
+}
+
+// ChangePassphrase re-wraps the master key with a new passphrase. None of the data in the store is rewritten.
+func (s *IntelligentStoreDAL) ChangePassphrase(oldPassphrase, newPassphrase string) errorsx.Error {
+	masterKey, err := s.unwrapMasterKey(oldPassphrase)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return s.writeEncryptionKeyFile(masterKey, newPassphrase)
+}
+
+func (s *IntelligentStoreDAL) unwrapMasterKey(passphrase string) ([]byte, errorsx.Error) {
+	if !s.isEncrypted {
+		return nil, errorsx.Errorf("the store is not encrypted")
+	}
+
+	var err error
+	keyFileBytes, err := s.readFile(encryptionKeyFileKey)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	keyFile := new(encryptionKeyFile)
+	err = json.Unmarshal(keyFileBytes, keyFile)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if keyFile.Version != encryptionKeyFileVersion {
+		return nil, errorsx.Errorf("unsupported encryption key file version: %d", keyFile.Version)
+	}
+
+	keyEncryptionAEAD, err := newKeyEncryptionAEAD(passphrase, keyFile)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	masterKey, err := keyEncryptionAEAD.Open(nil, keyFile.Nonce, keyFile.WrappedMasterKey, masterKeyAdditionalData)
+	if err != nil {
+		return nil, errorsx.Wrap(ErrWrongPassphrase)
+	}
+
+	return masterKey, nil
+}
+
+func (s *IntelligentStoreDAL) writeEncryptionKeyFile(masterKey []byte, passphrase string) errorsx.Error {
+	if passphrase == "" {
+		return errorsx.Errorf("the passphrase cannot be empty")
+	}
+
+	keyFile := &encryptionKeyFile{
+		Version: encryptionKeyFileVersion,
+		ScryptN: defaultScryptN,
+		ScryptR: defaultScryptR,
+		ScryptP: defaultScryptP,
+		Salt:    make([]byte, 32),
+	}
+
+	_, err := rand.Read(keyFile.Salt)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	keyEncryptionAEAD, err := newKeyEncryptionAEAD(passphrase, keyFile)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	keyFile.Nonce = make([]byte, keyEncryptionAEAD.NonceSize())
+	_, err = rand.Read(keyFile.Nonce)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	keyFile.WrappedMasterKey = keyEncryptionAEAD.Seal(nil, keyFile.Nonce, masterKey, masterKeyAdditionalData)
+
+	b, err := json.MarshalIndent(keyFile, "", "\t")
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	// the key file is replaced atomically, so that a crash can't leave the store without a readable key file
+	return errorsx.Wrap(s.backend.Put(encryptionKeyFileKey, bytes.NewReader(b)))
+}
+
+func newKeyEncryptionAEAD(passphrase string, keyFile *encryptionKeyFile) (cipher.AEAD, error) {
+	keyEncryptionKey, err := scrypt.Key([]byte(passphrase), keyFile.Salt, keyFile.ScryptN, keyFile.ScryptR, keyFile.ScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAESGCM(keyEncryptionKey)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives a key for one purpose from the master key, so that the same key is never used for two things
+func deriveKey(masterKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (s *IntelligentStoreDAL) setKeys(masterKey []byte) errorsx.Error {
+	legacyContentsAEAD, err := newAESGCM(deriveKey(masterKey, "contents"))
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.keys = &storeKeys{
+		contents: &contentsKeys{
+			fileKeyKey: deriveKey(masterKey, "file keys"),
+			legacyAEAD: legacyContentsAEAD,
+		},
+		objectNameKey: deriveKey(masterKey, "object names"),
+	}
+
+	return nil
+}
+
+func (s *IntelligentStoreDAL) getContentsKeys() (*contentsKeys, errorsx.Error) {
+	if s.keys == nil {
+		return nil, errorsx.Wrap(ErrStoreLocked)
+	}
+
+	return s.keys.contents, nil
+}
+
+// getObjectName gets the name the contents with this hash are stored under in the object store.
+// In an encrypted store, this is a keyed hash (HMAC) of the hash, so that the file names don't leak which contents are in the store.
+// In an unencrypted store, it is the hash itself.
+// The name has the same form as a hash, so it can be split into a directory and file name in the same way.
+func (s *IntelligentStoreDAL) getObjectName(hash intelligentstore.Hash) intelligentstore.Hash {
+	if !s.isEncrypted || s.keys == nil {
+		// in a locked store, nothing can be found by hash anyway, as the contents can't be decrypted
+		return hash
+	}
+
+	mac := hmac.New(sha256.New, s.keys.objectNameKey)
+	mac.Write([]byte(hash))
+	return intelligentstore.Hash(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// readFile reads the whole of a file in the storage backend
+func (s *IntelligentStoreDAL) readFile(key string) ([]byte, errorsx.Error) {
+	file, err := s.backend.Get(key)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer file.Close()
+
+	b, readErr := io.ReadAll(file)
+	if readErr != nil {
+		return nil, errorsx.Wrap(readErr, "key", key)
+	}
+
+	return b, nil
+}
+
+// openEncryptedFile opens a file that is encrypted in an encrypted store, and gives a reader for the decrypted contents.
+// In an unencrypted store, the file is just opened.
+func (s *IntelligentStoreDAL) openEncryptedFile(key string) (io.ReadCloser, errorsx.Error) {
+	file, err := s.backend.Get(key)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return s.newDecryptingReadCloser(file, key)
+}
+
+// newDecryptingReadCloser wraps a reader of an encrypted stream so that, in an encrypted store, it gives the decrypted contents.
+// The name must be the one the stream was encrypted with (see encryptedFileAdditionalData). Closing the returned reader closes the file.
+func (s *IntelligentStoreDAL) newDecryptingReadCloser(file io.ReadCloser, name string) (io.ReadCloser, errorsx.Error) {
+	if !s.isEncrypted {
+		return file, nil
+	}
+
+	var err error
+	keys, err := s.getContentsKeys()
+	if err != nil {
+		file.Close()
+		return nil, errorsx.Wrap(err)
+	}
+
+	decryptingReader, err := newDecryptingReader(file, keys, name)
+	if err != nil {
+		file.Close()
+		return nil, errorsx.Wrap(err, "name", name)
+	}
+
+	return readCloser{decryptingReader, file.Close}, nil
+}
+
+// readEncryptedFile reads the whole of a file that is encrypted in an encrypted store
+func (s *IntelligentStoreDAL) readEncryptedFile(key string) ([]byte, errorsx.Error) {
+	file, err := s.openEncryptedFile(key)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer file.Close()
+
+	b, readErr := io.ReadAll(file)
+	if readErr != nil {
+		return nil, errorsx.Wrap(readErr, "key", key)
+	}
+
+	return b, nil
+}
+
+// writeEncryptedFile writes (or replaces) the whole of a file that is encrypted in an encrypted store
+func (s *IntelligentStoreDAL) writeEncryptedFile(key string, data []byte) errorsx.Error {
+	if !s.isEncrypted {
+		return errorsx.Wrap(s.backend.Put(key, bytes.NewReader(data)))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writer, err := s.newEncryptingWriteCloser(buf, key)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	_, writeErr := writer.Write(data)
+	if writeErr != nil {
+		return errorsx.Wrap(writeErr)
+	}
+
+	writeErr = writer.Close()
+	if writeErr != nil {
+		return errorsx.Wrap(writeErr)
+	}
+
+	return errorsx.Wrap(s.backend.Put(key, buf))
+}
+
+// newEncryptingWriteCloser wraps the writer so that, in an encrypted store, everything written to it is encrypted.
+// The stream can only be decrypted with the same name: the key it is stored at, or for an object, the object's name.
+// Closing the returned writer finishes the encrypted stream, but does not close the underlying writer.
+func (s *IntelligentStoreDAL) newEncryptingWriteCloser(writer io.Writer, name string) (io.WriteCloser, errorsx.Error) {
+	if !s.isEncrypted {
+		return nopWriteCloser{writer}, nil
+	}
+
+	keys, err := s.getContentsKeys()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	encryptingWriter, encryptErr := newEncryptingWriter(writer, keys, name)
+	if encryptErr != nil {
+		return nil, errorsx.Wrap(encryptErr)
+	}
+
+	return encryptingWriter, nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// openEncryptedFileForSeeking opens a file that is encrypted in an encrypted store, for readers that need to seek.
+// Encrypted files (and files in backends that can't seek) are read into memory, as the stream can't be seeked.
+func (s *IntelligentStoreDAL) openEncryptedFileForSeeking(key string) (io.ReadSeekCloser, errorsx.Error) {
+	if !s.isEncrypted {
+		file, err := s.backend.Get(key)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+
+		seekableFile, ok := file.(io.ReadSeekCloser)
+		if ok {
+			return seekableFile, nil
+		}
+
+		file.Close()
+	}
+
+	b, err := s.readEncryptedFile(key)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return readSeekNopCloser{bytes.NewReader(b)}, nil
+}
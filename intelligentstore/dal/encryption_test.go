@@ -0,0 +1,282 @@
+package dal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EncryptedStream(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	legacyAEAD, err := newAESGCM(key)
+	require.NoError(t, err)
+
+	keys := &contentsKeys{
+		fileKeyKey: deriveKey(key, "file keys"),
+		legacyAEAD: legacyAEAD,
+	}
+
+	encrypt := func(t *testing.T, plaintext []byte) []byte {
+		buf := bytes.NewBuffer(nil)
+		writer, err := newEncryptingWriter(buf, keys, "buckets/1/versions/946782245.csv")
+		require.NoError(t, err)
+
+		_, err = writer.Write(plaintext)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return buf.Bytes()
+	}
+
+	decryptWithName := func(encrypted []byte, name string) ([]byte, error) {
+		reader, err := newDecryptingReader(bytes.NewReader(encrypted), keys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.ReadAll(reader)
+	}
+
+	decrypt := func(encrypted []byte) ([]byte, error) {
+		return decryptWithName(encrypted, "buckets/1/versions/946782245.csv")
+	}
+
+	for _, size := range []int{0, 1, encryptedSegmentSize - 1, encryptedSegmentSize, encryptedSegmentSize + 1, 3*encryptedSegmentSize + 5} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		encrypted := encrypt(t, plaintext)
+
+		decrypted, err := decrypt(encrypted)
+		require.NoError(t, err, "size: %d", size)
+		assert.Equal(t, plaintext, decrypted, "size: %d", size)
+	}
+
+	t.Run("tampering is detected", func(t *testing.T) {
+		plaintext := bytes.Repeat([]byte("a"), 2*encryptedSegmentSize+10)
+		encrypted := encrypt(t, plaintext)
+
+		flipped := append([]byte{}, encrypted...)
+		flipped[len(flipped)/2] ^= 1
+		_, err := decrypt(flipped)
+		assert.Equal(t, ErrEncryptedFileCorrupt, err)
+
+		// cut off the last segment, so that the file ends on a segment boundary
+		headerSize := len(encryptedFileMagicBytes) + encryptedFileSaltSize
+		truncated := encrypted[:headerSize+2*(encryptedSegmentSize+legacyAEAD.Overhead())]
+		_, err = decrypt(truncated)
+		assert.Equal(t, ErrEncryptedFileCorrupt, err)
+
+		_, err = decrypt([]byte("not encrypted"))
+		assert.Equal(t, ErrEncryptedFileCorrupt, err)
+	})
+
+	t.Run("each file has it's own key", func(t *testing.T) {
+		plaintext := []byte("the same contents")
+		first := encrypt(t, plaintext)
+		second := encrypt(t, plaintext)
+
+		headerSize := len(encryptedFileMagicBytes) + encryptedFileSaltSize
+		assert.NotEqual(t, first[:headerSize], second[:headerSize])
+		assert.NotEqual(t, first[headerSize:], second[headerSize:])
+
+		// a segment can't be moved into another file
+		spliced := append(append([]byte{}, second[:headerSize]...), first[headerSize:]...)
+		_, err := decrypt(spliced)
+		assert.Equal(t, ErrEncryptedFileCorrupt, err)
+	})
+
+	t.Run("a file can't be read as another file", func(t *testing.T) {
+		encrypted := encrypt(t, []byte("the manifest of one revision"))
+
+		_, err := decryptWithName(encrypted, "buckets/1/versions/946782246.csv")
+		assert.Equal(t, ErrEncryptedFileCorrupt, err)
+	})
+
+	t.Run("legacy files sealed with the contents key can still be read", func(t *testing.T) {
+		plaintext := []byte("written before each file had it's own key")
+
+		noncePrefix := make([]byte, encryptedNoncePrefixSize)
+		_, err := rand.Read(noncePrefix)
+		require.NoError(t, err)
+
+		legacyFile := append(append([]byte{}, legacyEncryptedFileMagicBytes...), noncePrefix...)
+		legacyFile = legacyAEAD.Seal(legacyFile, segmentNonce(noncePrefix, 0, true), plaintext, nil)
+
+		decrypted, err := decrypt(legacyFile)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	})
+}
+
+func Test_EncryptedStore(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+
+	err := mockStore.Store.SetupEncryption(passphrase)
+	require.Nil(t, err)
+	assert.True(t, mockStore.Store.IsEncrypted())
+
+	err = mockStore.Store.SetupEncryption(passphrase)
+	assert.Equal(t, ErrStoreAlreadyEncrypted, errorsx.Cause(err))
+
+	err = mockStore.Store.SetChunkingSettings(&intelligentstore.ChunkingSettings{MinFileSize: 1024 * 1024})
+	require.Nil(t, err)
+
+	bucket := mockStore.CreateBucket(t, "secrets")
+
+	bigFileContents := make([]byte, 3*1024*1024)
+	_, readErr := rand.Read(bigFileContents)
+	require.NoError(t, readErr)
+
+	smallFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "docs/plan.txt", mockNow, FileMode600, []byte(strings.Repeat("the secret plan\n", 100)))
+	bigFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "disk.img", mockNow, FileMode600, bigFileContents)
+	revision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{smallFile, bigFile})
+
+	t.Run("nothing is stored in plaintext", func(t *testing.T) {
+		hashes := []intelligentstore.Hash{smallFile.Descriptor.Hash, bigFile.Descriptor.Hash}
+		for _, chunk := range bigFile.Descriptor.Chunks {
+			hashes = append(hashes, chunk.Hash)
+		}
+
+		var checkDir func(dirPath string)
+		checkFile := func(path string, fileInfo os.FileInfo) {
+			for _, hash := range hashes {
+				assert.NotContains(t, path, hash.Remainder(), "file names should not contain content hashes")
+			}
+
+			if fileInfo.IsDir() {
+				checkDir(path)
+				return
+			}
+
+			if strings.HasPrefix(path, "/test-store/.backup_data/store_metadata/") && !strings.HasSuffix(path, "buckets-data.json") {
+				return
+			}
+
+			contents, err := fs.ReadFile(path)
+			require.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(contents, encryptedFileMagicBytes), "file should be encrypted: %q", path)
+			assert.False(t, bytes.Contains(contents, []byte("secret plan")))
+			assert.False(t, bytes.Contains(contents, []byte("secrets")))
+			assert.False(t, bytes.Contains(contents, []byte("plan.txt")))
+		}
+
+		checkDir = func(dirPath string) {
+			fileInfos, err := fs.ReadDir(dirPath)
+			require.NoError(t, err)
+
+			for _, fileInfo := range fileInfos {
+				checkFile(filepath.Join(dirPath, fileInfo.Name()), fileInfo)
+			}
+		}
+
+		checkDir("/test-store/.backup_data")
+	})
+
+	t.Run("locked store", func(t *testing.T) {
+		store, err := newIntelligentStoreConnToExisting("/test-store", mockNowProvider, fs, nil)
+		require.Nil(t, err)
+		assert.True(t, store.IsEncrypted())
+
+		_, err = store.BucketDAL.GetAllBuckets()
+		assert.Equal(t, ErrStoreLocked, errorsx.Cause(err))
+
+		err = store.Unlock("wrong passphrase")
+		assert.Equal(t, ErrWrongPassphrase, errorsx.Cause(err))
+	})
+
+	t.Run("unlocked store reads everything transparently", func(t *testing.T) {
+		store, err := newIntelligentStoreConnToExisting("/test-store", mockNowProvider, fs, nil)
+		require.Nil(t, err)
+
+		err = store.Unlock(passphrase)
+		require.Nil(t, err)
+
+		buckets, err := store.BucketDAL.GetAllBuckets()
+		require.Nil(t, err)
+		require.Len(t, buckets, 1)
+		assert.Equal(t, "secrets", buckets[0].BucketName)
+
+		descriptors, err := store.RevisionDAL.GetFilesInRevision(bucket, revision)
+		require.Nil(t, err)
+		assert.Len(t, descriptors, 2)
+
+		dirEntries, readDirErr := store.RevisionDAL.ReadDir(bucket, revision, "docs")
+		require.NoError(t, readDirErr)
+		require.Len(t, dirEntries, 1)
+
+		assert.Equal(t, smallFile.Contents, readObject(t, store, smallFile.Descriptor.Hash))
+		assert.Equal(t, bigFile.Contents, readObject(t, store, bigFile.Descriptor.Hash))
+
+		verifyResult, err := store.RevisionDAL.VerifyRevision(bucket, revision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Len(t, verifyResult.Failures, 0)
+
+		report, err := store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Len(t, report.Problems, 0)
+
+		gcResult, err := store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Len(t, gcResult.UnreferencedObjects, 0)
+		assert.Len(t, gcResult.UnreferencedChunkLists, 0)
+	})
+
+	t.Run("change passphrase", func(t *testing.T) {
+		keyFileBefore, readErr := fs.ReadFile(mockStore.GetPathOfKey(encryptionKeyFileKey))
+		require.NoError(t, readErr)
+
+		err := mockStore.Store.ChangePassphrase("wrong passphrase", "new passphrase")
+		assert.Equal(t, ErrWrongPassphrase, errorsx.Cause(err))
+
+		err = mockStore.Store.ChangePassphrase(passphrase, "new passphrase")
+		require.Nil(t, err)
+
+		keyFileAfter, readErr := fs.ReadFile(mockStore.GetPathOfKey(encryptionKeyFileKey))
+		require.NoError(t, readErr)
+		assert.NotEqual(t, keyFileBefore, keyFileAfter)
+
+		store, err := newIntelligentStoreConnToExisting("/test-store", mockNowProvider, fs, nil)
+		require.Nil(t, err)
+
+		err = store.Unlock(passphrase)
+		assert.Equal(t, ErrWrongPassphrase, errorsx.Cause(err))
+
+		err = store.Unlock("new passphrase")
+		require.Nil(t, err)
+
+		assert.Equal(t, smallFile.Contents, readObject(t, store, smallFile.Descriptor.Hash))
+
+		// the key file is replaced atomically, with no other files left behind
+		metadataFileInfos, readDirErr := fs.ReadDir(filepath.Dir(mockStore.GetPathOfKey(encryptionKeyFileKey)))
+		require.NoError(t, readDirErr)
+		var metadataFileNames []string
+		for _, fileInfo := range metadataFileInfos {
+			metadataFileNames = append(metadataFileNames, fileInfo.Name())
+		}
+		assert.ElementsMatch(t, []string{"buckets-data.json", "encryption-key.json", "object-references.json", "status-metadata.json", "users-data.json"}, metadataFileNames)
+	})
+}
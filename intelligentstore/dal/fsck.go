@@ -0,0 +1,540 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// FsckProblemType is the kind of problem found by Fsck
+type FsckProblemType string
+
+const (
+	FsckProblemTypeMetadataUnreadable  FsckProblemType = "METADATA_UNREADABLE"
+	FsckProblemTypeManifestUnreadable  FsckProblemType = "MANIFEST_UNREADABLE"
+	FsckProblemTypeChunkListUnreadable FsckProblemType = "CHUNK_LIST_UNREADABLE"
+	FsckProblemTypeMissingObject       FsckProblemType = "MISSING_OBJECT"
+	FsckProblemTypeOrphanedObject      FsckProblemType = "ORPHANED_OBJECT"
+	FsckProblemTypeEmptyObject         FsckProblemType = "EMPTY_OBJECT"
+	FsckProblemTypeBadObjectHeader     FsckProblemType = "BAD_OBJECT_HEADER"
+	FsckProblemTypeTempStoreLeftover   FsckProblemType = "TEMP_STORE_LEFTOVER"
+	FsckProblemTypeStaleLock           FsckProblemType = "STALE_LOCK"
+	FsckProblemTypeBadPack             FsckProblemType = "BAD_PACK"
+)
+
+// FsckProblem is a problem found when checking the store
+type FsckProblem struct {
+	Type FsckProblemType `json:"type"`
+	// Path is the key of the file in the store (relative to the backup data folder), or for temp store files, the path on the local filesystem
+	Path     string `json:"path"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckOptions configures a store check
+type FsckOptions struct {
+	// Repair fixes the problems that are safe to fix automatically:
+	// leftover temp store files and stale locks are removed, empty objects or objects with a bad header are quarantined (so that they will be uploaded again on the next backup),
+	// and packs left without an index by an interrupted backup are removed.
+	Repair bool
+}
+
+// FsckReport is the result of checking the whole store
+type FsckReport struct {
+	BucketsChecked   int64          `json:"bucketsChecked"`
+	ManifestsChecked int64          `json:"manifestsChecked"`
+	ObjectsChecked   int64          `json:"objectsChecked"`
+	PacksChecked     int64          `json:"packsChecked"`
+	Problems         []*FsckProblem `json:"problems"`
+}
+
+// HasUnrepairedProblems returns true if any problem found has not been repaired
+func (r *FsckReport) HasUnrepairedProblems() bool {
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			return true
+		}
+	}
+
+	return false
+}
+
+type fsckChecker struct {
+	store   *IntelligentStoreDAL
+	options FsckOptions
+	report  *FsckReport
+	// map[hash]description of the first place the hash was found
+	referencedHashes map[intelligentstore.Hash]string
+	// map[object name]description of the first place the hash was found. The object store is walked by object name (see getObjectName).
+	referencedObjectNames map[intelligentstore.Hash]string
+}
+
+// Fsck checks the whole store: the metadata files, every revision manifest, every chunk list, every object, every pack, the temp store and the locks.
+// Problems found are collected into the report. The returned error is only for errors that stopped the check from running.
+func (s *IntelligentStoreDAL) Fsck(options FsckOptions) (*FsckReport, errorsx.Error) {
+	checker := &fsckChecker{
+		store:                 s,
+		options:               options,
+		report:                &FsckReport{Problems: []*FsckProblem{}},
+		referencedHashes:      make(map[intelligentstore.Hash]string),
+		referencedObjectNames: make(map[intelligentstore.Hash]string),
+	}
+
+	lockIsHeld, err := checker.checkLock()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if options.Repair {
+		if lockIsHeld {
+			return nil, errorsx.Wrap(ErrLockAlreadyTaken, "detail", "the store is locked by a running process, so it can't be repaired")
+		}
+
+		_, err = s.LockDAL.acquireStoreLock("lock from fsck repair")
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+		defer func() {
+			removeLockErr := s.LockDAL.removeStoreLock()
+			if removeLockErr != nil {
+				log.Printf("failed to remove store lock after fsck repair. Error: %q\n", removeLockErr)
+			}
+		}()
+	}
+
+	checker.checkMetadataFiles()
+
+	err = checker.checkManifests()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = checker.checkChunkLists()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	checker.checkReferencedObjectsExist()
+
+	err = checker.checkObjects()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = checker.checkPacks()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = checker.checkTempStore()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return checker.report, nil
+}
+
+func (c *fsckChecker) addProblem(problemType FsckProblemType, path, detail string) *FsckProblem {
+	problem := &FsckProblem{Type: problemType, Path: path, Detail: detail}
+	c.report.Problems = append(c.report.Problems, problem)
+	return problem
+}
+
+// checkLock checks for stale locks. It returns true if the store lock is held by a process that is still running.
+func (c *fsckChecker) checkLock() (bool, errorsx.Error) {
+	locks, err := c.store.LockDAL.ListLocks()
+	if err != nil {
+		c.addProblem(FsckProblemTypeMetadataUnreadable, "locks/", err.Error())
+		return false, nil
+	}
+
+	storeLockIsHeld := false
+	for _, lock := range locks {
+		if !lock.IsStale {
+			if lock.Type == LockTypeStore {
+				storeLockIsHeld = true
+			}
+			continue
+		}
+
+		problem := c.addProblem(
+			FsckProblemTypeStaleLock,
+			lock.Key,
+			fmt.Sprintf("lock acquired at %s by process %d on host %q, which is no longer running (last heartbeat: %s). Lock text: %q", lock.AcquisitionTime, lock.Pid, lock.Hostname, lock.HeartbeatTime, lock.Text),
+		)
+
+		if c.options.Repair {
+			removeErr := c.store.LockDAL.ForceReleaseLock(lock.Key)
+			if removeErr != nil {
+				return false, errorsx.Wrap(removeErr)
+			}
+			problem.Repaired = true
+		}
+	}
+
+	return storeLockIsHeld, nil
+}
+
+func (c *fsckChecker) checkMetadataFiles() {
+	var status *intelligentstore.Status
+	var buckets []*intelligentstore.Bucket
+	var users []*intelligentstore.User
+
+	metadataFiles := []struct {
+		key string
+		// isEncrypted is true if the file is encrypted in an encrypted store
+		isEncrypted bool
+		target      interface{}
+	}{
+		{statusMetadataFileKey, false, &status},
+		{bucketsInformationKey, true, &buckets},
+		{usersInformationKey, false, &users},
+	}
+
+	for _, metadataFile := range metadataFiles {
+		err := c.decodeJSONFile(metadataFile.key, metadataFile.isEncrypted, metadataFile.target)
+		if err != nil {
+			c.addProblem(FsckProblemTypeMetadataUnreadable, metadataFile.key, err.Error())
+		}
+	}
+}
+
+func (c *fsckChecker) decodeJSONFile(key string, isEncrypted bool, target interface{}) error {
+	var file io.ReadCloser
+	var err error
+	if isEncrypted {
+		file, err = c.store.openEncryptedFile(key)
+	} else {
+		file, err = c.store.backend.Get(key)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(target)
+}
+
+func (c *fsckChecker) checkManifests() errorsx.Error {
+	manifestInfos, err := c.store.backend.List("buckets/")
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	bucketKeysSeen := make(map[string]struct{})
+	for _, manifestInfo := range manifestInfos {
+		// manifest keys are "buckets/<bucket ID>/versions/<revision version>.<extension>"
+		fragments := strings.Split(manifestInfo.Key, "/")
+		if len(fragments) == 4 && fragments[2] == revisionInfosFolderName {
+			// revision infos are next to the manifests, in a folder of their own
+			revisionInfo := new(intelligentstore.RevisionInfo)
+			decodeErr := c.decodeJSONFile(manifestInfo.Key, true, revisionInfo)
+			if decodeErr != nil {
+				c.addProblem(FsckProblemTypeMetadataUnreadable, manifestInfo.Key, decodeErr.Error())
+			}
+			continue
+		}
+
+		if len(fragments) != 4 || fragments[2] != "versions" {
+			c.addProblem(FsckProblemTypeManifestUnreadable, manifestInfo.Key, "unexpected file in the buckets folder")
+			continue
+		}
+
+		bucketKey := strings.Join(fragments[:2], "/")
+		_, bucketSeen := bucketKeysSeen[bucketKey]
+		if !bucketSeen {
+			bucketKeysSeen[bucketKey] = struct{}{}
+			c.report.BucketsChecked++
+		}
+
+		c.report.ManifestsChecked++
+
+		err = c.checkManifest(manifestInfo.Key)
+		if err != nil {
+			c.addProblem(FsckProblemTypeManifestUnreadable, manifestInfo.Key, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// checkChunkLists checks that every chunk list can be read, and adds the chunks of referenced chunk lists to the referenced hashes
+func (c *fsckChecker) checkChunkLists() errorsx.Error {
+	return c.store.walkChunkLists(func(chunkList *storedObject) errorsx.Error {
+		chunkListPath := chunkList.Key
+
+		firstReference, isReferenced := c.referencedObjectNames[chunkList.Hash]
+		if !isReferenced {
+			c.addProblem(FsckProblemTypeOrphanedObject, chunkListPath, "chunk list is not referenced by any revision. Run the garbage collector to remove it")
+			return nil
+		}
+
+		chunks, err := c.store.readChunkList(chunkListPath)
+		if err != nil {
+			c.addProblem(FsckProblemTypeChunkListUnreadable, chunkListPath, fmt.Sprintf("referenced by %s. Error: %s", firstReference, err))
+			return nil
+		}
+
+		for _, chunk := range chunks {
+			c.addReference(chunk.Hash, fmt.Sprintf("chunk list %q", chunkListPath))
+		}
+
+		return nil
+	})
+}
+
+func (c *fsckChecker) checkReferencedObjectsExist() {
+	for hash, firstReference := range c.referencedHashes {
+		_, err := c.store.StatFile(hash)
+		if err != nil {
+			// the object could be missing in any encoding, so the key is given without a file extension
+			objectPath := c.store.getObjectKeyWithoutExtension(hash)
+			c.addProblem(FsckProblemTypeMissingObject, objectPath, fmt.Sprintf("referenced by %s. Error: %s", firstReference, err))
+		}
+	}
+}
+
+func (c *fsckChecker) addReference(hash intelligentstore.Hash, reference string) {
+	_, alreadyReferenced := c.referencedHashes[hash]
+	if !alreadyReferenced {
+		c.referencedHashes[hash] = reference
+		c.referencedObjectNames[c.store.getObjectName(hash)] = reference
+	}
+}
+
+func (c *fsckChecker) checkManifest(manifestPath string) errorsx.Error {
+	file, err := c.store.openEncryptedFileForSeeking(manifestPath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	var reader revisionReader
+	switch filepath.Ext(manifestPath) {
+	case ".csv":
+		reader = &revisionCSVReader{file}
+	case ".json":
+		reader = &revisionJSONReader{file}
+	default:
+		file.Close()
+		return errorsx.Errorf("unknown revision manifest format: %q", filepath.Ext(manifestPath))
+	}
+	defer reader.Close()
+
+	iterator, err := reader.Iterator()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for iterator.Next() {
+		descriptor, err := iterator.Scan()
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if !ok {
+			continue
+		}
+
+		reference := fmt.Sprintf("%q in %q", regularFileDescriptor.RelativePath, manifestPath)
+		c.addReference(regularFileDescriptor.Hash, reference)
+		for _, chunk := range regularFileDescriptor.Chunks {
+			c.addReference(chunk.Hash, reference)
+		}
+	}
+
+	return errorsx.Wrap(iterator.Err())
+}
+
+func (c *fsckChecker) checkObjects() errorsx.Error {
+	return c.store.walkObjects(func(object *storedObject) errorsx.Error {
+		c.report.ObjectsChecked++
+		objectPath := object.Key
+
+		var problem *FsckProblem
+		if object.Info.Size == 0 {
+			// an uncompressed object for empty contents is legitimately empty
+			if object.Codec != noneObjectCodec {
+				problem = c.addProblem(FsckProblemTypeEmptyObject, objectPath, "object is zero bytes long")
+			}
+		} else {
+			hasHeader, err := c.hasCodecHeader(object)
+			if err != nil {
+				return errorsx.Wrap(err)
+			}
+
+			if !hasHeader {
+				headerName := string(object.Codec.Encoding)
+				if c.store.isEncrypted {
+					headerName = "encrypted file"
+				}
+				problem = c.addProblem(FsckProblemTypeBadObjectHeader, objectPath, fmt.Sprintf("object does not start with the %s header", headerName))
+			}
+		}
+
+		if problem != nil {
+			if c.options.Repair {
+				err := c.store.quarantineObject(object)
+				if err != nil {
+					return errorsx.Wrap(err)
+				}
+				problem.Repaired = true
+			}
+			return nil
+		}
+
+		_, isReferenced := c.referencedObjectNames[object.Hash]
+		if !isReferenced {
+			c.addProblem(FsckProblemTypeOrphanedObject, objectPath, "object is not referenced by any revision. Run the garbage collector to remove it")
+		}
+
+		return nil
+	})
+}
+
+// checkPacks checks every pack has an index, every object in the index is inside the pack, and every packed object is referenced
+func (c *fsckChecker) checkPacks() errorsx.Error {
+	objectInfos, err := c.store.backend.List(packsKeyPrefix)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	packSizes := make(map[string]int64)
+	for _, objectInfo := range objectInfos {
+		if strings.HasSuffix(objectInfo.Key, packFileExtension) {
+			packSizes[strings.TrimSuffix(objectInfo.Key, packFileExtension)] = objectInfo.Size
+		}
+	}
+
+	packIDs, err := c.store.listPackIDs()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, packID := range packIDs {
+		c.report.PacksChecked++
+		keyWithoutExtension := getPackKeyWithoutExtension(packID)
+		packKey := getPackKey(packID)
+
+		packSize, packExists := packSizes[keyWithoutExtension]
+		delete(packSizes, keyWithoutExtension)
+		if !packExists {
+			c.addProblem(FsckProblemTypeBadPack, packKey, "the pack is missing, but it's index is there")
+			continue
+		}
+
+		index, err := c.store.readPackIndex(packID)
+		if err != nil {
+			c.addProblem(FsckProblemTypeBadPack, getPackIndexKey(packID), fmt.Sprintf("the pack index can't be read. Error: %s", err))
+			continue
+		}
+
+		unreferencedObjects := 0
+		for _, entry := range index.Entries {
+			if entry.Offset < 0 || entry.Length < 0 || entry.Offset+entry.Length > packSize {
+				c.addProblem(FsckProblemTypeBadPack, packKey, fmt.Sprintf("object %q (offset %d, length %d) is outside of the pack, which is %d bytes long", entry.Name, entry.Offset, entry.Length, packSize))
+				continue
+			}
+
+			_, isReferenced := c.referencedObjectNames[entry.Name]
+			if !isReferenced {
+				unreferencedObjects++
+			}
+		}
+
+		if unreferencedObjects != 0 {
+			c.addProblem(FsckProblemTypeOrphanedObject, packKey, fmt.Sprintf("%d packed objects are not referenced by any revision. Run repack to remove them", unreferencedObjects))
+		}
+	}
+
+	// the packs left are the packs without an index
+	var packsWithoutIndex []string
+	for keyWithoutExtension := range packSizes {
+		packsWithoutIndex = append(packsWithoutIndex, keyWithoutExtension)
+	}
+	sort.Strings(packsWithoutIndex)
+
+	for _, keyWithoutExtension := range packsWithoutIndex {
+		packKey := keyWithoutExtension + packFileExtension
+		problem := c.addProblem(FsckProblemTypeBadPack, packKey, "the pack has no index, so none of it's objects are used. It is probably left over from an interrupted backup")
+		if c.options.Repair {
+			err = c.store.backend.Delete(packKey)
+			if err != nil {
+				return errorsx.Wrap(err)
+			}
+			problem.Repaired = true
+		}
+	}
+
+	return nil
+}
+
+// hasCodecHeader checks the object starts with the magic bytes of it's encoding.
+// In an encrypted store, the encoding can't be checked without decrypting the object, so the encrypted file header is checked instead.
+func (c *fsckChecker) hasCodecHeader(object *storedObject) (bool, errorsx.Error) {
+	magicBytes := object.Codec.MagicBytes
+	if c.store.isEncrypted {
+		// objects written before each file had it's own key have the legacy header, which is the same length
+		magicBytes = encryptedFileMagicBytes
+	}
+
+	if len(magicBytes) == 0 {
+		return true, nil
+	}
+
+	file, err := c.store.backend.Get(object.Key)
+	if err != nil {
+		return false, errorsx.Wrap(err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magicBytes))
+	_, readErr := io.ReadFull(file, header)
+	if readErr != nil {
+		if readErr == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, errorsx.Wrap(readErr)
+	}
+
+	if c.store.isEncrypted && bytes.Equal(header, legacyEncryptedFileMagicBytes) {
+		return true, nil
+	}
+
+	return bytes.Equal(header, magicBytes), nil
+}
+
+func (c *fsckChecker) checkTempStore() errorsx.Error {
+	fileInfos, err := c.store.TempStoreDAL.ListContents()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, fileInfo := range fileInfos {
+		if !c.store.TempStoreDAL.IsLeftover(fileInfo.Name()) {
+			// in use by a running process
+			continue
+		}
+
+		path := filepath.Join(c.store.TempStoreDAL.basePath, fileInfo.Name())
+		problem := c.addProblem(FsckProblemTypeTempStoreLeftover, path, "leftover from an interrupted process")
+
+		if c.options.Repair {
+			removeErr := c.store.fs.RemoveAll(path)
+			if removeErr != nil {
+				return errorsx.Wrap(removeErr)
+			}
+			problem.Repaired = true
+		}
+	}
+
+	return nil
+}
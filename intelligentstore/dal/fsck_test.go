@@ -0,0 +1,118 @@
+package dal
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Fsck(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, MockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	liveFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{liveFile})
+
+	t.Run("healthy store", func(t *testing.T) {
+		report, err := mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+
+		assert.Equal(t, int64(1), report.BucketsChecked)
+		assert.Equal(t, int64(1), report.ManifestsChecked)
+		assert.Equal(t, int64(1), report.ObjectsChecked)
+		assert.Len(t, report.Problems, 0)
+		assert.False(t, report.HasUnrepairedProblems())
+	})
+
+	orphanHash := intelligentstore.Hash("abcdef0123456789")
+	emptyHash := intelligentstore.Hash("cdef0123456789ab")
+	require.Nil(t, fs.MkdirAll("/test-store/.backup_data/objects/ab", 0700))
+	require.Nil(t, fs.MkdirAll("/test-store/.backup_data/objects/cd", 0700))
+	require.Nil(t, fs.WriteFile(mockStore.GetPathOfKey(mockStore.Store.getObjectKey(orphanHash, gzipObjectCodec)), []byte("not gzipped"), 0600))
+	require.Nil(t, fs.WriteFile(mockStore.GetPathOfKey(mockStore.Store.getObjectKey(emptyHash, gzipObjectCodec)), nil, 0600))
+
+	liveObject, err := mockStore.Store.findObject(liveFile.Descriptor.Hash)
+	require.Nil(t, err)
+	require.Nil(t, fs.Remove(mockStore.GetPathOfKey(liveObject.Key)))
+
+	tempLeftoverPath := filepath.Join(mockStore.Store.TempStoreDAL.basePath, "999999999-abcd-1")
+	require.Nil(t, fs.WriteFile(tempLeftoverPath, []byte("partial upload"), 0600))
+
+	// files in the temp store being used by a running process are not leftovers
+	inUseTempFile, inUseTempFilePath, err := mockStore.Store.TempStoreDAL.CreateTempFile()
+	require.Nil(t, err)
+	require.Nil(t, inUseTempFile.Close())
+
+	staleLock, marshalErr := json.Marshal(&StoreLock{AcquisitionTime: time.Unix(0, 0), Pid: 999999999, Text: "crashed process"})
+	require.Nil(t, marshalErr)
+	require.Nil(t, fs.MkdirAll(filepath.Dir(mockStore.GetPathOfKey(storeLockKey)), 0700))
+	require.Nil(t, fs.WriteFile(mockStore.GetPathOfKey(storeLockKey), staleLock, 0600))
+
+	t.Run("problems found", func(t *testing.T) {
+		report, err := mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+
+		problemTypes := []FsckProblemType{}
+		for _, problem := range report.Problems {
+			problemTypes = append(problemTypes, problem.Type)
+			assert.False(t, problem.Repaired)
+		}
+
+		assert.ElementsMatch(t, []FsckProblemType{
+			FsckProblemTypeStaleLock,
+			FsckProblemTypeMissingObject,
+			FsckProblemTypeBadObjectHeader,
+			FsckProblemTypeEmptyObject,
+			FsckProblemTypeTempStoreLeftover,
+		}, problemTypes)
+		assert.True(t, report.HasUnrepairedProblems())
+	})
+
+	t.Run("lock held by running process", func(t *testing.T) {
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(storeLockKey))
+
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.Fsck(FsckOptions{Repair: true})
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+		require.Nil(t, fs.WriteFile(mockStore.GetPathOfKey(storeLockKey), staleLock, 0600))
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		report, err := mockStore.Store.Fsck(FsckOptions{Repair: true})
+		require.Nil(t, err)
+
+		for _, problem := range report.Problems {
+			assert.Equal(t, problem.Type == FsckProblemTypeMissingObject, !problem.Repaired, "problem: %#v", problem)
+		}
+
+		_, statErr := fs.Stat(mockStore.GetPathOfKey(mockStore.Store.getQuarantineKey(orphanHash, gzipObjectCodec.FileExtension)))
+		require.Nil(t, statErr)
+		_, statErr = fs.Stat(mockStore.GetPathOfKey(mockStore.Store.getQuarantineKey(emptyHash, gzipObjectCodec.FileExtension)))
+		require.Nil(t, statErr)
+		_, statErr = fs.Stat(tempLeftoverPath)
+		assert.Error(t, statErr)
+		_, statErr = fs.Stat(inUseTempFilePath)
+		assert.Nil(t, statErr)
+
+		lock, lockErr := mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+
+		report, err = mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, FsckProblemTypeMissingObject, report.Problems[0].Type)
+	})
+}
@@ -0,0 +1,343 @@
+package dal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/humanise"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+)
+
+// GarbageCollectionOptions configures a run of the garbage collector
+type GarbageCollectionOptions struct {
+	// DryRun only reports what would be removed; nothing is changed on disk
+	DryRun bool
+	// Quarantine moves unreferenced objects into the quarantine folder instead of deleting them
+	Quarantine bool
+}
+
+// GarbageCollectionResult is a report of a garbage collection run
+type GarbageCollectionResult struct {
+	DryRun         bool  `json:"dryRun"`
+	ObjectsScanned int64 `json:"objectsScanned"`
+	LiveObjects    int64 `json:"liveObjects"`
+	// UnreferencedObjects are the names of the unreferenced objects. In an encrypted store, these are not the hashes of the contents (see getObjectName).
+	UnreferencedObjects []intelligentstore.Hash `json:"unreferencedObjects"`
+	// UnreferencedChunkLists are the chunk lists of contents stored as chunks, that are not referenced by any revision
+	UnreferencedChunkLists []intelligentstore.Hash `json:"unreferencedChunkLists"`
+	ReclaimableBytes       int64                   `json:"reclaimableBytes"`
+	// UnreferencedPackedObjects is how many packed objects are not referenced by any revision. They can't be removed one by one; repacking removes them.
+	UnreferencedPackedObjects int64 `json:"unreferencedPackedObjects"`
+	// IncompleteFiles is how many files left behind by writes that never finished (for example because the process crashed) were found
+	IncompleteFiles int64 `json:"incompleteFiles"`
+}
+
+// incompleteFileMinAge is how old an incomplete file must be before garbage collection removes it.
+// Locks are taken while garbage collection runs, so newer incomplete files could still be being written to.
+const incompleteFileMinAge = 24 * time.Hour
+
+// GarbageCollect finds objects in the object store that are not referenced by any revision of any bucket, and removes (or quarantines) them.
+// It holds the store lock while running, so it cannot run at the same time as a transaction.
+func (s *IntelligentStoreDAL) GarbageCollect(options GarbageCollectionOptions) (*GarbageCollectionResult, errorsx.Error) {
+	_, err := s.LockDAL.acquireStoreLock("lock from garbage collection")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer func() {
+		removeLockErr := s.LockDAL.removeStoreLock()
+		if removeLockErr != nil {
+			log.Printf("failed to remove store lock after garbage collection. Error: %q\n", removeLockErr)
+		}
+	}()
+
+	liveObjectNames, unreferencedChunkLists, err := s.getLiveObjectNames()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	result := &GarbageCollectionResult{
+		DryRun:                 options.DryRun,
+		UnreferencedObjects:    []intelligentstore.Hash{},
+		UnreferencedChunkLists: []intelligentstore.Hash{},
+	}
+
+	for _, chunkList := range unreferencedChunkLists {
+		result.UnreferencedChunkLists = append(result.UnreferencedChunkLists, chunkList.Hash)
+		result.ReclaimableBytes += chunkList.Info.Size
+	}
+
+	err = s.walkObjects(func(object *storedObject) errorsx.Error {
+		result.ObjectsScanned++
+
+		_, isLive := liveObjectNames[object.Hash]
+		if isLive {
+			result.LiveObjects++
+			return nil
+		}
+
+		result.UnreferencedObjects = append(result.UnreferencedObjects, object.Hash)
+		result.ReclaimableBytes += object.Info.Size
+
+		if options.DryRun {
+			return nil
+		}
+
+		// if the store lock has been lost, another process could be uploading new references to this object
+		err := s.LockDAL.checkStoreLockHeld()
+		if err != nil {
+			return err
+		}
+
+		if options.Quarantine {
+			return s.quarantineObject(object)
+		}
+
+		return errorsx.Wrap(s.backend.Delete(object.Key), "hash", object.Hash)
+	})
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	packedObjectNames, err := s.getPackedObjectNames()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	for _, name := range packedObjectNames {
+		_, isLive := liveObjectNames[name]
+		if !isLive {
+			result.UnreferencedPackedObjects++
+		}
+	}
+
+	incompleteFileRemover, ok := s.backend.(storagebackend.IncompleteFileRemover)
+	if ok {
+		result.IncompleteFiles, err = incompleteFileRemover.RemoveIncompleteFiles(s.nowProvider().Add(-incompleteFileMinAge), options.DryRun)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	if !options.DryRun {
+		for _, chunkList := range unreferencedChunkLists {
+			err = s.LockDAL.checkStoreLockHeld()
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			if options.Quarantine {
+				err = s.quarantineChunkList(chunkList)
+			} else {
+				err = errorsx.Wrap(s.backend.Delete(chunkList.Key), "hash", chunkList.Hash)
+			}
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// getLiveObjectNames gets the names (see getObjectName) of every object referenced by a revision, including the chunks of referenced chunk lists.
+// It also returns the chunk lists that aren't referenced by any revision.
+func (s *IntelligentStoreDAL) getLiveObjectNames() (map[intelligentstore.Hash]struct{}, []*storedObject, errorsx.Error) {
+	liveHashes, err := s.getLiveHashes()
+	if err != nil {
+		return nil, nil, errorsx.Wrap(err)
+	}
+
+	// objects are found by name when walking the object store, so the live hashes are looked up by name
+	liveObjectNames := make(map[intelligentstore.Hash]struct{}, len(liveHashes))
+	for hash := range liveHashes {
+		liveObjectNames[s.getObjectName(hash)] = struct{}{}
+	}
+
+	var unreferencedChunkLists []*storedObject
+	err = s.walkChunkLists(func(chunkList *storedObject) errorsx.Error {
+		_, isLive := liveObjectNames[chunkList.Hash]
+		if !isLive {
+			unreferencedChunkLists = append(unreferencedChunkLists, chunkList)
+			return nil
+		}
+
+		chunks, err := s.readChunkList(chunkList.Key)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		for _, chunk := range chunks {
+			liveObjectNames[s.getObjectName(chunk.Hash)] = struct{}{}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errorsx.Wrap(err)
+	}
+
+	return liveObjectNames, unreferencedChunkLists, nil
+}
+
+// getLiveHashes returns the set of object hashes that are referenced by a revision.
+// They are taken from the object reference index if the store has one, otherwise every revision of every bucket is read.
+// Chunks are only included if they are listed on the descriptor; the chunks of chunk lists are added by the caller.
+func (s *IntelligentStoreDAL) getLiveHashes() (map[intelligentstore.Hash]struct{}, errorsx.Error) {
+	index, err := s.readObjectReferenceIndex()
+	if err == nil {
+		liveHashes := make(map[intelligentstore.Hash]struct{}, len(index.Objects))
+		for hash := range index.Objects {
+			liveHashes[hash] = struct{}{}
+		}
+		return liveHashes, nil
+	}
+
+	if !os.IsNotExist(errorsx.Cause(err)) {
+		return nil, errorsx.Wrap(err)
+	}
+
+	buckets, err := s.BucketDAL.GetAllBuckets()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	liveHashes := make(map[intelligentstore.Hash]struct{})
+	for _, bucket := range buckets {
+		revisions, err := s.BucketDAL.GetRevisions(bucket)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "bucket", bucket.ID)
+		}
+
+		for _, revision := range revisions {
+			descriptors, err := s.RevisionDAL.GetFilesInRevision(bucket, revision)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			for _, descriptor := range descriptors {
+				regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+				if !ok {
+					continue
+				}
+
+				liveHashes[regularFileDescriptor.Hash] = struct{}{}
+				for _, chunk := range regularFileDescriptor.Chunks {
+					liveHashes[chunk.Hash] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return liveHashes, nil
+}
+
+type walkObjectsFunc func(object *storedObject) errorsx.Error
+
+// matchObjectFileNameFunc returns the codec of an object file (nil if the file isn't encoded contents), and the file name without the extension.
+// ok is false if the file isn't one of the files being walked.
+type matchObjectFileNameFunc func(fileName string) (codec *objectCodec, nameWithoutExtension string, ok bool)
+
+// walkObjects calls walkFunc for every object in the object store, in any encoding
+func (s *IntelligentStoreDAL) walkObjects(walkFunc walkObjectsFunc) errorsx.Error {
+	return s.walkObjectFiles(getObjectCodecByFileName, walkFunc)
+}
+
+// walkObjectFiles calls walkFunc for every file in the object store that matchFunc matches
+func (s *IntelligentStoreDAL) walkObjectFiles(matchFunc matchObjectFileNameFunc, walkFunc walkObjectsFunc) errorsx.Error {
+	objectInfos, err := s.backend.List(objectsKeyPrefix)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, objectInfo := range objectInfos {
+		// object keys are "objects/<first chunk of the name>/<remainder of the name><extension>"
+		fragments := strings.Split(strings.TrimPrefix(objectInfo.Key, objectsKeyPrefix), "/")
+		if len(fragments) != 2 {
+			continue
+		}
+
+		codec, nameWithoutExtension, ok := matchFunc(fragments[1])
+		if !ok {
+			continue
+		}
+
+		err = walkFunc(&storedObject{
+			Hash:  intelligentstore.NewHashFromObjectPath(fragments[0], nameWithoutExtension),
+			Key:   objectInfo.Key,
+			Codec: codec,
+			Info:  objectInfo,
+		})
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *IntelligentStoreDAL) getQuarantineKey(hash intelligentstore.Hash, extension string) string {
+	return "quarantine/objects/" + hash.FirstChunk() + "/" + hash.Remainder() + extension
+}
+
+// quarantineObject moves an object out of the object store into the quarantine folder. It keeps it's encoding's file extension.
+func (s *IntelligentStoreDAL) quarantineObject(object *storedObject) errorsx.Error {
+	return s.moveToQuarantine(object.Hash, object.Key, object.Codec.FileExtension)
+}
+
+// quarantineChunkList moves a chunk list out of the object store into the quarantine folder
+func (s *IntelligentStoreDAL) quarantineChunkList(chunkList *storedObject) errorsx.Error {
+	return s.moveToQuarantine(chunkList.Hash, chunkList.Key, chunkListFileExtension)
+}
+
+// moveToQuarantine copies the file into the quarantine folder, and then removes it from the object store
+func (s *IntelligentStoreDAL) moveToQuarantine(hash intelligentstore.Hash, key, extension string) errorsx.Error {
+	file, err := s.backend.Get(key)
+	if err != nil {
+		return errorsx.Wrap(err, "hash", hash)
+	}
+	defer file.Close()
+
+	err = s.backend.Put(s.getQuarantineKey(hash, extension), file)
+	if err != nil {
+		return errorsx.Wrap(err, "hash", hash)
+	}
+
+	err = s.backend.Delete(key)
+	if err != nil {
+		return errorsx.Wrap(err, "hash", hash)
+	}
+
+	return nil
+}
+
+func (r *GarbageCollectionResult) String() string {
+	verb := "removed"
+	if r.DryRun {
+		verb = "would be removed"
+	}
+
+	text := fmt.Sprintf(
+		"scanned %d objects. %d are referenced by a revision. %d objects and %d chunk lists are unreferenced and %s (%s)",
+		r.ObjectsScanned,
+		r.LiveObjects,
+		len(r.UnreferencedObjects),
+		len(r.UnreferencedChunkLists),
+		verb,
+		humanise.HumaniseBytes(r.ReclaimableBytes),
+	)
+
+	if r.UnreferencedPackedObjects != 0 {
+		text += fmt.Sprintf(". %d packed objects are unreferenced; run repack to remove them", r.UnreferencedPackedObjects)
+	}
+
+	if r.IncompleteFiles != 0 {
+		text += fmt.Sprintf(". %d incomplete files %s", r.IncompleteFiles, verb)
+	}
+
+	return text
+}
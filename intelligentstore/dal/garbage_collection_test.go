@@ -0,0 +1,70 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GarbageCollect(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, MockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	liveFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{liveFile})
+
+	orphanHash := intelligentstore.Hash("abcdef0123456789")
+	require.Nil(t, fs.MkdirAll("/test-store/.backup_data/objects/ab", 0700))
+	require.Nil(t, fs.WriteFile(mockStore.GetPathOfKey(mockStore.Store.getObjectKey(orphanHash, gzipObjectCodec)), []byte("orphaned object"), 0600))
+
+	t.Run("dry run", func(t *testing.T) {
+		result, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+
+		assert.Equal(t, int64(2), result.ObjectsScanned)
+		assert.Equal(t, int64(1), result.LiveObjects)
+		assert.Equal(t, []intelligentstore.Hash{orphanHash}, result.UnreferencedObjects)
+		assert.Equal(t, int64(len("orphaned object")), result.ReclaimableBytes)
+
+		isPresent, err := mockStore.Store.IsObjectPresent(orphanHash)
+		require.Nil(t, err)
+		assert.True(t, isPresent)
+	})
+
+	t.Run("lock already taken", func(t *testing.T) {
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.GarbageCollect(GarbageCollectionOptions{})
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		result, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{Quarantine: true})
+		require.Nil(t, err)
+		assert.Len(t, result.UnreferencedObjects, 1)
+
+		isPresent, err := mockStore.Store.IsObjectPresent(orphanHash)
+		require.Nil(t, err)
+		assert.False(t, isPresent)
+
+		_, statErr := fs.Stat(mockStore.GetPathOfKey(mockStore.Store.getQuarantineKey(orphanHash, gzipObjectCodec.FileExtension)))
+		require.Nil(t, statErr)
+
+		isPresent, err = mockStore.Store.IsObjectPresent(liveFile.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.True(t, isPresent)
+
+		lock, lockErr := mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+	})
+}
@@ -0,0 +1,40 @@
+package dal
+
+import (
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// HashAlgorithm gets the algorithm the store hashes new contents with
+func (s *IntelligentStoreDAL) HashAlgorithm() intelligentstore.HashAlgorithm {
+	if s.hashAlgorithm == "" {
+		return intelligentstore.DefaultHashAlgorithm
+	}
+
+	return s.hashAlgorithm
+}
+
+// SetHashAlgorithm sets the algorithm new contents are hashed with.
+// Contents that are already stored keep their hashes; they can still be read, but contents hashed with a different algorithm are not deduplicated against them.
+func (s *IntelligentStoreDAL) SetHashAlgorithm(hashAlgorithm intelligentstore.HashAlgorithm) errorsx.Error {
+	err := hashAlgorithm.Validate()
+	if err != nil {
+		return err
+	}
+
+	status, err := s.Status()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	status.HashAlgorithm = hashAlgorithm
+
+	err = s.UpdateStatus(status)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.hashAlgorithm = hashAlgorithm
+
+	return nil
+}
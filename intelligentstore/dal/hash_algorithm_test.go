@@ -0,0 +1,81 @@
+package dal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SetHashAlgorithm(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, MockNowProvider, fs)
+
+	status, err := mockStore.Store.Status()
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.HashAlgorithmSHA512, status.HashAlgorithm)
+
+	oldBucket := mockStore.CreateBucket(t, "old")
+	oldFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	mockStore.CreateRevision(t, oldBucket, []*intelligentstore.RegularFileDescriptorWithContents{oldFile})
+
+	err = mockStore.Store.SetHashAlgorithm("md5")
+	require.NotNil(t, err)
+
+	err = mockStore.Store.SetHashAlgorithm(intelligentstore.HashAlgorithmSHA256)
+	require.Nil(t, err)
+
+	status, err = mockStore.Store.Status()
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.HashAlgorithmSHA256, status.HashAlgorithm)
+
+	newBucket := mockStore.CreateBucket(t, "new")
+	contents := []byte("a text")
+	newDescriptor, err := intelligentstore.NewRegularFileDescriptorFromReaderWithHashAlgorithm("a.txt", time.Unix(0, 0), FileMode600, bytes.NewReader(contents), intelligentstore.HashAlgorithmSHA256)
+	require.Nil(t, err)
+	require.Equal(t, intelligentstore.HashAlgorithmSHA256, newDescriptor.Hash.Algorithm())
+	newRevision := mockStore.CreateRevision(t, newBucket, []*intelligentstore.RegularFileDescriptorWithContents{{Descriptor: newDescriptor, Contents: contents}})
+
+	t.Run("both objects can be read", func(t *testing.T) {
+		for _, hash := range []intelligentstore.Hash{oldFile.Descriptor.Hash, newDescriptor.Hash} {
+			object, err := mockStore.Store.GetObjectByHash(hash)
+			require.Nil(t, err)
+
+			b, readErr := io.ReadAll(object)
+			require.NoError(t, readErr)
+			require.Nil(t, object.Close())
+			assert.Equal(t, contents, b)
+		}
+	})
+
+	t.Run("deep verification uses the hash algorithm of each file", func(t *testing.T) {
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(newBucket, newRevision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Equal(t, int64(1), result.FilesVerified)
+		assert.Empty(t, result.Failures)
+	})
+
+	t.Run("objects are found by hash when walking the object store", func(t *testing.T) {
+		result, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), result.ObjectsScanned)
+		assert.Equal(t, int64(2), result.LiveObjects)
+		assert.Empty(t, result.UnreferencedObjects)
+	})
+
+	t.Run("hashes made with another algorithm are rejected", func(t *testing.T) {
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(newBucket, []*intelligentstore.FileInfo{oldFile.Descriptor.FileInfo})
+		require.Nil(t, err)
+		defer mockStore.Store.TransactionDAL.Rollback(tx)
+
+		_, err = tx.ProcessUploadHashesAndGetRequiredHashes([]*intelligentstore.RelativePathWithHash{
+			intelligentstore.NewRelativePathWithHash(oldFile.Descriptor.RelativePath, oldFile.Descriptor.Hash),
+		})
+		require.NotNil(t, err)
+	})
+}
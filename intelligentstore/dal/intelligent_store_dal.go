@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
 	"github.com/pkg/errors"
 )
 
@@ -23,20 +25,45 @@ var (
 
 const (
 	BackupDataFolderName = ".backup_data"
-	RequiredVersion      = 3
+	RequiredVersion      = 4
+)
+
+// keys of the store metadata files, relative to the backup data folder
+const (
+	statusMetadataFileKey = "store_metadata/status-metadata.json"
+	bucketsInformationKey = "store_metadata/buckets-data.json"
+	usersInformationKey   = "store_metadata/users-data.json"
 )
 
 // IntelligentStoreDAL represents the object to interact with the underlying storage
 type IntelligentStoreDAL struct {
-	StoreBasePath  string
-	nowProvider    NowProvider
-	fs             gofs.Fs
+	// StoreBasePath is the path of the store on the local filesystem. For a store in another storage backend, it is a description of where the store is.
+	StoreBasePath string
+	nowProvider   NowProvider
+	// fs is the local filesystem. It holds the temp store, and for a store on the local filesystem, the store itself (used by migrations).
+	fs gofs.Fs
+	// backend is where the objects, revision manifests, metadata and locks are kept
+	backend        storagebackend.Backend
 	BucketDAL      *BucketDAL
 	RevisionDAL    *RevisionDAL
 	TransactionDAL *TransactionDAL
 	LockDAL        *LockDAL
 	UserDAL        *UserDAL
 	TempStoreDAL   *TempStoreDAL
+	// chunkingSettings is nil if new contents should not be stored as chunks
+	chunkingSettings *intelligentstore.ChunkingSettings
+	// compressionSettings is nil if new objects should be encoded with the default encoding
+	compressionSettings *intelligentstore.CompressionSettings
+	// packingSettings is nil if new objects should not be packed
+	packingSettings *intelligentstore.PackingSettings
+	// hashAlgorithm is the algorithm new contents are hashed with. Empty if the store uses the default algorithm.
+	hashAlgorithm intelligentstore.HashAlgorithm
+	// packs is the index of packed objects, and the pack new objects are being added to
+	packs *packStore
+	// isEncrypted is true if the store's data is encrypted at rest
+	isEncrypted bool
+	// keys is nil until an encrypted store has been unlocked
+	keys *storeKeys
 }
 
 func NewIntelligentStoreConnToExistingForMigrationUpgrades(pathToBase string) (*IntelligentStoreDAL, errorsx.Error) {
@@ -47,6 +74,16 @@ func NewIntelligentStoreConnToExistingForMigrationUpgrades(pathToBase string) (*
 	})
 }
 
+// NewIntelligentStoreConnToExistingForFsck connects to a store without clearing out the temp store, so that the store can be checked as it is on disk
+func NewIntelligentStoreConnToExistingForFsck(pathToBase string) (*IntelligentStoreDAL, errorsx.Error) {
+	fs := gofs.NewOsFs()
+
+	return newIntelligentStoreConnToExisting(pathToBase, prodNowProvider, fs, &StoreConnOptions{
+		MaxOpenFiles:          defaultStoreConnOptions.MaxOpenFiles,
+		KeepTempStoreContents: true,
+	})
+}
+
 func NewIntelligentStoreConnToExisting(pathToBase string) (*IntelligentStoreDAL, errorsx.Error) {
 	fs := gofs.NewOsFs()
 
@@ -54,16 +91,16 @@ func NewIntelligentStoreConnToExisting(pathToBase string) (*IntelligentStoreDAL,
 }
 
 func (s *IntelligentStoreDAL) Status() (*intelligentstore.Status, errorsx.Error) {
-	file, err := s.fs.Open(s.getStatusMetadataFilePath())
+	file, err := s.backend.Get(statusMetadataFileKey)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 	defer file.Close()
 
 	status := new(intelligentstore.Status)
-	err = json.NewDecoder(file).Decode(status)
-	if err != nil {
-		return nil, errorsx.Wrap(err)
+	decodeErr := json.NewDecoder(file).Decode(status)
+	if decodeErr != nil {
+		return nil, errorsx.Wrap(decodeErr)
 	}
 
 	return status, nil
@@ -75,16 +112,7 @@ func (s *IntelligentStoreDAL) UpdateStatus(status *intelligentstore.Status) erro
 		return errorsx.Wrap(err)
 	}
 
-	err = s.fs.WriteFile(s.getStatusMetadataFilePath(), b, 0644)
-	if err != nil {
-		return errorsx.Wrap(err)
-	}
-
-	return nil
-}
-
-func (s *IntelligentStoreDAL) getStatusMetadataFilePath() string {
-	return filepath.Join(s.StoreBasePath, ".backup_data", "store_metadata", "status-metadata.json")
+	return errorsx.Wrap(s.backend.Put(statusMetadataFileKey, bytes.NewReader(b)))
 }
 
 func checkStoreExists(pathToBase string, fs gofs.Fs) errorsx.Error {
@@ -104,58 +132,38 @@ func checkStoreExists(pathToBase string, fs gofs.Fs) errorsx.Error {
 }
 
 func (s *IntelligentStoreDAL) ensureMigrationsUpToDate() errorsx.Error {
-	statusMetadataFilePath := s.getStatusMetadataFilePath()
-
-	_, err := s.fs.Stat(statusMetadataFilePath)
+	status, err := s.Status()
 	if nil != err {
-		if !os.IsNotExist(err) {
+		if !os.IsNotExist(errorsx.Cause(err)) {
 			// unexpected error
 			return errorsx.Wrap(err)
 		}
 
 		// statusMetadata doesn't exist. Create it with version = 2 (the minimum version before versioning was introduced)
 		const minSchemaVersion = 2
-		log.Printf("didn't find %s. Creating this file with schemaVersion %d\n", statusMetadataFilePath, minSchemaVersion)
+		log.Printf("didn't find %s. Creating this file with schemaVersion %d\n", statusMetadataFileKey, minSchemaVersion)
 
-		status := &intelligentstore.Status{
+		return s.UpdateStatus(&intelligentstore.Status{
 			SchemaVersion: minSchemaVersion,
-		}
-
-		f, err := s.fs.Create(statusMetadataFilePath)
-		if err != nil {
-			return errorsx.Wrap(err)
-		}
-		defer f.Close()
-
-		err = json.NewEncoder(f).Encode(status)
-		if err != nil {
-			return errorsx.Wrap(err)
-		}
-	} else {
-		// file exists
-		f, err := s.fs.Open(statusMetadataFilePath)
-		if err != nil {
-			return errorsx.Wrap(err)
-		}
-		defer f.Close()
-
-		status := new(intelligentstore.Status)
-		err = json.NewDecoder(f).Decode(status)
-		if err != nil {
-			return errorsx.Wrap(err)
-		}
+		})
+	}
 
-		if status.SchemaVersion != RequiredVersion {
-			return errorsx.Errorf("required schema version: %d, but store schema version: %d. Run the %q command to update the schema", RequiredVersion, status.SchemaVersion, intelligentstore.RunMigrationsCommandName)
-		}
+	if status.SchemaVersion != RequiredVersion {
+		return errorsx.Errorf("required schema version: %d, but store schema version: %d. Run the %q command to update the schema", RequiredVersion, status.SchemaVersion, intelligentstore.RunMigrationsCommandName)
 	}
 
 	return nil
 }
 
 type StoreConnOptions struct {
+	// MaxOpenFiles is the most files opened at once when verifying. 0 uses the default.
 	MaxOpenFiles                uint
 	IgnoreMigrationsNotUpToDate bool
+	// KeepTempStoreContents stops the connection from clearing out the temp store, so that it can be inspected
+	KeepTempStoreContents bool
+	// TempStorePath is where the temp store is kept on the local filesystem.
+	// It is only used for stores that are not on the local filesystem; by default a directory in the system temp directory is used.
+	TempStorePath string
 }
 
 var defaultStoreConnOptions = &StoreConnOptions{
@@ -163,19 +171,58 @@ var defaultStoreConnOptions = &StoreConnOptions{
 }
 
 func newIntelligentStoreConnToExisting(pathToBase string, nowFunc NowProvider, fs gofs.Fs, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	err := checkStoreExists(pathToBase, fs)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := storagebackend.NewFilesystemBackend(fs, filepath.Join(pathToBase, BackupDataFolderName))
+	tempStorePath := filepath.Join(pathToBase, BackupDataFolderName, "tmp")
+
+	return newIntelligentStoreConn(pathToBase, backend, tempStorePath, nowFunc, fs, options)
+}
+
+// NewIntelligentStoreConnToBackend connects to a store kept in a storage backend other than the local filesystem, for example object storage.
+// Migrations can't be run on these stores, so they are always created with the latest schema version.
+func NewIntelligentStoreConnToBackend(backend storagebackend.Backend, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	return newIntelligentStoreConnToBackend(backend, prodNowProvider, gofs.NewOsFs(), options)
+}
+
+func newIntelligentStoreConnToBackend(backend storagebackend.Backend, nowFunc NowProvider, fs gofs.Fs, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	_, err := backend.Stat(bucketsInformationKey)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil, errorsx.Wrap(ErrStoreNotInitedYet, "backend", backend.String())
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	tempStorePath := filepath.Join(os.TempDir(), fmt.Sprintf("intelligent-store-%d", os.Getpid()))
+	if options != nil && options.TempStorePath != "" {
+		tempStorePath = options.TempStorePath
+	}
+
+	return newIntelligentStoreConn(backend.String(), backend, tempStorePath, nowFunc, fs, options)
+}
+
+func newIntelligentStoreConn(storeBasePath string, backend storagebackend.Backend, tempStorePath string, nowFunc NowProvider, fs gofs.Fs, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	var err errorsx.Error
+
 	if options == nil {
 		options = defaultStoreConnOptions
 	}
 
-	err := checkStoreExists(pathToBase, fs)
-	if err != nil {
-		return nil, err
+	maxOpenFiles := options.MaxOpenFiles
+	if maxOpenFiles == 0 {
+		maxOpenFiles = defaultStoreConnOptions.MaxOpenFiles
 	}
 
 	storeDAL := &IntelligentStoreDAL{
-		StoreBasePath: pathToBase,
+		StoreBasePath: storeBasePath,
 		nowProvider:   nowFunc,
 		fs:            fs,
+		backend:       backend,
+		packs:         &packStore{},
 	}
 
 	if !options.IgnoreMigrationsNotUpToDate {
@@ -185,12 +232,22 @@ func newIntelligentStoreConnToExisting(pathToBase string, nowFunc NowProvider, f
 		}
 	}
 
+	err = storeDAL.loadStoreSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	err = storeDAL.loadEncryptionState()
+	if err != nil {
+		return nil, err
+	}
+
 	storeDAL.BucketDAL = &BucketDAL{storeDAL}
-	storeDAL.RevisionDAL = NewRevisionDAL(storeDAL, storeDAL.BucketDAL, options.MaxOpenFiles)
-	storeDAL.TransactionDAL = &TransactionDAL{storeDAL, &revisionCSVWriter{}}
-	storeDAL.LockDAL = &LockDAL{storeDAL}
+	storeDAL.RevisionDAL = NewRevisionDAL(storeDAL, storeDAL.BucketDAL, maxOpenFiles)
+	storeDAL.TransactionDAL = newTransactionDAL(storeDAL, &revisionCSVWriter{})
+	storeDAL.LockDAL = newLockDAL(storeDAL)
 	storeDAL.UserDAL = &UserDAL{storeDAL}
-	storeDAL.TempStoreDAL, err = NewTempStoreDAL(pathToBase, fs)
+	storeDAL.TempStoreDAL, err = NewTempStoreDAL(tempStorePath, fs, !options.KeepTempStoreContents)
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +269,55 @@ func createStoreAndNewConn(pathToBase string, nowFunc NowProvider, fs gofs.Fs) (
 	return newIntelligentStoreConnToExisting(pathToBase, nowFunc, fs, nil)
 }
 
+// CreateIntelligentStoreInBackend creates a new store in an empty storage backend, and connects to it
+func CreateIntelligentStoreInBackend(backend storagebackend.Backend, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	return createStoreInBackendAndNewConn(backend, prodNowProvider, gofs.NewOsFs(), options)
+}
+
+func createStoreInBackendAndNewConn(backend storagebackend.Backend, nowFunc NowProvider, fs gofs.Fs, options *StoreConnOptions) (*IntelligentStoreDAL, errorsx.Error) {
+	objectInfos, err := backend.List("")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if len(objectInfos) != 0 {
+		return nil, errorsx.Errorf("%q is not empty. Creating a new store requires an empty location", backend.String())
+	}
+
+	status, marshalErr := json.Marshal(&intelligentstore.Status{
+		SchemaVersion: RequiredVersion,
+		HashAlgorithm: intelligentstore.DefaultHashAlgorithm,
+	})
+	if marshalErr != nil {
+		return nil, errorsx.Wrap(marshalErr)
+	}
+
+	objectReferenceIndex, marshalErr := json.Marshal(newObjectReferenceIndex())
+	if marshalErr != nil {
+		return nil, errorsx.Wrap(marshalErr)
+	}
+
+	files := []struct {
+		key      string
+		contents []byte
+	}{
+		{usersInformationKey, []byte("[]")},
+		{statusMetadataFileKey, status},
+		{objectReferenceIndexKey, objectReferenceIndex},
+		// written last, as it is what marks the store as created
+		{bucketsInformationKey, []byte("[]")},
+	}
+
+	for _, file := range files {
+		err = backend.PutIfAbsent(file.key, bytes.NewReader(file.contents))
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	return newIntelligentStoreConnToBackend(backend, nowFunc, fs, options)
+}
+
 func createStoreFoldersAndFiles(pathToBase string, fs gofs.Fs) errorsx.Error {
 	fileInfos, err := fs.ReadDir(pathToBase)
 	if nil != err {
@@ -245,6 +351,16 @@ func createStoreFoldersAndFiles(pathToBase string, fs gofs.Fs) errorsx.Error {
 		return errorsx.Wrap(err)
 	}
 
+	objectReferenceIndex, marshalErr := json.Marshal(newObjectReferenceIndex())
+	if nil != marshalErr {
+		return errorsx.Wrap(marshalErr)
+	}
+
+	err = fs.WriteFile(filepath.Join(pathToBase, BackupDataFolderName, filepath.FromSlash(objectReferenceIndexKey)), objectReferenceIndex, 0600)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
 	objectsFolderPath := filepath.Join(pathToBase, BackupDataFolderName, "objects")
 	err = fs.MkdirAll(objectsFolderPath, 0700)
 	if nil != err {
@@ -260,64 +376,135 @@ func createStoreFoldersAndFiles(pathToBase string, fs gofs.Fs) errorsx.Error {
 	return nil
 }
 
-func (s *IntelligentStoreDAL) getObjectPath(hash intelligentstore.Hash) string {
-	return filepath.Join(s.StoreBasePath, BackupDataFolderName, "objects", hash.FirstChunk(), hash.Remainder()+".gz")
+// StatFile stats the object with this hash. If the contents are stored as chunks, the chunk list is stat'ed instead.
+func (s *IntelligentStoreDAL) StatFile(hash intelligentstore.Hash) (*storagebackend.ObjectInfo, errorsx.Error) {
+	object, err := s.findObject(hash)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			chunkListInfo, chunkListErr := s.backend.Stat(s.getChunkListKey(hash))
+			if chunkListErr == nil {
+				return chunkListInfo, nil
+			}
+
+			object, err = s.findObjectAfterReloadingPacks(hash)
+		}
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	return object.Info, nil
 }
 
-func (s *IntelligentStoreDAL) StatFile(hash intelligentstore.Hash) (os.FileInfo, errorsx.Error) {
-	fileInfo, err := s.fs.Stat(s.getObjectPath(hash))
+// findObjectAfterReloadingPacks reloads the pack indexes, and then looks for the object again.
+// It is used when an object isn't found, in case it has been packed by another process since the pack indexes were loaded.
+func (s *IntelligentStoreDAL) findObjectAfterReloadingPacks(hash intelligentstore.Hash) (*storedObject, errorsx.Error) {
+	err := s.reloadPackIndexes()
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
-	return fileInfo, nil
+	return s.findObject(hash)
 }
 
+// GetGzippedObjectByHash gets the contents with this hash, gzipped.
+// Objects stored with gzip in an unencrypted store are returned as they are on disk; any other objects (and contents stored as chunks) are gzipped as they are read.
 func (s *IntelligentStoreDAL) GetGzippedObjectByHash(hash intelligentstore.Hash) (io.ReadCloser, errorsx.Error) {
-	file, err := s.fs.Open(s.getObjectPath(hash))
+	object, err := s.findObject(hash)
+	if err != nil && !os.IsNotExist(errorsx.Cause(err)) {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if err == nil && object.Codec == gzipObjectCodec && !s.isEncrypted {
+		file, err := s.openObjectFile(object)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+
+		return file, nil
+	}
+
+	contents, err := s.GetObjectByHash(hash)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
-	return file, nil
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		gzipWriter := gzip.NewWriter(pipeWriter)
+		_, copyErr := io.Copy(gzipWriter, contents)
+		if copyErr == nil {
+			copyErr = gzipWriter.Close()
+		}
+		contents.Close()
+		pipeWriter.CloseWithError(copyErr)
+	}()
+
+	return pipeReader, nil
 }
 
+// GetObjectByHash gets a reader for the (decoded) contents with this hash. Contents stored as chunks are reassembled transparently.
 func (s *IntelligentStoreDAL) GetObjectByHash(hash intelligentstore.Hash) (io.ReadCloser, errorsx.Error) {
+	object, err := s.findObject(hash)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			chunkedObject, chunkedObjectErr := s.getChunkedObject(hash)
+			if chunkedObjectErr == nil {
+				return chunkedObject, nil
+			}
+
+			object, err = s.findObjectAfterReloadingPacks(hash)
+		}
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	return s.openObject(object)
+}
+
+// openObject opens an object, decrypts it (in an encrypted store) and decodes it with the object's encoding
+func (s *IntelligentStoreDAL) openObject(object *storedObject) (io.ReadCloser, errorsx.Error) {
 	var err error
 
-	gzippedFile, err := s.GetGzippedObjectByHash(hash)
+	objectFile, err := s.openObjectFile(object)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	encodedFile, err := s.newDecryptingReadCloser(objectFile, string(object.Hash))
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 	defer func() {
 		if err != nil {
-			gzippedFile.Close()
+			encodedFile.Close()
 		}
 	}()
 
-	gzipReader, err := gzip.NewReader(gzippedFile)
+	decoder, err := object.Codec.NewReader(encodedFile)
 	if err != nil {
-		return nil, errorsx.Wrap(err)
+		return nil, errorsx.Wrap(err, "hash", object.Hash, "encoding", object.Codec.Encoding)
 	}
 
 	closeFunc := func() error {
-		gzipReaderErr := gzipReader.Close()
-		gzippedFileErr := gzippedFile.Close()
-		if gzipReaderErr != nil {
-			if gzippedFileErr != nil {
-				return fmt.Errorf("failed to close gzip reader and original file. Errors: gzip Reader error: %q. original file error: %q", gzipReaderErr, gzippedFileErr)
+		decoderErr := decoder.Close()
+		encodedFileErr := encodedFile.Close()
+		if decoderErr != nil {
+			if encodedFileErr != nil {
+				return fmt.Errorf("failed to close decoder and original file. Errors: decoder error: %q. original file error: %q", decoderErr, encodedFileErr)
 			}
-			return gzipReaderErr
+			return decoderErr
 		}
 
-		if gzippedFileErr != nil {
-			return gzipReaderErr
+		if encodedFileErr != nil {
+			return encodedFileErr
 		}
 
 		return nil
 	}
 
-	return readCloser{gzipReader, closeFunc}, nil
+	return readCloser{decoder, closeFunc}, nil
 }
 
 type readCloser struct {
@@ -363,16 +550,28 @@ func (s *IntelligentStoreDAL) Search(searchTerm string) ([]*intelligentstore.Sea
 	return searchResults, nil
 }
 
+// IsObjectPresent checks if the contents with this hash are in the store, either as a single object (in any encoding, as an object file or packed) or as chunks.
+// Objects added to the open pack count as present, so that they aren't added again.
 func (s *IntelligentStoreDAL) IsObjectPresent(hash intelligentstore.Hash) (bool, errorsx.Error) {
-	bucketsDirPath := filepath.Join(s.StoreBasePath, BackupDataFolderName, "objects")
+	_, err := s.findObject(hash)
+	if err == nil {
+		return true, nil
+	}
 
-	filePath := filepath.Join(bucketsDirPath, hash.FirstChunk(), hash.Remainder()+".gz")
-	_, err := s.fs.Stat(filePath)
-	if nil != err {
-		if os.IsNotExist(err) {
+	if !os.IsNotExist(errorsx.Cause(err)) {
+		return false, errorsx.Wrap(err)
+	}
+
+	if s.isInOpenPack(hash) {
+		return true, nil
+	}
+
+	_, statErr := s.backend.Stat(s.getChunkListKey(hash))
+	if statErr != nil {
+		if os.IsNotExist(errorsx.Cause(statErr)) {
 			return false, nil
 		}
-		return false, errorsx.Wrap(err, "hash", hash)
+		return false, errorsx.Wrap(statErr, "hash", hash)
 	}
 
 	return true, nil
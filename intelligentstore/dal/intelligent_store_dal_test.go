@@ -11,6 +11,8 @@ import (
 	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/goutil/gofs/mockfs"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend/s3test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -233,18 +235,23 @@ func Test_GetLockInformation(t *testing.T) {
 	tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
 	require.Nil(t, err)
 
-	lock, err = mockStore.Store.LockDAL.GetLockInformation()
+	lock, err = mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
 	require.Nil(t, err)
 	require.NotNil(t, lock)
 	assert.Equal(t, "lock from transaction. Bucket: 1 (docs), revision version: 946782245", lock.Text)
 
+	// transactions only lock their bucket
+	lock, err = mockStore.Store.LockDAL.GetLockInformation()
+	require.Nil(t, err)
+	require.Nil(t, lock)
+
 	_, err = tx.ProcessUploadHashesAndGetRequiredHashes(nil)
 	require.Nil(t, err)
 
 	err = mockStore.Store.TransactionDAL.Commit(tx)
 	require.Nil(t, err)
 
-	lock, err = mockStore.Store.LockDAL.GetLockInformation()
+	lock, err = mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
 	require.Nil(t, err)
 	require.Nil(t, lock)
 }
@@ -266,3 +273,100 @@ func Test_Search(t *testing.T) {
 	assert.Equal(t, bucket.BucketName, searchResults[0].Bucket.BucketName)
 	assert.Equal(t, revision.VersionTimestamp, searchResults[0].Revision.VersionTimestamp)
 }
+
+func Test_storeInS3Backend(t *testing.T) {
+	server := s3test.NewFakeS3Server("backups")
+	defer server.Close()
+
+	backend, err := storagebackend.NewS3Backend(storagebackend.S3Config{
+		Endpoint: server.URL,
+		Region:   server.Region,
+		Bucket:   server.Bucket,
+		Prefix:   "my-store",
+		Credentials: storagebackend.S3Credentials{
+			AccessKeyID:     server.AccessKeyID,
+			SecretAccessKey: server.SecretAccessKey,
+		},
+	})
+	require.Nil(t, err)
+
+	fs := mockfs.NewMockFs()
+	options := &StoreConnOptions{TempStorePath: "/tmp/intelligent-store"}
+
+	_, err = newIntelligentStoreConnToBackend(backend, MockNowProvider, fs, options)
+	assert.Equal(t, ErrStoreNotInitedYet, errorsx.Cause(err))
+
+	store, err := createStoreInBackendAndNewConn(backend, MockNowProvider, fs, options)
+	require.Nil(t, err)
+	assert.Equal(t, "s3://backups/my-store", store.StoreBasePath)
+
+	_, err = createStoreInBackendAndNewConn(backend, MockNowProvider, fs, options)
+	require.Error(t, err)
+
+	mockStore := &MockStore{store, fs}
+	bucket := mockStore.CreateBucket(t, "docs")
+	file := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	revision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{file})
+
+	t.Run("read back with a new connection", func(t *testing.T) {
+		store, err := newIntelligentStoreConnToBackend(backend, MockNowProvider, fs, options)
+		require.Nil(t, err)
+
+		bucket, err := store.BucketDAL.GetBucketByName("docs")
+		require.Nil(t, err)
+
+		latestRevision, err := store.BucketDAL.GetLatestRevision(bucket)
+		require.Nil(t, err)
+		assert.Equal(t, revision.VersionTimestamp, latestRevision.VersionTimestamp)
+
+		reader, getErr := store.RevisionDAL.GetFileContentsInRevision(bucket, latestRevision, "a.txt")
+		require.NoError(t, getErr)
+		defer reader.Close()
+
+		b, readErr := io.ReadAll(reader)
+		require.NoError(t, readErr)
+		assert.Equal(t, "a text", string(b))
+
+		result, err := store.RevisionDAL.VerifyRevision(bucket, latestRevision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Len(t, result.Failures, 0)
+	})
+
+	t.Run("fsck", func(t *testing.T) {
+		report, err := store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, int64(1), report.ManifestsChecked)
+		assert.Equal(t, int64(1), report.ObjectsChecked)
+		assert.Len(t, report.Problems, 0)
+	})
+
+	t.Run("garbage collection", func(t *testing.T) {
+		orphanHash, hashErr := intelligentstore.NewHash(bytes.NewReader([]byte("orphan")))
+		require.NoError(t, hashErr)
+		// contents this small are stored raw, as compressing them doesn't make them smaller
+		require.Nil(t, store.writeObject(bytes.NewReader([]byte("orphan")), orphanHash))
+
+		result, err := store.GarbageCollect(GarbageCollectionOptions{Quarantine: true})
+		require.Nil(t, err)
+		assert.Equal(t, []intelligentstore.Hash{orphanHash}, result.UnreferencedObjects)
+
+		isPresent, err := store.IsObjectPresent(orphanHash)
+		require.Nil(t, err)
+		assert.False(t, isPresent)
+		assert.Contains(t, server.ObjectNames(), "my-store/"+store.getQuarantineKey(orphanHash, noneObjectCodec.FileExtension))
+
+		isPresent, err = store.IsObjectPresent(file.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.True(t, isPresent)
+	})
+
+	t.Run("store lock", func(t *testing.T) {
+		_, err := store.LockDAL.acquireStoreLock("first")
+		require.Nil(t, err)
+
+		_, err = store.LockDAL.acquireStoreLock("second")
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, store.LockDAL.removeStoreLock())
+	})
+}
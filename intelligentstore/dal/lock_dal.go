@@ -1,28 +1,124 @@
 package dal
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
 )
 
+// There are 3 kinds of locks, all kept under "locks/":
+//   - the store lock, an exclusive lock on the whole store, for maintenance that changes or removes data other processes could be using (pruning, garbage collection, repacking, migrations, fsck repairs)
+//   - bucket locks, one per bucket, taken by transactions. Transactions on different buckets can run at the same time
+//   - shared locks, taken by readers that need the objects to stay where they are while they read them
+//
+// Every lock is created atomically (with PutIfAbsent, or at a key no other process uses), and only then are the locks it conflicts with checked for.
+// If there is a conflicting lock, the new lock is removed again. That way, two processes taking conflicting locks at the same time can both fail, but never both succeed.
+//
+// While a process holds a lock (apart from the short-lived object reference index lock), it refreshes the lock's heartbeat time.
+// A lock is stale if it was taken on this host by a process that is no longer running, or if its heartbeat hasn't been refreshed for lockHeartbeatExpiry.
+// Stale locks are removed when a conflicting lock is taken. A stale lock is read again just before it is removed, and is kept if it has changed since it was found to be stale.
+// A holder gives up it's lock, rather than refreshing it, once it's heartbeat is close to expiring, so that it can't overwrite a lock another process took after removing it as stale.
+// Once a holder finds it has lost a lock, it stops writing under it (see ErrLockLost), and when it is done, it only removes the lock if it is still it's own.
 type LockDAL struct {
 	storeDAL *IntelligentStoreDAL
+	mu       sync.Mutex
+	// heartbeats are the heartbeats of the locks held through this LockDAL, by key
+	heartbeats map[string]*lockHeartbeat
 }
 
-// GetLockInformation gets the information about the current Lock on the Store, if any.
+func newLockDAL(storeDAL *IntelligentStoreDAL) *LockDAL {
+	return &LockDAL{
+		storeDAL:   storeDAL,
+		heartbeats: make(map[string]*lockHeartbeat),
+	}
+}
+
+const (
+	storeLockKey           = "locks/store_lock.json"
+	bucketLocksKeyPrefix   = "locks/buckets/"
+	sharedLocksKeyPrefix   = "locks/shared/"
+	objectReferenceLockKey = "locks/object_reference_index.json"
+)
+
+const (
+	// objectReferenceLockTimeout is how long to wait for another process to finish updating the object reference index
+	objectReferenceLockTimeout = time.Minute
+	// lockHeartbeatInterval is how often the heartbeat time of a held lock is refreshed
+	lockHeartbeatInterval = time.Minute
+	// lockHeartbeatExpiry is how long after its last heartbeat a lock is stale
+	lockHeartbeatExpiry = 10 * time.Minute
+)
+
+var (
+	ErrLockAlreadyTaken = errors.New("lock already taken")
+	ErrLockNotFound     = errors.New("lock not found")
+	// ErrLockLost is returned when a lock this process took is no longer held: it was force released, or it's heartbeat couldn't be refreshed, so it may have been removed as stale by another process
+	ErrLockLost = errors.New("the lock is no longer held by this process")
+)
+
+// localHostname is the hostname of this machine, recorded in the locks it takes
+var localHostname = getLocalHostname()
+
+func getLocalHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return hostname
+}
+
+type LockType string
+
+const (
+	LockTypeStore                LockType = "store"
+	LockTypeBucket               LockType = "bucket"
+	LockTypeShared               LockType = "shared"
+	LockTypeObjectReferenceIndex LockType = "object reference index"
+)
+
+// LockInformation is a lock in the store
+type LockInformation struct {
+	Key  string   `json:"key"`
+	Type LockType `json:"type"`
+	// BucketName is the name of the bucket a bucket lock is for. Empty for other types of locks.
+	BucketName string `json:"bucketName,omitempty"`
+	*StoreLock
+	IsStale bool `json:"isStale"`
+}
+
+// GetLockInformation gets the information about the current store lock (the exclusive lock on the whole Store), if any.
 // It returns
 // - (*StoreLock, nil) if there is a lock
 // - (nil, nil) if there is currently no lock
 // - (nil, error) for any error
 func (s *LockDAL) GetLockInformation() (*StoreLock, error) {
-	file, err := s.storeDAL.fs.Open(s.getLockFilePath())
+	return s.readLock(storeLockKey)
+}
+
+// GetBucketLockInformation gets the information about the current lock on the bucket, if any. It returns the same as GetLockInformation.
+func (s *LockDAL) GetBucketLockInformation(bucket *intelligentstore.Bucket) (*StoreLock, error) {
+	return s.readLock(getBucketLockKey(bucket))
+}
+
+func (s *LockDAL) readLock(key string) (*StoreLock, error) {
+	file, err := s.storeDAL.backend.Get(key)
 	if nil != err {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(errorsx.Cause(err)) {
 			return nil, nil
 		}
 		return nil, err
@@ -30,60 +126,577 @@ func (s *LockDAL) GetLockInformation() (*StoreLock, error) {
 	defer file.Close()
 
 	var storeLock *StoreLock
-	err = json.NewDecoder(file).Decode(&storeLock)
-	if nil != err {
-		return nil, err
+	decodeErr := json.NewDecoder(file).Decode(&storeLock)
+	if nil != decodeErr {
+		return nil, decodeErr
 	}
 
 	return storeLock, nil
 }
 
-var ErrLockAlreadyTaken = errors.New("lock already taken")
+func getBucketLockKey(bucket *intelligentstore.Bucket) string {
+	return bucketLocksKeyPrefix + strconv.Itoa(bucket.ID) + ".json"
+}
 
-func (s *LockDAL) acquireStoreLock(text string) (*StoreLock, errorsx.Error) {
-	_, err := s.storeDAL.fs.Stat(s.getLockFilePath())
-	if nil == err {
-		return nil, errorsx.Wrap(ErrLockAlreadyTaken)
-	}
-	if !os.IsNotExist(err) {
+// ListLocks lists every lock in the store, including stale locks
+func (s *LockDAL) ListLocks() ([]*LockInformation, errorsx.Error) {
+	objectInfos, err := s.storeDAL.backend.List("locks/")
+	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
 
-	//lockFile, err := s.fs.OpenFile(s.getLockFilePath(), os.O_CREATE, 0600)
-	lockFile, err := s.storeDAL.fs.OpenFile(s.getLockFilePath(), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	buckets, err := s.storeDAL.BucketDAL.GetAllBuckets()
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
-	defer lockFile.Close()
 
-	lock := &StoreLock{
-		time.Now(),
-		os.Getpid(),
-		text,
+	bucketNamesByID := make(map[int]string)
+	for _, bucket := range buckets {
+		bucketNamesByID[bucket.ID] = bucket.BucketName
+	}
+
+	now := time.Now()
+	locks := []*LockInformation{}
+	for _, objectInfo := range objectInfos {
+		lock, err := s.readLock(objectInfo.Key)
+		if nil != err {
+			return nil, errorsx.Wrap(err, "key", objectInfo.Key)
+		}
+
+		if lock == nil {
+			// released since it was listed
+			continue
+		}
+
+		lockInformation := &LockInformation{
+			Key:       objectInfo.Key,
+			Type:      getLockType(objectInfo.Key),
+			StoreLock: lock,
+			IsStale:   lock.IsStale(now),
+		}
+
+		if lockInformation.Type == LockTypeBucket {
+			bucketID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(objectInfo.Key, bucketLocksKeyPrefix), ".json"))
+			if nil == err {
+				lockInformation.BucketName = bucketNamesByID[bucketID]
+			}
+		}
+
+		locks = append(locks, lockInformation)
+	}
+
+	return locks, nil
+}
+
+func getLockType(key string) LockType {
+	switch {
+	case key == storeLockKey:
+		return LockTypeStore
+	case key == objectReferenceLockKey:
+		return LockTypeObjectReferenceIndex
+	case strings.HasPrefix(key, bucketLocksKeyPrefix):
+		return LockTypeBucket
+	default:
+		return LockTypeShared
+	}
+}
+
+// ForceReleaseLock removes the lock at the key (as listed by ListLocks), whether or not it is stale.
+// If the process holding it is still running, it can carry on writing until it's next heartbeat finds the lock gone, so this should only be used for locks of processes that are known to be gone.
+func (s *LockDAL) ForceReleaseLock(key string) errorsx.Error {
+	if !strings.HasPrefix(key, "locks/") || !strings.HasSuffix(key, ".json") || strings.Contains(key, "..") {
+		return errorsx.Errorf("%q is not the key of a lock", key)
+	}
+
+	lock, err := s.readLock(key)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	if lock == nil {
+		return errorsx.Wrap(ErrLockNotFound, "key", key)
+	}
+
+	deleteErr := s.storeDAL.backend.Delete(key)
+	if nil != deleteErr {
+		return errorsx.Wrap(deleteErr)
+	}
+
+	return nil
+}
+
+func newStoreLock(text string) *StoreLock {
+	now := time.Now()
+	return &StoreLock{
+		AcquisitionTime: now,
+		Pid:             os.Getpid(),
+		Hostname:        localHostname,
+		HeartbeatTime:   now,
+		Text:            text,
+	}
+}
+
+// createLock creates the lock at the key, if there is no lock there yet. A stale lock at the key is removed first.
+func (s *LockDAL) createLock(key string, lock *StoreLock) errorsx.Error {
+	err := s.putLockIfAbsent(key, lock)
+	if nil == err || errorsx.Cause(err) != ErrLockAlreadyTaken {
+		return err
+	}
+
+	removed, removeErr := s.removeLockIfStale(key)
+	if nil != removeErr {
+		return errorsx.Wrap(removeErr)
+	}
+
+	if !removed {
+		return err
+	}
+
+	return s.putLockIfAbsent(key, lock)
+}
+
+func (s *LockDAL) putLockIfAbsent(key string, lock *StoreLock) errorsx.Error {
+	b, err := json.Marshal(lock)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	putErr := s.storeDAL.backend.PutIfAbsent(key, bytes.NewReader(b))
+	if nil != putErr {
+		if errorsx.Cause(putErr) == storagebackend.ErrAlreadyExists {
+			return errorsx.Wrap(ErrLockAlreadyTaken, "key", key)
+		}
+		return errorsx.Wrap(putErr)
+	}
+
+	return nil
+}
+
+// removeLockIfStale removes the lock at the key if it is stale. It returns true if there is no longer a lock at the key.
+func (s *LockDAL) removeLockIfStale(key string) (bool, errorsx.Error) {
+	lock, err := s.readLock(key)
+	if nil != err {
+		return false, errorsx.Wrap(err)
+	}
+
+	if lock == nil {
+		return true, nil
+	}
+
+	if !lock.IsStale(time.Now()) {
+		return false, nil
+	}
+
+	log.Printf("removing stale lock %q, acquired at %s by process %d on host %q (last heartbeat: %s). Lock text: %q\n", key, lock.AcquisitionTime, lock.Pid, lock.Hostname, lock.HeartbeatTime, lock.Text)
+
+	return s.removeLockIfUnchanged(key, lock)
+}
+
+// removeLockIfUnchanged removes the lock at the key, if it is still the lock that was read.
+// The lock is read again just before it is removed, so that a lock whose heartbeat was refreshed, or that was removed and taken again by another process, since it was read isn't removed.
+// It returns true if there is no longer a lock at the key.
+func (s *LockDAL) removeLockIfUnchanged(key string, lock *StoreLock) (bool, errorsx.Error) {
+	currentLock, err := s.readLock(key)
+	if nil != err {
+		return false, errorsx.Wrap(err)
+	}
+
+	if currentLock == nil {
+		return true, nil
+	}
+
+	if !currentLock.isSameLock(lock) {
+		log.Printf("not removing lock %q, as it has changed since it was found to be stale\n", key)
+		return false, nil
+	}
+
+	deleteErr := s.storeDAL.backend.Delete(key)
+	if nil != deleteErr {
+		return false, errorsx.Wrap(deleteErr)
 	}
 
-	err = json.NewEncoder(lockFile).Encode(lock)
+	return true, nil
+}
+
+// removeLockAfterConflict removes a lock that was just taken, because a conflicting lock was found, and returns the error for the conflict
+func (s *LockDAL) removeLockAfterConflict(key, conflictingLockKey string) errorsx.Error {
+	removeErr := s.removeHeldLock(key)
+	if removeErr != nil {
+		return errorsx.Wrap(removeErr, "detail", "failed to remove lock after finding a conflicting lock", "conflictingLock", conflictingLockKey)
+	}
+
+	return errorsx.Wrap(ErrLockAlreadyTaken, "conflictingLock", conflictingLockKey)
+}
+
+// acquireStoreLock takes the exclusive lock on the whole store. It can only be taken when no other lock (store, bucket or shared) is held.
+func (s *LockDAL) acquireStoreLock(text string) (*StoreLock, errorsx.Error) {
+	lock := newStoreLock(text)
+
+	err := s.createLock(storeLockKey, lock)
 	if nil != err {
 		return nil, errorsx.Wrap(err)
 	}
 
+	s.startHeartbeat(storeLockKey, lock)
+
+	for _, prefix := range []string{bucketLocksKeyPrefix, sharedLocksKeyPrefix} {
+		objectInfos, err := s.storeDAL.backend.List(prefix)
+		if nil != err {
+			removeErr := s.removeStoreLock()
+			if removeErr != nil {
+				return nil, errorsx.Wrap(removeErr, "listLocksError", err)
+			}
+			return nil, errorsx.Wrap(err)
+		}
+
+		for _, objectInfo := range objectInfos {
+			removed, err := s.removeLockIfStale(objectInfo.Key)
+			if nil != err {
+				removeErr := s.removeStoreLock()
+				if removeErr != nil {
+					return nil, errorsx.Wrap(removeErr, "removeStaleLockError", err)
+				}
+				return nil, errorsx.Wrap(err)
+			}
+
+			if !removed {
+				return nil, s.removeLockAfterConflict(storeLockKey, objectInfo.Key)
+			}
+		}
+	}
+
 	return lock, nil
 }
 
 func (s *LockDAL) removeStoreLock() errorsx.Error {
-	err := s.storeDAL.fs.RemoveAll(s.getLockFilePath())
+	return s.removeHeldLock(storeLockKey)
+}
+
+// checkStoreLockHeld checks the store lock taken by this process is still held. It is checked before maintenance writes to or deletes from the store.
+func (s *LockDAL) checkStoreLockHeld() errorsx.Error {
+	return s.checkLockHeld(storeLockKey)
+}
+
+// acquireBucketLock takes the lock on a bucket, for a transaction. It can't be taken while the store lock is held.
+func (s *LockDAL) acquireBucketLock(bucket *intelligentstore.Bucket, text string) (*StoreLock, errorsx.Error) {
+	lock := newStoreLock(text)
+	key := getBucketLockKey(bucket)
+
+	err := s.createLock(key, lock)
+	if nil != err {
+		return nil, errorsx.Wrap(err, "bucket", bucket.BucketName)
+	}
+
+	s.startHeartbeat(key, lock)
+
+	err = s.checkNoStoreLock(key)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return lock, nil
+}
+
+func (s *LockDAL) removeBucketLock(bucket *intelligentstore.Bucket) errorsx.Error {
+	return s.removeHeldLock(getBucketLockKey(bucket))
+}
+
+// checkBucketLockHeld checks the lock on the bucket taken by this process is still held. It is checked before a transaction writes it's revision.
+func (s *LockDAL) checkBucketLockHeld(bucket *intelligentstore.Bucket) errorsx.Error {
+	return errorsx.Wrap(s.checkLockHeld(getBucketLockKey(bucket)), "bucket", bucket.BucketName)
+}
+
+// checkNoStoreLock checks the store lock isn't held (or is stale, in which case it is removed), after the lock at the key has been taken.
+// If it is held, the lock at the key is removed again.
+func (s *LockDAL) checkNoStoreLock(key string) errorsx.Error {
+	removed, err := s.removeLockIfStale(storeLockKey)
+	if err != nil {
+		removeErr := s.removeHeldLock(key)
+		if removeErr != nil {
+			return errorsx.Wrap(removeErr, "checkStoreLockError", err)
+		}
+		return errorsx.Wrap(err)
+	}
+
+	if !removed {
+		return s.removeLockAfterConflict(key, storeLockKey)
+	}
+
+	return nil
+}
+
+// SharedLock is a lock held by a reader. Any amount of shared locks can be held at the same time, but the store lock can't be taken while any are held.
+type SharedLock struct {
+	*StoreLock
+	key string
+}
+
+// AcquireSharedLock takes a shared lock, so that maintenance that removes or moves objects (like garbage collection) can't run while the store is being read.
+// It can't be taken while the store lock is held. It must be released with ReleaseSharedLock.
+func (s *LockDAL) AcquireSharedLock(text string) (*SharedLock, errorsx.Error) {
+	b := make([]byte, 8)
+	_, randErr := rand.Read(b)
+	if randErr != nil {
+		return nil, errorsx.Wrap(randErr)
+	}
+
+	lock := &SharedLock{
+		StoreLock: newStoreLock(text),
+		key:       fmt.Sprintf("%s%d-%s.json", sharedLocksKeyPrefix, os.Getpid(), hex.EncodeToString(b)),
+	}
+
+	err := s.createLock(lock.key, lock.StoreLock)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	s.startHeartbeat(lock.key, lock.StoreLock)
+
+	err = s.checkNoStoreLock(lock.key)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return lock, nil
+}
+
+// ReleaseSharedLock releases a shared lock taken with AcquireSharedLock
+func (s *LockDAL) ReleaseSharedLock(lock *SharedLock) errorsx.Error {
+	return s.removeHeldLock(lock.key)
+}
+
+// withObjectReferenceIndexLock runs fn while holding the lock on the object reference index.
+// Transactions on different buckets can commit at the same time, so the index is locked while it is read, updated and written back.
+// If another process holds the lock, it is waited for, since it is only held for as long as an update takes.
+func (s *LockDAL) withObjectReferenceIndexLock(fn func() errorsx.Error) errorsx.Error {
+	lock := newStoreLock("lock from updating the object reference index")
+	deadline := time.Now().Add(objectReferenceLockTimeout)
+
+	for {
+		err := s.createLock(objectReferenceLockKey, lock)
+		if err == nil {
+			break
+		}
+
+		if errorsx.Cause(err) != ErrLockAlreadyTaken || time.Now().After(deadline) {
+			return errorsx.Wrap(err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	fnErr := fn()
+
+	removeErr := s.storeDAL.backend.Delete(objectReferenceLockKey)
+	if fnErr != nil {
+		return errorsx.Wrap(fnErr)
+	}
+
+	if removeErr != nil {
+		return errorsx.Wrap(removeErr)
+	}
+
+	return nil
+}
+
+// isProcessRunning checks whether a process with the given pid is running on this machine
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
 	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	if err == nil {
+		return true
+	}
+
+	// the process exists, but belongs to another user
+	return errors.Is(err, syscall.EPERM)
+}
+
+// lockHeartbeat refreshes the heartbeat time of a held lock, until it is stopped
+type lockHeartbeat struct {
+	stop chan struct{}
+	done chan struct{}
+	// holder is the lock as it was taken, to tell if the lock in the store is still this process's lock
+	holder StoreLock
+	// lost is set (while holding the LockDAL's mutex) once the heartbeat finds the lock is no longer held, or hasn't been able to refresh it for so long that it may have been removed as stale
+	lost bool
+}
+
+// startHeartbeat registers a lock this process has just taken, and starts refreshing it's heartbeat
+func (s *LockDAL) startHeartbeat(key string, lock *StoreLock) {
+	heartbeat := &lockHeartbeat{
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		holder: *lock,
+	}
+
+	s.mu.Lock()
+	s.heartbeats[key] = heartbeat
+	s.mu.Unlock()
+
+	// the goroutine has it's own copy of the lock, so the caller's copy isn't written to
+	heartbeatLock := *lock
+
+	go func() {
+		defer close(heartbeat.done)
+
+		ticker := time.NewTicker(lockHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-heartbeat.stop:
+				return
+			case <-ticker.C:
+				isStillHeld, err := s.refreshHeartbeat(key, &heartbeatLock)
+				if err != nil {
+					log.Printf("failed to refresh the heartbeat of lock %q. Error: %q\n", key, err)
+					if time.Since(heartbeatLock.HeartbeatTime) <= lockHeartbeatExpiry-lockHeartbeatInterval {
+						continue
+					}
+					// another process may remove the lock as stale soon, so it is given up now
+					isStillHeld = false
+				}
+
+				if !isStillHeld {
+					log.Printf("lock %q is no longer held (it may have been force released, or removed as stale). Stopping it's heartbeat\n", key)
+					s.mu.Lock()
+					heartbeat.lost = true
+					s.mu.Unlock()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops the heartbeat of the lock at the key, if it has one, and waits for it to stop, so that it doesn't write the lock again after it has been removed.
+// It returns the heartbeat, or nil if this process doesn't hold a lock at the key.
+func (s *LockDAL) stopHeartbeat(key string) *lockHeartbeat {
+	s.mu.Lock()
+	heartbeat, ok := s.heartbeats[key]
+	delete(s.heartbeats, key)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	close(heartbeat.stop)
+	<-heartbeat.done
+
+	return heartbeat
+}
+
+// removeHeldLock stops the heartbeat of a lock this process holds, and removes the lock.
+// The lock is read first, and only removed if it is still this process's lock, so that a lock another process took after this process lost it isn't removed.
+func (s *LockDAL) removeHeldLock(key string) errorsx.Error {
+	heartbeat := s.stopHeartbeat(key)
+	if heartbeat == nil {
+		return errorsx.Errorf("lock %q is not held by this process", key)
+	}
+
+	currentLock, err := s.readLock(key)
+	if nil != err {
 		return errorsx.Wrap(err)
 	}
+
+	if currentLock == nil || !currentLock.isSameHolder(&heartbeat.holder) {
+		log.Printf("lock %q was lost before it was removed, so it is left in place\n", key)
+		return nil
+	}
+
+	deleteErr := s.storeDAL.backend.Delete(key)
+	if nil != deleteErr {
+		return errorsx.Wrap(deleteErr)
+	}
+
+	return nil
+}
+
+// checkLockHeld checks this process still holds the lock at the key. If the heartbeat has found the lock is lost, the cause of the returned error is ErrLockLost.
+// It only checks what the heartbeat last found, so it is cheap enough to check before every write.
+func (s *LockDAL) checkLockHeld(key string) errorsx.Error {
+	s.mu.Lock()
+	heartbeat, ok := s.heartbeats[key]
+	isLost := ok && heartbeat.lost
+	s.mu.Unlock()
+
+	if !ok || isLost {
+		return errorsx.Wrap(ErrLockLost, "key", key)
+	}
+
 	return nil
 }
 
-func (s *LockDAL) getLockFilePath() string {
-	return filepath.Join(s.storeDAL.StoreBasePath, ".backup_data", "locks", "store_lock.json")
+// refreshHeartbeat writes the lock again, with the heartbeat time set to now. It returns false if the lock at the key is no longer this lock.
+// A lock whose heartbeat is about to expire isn't written again, as another process may be removing it as stale, and writing it could overwrite a lock the other process has taken since.
+// The lock is given up instead.
+func (s *LockDAL) refreshHeartbeat(key string, lock *StoreLock) (bool, errorsx.Error) {
+	currentLock, err := s.readLock(key)
+	if err != nil {
+		return false, errorsx.Wrap(err)
+	}
+
+	if currentLock == nil || !currentLock.isSameHolder(lock) {
+		return false, nil
+	}
+
+	now := time.Now()
+	if !currentLock.HeartbeatTime.IsZero() && now.Sub(currentLock.HeartbeatTime) > lockHeartbeatExpiry-lockHeartbeatInterval {
+		log.Printf("the heartbeat of lock %q was last refreshed at %s, so it may be removed as stale by another process. Not refreshing it\n", key, currentLock.HeartbeatTime)
+		return false, nil
+	}
+
+	lock.HeartbeatTime = now
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return false, errorsx.Wrap(err)
+	}
+
+	putErr := s.storeDAL.backend.Put(key, bytes.NewReader(b))
+	if putErr != nil {
+		return false, errorsx.Wrap(putErr)
+	}
+
+	return true, nil
 }
 
 type StoreLock struct {
 	AcquisitionTime time.Time `json:"acquisitionTime"`
 	Pid             int       `json:"pid"`
-	Text            string    `json:"text"`
+	// Hostname is the host the lock was taken on. Empty for locks taken before it was recorded.
+	Hostname string `json:"hostname"`
+	// HeartbeatTime is refreshed every lockHeartbeatInterval while the lock is held. Zero for locks taken before heartbeats were introduced.
+	HeartbeatTime time.Time `json:"heartbeatTime"`
+	Text          string    `json:"text"`
+}
+
+// IsStale returns true if the process that took the lock is gone: it ran on this host and is no longer running, or it hasn't refreshed the heartbeat of the lock for lockHeartbeatExpiry.
+// Locks without a hostname are assumed to be from this host.
+func (l *StoreLock) IsStale(now time.Time) bool {
+	if l.Hostname == "" || l.Hostname == localHostname {
+		if !isProcessRunning(l.Pid) {
+			return true
+		}
+	}
+
+	if !l.HeartbeatTime.IsZero() && now.Sub(l.HeartbeatTime) > lockHeartbeatExpiry {
+		return true
+	}
+
+	return false
+}
+
+func (l *StoreLock) isSameHolder(other *StoreLock) bool {
+	return l.Pid == other.Pid && l.Hostname == other.Hostname && l.AcquisitionTime.Equal(other.AcquisitionTime)
+}
+
+// isSameLock returns true if the locks are held by the same holder, and have the same heartbeat time
+func (l *StoreLock) isSameLock(other *StoreLock) bool {
+	return l.isSameHolder(other) && l.HeartbeatTime.Equal(other.HeartbeatTime)
 }
@@ -0,0 +1,340 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Locks(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	docsBucket := mockStore.CreateBucket(t, "docs")
+	photosBucket := mockStore.CreateBucket(t, "photos")
+
+	t.Run("transactions on different buckets can run at the same time", func(t *testing.T) {
+		docsTx, err := mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, nil)
+		require.Nil(t, err)
+
+		photosTx, err := mockStore.Store.TransactionDAL.CreateTransaction(photosBucket, nil)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, nil)
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(docsTx))
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(photosTx))
+	})
+
+	t.Run("the store lock can't be taken while a transaction is open", func(t *testing.T) {
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, nil)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.LockDAL.acquireStoreLock("maintenance")
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+
+		// the failed attempt doesn't leave the store lock behind
+		lock, lockErr := mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+	})
+
+	t.Run("transactions and shared locks can't be started while the store lock is held", func(t *testing.T) {
+		_, err := mockStore.Store.LockDAL.acquireStoreLock("maintenance")
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, nil)
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		_, err = mockStore.Store.LockDAL.AcquireSharedLock("reader")
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.LockDAL.removeStoreLock())
+
+		// the failed attempts don't leave their locks behind
+		lock, lockErr := mockStore.Store.LockDAL.GetBucketLockInformation(docsBucket)
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+
+		sharedLocks, listErr := mockStore.Store.backend.List(sharedLocksKeyPrefix)
+		require.Nil(t, listErr)
+		assert.Len(t, sharedLocks, 0)
+	})
+
+	t.Run("shared locks", func(t *testing.T) {
+		firstLock, err := mockStore.Store.LockDAL.AcquireSharedLock("first reader")
+		require.Nil(t, err)
+
+		secondLock, err := mockStore.Store.LockDAL.AcquireSharedLock("second reader")
+		require.Nil(t, err)
+
+		// transactions can run while the store is being read
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, nil)
+		require.Nil(t, err)
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+
+		require.Nil(t, mockStore.Store.LockDAL.ReleaseSharedLock(firstLock))
+
+		_, err = mockStore.Store.LockDAL.acquireStoreLock("maintenance")
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.LockDAL.ReleaseSharedLock(secondLock))
+
+		_, err = mockStore.Store.LockDAL.acquireStoreLock("maintenance")
+		require.Nil(t, err)
+		require.Nil(t, mockStore.Store.LockDAL.removeStoreLock())
+	})
+
+	t.Run("rolling back a transaction keeps the objects of other open transactions", func(t *testing.T) {
+		// objects of transactions in the same process are added to the same open pack
+		require.Nil(t, mockStore.Store.SetPackingSettings(&intelligentstore.PackingSettings{MaxObjectSize: 1024}))
+
+		file := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", MockNowProvider(), FileMode600, []byte("file a"))
+
+		docsTx, err := mockStore.Store.TransactionDAL.CreateTransaction(docsBucket, []*intelligentstore.FileInfo{file.Descriptor.GetFileInfo()})
+		require.Nil(t, err)
+
+		photosTx, err := mockStore.Store.TransactionDAL.CreateTransaction(photosBucket, nil)
+		require.Nil(t, err)
+
+		hashes, err := docsTx.ProcessUploadHashesAndGetRequiredHashes([]*intelligentstore.RelativePathWithHash{
+			intelligentstore.NewRelativePathWithHash(file.Descriptor.RelativePath, file.Descriptor.Hash),
+		})
+		require.Nil(t, err)
+		require.Len(t, hashes, 1)
+
+		require.Nil(t, mockStore.Store.TransactionDAL.BackupFile(docsTx, bytes.NewReader(file.Contents)))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(photosTx))
+		require.Nil(t, mockStore.Store.TransactionDAL.Commit(docsTx))
+
+		contents, contentsErr := mockStore.Store.RevisionDAL.GetFileContentsInRevision(docsBucket, docsTx.Revision, "a.txt")
+		require.NoError(t, contentsErr)
+		defer contents.Close()
+
+		b, readErr := ioutil.ReadAll(contents)
+		require.NoError(t, readErr)
+		assert.Equal(t, "file a", string(b))
+	})
+}
+
+func Test_StoreLock_IsStale(t *testing.T) {
+	now := time.Now()
+	deadPid := 999999999
+
+	testCases := []struct {
+		name    string
+		lock    *StoreLock
+		isStale bool
+	}{
+		{"running process on this host", &StoreLock{Pid: os.Getpid(), Hostname: localHostname, HeartbeatTime: now}, false},
+		{"stopped process on this host", &StoreLock{Pid: deadPid, Hostname: localHostname, HeartbeatTime: now}, true},
+		{"process on another host with a recent heartbeat", &StoreLock{Pid: deadPid, Hostname: "other-host", HeartbeatTime: now.Add(-time.Minute)}, false},
+		{"process on another host with an expired heartbeat", &StoreLock{Pid: deadPid, Hostname: "other-host", HeartbeatTime: now.Add(-time.Hour)}, true},
+		{"running process on this host with an expired heartbeat", &StoreLock{Pid: os.Getpid(), Hostname: localHostname, HeartbeatTime: now.Add(-time.Hour)}, true},
+		{"lock from before hostnames and heartbeats, from a running process", &StoreLock{Pid: os.Getpid()}, false},
+		{"lock from before hostnames and heartbeats, from a stopped process", &StoreLock{Pid: deadPid}, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.isStale, testCase.lock.IsStale(now))
+		})
+	}
+}
+
+func Test_StaleLocks(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	writeLock := func(t *testing.T, key string, lock *StoreLock) {
+		b, err := json.Marshal(lock)
+		require.Nil(t, err)
+		require.Nil(t, mockStore.Store.backend.Put(key, bytes.NewReader(b)))
+	}
+
+	t.Run("stale locks are removed when a conflicting lock is taken", func(t *testing.T) {
+		writeLock(t, getBucketLockKey(bucket), &StoreLock{Pid: 999999999, Hostname: localHostname, HeartbeatTime: time.Now(), Text: "crashed backup"})
+		writeLock(t, storeLockKey, &StoreLock{Pid: 999999999, Hostname: "other-host", HeartbeatTime: time.Now().Add(-time.Hour), Text: "crashed prune"})
+
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		require.Nil(t, err)
+
+		lock, lockErr := mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
+		require.Nil(t, lockErr)
+		assert.Equal(t, os.Getpid(), lock.Pid)
+		assert.Equal(t, localHostname, lock.Hostname)
+
+		lock, lockErr = mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+	})
+
+	t.Run("locks from running processes on other hosts are kept", func(t *testing.T) {
+		writeLock(t, storeLockKey, &StoreLock{Pid: 999999999, Hostname: "other-host", HeartbeatTime: time.Now(), Text: "prune on another host"})
+
+		_, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		assert.Equal(t, ErrLockAlreadyTaken, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(storeLockKey))
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		lock, err := mockStore.Store.LockDAL.acquireBucketLock(bucket, "backup")
+		require.Nil(t, err)
+
+		heartbeatLock := *lock
+		heartbeatLock.HeartbeatTime = time.Time{}
+		isStillHeld, err := mockStore.Store.LockDAL.refreshHeartbeat(getBucketLockKey(bucket), &heartbeatLock)
+		require.Nil(t, err)
+		assert.True(t, isStillHeld)
+
+		storedLock, lockErr := mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
+		require.Nil(t, lockErr)
+		assert.False(t, storedLock.HeartbeatTime.IsZero())
+		assert.True(t, storedLock.AcquisitionTime.Equal(lock.AcquisitionTime))
+
+		// once the lock has been force released, the heartbeat doesn't write it again
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket)))
+
+		isStillHeld, err = mockStore.Store.LockDAL.refreshHeartbeat(getBucketLockKey(bucket), &heartbeatLock)
+		require.Nil(t, err)
+		assert.False(t, isStillHeld)
+
+		storedLock, lockErr = mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
+		require.Nil(t, lockErr)
+		assert.Nil(t, storedLock)
+
+		require.Nil(t, mockStore.Store.LockDAL.removeBucketLock(bucket))
+	})
+
+	t.Run("a lock whose heartbeat is about to expire isn't refreshed", func(t *testing.T) {
+		expiringLock := &StoreLock{Pid: os.Getpid(), Hostname: localHostname, HeartbeatTime: time.Now().Add(-lockHeartbeatExpiry + lockHeartbeatInterval/2)}
+		writeLock(t, getBucketLockKey(bucket), expiringLock)
+
+		heartbeatLock := *expiringLock
+		isStillHeld, err := mockStore.Store.LockDAL.refreshHeartbeat(getBucketLockKey(bucket), &heartbeatLock)
+		require.Nil(t, err)
+		assert.False(t, isStillHeld)
+
+		storedLock, lockErr := mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
+		require.Nil(t, lockErr)
+		assert.True(t, storedLock.HeartbeatTime.Equal(expiringLock.HeartbeatTime))
+
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket)))
+	})
+
+	t.Run("a transaction that lost it's lock can't commit, and doesn't remove the lock another process took", func(t *testing.T) {
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, nil)
+		require.Nil(t, err)
+
+		// the lock is removed as stale, and taken by another process, and then the heartbeat finds it has been lost
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket)))
+		otherLock := &StoreLock{Pid: 999999999, Hostname: "other-host", HeartbeatTime: time.Now(), Text: "backup on another host"}
+		writeLock(t, getBucketLockKey(bucket), otherLock)
+
+		mockStore.Store.LockDAL.mu.Lock()
+		mockStore.Store.LockDAL.heartbeats[getBucketLockKey(bucket)].lost = true
+		mockStore.Store.LockDAL.mu.Unlock()
+
+		err = mockStore.Store.TransactionDAL.Commit(tx)
+		assert.Equal(t, ErrLockLost, errorsx.Cause(err))
+
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+
+		storedLock, lockErr := mockStore.Store.LockDAL.GetBucketLockInformation(bucket)
+		require.Nil(t, lockErr)
+		require.NotNil(t, storedLock)
+		assert.True(t, storedLock.isSameHolder(otherLock))
+
+		revisions, err := mockStore.Store.RevisionDAL.GetRevisions(bucket)
+		require.Nil(t, err)
+		assert.Empty(t, revisions)
+
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket)))
+	})
+
+	t.Run("stale locks that have changed since they were read aren't removed", func(t *testing.T) {
+		staleLock := &StoreLock{Pid: 999999999, Hostname: "other-host", HeartbeatTime: time.Now().Add(-2 * lockHeartbeatExpiry)}
+		writeLock(t, storeLockKey, staleLock)
+
+		// the heartbeat is refreshed after the lock was found to be stale
+		refreshedLock := *staleLock
+		refreshedLock.HeartbeatTime = time.Now()
+		writeLock(t, storeLockKey, &refreshedLock)
+
+		removed, err := mockStore.Store.LockDAL.removeLockIfUnchanged(storeLockKey, staleLock)
+		require.Nil(t, err)
+		assert.False(t, removed)
+
+		storedLock, lockErr := mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.NotNil(t, storedLock)
+
+		// but are removed if they haven't
+		removed, err = mockStore.Store.LockDAL.removeLockIfUnchanged(storeLockKey, &refreshedLock)
+		require.Nil(t, err)
+		assert.True(t, removed)
+
+		storedLock, lockErr = mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, storedLock)
+	})
+
+	t.Run("list and force release locks", func(t *testing.T) {
+		tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+		require.Nil(t, err)
+
+		sharedLock, err := mockStore.Store.LockDAL.AcquireSharedLock("reader")
+		require.Nil(t, err)
+
+		writeLock(t, getBucketLockKey(&intelligentstore.Bucket{ID: 99}), &StoreLock{Pid: 999999999, Hostname: localHostname, HeartbeatTime: time.Now()})
+
+		locks, err := mockStore.Store.LockDAL.ListLocks()
+		require.Nil(t, err)
+		require.Len(t, locks, 3)
+
+		assert.Equal(t, getBucketLockKey(bucket), locks[0].Key)
+		assert.Equal(t, LockTypeBucket, locks[0].Type)
+		assert.Equal(t, "docs", locks[0].BucketName)
+		assert.False(t, locks[0].IsStale)
+
+		assert.Equal(t, "locks/buckets/99.json", locks[1].Key)
+		assert.True(t, locks[1].IsStale)
+
+		assert.Equal(t, LockTypeShared, locks[2].Type)
+		assert.Equal(t, "reader", locks[2].Text)
+
+		err = mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket))
+		require.Nil(t, err)
+
+		err = mockStore.Store.LockDAL.ForceReleaseLock(getBucketLockKey(bucket))
+		assert.Equal(t, ErrLockNotFound, errorsx.Cause(err))
+
+		err = mockStore.Store.LockDAL.ForceReleaseLock(bucketsInformationKey)
+		assert.NotNil(t, err)
+
+		require.Nil(t, mockStore.Store.LockDAL.ForceReleaseLock("locks/buckets/99.json"))
+		require.Nil(t, mockStore.Store.LockDAL.ReleaseSharedLock(sharedLock))
+		require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+
+		locks, err = mockStore.Store.LockDAL.ListLocks()
+		require.Nil(t, err)
+		assert.Len(t, locks, 0)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
 )
 
 type MigrationFunc func(store *IntelligentStoreDAL) errorsx.Error
@@ -18,6 +19,7 @@ func GetAllMigrations() []Migration {
 		{Name: "gob to json records", Migration: Run1},
 		{Name: "gzip files", Migration: Run2},
 		{Name: "rename revision contents with .json file extensions", Migration: Run3},
+		{Name: "record the hash algorithm", Migration: Run4},
 	}
 }
 
@@ -28,6 +30,26 @@ func (s *IntelligentStoreDAL) RunMigrations(migrations []Migration) errorsx.Erro
 		return errorsx.Wrap(err)
 	}
 
+	_, isOnLocalFilesystem := s.backend.(*storagebackend.FilesystemBackend)
+	if !isOnLocalFilesystem && status.SchemaVersion < len(migrations) {
+		// the migrations work on the files of the store directly. Stores in other backends are always created with the latest schema version.
+		return errorsx.Errorf("migrations can only be run on stores on the local filesystem, but the store at %q has schema version %d", s.StoreBasePath, status.SchemaVersion)
+	}
+
+	if status.SchemaVersion < len(migrations) {
+		_, err = s.LockDAL.acquireStoreLock("lock from running migrations")
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+
+		defer func() {
+			removeLockErr := s.LockDAL.removeStoreLock()
+			if removeLockErr != nil {
+				log.Printf("failed to remove store lock after running migrations. Error: %q\n", removeLockErr)
+			}
+		}()
+	}
+
 	for i, migration := range migrations {
 		thisMigrationVersion := i + 1
 
@@ -42,6 +64,12 @@ func (s *IntelligentStoreDAL) RunMigrations(migrations []Migration) errorsx.Erro
 			return err
 		}
 
+		// the migration may have changed the status, so it is read again before the schema version is updated
+		status, err = s.Status()
+		if err != nil {
+			return err
+		}
+
 		status.SchemaVersion = thisMigrationVersion
 
 		err = s.UpdateStatus(status)
@@ -1,69 +1,50 @@
 package dal
 
 import (
-	"compress/gzip"
-	"io"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/jamesrr39/goutil/errorsx"
-	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
 )
 
+// Run2 gzips the objects from before objects were compressed.
+// Each gzipped object is written through the temp store and checked before it is moved into place, and the uncompressed object is only removed after that,
+// so the migration can be run again if it is interrupted.
 func Run2(store *IntelligentStoreDAL) errorsx.Error {
-	err := gofs.Walk(store.fs, filepath.Join(store.StoreBasePath, ".backup_data", "objects"), func(path string, fileInfo os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	// objects from before objects were compressed have no file extension. Files written in a newer format all have one.
+	matchFunc := func(fileName string) (*objectCodec, string, bool) {
+		if strings.Contains(fileName, ".") {
+			return nil, "", false
 		}
 
-		if fileInfo.IsDir() {
-			return nil
-		}
-
-		if strings.HasSuffix(fileInfo.Name(), ".gz") {
-			// skip already completed copying
-			return nil
-		}
-
-		gzipFile, err := os.Create(path + ".gz")
-		if err != nil {
-			return err
-		}
-		writer := gzip.NewWriter(gzipFile)
-		defer writer.Close()
-
-		oldFile, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer oldFile.Close()
+		return nil, fileName, true
+	}
 
-		_, err = io.Copy(writer, oldFile)
-		if err != nil {
-			return err
-		}
+	return store.walkObjectFiles(matchFunc, store.gzipUncompressedObject)
+}
 
-		err = writer.Flush()
-		if err != nil {
-			return err
-		}
+// gzipUncompressedObject stores the uncompressed object gzipped, and then removes the uncompressed object
+func (s *IntelligentStoreDAL) gzipUncompressedObject(object *storedObject) errorsx.Error {
+	oldFile, err := s.backend.Get(object.Key)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer oldFile.Close()
 
-		oldFile.Close()
-		if err != nil {
-			return err
-		}
+	tempFilePath, _, _, err := s.encodeObjectToTempFile(object.Hash, oldFile, gzipObjectCodec, 0)
+	if err != nil {
+		return errorsx.Wrap(err, "key", object.Key)
+	}
 
-		err = os.Remove(path)
-		if err != nil {
-			return err
-		}
+	err = s.storeEncodedObject(tempFilePath, object.Hash, gzipObjectCodec)
+	if err != nil && errorsx.Cause(err) != storagebackend.ErrAlreadyExists {
+		return errorsx.Wrap(err, "key", object.Key)
+	}
 
-		return nil
-	}, gofs.WalkOptions{})
-	if err != nil {
-		return errorsx.Wrap(err)
+	closeErr := oldFile.Close()
+	if closeErr != nil {
+		return errorsx.Wrap(closeErr)
 	}
 
-	return nil
+	return s.backend.Delete(object.Key)
 }
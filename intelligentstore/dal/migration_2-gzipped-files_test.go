@@ -0,0 +1,64 @@
+package dal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Run2(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, MockNowProvider, fs)
+
+	contents := []byte("contents from before objects were gzipped")
+	hash, err := intelligentstore.NewHash(bytes.NewReader(contents))
+	require.NoError(t, err)
+
+	uncompressedObjectPath := mockStore.GetPathOfKey(mockStore.Store.getObjectKeyWithoutExtension(hash))
+	require.Nil(t, fs.MkdirAll(mockStore.GetPathOfKey("objects/"+hash.FirstChunk()), 0700))
+	require.Nil(t, fs.WriteFile(uncompressedObjectPath, contents, 0600))
+
+	t.Run("an object that doesn't match it's hash is left as it is", func(t *testing.T) {
+		wrongContentsHash, err := intelligentstore.NewHash(bytes.NewReader([]byte("other contents")))
+		require.NoError(t, err)
+
+		wrongObjectPath := mockStore.GetPathOfKey(mockStore.Store.getObjectKeyWithoutExtension(wrongContentsHash))
+		require.Nil(t, fs.MkdirAll(mockStore.GetPathOfKey("objects/"+wrongContentsHash.FirstChunk()), 0700))
+		require.Nil(t, fs.WriteFile(wrongObjectPath, []byte("bit rot"), 0600))
+
+		err = Run2(mockStore.Store)
+		require.NotNil(t, err)
+
+		_, statErr := fs.Stat(wrongObjectPath)
+		require.NoError(t, statErr)
+
+		isPresent, err := mockStore.Store.IsObjectPresent(wrongContentsHash)
+		require.Nil(t, err)
+		assert.False(t, isPresent)
+
+		require.Nil(t, fs.Remove(wrongObjectPath))
+	})
+
+	err = Run2(mockStore.Store)
+	require.Nil(t, err)
+
+	_, statErr := fs.Stat(uncompressedObjectPath)
+	assert.True(t, statErr != nil)
+
+	object, err := mockStore.Store.findObject(hash)
+	require.Nil(t, err)
+	assert.Equal(t, gzipObjectCodec, object.Codec)
+
+	reader, err := mockStore.Store.GetObjectByHash(hash)
+	require.Nil(t, err)
+	defer reader.Close()
+
+	b, readErr := io.ReadAll(reader)
+	require.NoError(t, readErr)
+	assert.Equal(t, contents, b)
+}
@@ -0,0 +1,30 @@
+package dal
+
+import (
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// Run4 records the hash algorithm in the store status, so that existing stores keep hashing contents with SHA-512 if the default algorithm changes
+func Run4(store *IntelligentStoreDAL) errorsx.Error {
+	status, err := store.Status()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	if status.HashAlgorithm != "" {
+		return nil
+	}
+
+	// every hash from before the hash algorithm was recorded is a SHA-512 hash
+	status.HashAlgorithm = intelligentstore.HashAlgorithmSHA512
+
+	err = store.UpdateStatus(status)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	store.hashAlgorithm = status.HashAlgorithm
+
+	return nil
+}
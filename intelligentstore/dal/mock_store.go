@@ -3,6 +3,7 @@ package dal
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -36,6 +37,11 @@ func NewMockStore(t *testing.T, nowFunc NowProvider, fs gofs.Fs) *MockStore {
 	return &MockStore{store, fs}
 }
 
+// GetPathOfKey gets the path on the mock filesystem of a file in the store
+func (m *MockStore) GetPathOfKey(key string) string {
+	return filepath.Join(m.Store.StoreBasePath, BackupDataFolderName, filepath.FromSlash(key))
+}
+
 func (m *MockStore) CreateBucket(t *testing.T, bucketName string) *intelligentstore.Bucket {
 	bucket, err := m.Store.BucketDAL.CreateBucket(bucketName)
 	require.Nil(t, err)
@@ -69,7 +75,7 @@ func (m *MockStore) CreateRevision(t *testing.T, bucket *intelligentstore.Bucket
 		mapOfHashes[descriptorWithContents.Descriptor.Hash] = descriptorWithContents
 	}
 
-	hashes, err := tx.ProcessUploadHashesAndGetRequiredHashes(relativePathsWithHashes)
+	hashes, err := m.Store.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, relativePathsWithHashes)
 	require.Nil(t, err)
 
 	for _, hash := range hashes {
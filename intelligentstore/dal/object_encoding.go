@@ -0,0 +1,415 @@
+package dal
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+	"github.com/klauspost/compress/zstd"
+)
+
+// objectCodec is a way of encoding objects on disk. The encoding of each object is recorded in it's file extension.
+type objectCodec struct {
+	Encoding      intelligentstore.ObjectEncoding
+	FileExtension string
+	// MagicBytes is how every object with this encoding starts. Empty if objects with this encoding can start with anything.
+	MagicBytes []byte
+	// MaxLevel is the highest compression level allowed. 0 if the encoding doesn't have levels.
+	MaxLevel  int
+	NewWriter func(writer io.Writer, level int) (io.WriteCloser, error)
+	NewReader func(reader io.Reader) (io.ReadCloser, error)
+}
+
+var gzipObjectCodec = &objectCodec{
+	Encoding:      intelligentstore.ObjectEncodingGzip,
+	FileExtension: ".gz",
+	MagicBytes:    []byte{0x1f, 0x8b},
+	MaxLevel:      gzip.BestCompression,
+	NewWriter: func(writer io.Writer, level int) (io.WriteCloser, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(writer, level)
+	},
+	NewReader: func(reader io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(reader)
+	},
+}
+
+var zstdObjectCodec = &objectCodec{
+	Encoding:      intelligentstore.ObjectEncodingZstd,
+	FileExtension: ".zst",
+	MagicBytes:    []byte{0x28, 0xb5, 0x2f, 0xfd},
+	MaxLevel:      22,
+	NewWriter: func(writer io.Writer, level int) (io.WriteCloser, error) {
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(writer, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+	},
+	NewReader: func(reader io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+var noneObjectCodec = &objectCodec{
+	Encoding:      intelligentstore.ObjectEncodingNone,
+	FileExtension: ".raw",
+	NewWriter: func(writer io.Writer, level int) (io.WriteCloser, error) {
+		return nopWriteCloser{writer}, nil
+	},
+	NewReader: func(reader io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(reader), nil
+	},
+}
+
+// objectCodecs are all the supported object encodings, in the order they are looked for when reading an object
+var objectCodecs = []*objectCodec{gzipObjectCodec, zstdObjectCodec, noneObjectCodec}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func getObjectCodec(encoding intelligentstore.ObjectEncoding) (*objectCodec, errorsx.Error) {
+	for _, codec := range objectCodecs {
+		if codec.Encoding == encoding {
+			return codec, nil
+		}
+	}
+
+	return nil, errorsx.Errorf("unknown object encoding: %q", encoding)
+}
+
+// getObjectCodecByFileName returns the codec for an object file name, and the file name without the extension
+func getObjectCodecByFileName(fileName string) (*objectCodec, string, bool) {
+	for _, codec := range objectCodecs {
+		if strings.HasSuffix(fileName, codec.FileExtension) {
+			return codec, strings.TrimSuffix(fileName, codec.FileExtension), true
+		}
+	}
+
+	return nil, "", false
+}
+
+// validateCompressionSettings checks the encoding is known, and the level is allowed for the encoding
+func validateCompressionSettings(settings *intelligentstore.CompressionSettings) errorsx.Error {
+	codec, err := getObjectCodec(settings.Encoding)
+	if err != nil {
+		return err
+	}
+
+	if settings.Level < 0 || settings.Level > codec.MaxLevel {
+		return errorsx.Errorf("compression level for encoding %q must be between 1 and %d (or 0 for the default level), but was %d", codec.Encoding, codec.MaxLevel, settings.Level)
+	}
+
+	return nil
+}
+
+// storedObject is an object file in the object store, or an object in a pack
+type storedObject struct {
+	// Hash is the name of the object (see getObjectName). Only in an unencrypted store is it the hash of the contents.
+	Hash intelligentstore.Hash
+	// Key is the key of the object file, or for a packed object, the key of the pack
+	Key   string
+	Codec *objectCodec
+	Info  *storagebackend.ObjectInfo
+	// Pack is where the object is in it's pack. nil if the object is not packed.
+	Pack *packIndexEntry
+}
+
+const objectsKeyPrefix = "objects/"
+
+// getObjectKeyWithoutExtension gets the key of the object with this hash, without the extension for the encoding
+func (s *IntelligentStoreDAL) getObjectKeyWithoutExtension(hash intelligentstore.Hash) string {
+	objectName := s.getObjectName(hash)
+	return objectsKeyPrefix + objectName.FirstChunk() + "/" + objectName.Remainder()
+}
+
+func (s *IntelligentStoreDAL) getObjectKey(hash intelligentstore.Hash, codec *objectCodec) string {
+	return s.getObjectKeyWithoutExtension(hash) + codec.FileExtension
+}
+
+// findObject looks for the object with this hash, in any encoding, as an object file or in a pack.
+// If there is no object with this hash, the cause of the returned error satisfies os.IsNotExist.
+// Contents stored as chunks are not objects themselves, so they are not found by this method.
+func (s *IntelligentStoreDAL) findObject(hash intelligentstore.Hash) (*storedObject, errorsx.Error) {
+	var firstErr error
+	for _, codec := range objectCodecs {
+		key := s.getObjectKey(hash, codec)
+		objectInfo, err := s.backend.Stat(key)
+		if err != nil {
+			if !os.IsNotExist(errorsx.Cause(err)) {
+				return nil, errorsx.Wrap(err, "hash", hash)
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		return &storedObject{Hash: s.getObjectName(hash), Key: key, Codec: codec, Info: objectInfo}, nil
+	}
+
+	packedObject, err := s.findPackedObject(hash)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "hash", hash)
+	}
+
+	if packedObject != nil {
+		return packedObject, nil
+	}
+
+	return nil, errorsx.Wrap(firstErr, "hash", hash)
+}
+
+// openObjectFile opens an object as it is stored: encrypted (in an encrypted store), and encoded
+func (s *IntelligentStoreDAL) openObjectFile(object *storedObject) (io.ReadCloser, errorsx.Error) {
+	if object.Pack == nil {
+		return s.backend.Get(object.Key)
+	}
+
+	return s.backend.GetRange(object.Key, object.Pack.Offset, object.Pack.Length)
+}
+
+// SetCompressionSettings sets how new objects are encoded. nil sets the default (gzip, at the default level).
+// Objects that are already stored are not changed.
+func (s *IntelligentStoreDAL) SetCompressionSettings(settings *intelligentstore.CompressionSettings) errorsx.Error {
+	if settings != nil {
+		err := validateCompressionSettings(settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := s.Status()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	status.Compression = settings
+
+	err = s.UpdateStatus(status)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.compressionSettings = settings
+
+	return nil
+}
+
+// getDefaultObjectCodec gets the codec and level new objects should be written with
+func (s *IntelligentStoreDAL) getDefaultObjectCodec() (*objectCodec, int, errorsx.Error) {
+	if s.compressionSettings == nil {
+		return gzipObjectCodec, 0, nil
+	}
+
+	codec, err := getObjectCodec(s.compressionSettings.Encoding)
+	if err != nil {
+		return nil, 0, errorsx.Wrap(err)
+	}
+
+	return codec, s.compressionSettings.Level, nil
+}
+
+// writeObject writes the contents into the object store, encoded with the store's default encoding.
+// If compressing the contents doesn't make them smaller, they are stored uncompressed instead.
+// Contents small enough to be packed are added to the open pack, and are only in the store once the pack is finished (see finishOpenPack).
+func (s *IntelligentStoreDAL) writeObject(sourceFile io.ReadSeeker, hash intelligentstore.Hash) errorsx.Error {
+	codec, level, err := s.getDefaultObjectCodec()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	if s.packingSettings != nil {
+		size, seekErr := sourceFile.Seek(0, io.SeekEnd)
+		if seekErr != nil {
+			return errorsx.Wrap(seekErr)
+		}
+
+		_, seekErr = sourceFile.Seek(0, io.SeekStart)
+		if seekErr != nil {
+			return errorsx.Wrap(seekErr)
+		}
+
+		if s.packingSettings.ShouldPack(size) {
+			return s.writePackedObject(sourceFile, hash, codec, level)
+		}
+	}
+
+	tempFilePath, contentsSize, encodedSize, err := s.encodeObjectToTempFile(s.getObjectName(hash), sourceFile, codec, level)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	if codec != noneObjectCodec && encodedSize >= contentsSize {
+		// compression didn't save any space, store the raw contents instead
+		removeErr := s.fs.Remove(tempFilePath)
+		if removeErr != nil {
+			return errorsx.Wrap(removeErr)
+		}
+
+		_, seekErr := sourceFile.Seek(0, io.SeekStart)
+		if seekErr != nil {
+			return errorsx.Wrap(seekErr)
+		}
+
+		codec = noneObjectCodec
+		tempFilePath, _, _, err = s.encodeObjectToTempFile(s.getObjectName(hash), sourceFile, codec, 0)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	err = s.storeEncodedObject(tempFilePath, hash, codec)
+	if err != nil {
+		if errorsx.Cause(err) == storagebackend.ErrAlreadyExists {
+			// the same contents have already been stored (for example by another upload running at the same time)
+			return nil
+		}
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+// encodeObjectToTempFile encodes (and, in an encrypted store, encrypts) the contents of the object with the name into a new file in the temp store.
+// It returns the path of the file, the size of the contents, and the size of the encoded contents before encryption.
+func (s *IntelligentStoreDAL) encodeObjectToTempFile(name intelligentstore.Hash, sourceFile io.Reader, codec *objectCodec, level int) (string, int64, int64, errorsx.Error) {
+	tempFile, tempFilePath, err := s.TempStoreDAL.CreateTempFile()
+	if err != nil {
+		return "", 0, 0, errorsx.Wrap(err)
+	}
+	defer tempFile.Close()
+
+	contentsSize, encodedSize, err := s.encodeObjectWithEncryption(tempFile, name, sourceFile, codec, level)
+	if err != nil {
+		s.fs.Remove(tempFilePath)
+		return "", 0, 0, errorsx.Wrap(err)
+	}
+
+	closeErr := tempFile.Close()
+	if closeErr != nil {
+		s.fs.Remove(tempFilePath)
+		return "", 0, 0, errorsx.Wrap(closeErr)
+	}
+
+	return tempFilePath, contentsSize, encodedSize, nil
+}
+
+// encodeObjectWithEncryption encodes the contents with the codec, and in an encrypted store encrypts them for the object with the name, into the writer.
+// It returns the size of the contents, and the size of the encoded contents before encryption.
+func (s *IntelligentStoreDAL) encodeObjectWithEncryption(writer io.Writer, name intelligentstore.Hash, sourceFile io.Reader, codec *objectCodec, level int) (int64, int64, errorsx.Error) {
+	encryptingWriter, err := s.newEncryptingWriteCloser(writer, string(name))
+	if err != nil {
+		return 0, 0, errorsx.Wrap(err)
+	}
+
+	contentsSize, encodedSize, err := encodeObject(encryptingWriter, sourceFile, codec, level)
+	if err != nil {
+		return 0, 0, errorsx.Wrap(err)
+	}
+
+	closeErr := encryptingWriter.Close()
+	if closeErr != nil {
+		return 0, 0, errorsx.Wrap(closeErr)
+	}
+
+	return contentsSize, encodedSize, nil
+}
+
+// storeEncodedObject checks the encoded object in the temp file can be read back as the contents with the hash, and then stores it. The temp file is removed.
+// If the object already exists, the existing object is kept and the cause of the returned error is storagebackend.ErrAlreadyExists.
+func (s *IntelligentStoreDAL) storeEncodedObject(tempFilePath string, hash intelligentstore.Hash, codec *objectCodec) errorsx.Error {
+	defer s.fs.Remove(tempFilePath)
+
+	tempFile, openErr := s.fs.Open(tempFilePath)
+	if openErr != nil {
+		return errorsx.Wrap(openErr)
+	}
+	defer tempFile.Close()
+
+	err := s.verifyEncodedObject(tempFile, hash, codec)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	_, seekErr := tempFile.Seek(0, io.SeekStart)
+	if seekErr != nil {
+		return errorsx.Wrap(seekErr)
+	}
+
+	return s.backend.PutIfAbsent(s.getObjectKey(hash, codec), tempFile)
+}
+
+// verifyEncodedObject checks the encoded (and, in an encrypted store, encrypted) object decodes to the contents with the hash
+func (s *IntelligentStoreDAL) verifyEncodedObject(encodedObject io.Reader, hash intelligentstore.Hash, codec *objectCodec) errorsx.Error {
+	decryptingReader, err := s.newDecryptingReadCloser(io.NopCloser(encodedObject), string(s.getObjectName(hash)))
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer decryptingReader.Close()
+
+	decoder, decoderErr := codec.NewReader(decryptingReader)
+	if decoderErr != nil {
+		return errorsx.Wrap(decoderErr, "hash", hash)
+	}
+	defer decoder.Close()
+
+	decodedHash, hashErr := intelligentstore.NewHashWithAlgorithm(decoder, hash.Algorithm())
+	if hashErr != nil {
+		return errorsx.Wrap(hashErr, "hash", hash)
+	}
+
+	if decodedHash != hash {
+		return errorsx.Errorf("the encoded object doesn't decode to the contents it was written from. Expected hash %q, but got %q", hash, decodedHash)
+	}
+
+	return nil
+}
+
+// encodeObject encodes the contents with the codec into the writer, and returns the size of the contents, and the size of the encoded contents
+func encodeObject(writer io.Writer, sourceFile io.Reader, codec *objectCodec, level int) (int64, int64, errorsx.Error) {
+	countingWriter := &countingWriter{Writer: writer}
+
+	encoder, err := codec.NewWriter(countingWriter, level)
+	if err != nil {
+		return 0, 0, errorsx.Wrap(err)
+	}
+
+	contentsSize, err := io.Copy(encoder, sourceFile)
+	if err == nil {
+		err = encoder.Close()
+	}
+
+	if err != nil {
+		return 0, 0, errorsx.Wrap(err)
+	}
+
+	return contentsSize, countingWriter.BytesWritten, nil
+}
+
+type countingWriter struct {
+	io.Writer
+	BytesWritten int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	bytesWritten, err := w.Writer.Write(p)
+	w.BytesWritten += int64(bytesWritten)
+	return bytesWritten, err
+}
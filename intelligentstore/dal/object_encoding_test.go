@@ -0,0 +1,132 @@
+package dal
+
+import (
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readObject(t *testing.T, store *IntelligentStoreDAL, hash intelligentstore.Hash) []byte {
+	object, err := store.GetObjectByHash(hash)
+	require.Nil(t, err)
+	defer object.Close()
+
+	contents, readErr := io.ReadAll(object)
+	require.NoError(t, readErr)
+
+	return contents
+}
+
+func Test_ObjectEncoding(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	compressibleContents := []byte(strings.Repeat("the same line, again and again\n", 1000))
+
+	randomContents := make([]byte, 64*1024)
+	_, readErr := rand.New(rand.NewSource(1)).Read(randomContents)
+	require.NoError(t, readErr)
+
+	t.Run("invalid settings", func(t *testing.T) {
+		err := mockStore.Store.SetCompressionSettings(&intelligentstore.CompressionSettings{Encoding: "lzma"})
+		assert.NotNil(t, err)
+
+		err = mockStore.Store.SetCompressionSettings(&intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingGzip, Level: 10})
+		assert.NotNil(t, err)
+	})
+
+	testCases := []struct {
+		Name          string
+		Settings      *intelligentstore.CompressionSettings
+		ExpectedCodec *objectCodec
+	}{
+		{"default", nil, gzipObjectCodec},
+		{"gzip with level", &intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingGzip, Level: 9}, gzipObjectCodec},
+		{"zstd", &intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingZstd}, zstdObjectCodec},
+		{"zstd with level", &intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingZstd, Level: 19}, zstdObjectCodec},
+		{"none", &intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingNone}, noneObjectCodec},
+	}
+
+	for i, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := mockStore.Store.SetCompressionSettings(testCase.Settings)
+			require.Nil(t, err)
+
+			// make the contents unique for this test case, so that they are not already in the store
+			suffix := []byte{byte(i)}
+			compressibleFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "compressible.txt", mockNow, FileMode600, append(append([]byte{}, compressibleContents...), suffix...))
+			randomFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "random.bin", mockNow, FileMode600, append(append([]byte{}, randomContents...), suffix...))
+
+			mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{compressibleFile, randomFile})
+			mockNow = mockNow.Add(time.Hour)
+
+			compressibleObject, err := mockStore.Store.findObject(compressibleFile.Descriptor.Hash)
+			require.Nil(t, err)
+			assert.Equal(t, testCase.ExpectedCodec, compressibleObject.Codec)
+
+			randomObject, err := mockStore.Store.findObject(randomFile.Descriptor.Hash)
+			require.Nil(t, err)
+			assert.Equal(t, noneObjectCodec, randomObject.Codec, "incompressible contents should be stored uncompressed")
+
+			assert.Equal(t, compressibleFile.Contents, readObject(t, mockStore.Store, compressibleFile.Descriptor.Hash))
+			assert.Equal(t, randomFile.Contents, readObject(t, mockStore.Store, randomFile.Descriptor.Hash))
+
+			gzippedObject, err := mockStore.Store.GetGzippedObjectByHash(compressibleFile.Descriptor.Hash)
+			require.Nil(t, err)
+			defer gzippedObject.Close()
+
+			gzipReader, gzipErr := gzip.NewReader(gzippedObject)
+			require.NoError(t, gzipErr)
+
+			gunzippedContents, readErr := io.ReadAll(gzipReader)
+			require.NoError(t, readErr)
+			assert.Equal(t, compressibleFile.Contents, gunzippedContents)
+		})
+	}
+
+	t.Run("settings are loaded from the store", func(t *testing.T) {
+		err := mockStore.Store.SetCompressionSettings(&intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingZstd, Level: 3})
+		require.Nil(t, err)
+
+		store, err := newIntelligentStoreConnToExisting("/test-store", mockNowProvider, fs, nil)
+		require.Nil(t, err)
+
+		assert.Equal(t, &intelligentstore.CompressionSettings{Encoding: intelligentstore.ObjectEncodingZstd, Level: 3}, store.compressionSettings)
+	})
+
+	t.Run("fsck and garbage collection accept every encoding", func(t *testing.T) {
+		report, err := mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Len(t, report.Problems, 0)
+
+		result, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Len(t, result.UnreferencedObjects, 0)
+		assert.Equal(t, int64(2*len(testCases)), result.LiveObjects)
+	})
+
+	t.Run("empty contents", func(t *testing.T) {
+		emptyFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "empty.txt", mockNow, FileMode600, []byte{})
+		mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{emptyFile})
+
+		assert.Equal(t, []byte{}, readObject(t, mockStore.Store, emptyFile.Descriptor.Hash))
+
+		report, err := mockStore.Store.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Len(t, report.Problems, 0)
+	})
+}
@@ -0,0 +1,297 @@
+package dal
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/pkg/errors"
+)
+
+// objectReferenceIndexKey is the key of the index of which revisions reference which contents. Stores from before the index was introduced don't have it until it is rebuilt.
+const objectReferenceIndexKey = "store_metadata/object-references.json"
+
+const objectReferenceIndexVersion = 1
+
+var ErrNoObjectReferenceIndex = errors.New("the store has no object reference index. Run the rebuild-reference-index command to build it")
+
+// objectReferenceIndex counts the references to each content hash (and to each chunk) from the revisions in the store.
+// It is updated when a revision is committed or deleted, so that the references can be found without reading every revision manifest.
+type objectReferenceIndex struct {
+	Version int                                                          `json:"version"`
+	Objects map[intelligentstore.Hash]*intelligentstore.ObjectReferences `json:"objects"`
+}
+
+func newObjectReferenceIndex() *objectReferenceIndex {
+	return &objectReferenceIndex{
+		Version: objectReferenceIndexVersion,
+		Objects: make(map[intelligentstore.Hash]*intelligentstore.ObjectReferences),
+	}
+}
+
+// addRevision counts the references from the files of a revision
+func (index *objectReferenceIndex) addRevision(revision *intelligentstore.Revision, descriptors []intelligentstore.FileDescriptor) {
+	revisionReference := &intelligentstore.RevisionReference{
+		BucketID:        revision.Bucket.ID,
+		RevisionVersion: revision.VersionTimestamp,
+	}
+
+	for _, descriptor := range descriptors {
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if !ok {
+			continue
+		}
+
+		references := index.getOrCreate(regularFileDescriptor.Hash, regularFileDescriptor.Size)
+		references.AddReference(revisionReference)
+		if len(regularFileDescriptor.Chunks) != 0 {
+			references.Chunked = true
+		}
+
+		for _, chunk := range regularFileDescriptor.Chunks {
+			index.getOrCreate(chunk.Hash, chunk.Size).AddReference(revisionReference)
+		}
+	}
+}
+
+// removeRevision removes the references from the files of a deleted revision
+func (index *objectReferenceIndex) removeRevision(revision *intelligentstore.Revision, descriptors []intelligentstore.FileDescriptor) {
+	for _, descriptor := range descriptors {
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if !ok {
+			continue
+		}
+
+		index.removeReference(regularFileDescriptor.Hash, revision.Bucket.ID)
+		for _, chunk := range regularFileDescriptor.Chunks {
+			index.removeReference(chunk.Hash, revision.Bucket.ID)
+		}
+	}
+}
+
+func (index *objectReferenceIndex) getOrCreate(hash intelligentstore.Hash, size int64) *intelligentstore.ObjectReferences {
+	references, ok := index.Objects[hash]
+	if !ok {
+		references = &intelligentstore.ObjectReferences{
+			Size:         size,
+			BucketCounts: make(map[int]int64),
+		}
+		index.Objects[hash] = references
+	}
+
+	return references
+}
+
+func (index *objectReferenceIndex) removeReference(hash intelligentstore.Hash, bucketID int) {
+	references, ok := index.Objects[hash]
+	if !ok {
+		return
+	}
+
+	references.RemoveReference(bucketID)
+	if references.Count == 0 {
+		delete(index.Objects, hash)
+	}
+}
+
+// readObjectReferenceIndex reads the object reference index. If the store doesn't have one, the cause of the returned error satisfies os.IsNotExist.
+func (s *IntelligentStoreDAL) readObjectReferenceIndex() (*objectReferenceIndex, errorsx.Error) {
+	b, err := s.readEncryptedFile(objectReferenceIndexKey)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	index := new(objectReferenceIndex)
+	unmarshalErr := json.Unmarshal(b, index)
+	if unmarshalErr != nil {
+		return nil, errorsx.Wrap(unmarshalErr, "key", objectReferenceIndexKey)
+	}
+
+	if index.Version != objectReferenceIndexVersion {
+		return nil, errorsx.Errorf("unsupported object reference index version: %d. Run the rebuild-reference-index command to rebuild it", index.Version)
+	}
+
+	if index.Objects == nil {
+		index.Objects = make(map[intelligentstore.Hash]*intelligentstore.ObjectReferences)
+	}
+
+	return index, nil
+}
+
+func (s *IntelligentStoreDAL) writeObjectReferenceIndex(index *objectReferenceIndex) errorsx.Error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return s.writeEncryptedFile(objectReferenceIndexKey, b)
+}
+
+// updateObjectReferenceIndex reads the object reference index, updates it and writes it back.
+// Stores without an index are left without one; the index has to be rebuilt to start maintaining it.
+// Transactions on different buckets can commit at the same time, so the index is locked during the update.
+func (s *IntelligentStoreDAL) updateObjectReferenceIndex(updateFunc func(index *objectReferenceIndex)) errorsx.Error {
+	return s.LockDAL.withObjectReferenceIndexLock(func() errorsx.Error {
+		index, err := s.readObjectReferenceIndex()
+		if err != nil {
+			if os.IsNotExist(errorsx.Cause(err)) {
+				return nil
+			}
+			return errorsx.Wrap(err)
+		}
+
+		updateFunc(index)
+
+		return s.writeObjectReferenceIndex(index)
+	})
+}
+
+// HasObjectReferenceIndex returns true if the store maintains an object reference index
+func (s *IntelligentStoreDAL) HasObjectReferenceIndex() (bool, errorsx.Error) {
+	_, err := s.backend.Stat(objectReferenceIndexKey)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return false, nil
+		}
+		return false, errorsx.Wrap(err)
+	}
+
+	return true, nil
+}
+
+// GetObjectReferences gets how the contents with this hash are referenced by the revisions in the store.
+// Contents that aren't referenced have a count of 0. If the store has no object reference index, the cause of the returned error is ErrNoObjectReferenceIndex.
+func (s *IntelligentStoreDAL) GetObjectReferences(hash intelligentstore.Hash) (*intelligentstore.ObjectReferences, errorsx.Error) {
+	index, err := s.readObjectReferenceIndex()
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil, errorsx.Wrap(ErrNoObjectReferenceIndex)
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	references, ok := index.Objects[hash]
+	if !ok {
+		return &intelligentstore.ObjectReferences{BucketCounts: make(map[int]int64)}, nil
+	}
+
+	return references, nil
+}
+
+// GetBucketUniqueSizes gets, for each bucket, the size of the contents that are only referenced by that bucket.
+// Contents stored as chunks are counted by their chunks. If the store has no object reference index, the cause of the returned error is ErrNoObjectReferenceIndex.
+func (s *IntelligentStoreDAL) GetBucketUniqueSizes() (map[int]int64, errorsx.Error) {
+	index, err := s.readObjectReferenceIndex()
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil, errorsx.Wrap(ErrNoObjectReferenceIndex)
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	return index.bucketUniqueSizes(), nil
+}
+
+// bucketUniqueSizes gets, for each bucket, the size of the stored contents only referenced by that bucket.
+// Contents stored as chunks are counted by their chunks.
+func (index *objectReferenceIndex) bucketUniqueSizes() map[int]int64 {
+	uniqueSizes := make(map[int]int64)
+	for _, references := range index.Objects {
+		if references.Chunked || len(references.BucketCounts) != 1 {
+			continue
+		}
+
+		for bucketID := range references.BucketCounts {
+			uniqueSizes[bucketID] += references.Size
+		}
+	}
+
+	return uniqueSizes
+}
+
+// storedSize gets the total size of the distinct contents referenced, before they are encoded. Contents stored as chunks are counted by their chunks.
+func (index *objectReferenceIndex) storedSize() int64 {
+	var size int64
+	for _, references := range index.Objects {
+		if references.Chunked {
+			continue
+		}
+
+		size += references.Size
+	}
+
+	return size
+}
+
+// RebuildObjectReferenceIndexResult is a report of rebuilding the object reference index
+type RebuildObjectReferenceIndexResult struct {
+	RevisionsRead int64 `json:"revisionsRead"`
+	Objects       int64 `json:"objects"`
+}
+
+// RebuildObjectReferenceIndex reads every revision manifest and writes a new object reference index from them.
+// It is used to create the index for stores from before the index was introduced, or to correct an index that has got out of date.
+func (s *IntelligentStoreDAL) RebuildObjectReferenceIndex() (*RebuildObjectReferenceIndexResult, errorsx.Error) {
+	_, err := s.LockDAL.acquireStoreLock("lock from rebuilding the object reference index")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer func() {
+		removeLockErr := s.LockDAL.removeStoreLock()
+		if removeLockErr != nil {
+			log.Printf("failed to remove store lock after rebuilding the object reference index. Error: %q\n", removeLockErr)
+		}
+	}()
+
+	index, revisionsRead, err := s.buildObjectReferenceIndex()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	// if the store lock has been lost, revisions could have been committed since the index was built
+	err = s.LockDAL.checkStoreLockHeld()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = s.writeObjectReferenceIndex(index)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return &RebuildObjectReferenceIndexResult{
+		RevisionsRead: revisionsRead,
+		Objects:       int64(len(index.Objects)),
+	}, nil
+}
+
+// buildObjectReferenceIndex builds the object reference index by reading every revision of every bucket. It also returns how many revisions were read.
+func (s *IntelligentStoreDAL) buildObjectReferenceIndex() (*objectReferenceIndex, int64, errorsx.Error) {
+	buckets, err := s.BucketDAL.GetAllBuckets()
+	if err != nil {
+		return nil, 0, errorsx.Wrap(err)
+	}
+
+	index := newObjectReferenceIndex()
+	var revisionsRead int64
+	for _, bucket := range buckets {
+		revisions, err := s.BucketDAL.GetRevisions(bucket)
+		if err != nil {
+			return nil, 0, errorsx.Wrap(err, "bucket", bucket.ID)
+		}
+
+		for _, revision := range revisions {
+			descriptors, err := s.RevisionDAL.GetFilesInRevision(bucket, revision)
+			if err != nil {
+				return nil, 0, errorsx.Wrap(err)
+			}
+
+			index.addRevision(revision, descriptors)
+			revisionsRead++
+		}
+	}
+
+	return index, revisionsRead, nil
+}
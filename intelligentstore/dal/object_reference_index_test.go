@@ -0,0 +1,107 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ObjectReferenceIndex(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+	docsBucket := mockStore.CreateBucket(t, "docs")
+	photosBucket := mockStore.CreateBucket(t, "photos")
+
+	sharedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "shared.txt", mockNow, FileMode600, []byte("shared contents"))
+	docsFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte("docs contents"))
+
+	firstRevision := mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile, docsFile})
+	mockNow = mockNow.Add(time.Hour)
+	secondRevision := mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+	mockNow = mockNow.Add(time.Hour)
+	photosRevision := mockStore.CreateRevision(t, photosBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+
+	t.Run("maintained on commit", func(t *testing.T) {
+		references, err := mockStore.Store.GetObjectReferences(sharedFile.Descriptor.Hash)
+		require.Nil(t, err)
+
+		assert.Equal(t, int64(3), references.Count)
+		assert.Equal(t, int64(len("shared contents")), references.Size)
+		assert.Equal(t, map[int]int64{docsBucket.ID: 2, photosBucket.ID: 1}, references.BucketCounts)
+		assert.Equal(t, &intelligentstore.RevisionReference{BucketID: docsBucket.ID, RevisionVersion: firstRevision.VersionTimestamp}, references.FirstSeen)
+		assert.Equal(t, &intelligentstore.RevisionReference{BucketID: photosBucket.ID, RevisionVersion: photosRevision.VersionTimestamp}, references.LastSeen)
+
+		uniqueSizes, err := mockStore.Store.GetBucketUniqueSizes()
+		require.Nil(t, err)
+		assert.Equal(t, map[int]int64{docsBucket.ID: int64(len("docs contents"))}, uniqueSizes)
+	})
+
+	t.Run("unreferenced contents", func(t *testing.T) {
+		references, err := mockStore.Store.GetObjectReferences(intelligentstore.Hash("abcdef0123456789"))
+		require.Nil(t, err)
+		assert.Equal(t, int64(0), references.Count)
+	})
+
+	t.Run("maintained on prune", func(t *testing.T) {
+		err := mockStore.Store.BucketDAL.SetRetentionPolicy(docsBucket, &intelligentstore.RetentionPolicy{KeepLast: 1})
+		require.Nil(t, err)
+
+		fetchedBucket, err := mockStore.Store.BucketDAL.GetBucketByName("docs")
+		require.Nil(t, err)
+
+		result, err := mockStore.Store.PruneBucket(fetchedBucket, PruneOptions{})
+		require.Nil(t, err)
+		require.Len(t, result.Pruned, 1)
+		assert.Equal(t, firstRevision.VersionTimestamp, result.Pruned[0].VersionTimestamp)
+
+		references, err := mockStore.Store.GetObjectReferences(sharedFile.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), references.Count)
+		assert.Equal(t, map[int]int64{docsBucket.ID: 1, photosBucket.ID: 1}, references.BucketCounts)
+
+		references, err = mockStore.Store.GetObjectReferences(docsFile.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.Equal(t, int64(0), references.Count)
+
+		gcResult, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Equal(t, []intelligentstore.Hash{docsFile.Descriptor.Hash}, gcResult.UnreferencedObjects)
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		err := mockStore.Store.backend.Delete(objectReferenceIndexKey)
+		require.Nil(t, err)
+
+		_, err = mockStore.Store.GetObjectReferences(sharedFile.Descriptor.Hash)
+		assert.Equal(t, ErrNoObjectReferenceIndex, errorsx.Cause(err))
+
+		// without an index, garbage collection reads the revisions instead
+		gcResult, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{DryRun: true})
+		require.Nil(t, err)
+		assert.Equal(t, []intelligentstore.Hash{docsFile.Descriptor.Hash}, gcResult.UnreferencedObjects)
+
+		result, err := mockStore.Store.RebuildObjectReferenceIndex()
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), result.RevisionsRead)
+		assert.Equal(t, int64(1), result.Objects)
+
+		references, err := mockStore.Store.GetObjectReferences(sharedFile.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), references.Count)
+		assert.Equal(t, &intelligentstore.RevisionReference{BucketID: docsBucket.ID, RevisionVersion: secondRevision.VersionTimestamp}, references.FirstSeen)
+
+		lock, lockErr := mockStore.Store.LockDAL.GetLockInformation()
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+	})
+}
@@ -0,0 +1,484 @@
+package dal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend"
+)
+
+const (
+	packsKeyPrefix         = "packs/"
+	packFileExtension      = ".pack"
+	packIndexFileExtension = ".idx"
+	// targetPackSize is the size at which a pack is finished, and a new one started
+	targetPackSize = 16 * 1024 * 1024
+)
+
+// packIndexEntry is where an object is in a pack
+type packIndexEntry struct {
+	// Name is the name of the object (see getObjectName)
+	Name     intelligentstore.Hash           `json:"name"`
+	Encoding intelligentstore.ObjectEncoding `json:"encoding"`
+	Offset   int64                           `json:"offset"`
+	Length   int64                           `json:"length"`
+}
+
+// packIndex lists the objects in a pack.
+// Each object in a pack is encoded (and, in an encrypted store, encrypted) on it's own, in the same way as an object file, so that it can be read without reading the rest of the pack.
+// The index is written after the pack is complete, so a pack without an index is never read from.
+type packIndex struct {
+	Entries []*packIndexEntry `json:"entries"`
+}
+
+// packLocation is where a packed object is
+type packLocation struct {
+	PackID string
+	Entry  *packIndexEntry
+}
+
+// packStore is the index of every packed object, and the pack new objects are being added to
+type packStore struct {
+	mu sync.Mutex
+	// indexedPackIDs are the packs whose index has been loaded. nil until the indexes are first loaded.
+	indexedPackIDs map[string]struct{}
+	// objects is a map of object name to where it is packed
+	objects map[intelligentstore.Hash]*packLocation
+	// openPack is the pack new objects are being added to. nil if there is no open pack.
+	openPack *packWriter
+	// openTransactions is how many transactions are open in this process. They all add objects to the same open pack.
+	openTransactions int
+}
+
+// packWriter is a pack being written
+type packWriter struct {
+	id      string
+	file    *backendFileWriter
+	size    int64
+	entries []*packIndexEntry
+	// names are the names of the objects in the pack
+	names map[intelligentstore.Hash]struct{}
+}
+
+// getPackKeyWithoutExtension gets the key of a pack, without the extension. Packs are laid out like objects, by the first characters of their ID.
+func getPackKeyWithoutExtension(packID string) string {
+	return packsKeyPrefix + packID[:2] + "/" + packID[2:]
+}
+
+func getPackKey(packID string) string {
+	return getPackKeyWithoutExtension(packID) + packFileExtension
+}
+
+func getPackIndexKey(packID string) string {
+	return getPackKeyWithoutExtension(packID) + packIndexFileExtension
+}
+
+func newPackID() (string, errorsx.Error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errorsx.Wrap(err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// SetPackingSettings sets whether (and up to which size) new objects are appended into pack files. nil turns packing off.
+// Objects that are already stored are not changed.
+func (s *IntelligentStoreDAL) SetPackingSettings(settings *intelligentstore.PackingSettings) errorsx.Error {
+	if settings != nil {
+		err := settings.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := s.Status()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	status.Packing = settings
+
+	err = s.UpdateStatus(status)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	s.packingSettings = settings
+
+	return nil
+}
+
+// listPackIDs lists the packs that have an index, sorted by ID
+func (s *IntelligentStoreDAL) listPackIDs() ([]string, errorsx.Error) {
+	objectInfos, err := s.backend.List(packsKeyPrefix)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var packIDs []string
+	for _, objectInfo := range objectInfos {
+		// pack index keys are "packs/<first 2 characters of the ID>/<rest of the ID>.idx"
+		fragments := strings.Split(strings.TrimPrefix(objectInfo.Key, packsKeyPrefix), "/")
+		if len(fragments) != 2 || !strings.HasSuffix(fragments[1], packIndexFileExtension) {
+			continue
+		}
+
+		packIDs = append(packIDs, fragments[0]+strings.TrimSuffix(fragments[1], packIndexFileExtension))
+	}
+
+	return packIDs, nil
+}
+
+func (s *IntelligentStoreDAL) readPackIndex(packID string) (*packIndex, errorsx.Error) {
+	b, err := s.readEncryptedFile(getPackIndexKey(packID))
+	if err != nil {
+		return nil, errorsx.Wrap(err, "packID", packID)
+	}
+
+	index := new(packIndex)
+	unmarshalErr := json.Unmarshal(b, index)
+	if unmarshalErr != nil {
+		return nil, errorsx.Wrap(unmarshalErr, "packID", packID)
+	}
+
+	return index, nil
+}
+
+// reloadPackIndexes loads the indexes of packs added since the indexes were last loaded, and forgets packs that have been removed (for example by a repack in another process)
+func (s *IntelligentStoreDAL) reloadPackIndexes() errorsx.Error {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	return s.loadPackIndexesLocked()
+}
+
+func (s *IntelligentStoreDAL) loadPackIndexesLocked() errorsx.Error {
+	packs := s.packs
+
+	packIDs, err := s.listPackIDs()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	listedPackIDs := make(map[string]struct{}, len(packIDs))
+	for _, packID := range packIDs {
+		listedPackIDs[packID] = struct{}{}
+	}
+
+	packRemoved := false
+	for packID := range packs.indexedPackIDs {
+		_, isListed := listedPackIDs[packID]
+		if !isListed {
+			packRemoved = true
+			break
+		}
+	}
+
+	if packs.indexedPackIDs == nil || packRemoved {
+		// the objects of a removed pack could be in a pack that is already indexed, so the whole index is built again
+		packs.indexedPackIDs = make(map[string]struct{})
+		packs.objects = make(map[intelligentstore.Hash]*packLocation)
+	}
+
+	for _, packID := range packIDs {
+		_, isIndexed := packs.indexedPackIDs[packID]
+		if isIndexed {
+			continue
+		}
+
+		index, err := s.readPackIndex(packID)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		packs.addIndex(packID, index)
+	}
+
+	return nil
+}
+
+func (p *packStore) addIndex(packID string, index *packIndex) {
+	p.indexedPackIDs[packID] = struct{}{}
+	for _, entry := range index.Entries {
+		_, alreadyPacked := p.objects[entry.Name]
+		if !alreadyPacked {
+			p.objects[entry.Name] = &packLocation{packID, entry}
+		}
+	}
+}
+
+// findPackedObject looks for the object with this hash in the packs. If it is not packed, nil is returned.
+// Objects in the open pack are not found, as they can't be read until the pack is finished.
+func (s *IntelligentStoreDAL) findPackedObject(hash intelligentstore.Hash) (*storedObject, errorsx.Error) {
+	packs := s.packs
+	packs.mu.Lock()
+	defer packs.mu.Unlock()
+
+	if packs.indexedPackIDs == nil {
+		err := s.loadPackIndexesLocked()
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	location, ok := packs.objects[s.getObjectName(hash)]
+	if !ok {
+		return nil, nil
+	}
+
+	codec, err := getObjectCodec(location.Entry.Encoding)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "packID", location.PackID)
+	}
+
+	key := getPackKey(location.PackID)
+
+	return &storedObject{
+		Hash:  location.Entry.Name,
+		Key:   key,
+		Codec: codec,
+		Info:  &storagebackend.ObjectInfo{Key: key, Size: location.Entry.Length},
+		Pack:  location.Entry,
+	}, nil
+}
+
+// isInOpenPack returns true if the object with this hash has been added to the open pack
+func (s *IntelligentStoreDAL) isInOpenPack(hash intelligentstore.Hash) bool {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	if s.packs.openPack == nil {
+		return false
+	}
+
+	_, ok := s.packs.openPack.names[s.getObjectName(hash)]
+	return ok
+}
+
+// getPackedObjectNames gets the names of every packed object
+func (s *IntelligentStoreDAL) getPackedObjectNames() ([]intelligentstore.Hash, errorsx.Error) {
+	packs := s.packs
+	packs.mu.Lock()
+	defer packs.mu.Unlock()
+
+	err := s.loadPackIndexesLocked()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var names []intelligentstore.Hash
+	for name := range packs.objects {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// writePackedObject encodes the contents and adds them to the open pack.
+// If compressing the contents doesn't make them smaller, they are packed uncompressed instead.
+func (s *IntelligentStoreDAL) writePackedObject(sourceFile io.Reader, hash intelligentstore.Hash, codec *objectCodec, level int) errorsx.Error {
+	contents, readErr := io.ReadAll(sourceFile)
+	if readErr != nil {
+		return errorsx.Wrap(readErr, "hash", hash)
+	}
+
+	encoded, encodedSize, err := s.encodePackedObject(s.getObjectName(hash), contents, codec, level)
+	if err != nil {
+		return errorsx.Wrap(err, "hash", hash)
+	}
+
+	if codec != noneObjectCodec && encodedSize >= int64(len(contents)) {
+		// compression didn't save any space, pack the raw contents instead
+		codec = noneObjectCodec
+		encoded, _, err = s.encodePackedObject(s.getObjectName(hash), contents, codec, 0)
+		if err != nil {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+	}
+
+	err = s.verifyEncodedObject(bytes.NewReader(encoded), hash, codec)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return s.addToOpenPack(s.getObjectName(hash), codec.Encoding, encoded)
+}
+
+// encodePackedObject encodes (and, in an encrypted store, encrypts) the contents of the object with the name, to be packed.
+// It returns the bytes to pack, and the size of the encoded contents before encryption.
+func (s *IntelligentStoreDAL) encodePackedObject(name intelligentstore.Hash, contents []byte, codec *objectCodec, level int) ([]byte, int64, errorsx.Error) {
+	buffer := bytes.NewBuffer(nil)
+
+	_, encodedSize, err := s.encodeObjectWithEncryption(buffer, name, bytes.NewReader(contents), codec, level)
+	if err != nil {
+		return nil, 0, errorsx.Wrap(err)
+	}
+
+	return buffer.Bytes(), encodedSize, nil
+}
+
+// addToOpenPack appends an encoded object to the open pack, starting a new pack if there isn't one open.
+// When the pack reaches the target pack size, it is finished.
+func (s *IntelligentStoreDAL) addToOpenPack(name intelligentstore.Hash, encoding intelligentstore.ObjectEncoding, encoded []byte) errorsx.Error {
+	packs := s.packs
+	packs.mu.Lock()
+	defer packs.mu.Unlock()
+
+	if packs.openPack == nil {
+		packID, err := newPackID()
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		packs.openPack = &packWriter{
+			id:    packID,
+			file:  s.createFile(getPackKey(packID)),
+			names: make(map[intelligentstore.Hash]struct{}),
+		}
+	}
+
+	pack := packs.openPack
+
+	_, alreadyPacked := pack.names[name]
+	if alreadyPacked {
+		return nil
+	}
+
+	_, err := pack.file.Write(encoded)
+	if err != nil {
+		// the objects already added to the pack are lost with it. The transactions that added them find them missing when they are committed, and have to upload them again
+		pack.file.Abort()
+		packs.openPack = nil
+		return errorsx.Wrap(err, "packID", pack.id)
+	}
+
+	pack.entries = append(pack.entries, &packIndexEntry{
+		Name:     name,
+		Encoding: encoding,
+		Offset:   pack.size,
+		Length:   int64(len(encoded)),
+	})
+	pack.names[name] = struct{}{}
+	pack.size += int64(len(encoded))
+
+	if pack.size < targetPackSize {
+		return nil
+	}
+
+	return s.finishOpenPackLocked()
+}
+
+// finishOpenPack finishes the open pack, if there is one: the pack is stored, and then it's index.
+// Only after this are the objects in it in the store.
+func (s *IntelligentStoreDAL) finishOpenPack() errorsx.Error {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	return s.finishOpenPackLocked()
+}
+
+func (s *IntelligentStoreDAL) finishOpenPackLocked() errorsx.Error {
+	packs := s.packs
+	pack := packs.openPack
+	if pack == nil {
+		return nil
+	}
+
+	packs.openPack = nil
+
+	if len(pack.entries) == 0 {
+		pack.file.Abort()
+		return nil
+	}
+
+	// if the pack can't be finished, its objects are lost, as when a write to it fails
+	err := pack.file.Close()
+	if err != nil {
+		return errorsx.Wrap(err, "packID", pack.id)
+	}
+
+	index := &packIndex{Entries: pack.entries}
+	b, err := json.Marshal(index)
+	if err != nil {
+		s.deletePackWithoutIndex(pack.id)
+		return errorsx.Wrap(err, "packID", pack.id)
+	}
+
+	err = s.writeEncryptedFile(getPackIndexKey(pack.id), b)
+	if err != nil {
+		s.deletePackWithoutIndex(pack.id)
+		return errorsx.Wrap(err, "packID", pack.id)
+	}
+
+	if packs.indexedPackIDs != nil {
+		packs.addIndex(pack.id, index)
+	}
+
+	return nil
+}
+
+// deletePackWithoutIndex deletes a pack whose index couldn't be written. A pack without an index is never read from, so it would only take up space.
+func (s *IntelligentStoreDAL) deletePackWithoutIndex(packID string) {
+	err := s.backend.Delete(getPackKey(packID))
+	if err != nil {
+		slog.Warn("failed to delete a pack whose index couldn't be written", "packID", packID, "error", err)
+	}
+}
+
+// abortOpenPack discards the open pack, if there is one
+func (s *IntelligentStoreDAL) abortOpenPack() {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	s.abortOpenPackLocked()
+}
+
+func (s *IntelligentStoreDAL) abortOpenPackLocked() {
+	if s.packs.openPack == nil {
+		return
+	}
+
+	s.packs.openPack.file.Abort()
+	s.packs.openPack = nil
+}
+
+// transactionOpened records that a transaction has been opened in this process
+func (s *IntelligentStoreDAL) transactionOpened() {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	s.packs.openTransactions++
+}
+
+// transactionCommitted records that a transaction opened in this process has been committed
+func (s *IntelligentStoreDAL) transactionCommitted() {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	s.packs.openTransactions--
+}
+
+// transactionRolledBack records that a transaction opened in this process has been rolled back, and discards the open pack.
+// If other transactions in this process are still open, the open pack could also have their objects in it, so it is kept, and stored when they commit.
+// The objects only the rolled back transaction added are then left for the garbage collector.
+func (s *IntelligentStoreDAL) transactionRolledBack() {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+
+	s.packs.openTransactions--
+	if s.packs.openTransactions > 0 {
+		return
+	}
+
+	s.abortOpenPackLocked()
+}
@@ -0,0 +1,258 @@
+package dal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listKeys(t *testing.T, store *IntelligentStoreDAL, prefix string) []string {
+	objectInfos, err := store.backend.List(prefix)
+	require.Nil(t, err)
+
+	keys := []string{}
+	for _, objectInfo := range objectInfos {
+		keys = append(keys, objectInfo.Key)
+	}
+	return keys
+}
+
+func Test_packing(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+	store := mockStore.Store
+
+	err := store.SetPackingSettings(&intelligentstore.PackingSettings{MaxObjectSize: intelligentstore.MaxPackedObjectSize + 1})
+	require.Error(t, err)
+
+	require.Nil(t, store.SetPackingSettings(&intelligentstore.PackingSettings{MaxObjectSize: 1024}))
+
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	smallFile1 := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte("a text"))
+	smallFile2 := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", mockNow, FileMode600, []byte(strings.Repeat("compressible ", 50)))
+	emptyFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "empty.txt", mockNow, FileMode600, []byte{})
+	largeFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "large.txt", mockNow, FileMode600, bytes.Repeat([]byte("large "), 1000))
+
+	firstRevision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{smallFile1, smallFile2, emptyFile, largeFile})
+	mockNow = mockNow.Add(time.Hour)
+
+	assertContents := func(t *testing.T, store *IntelligentStoreDAL, file *intelligentstore.RegularFileDescriptorWithContents) {
+		assert.Equal(t, file.Contents, readObject(t, store, file.Descriptor.Hash))
+	}
+
+	t.Run("small objects are packed", func(t *testing.T) {
+		objectKeys := listKeys(t, store, objectsKeyPrefix)
+		require.Len(t, objectKeys, 1)
+		assert.Equal(t, store.getObjectKey(largeFile.Descriptor.Hash, gzipObjectCodec), objectKeys[0])
+
+		packIDs, err := store.listPackIDs()
+		require.Nil(t, err)
+		require.Len(t, packIDs, 1)
+		assert.Equal(t, []string{getPackIndexKey(packIDs[0]), getPackKey(packIDs[0])}, listKeys(t, store, packsKeyPrefix))
+
+		for _, file := range []*intelligentstore.RegularFileDescriptorWithContents{smallFile1, smallFile2, emptyFile, largeFile} {
+			assertContents(t, store, file)
+
+			isPresent, err := store.IsObjectPresent(file.Descriptor.Hash)
+			require.Nil(t, err)
+			assert.True(t, isPresent)
+		}
+
+		object, err := store.findObject(smallFile2.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.Equal(t, getPackKey(packIDs[0]), object.Key)
+		assert.Equal(t, gzipObjectCodec, object.Codec)
+
+		objectInfo, err := store.StatFile(smallFile1.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.Equal(t, getPackKey(packIDs[0]), objectInfo.Key)
+
+		gzippedObject, err := store.GetGzippedObjectByHash(smallFile2.Descriptor.Hash)
+		require.Nil(t, err)
+		defer gzippedObject.Close()
+
+		gzipReader, gzipErr := gzip.NewReader(gzippedObject)
+		require.NoError(t, gzipErr)
+		b, readErr := io.ReadAll(gzipReader)
+		require.NoError(t, readErr)
+		assert.Equal(t, smallFile2.Contents, b)
+	})
+
+	t.Run("packed objects are found by a new connection", func(t *testing.T) {
+		newStore, err := newIntelligentStoreConnToExisting(store.StoreBasePath, mockNowProvider, fs, nil)
+		require.Nil(t, err)
+
+		assertContents(t, newStore, smallFile1)
+
+		result, err := newStore.RevisionDAL.VerifyRevision(bucket, firstRevision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Len(t, result.Failures, 0)
+
+		report, err := newStore.Fsck(FsckOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, int64(1), report.PacksChecked)
+		assert.Len(t, report.Problems, 0)
+	})
+
+	t.Run("rollback discards the open pack", func(t *testing.T) {
+		file := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", mockNow, FileMode600, []byte("c text"))
+		tx, err := store.TransactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{file.Descriptor.GetFileInfo()})
+		require.Nil(t, err)
+
+		_, err = tx.ProcessUploadHashesAndGetRequiredHashes([]*intelligentstore.RelativePathWithHash{
+			intelligentstore.NewRelativePathWithHash(file.Descriptor.RelativePath, file.Descriptor.Hash),
+		})
+		require.Nil(t, err)
+
+		require.Nil(t, store.TransactionDAL.BackupFile(tx, bytes.NewReader(file.Contents)))
+		require.Nil(t, store.TransactionDAL.Rollback(tx))
+
+		assert.Len(t, listKeys(t, store, packsKeyPrefix), 2)
+
+		isPresent, err := store.IsObjectPresent(file.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.False(t, isPresent)
+	})
+
+	secondRevision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{
+		smallFile1,
+		intelligentstore.NewRegularFileDescriptorWithContents(t, "d.txt", mockNow, FileMode600, []byte("d text")),
+	})
+
+	t.Run("repack", func(t *testing.T) {
+		packIDs, err := store.listPackIDs()
+		require.Nil(t, err)
+		require.Len(t, packIDs, 2)
+
+		// no objects are unreferenced yet, but the two small packs can be merged
+		result, err := store.Repack(RepackOptions{DryRun: true, MinUnreferencedPercent: 25})
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), result.PacksRewritten)
+		assert.Equal(t, int64(0), result.ObjectsDropped)
+
+		require.Nil(t, store.backend.Delete(store.TransactionDAL.revisionManifestWriter.GetManifestFileKey(firstRevision)))
+		_, err = store.RebuildObjectReferenceIndex()
+		require.Nil(t, err)
+
+		gcResult, err := store.GarbageCollect(GarbageCollectionOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), gcResult.UnreferencedPackedObjects)
+
+		result, err = store.Repack(RepackOptions{MinUnreferencedPercent: 25})
+		require.Nil(t, err)
+		assert.Equal(t, int64(2), result.PacksRewritten)
+		assert.Equal(t, int64(2), result.ObjectsKept)
+		assert.Equal(t, int64(2), result.ObjectsDropped)
+
+		newPackIDs, err := store.listPackIDs()
+		require.Nil(t, err)
+		require.Len(t, newPackIDs, 1)
+		assert.NotContains(t, packIDs, newPackIDs[0])
+
+		assertContents(t, store, smallFile1)
+
+		isPresent, err := store.IsObjectPresent(smallFile2.Descriptor.Hash)
+		require.Nil(t, err)
+		assert.False(t, isPresent)
+
+		verifyResult, err := store.RevisionDAL.VerifyRevision(bucket, secondRevision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Len(t, verifyResult.Failures, 0)
+
+		// a pack on it's own is left as it is
+		result, err = store.Repack(RepackOptions{MinUnreferencedPercent: 25})
+		require.Nil(t, err)
+		assert.Equal(t, int64(0), result.PacksRewritten)
+	})
+
+	t.Run("fsck finds packs without an index", func(t *testing.T) {
+		leftoverPackKey := getPackKey("abcdef0123456789")
+		require.Nil(t, store.backend.Put(leftoverPackKey, strings.NewReader("partial pack")))
+
+		report, err := store.Fsck(FsckOptions{Repair: true})
+		require.Nil(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, FsckProblemTypeBadPack, report.Problems[0].Type)
+		assert.Equal(t, leftoverPackKey, report.Problems[0].Path)
+		assert.True(t, report.Problems[0].Repaired)
+
+		assert.NotContains(t, listKeys(t, store, packsKeyPrefix), leftoverPackKey)
+	})
+}
+
+func Test_packing_lostPackContentsAreUploadedAgain(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	store := mockStore.Store
+	require.Nil(t, store.SetPackingSettings(&intelligentstore.PackingSettings{MaxObjectSize: 1024}))
+
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	file := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	tx, err := store.TransactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{file.Descriptor.GetFileInfo()})
+	require.Nil(t, err)
+
+	_, err = tx.ProcessUploadHashesAndGetRequiredHashes([]*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash(file.Descriptor.RelativePath, file.Descriptor.Hash),
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, store.TransactionDAL.BackupFile(tx, bytes.NewReader(file.Contents)))
+	assert.Equal(t, intelligentstore.UploadStatusCompleted, tx.UploadStatusMap[file.Descriptor.Hash])
+
+	// the pack the contents were added to can't be stored
+	store.abortOpenPack()
+
+	err = store.TransactionDAL.Commit(tx)
+	require.Error(t, err)
+	assert.Equal(t, ErrUploadedContentsMissing, errorsx.Cause(err))
+	assert.Equal(t, intelligentstore.UploadStatusPending, tx.UploadStatusMap[file.Descriptor.Hash])
+
+	revisions, err := store.RevisionDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	assert.Len(t, revisions, 0)
+
+	require.Nil(t, store.TransactionDAL.BackupFile(tx, bytes.NewReader(file.Contents)))
+	require.Nil(t, store.TransactionDAL.Commit(tx))
+
+	assert.Equal(t, file.Contents, readObject(t, store, file.Descriptor.Hash))
+}
+
+func Test_packingInEncryptedStore(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	store := mockStore.Store
+	require.Nil(t, store.SetupEncryption("my passphrase"))
+	require.Nil(t, store.SetPackingSettings(&intelligentstore.PackingSettings{MaxObjectSize: 1024}))
+
+	bucket := mockStore.CreateBucket(t, "docs")
+	file := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", MockNowProvider(), FileMode600, []byte("secret text"))
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{file})
+
+	packIDs, err := store.listPackIDs()
+	require.Nil(t, err)
+	require.Len(t, packIDs, 1)
+
+	pack, err := store.readFile(getPackKey(packIDs[0]))
+	require.Nil(t, err)
+	assert.False(t, bytes.Contains(pack, file.Contents))
+
+	packIndex, err := store.readFile(getPackIndexKey(packIDs[0]))
+	require.Nil(t, err)
+	assert.False(t, bytes.Contains(packIndex, []byte(file.Descriptor.Hash)))
+
+	assert.Equal(t, file.Contents, readObject(t, store, file.Descriptor.Hash))
+}
@@ -0,0 +1,210 @@
+package dal
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/pkg/errors"
+)
+
+var ErrNoRetentionPolicy = errors.New("bucket has no retention policy")
+
+// PruneOptions configures a prune of a bucket
+type PruneOptions struct {
+	// DryRun only reports which revisions would be pruned; nothing is changed on disk
+	DryRun bool
+}
+
+// PruneResult is a report of the revisions kept and pruned from a bucket
+type PruneResult struct {
+	DryRun bool                         `json:"dryRun"`
+	Kept   []*intelligentstore.Revision `json:"kept"`
+	Pruned []*intelligentstore.Revision `json:"pruned"`
+}
+
+// PruneBucket evaluates the retention policy of the bucket against its revisions, and deletes the revisions that are not to be kept.
+// Only the revision manifests are deleted; objects that are no longer referenced are left in place, ready for the garbage collector.
+func (s *IntelligentStoreDAL) PruneBucket(bucket *intelligentstore.Bucket, options PruneOptions) (*PruneResult, errorsx.Error) {
+	if bucket.RetentionPolicy == nil {
+		return nil, errorsx.Wrap(ErrNoRetentionPolicy, "bucket", bucket.BucketName)
+	}
+
+	_, err := s.LockDAL.acquireStoreLock(fmt.Sprintf("lock from prune. Bucket: %d (%s)", bucket.ID, bucket.BucketName))
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer func() {
+		removeLockErr := s.LockDAL.removeStoreLock()
+		if removeLockErr != nil {
+			log.Printf("failed to remove store lock after pruning. Error: %q\n", removeLockErr)
+		}
+	}()
+
+	revisions, err := s.BucketDAL.GetRevisions(bucket)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	toKeep, toPrune := bucket.RetentionPolicy.Evaluate(revisions, s.nowProvider())
+
+	result := &PruneResult{
+		DryRun: options.DryRun,
+		Kept:   toKeep,
+		Pruned: toPrune,
+	}
+
+	if options.DryRun || len(toPrune) == 0 {
+		return result, nil
+	}
+
+	err = s.LockDAL.checkStoreLockHeld()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = s.RevisionDAL.deleteRevisionManifests(toPrune)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return result, nil
+}
+
+type movedManifest struct {
+	originalKey, trashPath string
+}
+
+// deleteRevisionManifests deletes the manifests of the revisions given as a single unit.
+// All manifests are first moved into a folder in the temp store. If any manifest can't be moved, the ones already moved are put back and no revision is deleted.
+// The references from the deleted revisions are removed from the object reference index, if the store has one.
+func (r *RevisionDAL) deleteRevisionManifests(revisions []*intelligentstore.Revision) errorsx.Error {
+	index, err := r.readObjectReferenceIndex()
+	if err != nil {
+		if !os.IsNotExist(errorsx.Cause(err)) {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	if index != nil {
+		// the manifests are read before they are deleted
+		for _, revision := range revisions {
+			descriptors, err := r.GetFilesInRevision(revision.Bucket, revision)
+			if err != nil {
+				return errorsx.Wrap(err)
+			}
+
+			index.removeRevision(revision, descriptors)
+		}
+	}
+
+	trashDirPath, err := r.TempStoreDAL.CreateTempDir()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	var movedManifests []movedManifest
+	for _, revision := range revisions {
+		err = r.moveManifestToTrash(revision, trashDirPath, &movedManifests)
+		if err != nil {
+			restoreErr := r.restoreMovedManifests(movedManifests)
+			if restoreErr != nil {
+				return errorsx.Wrap(restoreErr, "original error", err.Error())
+			}
+			return errorsx.Wrap(err, "bucket", revision.Bucket.ID, "revision", revision.VersionTimestamp)
+		}
+	}
+
+	removeErr := r.fs.RemoveAll(trashDirPath)
+	if removeErr != nil {
+		return errorsx.Wrap(removeErr)
+	}
+
+	// without their manifests, the infos of the revisions are not used any more, so failing to delete them is only logged
+	for _, revision := range revisions {
+		err = r.deleteRevisionInfo(revision)
+		if err != nil {
+			log.Printf("failed to delete the info of revision %d in bucket %d. Error: %q\n", revision.VersionTimestamp, revision.Bucket.ID, err)
+		}
+	}
+
+	if index != nil {
+		err = r.writeObjectReferenceIndex(index)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *RevisionDAL) moveManifestToTrash(revision *intelligentstore.Revision, trashDirPath string, movedManifests *[]movedManifest) errorsx.Error {
+	var err error
+
+	reader, err := r.getRevisionReader(revision)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	trashPath := filepath.Join(trashDirPath, fmt.Sprintf("%d-%s", revision.Bucket.ID, path.Base(reader.FileKey)))
+	err = r.copyManifestToTrash(reader.FileKey, trashPath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	err = r.backend.Delete(reader.FileKey)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	*movedManifests = append(*movedManifests, movedManifest{reader.FileKey, trashPath})
+
+	return nil
+}
+
+func (r *RevisionDAL) copyManifestToTrash(key, trashPath string) errorsx.Error {
+	manifestFile, err := r.backend.Get(key)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer manifestFile.Close()
+
+	trashFile, createErr := r.fs.Create(trashPath)
+	if createErr != nil {
+		return errorsx.Wrap(createErr)
+	}
+	defer trashFile.Close()
+
+	_, copyErr := io.Copy(trashFile, manifestFile)
+	if copyErr != nil {
+		return errorsx.Wrap(copyErr, "manifest key", key)
+	}
+
+	return errorsx.Wrap(trashFile.Close())
+}
+
+func (r *RevisionDAL) restoreMovedManifests(movedManifests []movedManifest) errorsx.Error {
+	for _, manifest := range movedManifests {
+		err := r.restoreMovedManifest(manifest)
+		if err != nil {
+			return errorsx.Wrap(err, "manifest key", manifest.originalKey)
+		}
+	}
+
+	return nil
+}
+
+func (r *RevisionDAL) restoreMovedManifest(manifest movedManifest) errorsx.Error {
+	trashFile, err := r.fs.Open(manifest.trashPath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer trashFile.Close()
+
+	return r.backend.Put(manifest.originalKey, trashFile)
+}
@@ -0,0 +1,74 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PruneBucket(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	var revisions []*intelligentstore.Revision
+	for _, contents := range []string{"version 1", "version 2", "version 3"} {
+		revisions = append(revisions, mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{
+			intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte(contents)),
+		}))
+		mockNow = mockNow.Add(time.Hour)
+	}
+
+	_, err := mockStore.Store.PruneBucket(bucket, PruneOptions{})
+	assert.Equal(t, ErrNoRetentionPolicy, errorsx.Cause(err))
+
+	err = mockStore.Store.BucketDAL.SetRetentionPolicy(bucket, &intelligentstore.RetentionPolicy{KeepLast: 2})
+	require.Nil(t, err)
+
+	fetchedBucket, err := mockStore.Store.BucketDAL.GetBucketByName("docs")
+	require.Nil(t, err)
+	assert.Equal(t, 2, fetchedBucket.RetentionPolicy.KeepLast)
+
+	result, err := mockStore.Store.PruneBucket(fetchedBucket, PruneOptions{DryRun: true})
+	require.Nil(t, err)
+	require.Len(t, result.Pruned, 1)
+	assert.Equal(t, revisions[0].VersionTimestamp, result.Pruned[0].VersionTimestamp)
+
+	storedRevisions, err := mockStore.Store.BucketDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	assert.Len(t, storedRevisions, 3)
+
+	result, err = mockStore.Store.PruneBucket(fetchedBucket, PruneOptions{})
+	require.Nil(t, err)
+	assert.Len(t, result.Kept, 2)
+
+	storedRevisions, err = mockStore.Store.BucketDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	require.Len(t, storedRevisions, 2)
+
+	_, err = mockStore.Store.BucketDAL.GetRevision(bucket, revisions[0].VersionTimestamp)
+	assert.Equal(t, ErrRevisionDoesNotExist, errorsx.Cause(err))
+
+	// the info of the pruned revision is deleted with it
+	revisionInfo, err := mockStore.Store.RevisionDAL.GetRevisionInfo(revisions[0])
+	require.Nil(t, err)
+	assert.Nil(t, revisionInfo)
+
+	revisionInfo, err = mockStore.Store.RevisionDAL.GetRevisionInfo(revisions[1])
+	require.Nil(t, err)
+	assert.NotNil(t, revisionInfo)
+
+	gcResult, err := mockStore.Store.GarbageCollect(GarbageCollectionOptions{})
+	require.Nil(t, err)
+	assert.Len(t, gcResult.UnreferencedObjects, 1)
+}
@@ -0,0 +1,224 @@
+package dal
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/humanise"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// RepackOptions configures which packs are rewritten by Repack
+type RepackOptions struct {
+	// DryRun only reports what would be repacked; nothing is changed in the store
+	DryRun bool
+	// MinUnreferencedPercent is the percentage of a pack's bytes that have to be unreferenced for the pack to be rewritten
+	MinUnreferencedPercent float64
+}
+
+// RepackResult is a report of a repack
+type RepackResult struct {
+	DryRun         bool  `json:"dryRun"`
+	PacksScanned   int64 `json:"packsScanned"`
+	PacksRewritten int64 `json:"packsRewritten"`
+	// ObjectsKept is how many objects were copied from the rewritten packs into new packs
+	ObjectsKept int64 `json:"objectsKept"`
+	// ObjectsDropped is how many unreferenced (or duplicated) objects in the rewritten packs were left out of the new packs
+	ObjectsDropped int64 `json:"objectsDropped"`
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// packToRepack is a pack, and the objects in it that are kept if it is rewritten
+type packToRepack struct {
+	packID         string
+	keptEntries    []*packIndexEntry
+	droppedObjects int64
+	liveBytes      int64
+	deadBytes      int64
+}
+
+// Repack consolidates packs: packs with too many unreferenced objects (for example after pruning), and packs that are much smaller than the target pack size,
+// are rewritten into new packs with only the referenced objects. The old packs are removed once the new packs are stored.
+// It holds the store lock while running, so it cannot run at the same time as a transaction.
+func (s *IntelligentStoreDAL) Repack(options RepackOptions) (*RepackResult, errorsx.Error) {
+	_, err := s.LockDAL.acquireStoreLock("lock from repack")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer func() {
+		removeLockErr := s.LockDAL.removeStoreLock()
+		if removeLockErr != nil {
+			log.Printf("failed to remove store lock after repack. Error: %q\n", removeLockErr)
+		}
+	}()
+
+	liveObjectNames, _, err := s.getLiveObjectNames()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	packIDs, err := s.listPackIDs()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	result := &RepackResult{
+		DryRun:       options.DryRun,
+		PacksScanned: int64(len(packIDs)),
+	}
+
+	// an object can be in more than one pack (for example after an interrupted repack). Only the first copy is kept.
+	seenObjectNames := make(map[intelligentstore.Hash]struct{})
+	var sparsePacks, smallPacks []*packToRepack
+	for _, packID := range packIDs {
+		index, err := s.readPackIndex(packID)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+
+		pack := &packToRepack{packID: packID}
+		for _, entry := range index.Entries {
+			_, isLive := liveObjectNames[entry.Name]
+			_, isSeen := seenObjectNames[entry.Name]
+			if !isLive || isSeen {
+				pack.droppedObjects++
+				pack.deadBytes += entry.Length
+				continue
+			}
+
+			seenObjectNames[entry.Name] = struct{}{}
+			pack.keptEntries = append(pack.keptEntries, entry)
+			pack.liveBytes += entry.Length
+		}
+
+		totalBytes := pack.liveBytes + pack.deadBytes
+		switch {
+		case pack.deadBytes != 0 && float64(pack.deadBytes)*100 >= float64(totalBytes)*options.MinUnreferencedPercent:
+			sparsePacks = append(sparsePacks, pack)
+		case pack.liveBytes < targetPackSize/2:
+			smallPacks = append(smallPacks, pack)
+		}
+	}
+
+	packsToRewrite := sparsePacks
+	if len(smallPacks) > 1 || (len(smallPacks) == 1 && len(sparsePacks) != 0) {
+		// small packs are only worth rewriting if they can be merged with another pack
+		packsToRewrite = append(packsToRewrite, smallPacks...)
+	}
+
+	for _, pack := range packsToRewrite {
+		result.PacksRewritten++
+		result.ObjectsKept += int64(len(pack.keptEntries))
+		result.ObjectsDropped += pack.droppedObjects
+		result.ReclaimedBytes += pack.deadBytes
+	}
+
+	if options.DryRun || len(packsToRewrite) == 0 {
+		return result, nil
+	}
+
+	for _, pack := range packsToRewrite {
+		err = s.LockDAL.checkStoreLockHeld()
+		if err != nil {
+			s.abortOpenPack()
+			return nil, errorsx.Wrap(err)
+		}
+
+		err = s.copyPackedObjectsToOpenPack(pack)
+		if err != nil {
+			s.abortOpenPack()
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	err = s.finishOpenPack()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = s.LockDAL.checkStoreLockHeld()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	// the new packs are complete, so the old ones can be removed. The index is removed first, so that the pack is never used without it's index.
+	for _, pack := range packsToRewrite {
+		err = s.backend.Delete(getPackIndexKey(pack.packID))
+		if err != nil {
+			return nil, errorsx.Wrap(err, "packID", pack.packID)
+		}
+
+		err = s.backend.Delete(getPackKey(pack.packID))
+		if err != nil {
+			return nil, errorsx.Wrap(err, "packID", pack.packID)
+		}
+	}
+
+	err = s.reloadPackIndexes()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return result, nil
+}
+
+// copyPackedObjectsToOpenPack reads through a pack, and adds the objects that are kept to the open pack.
+// The objects are copied as they are stored, so they don't need to be decoded (or decrypted) and encoded again.
+func (s *IntelligentStoreDAL) copyPackedObjectsToOpenPack(pack *packToRepack) errorsx.Error {
+	if len(pack.keptEntries) == 0 {
+		return nil
+	}
+
+	entries := append([]*packIndexEntry{}, pack.keptEntries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	file, err := s.backend.Get(getPackKey(pack.packID))
+	if err != nil {
+		return errorsx.Wrap(err, "packID", pack.packID)
+	}
+	defer file.Close()
+
+	var position int64
+	for _, entry := range entries {
+		_, copyErr := io.CopyN(io.Discard, file, entry.Offset-position)
+		if copyErr != nil {
+			return errorsx.Wrap(copyErr, "packID", pack.packID)
+		}
+
+		encoded := make([]byte, entry.Length)
+		_, readErr := io.ReadFull(file, encoded)
+		if readErr != nil {
+			return errorsx.Wrap(readErr, "packID", pack.packID, "name", entry.Name)
+		}
+		position = entry.Offset + entry.Length
+
+		err = s.addToOpenPack(entry.Name, entry.Encoding, encoded)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *RepackResult) String() string {
+	verb := "rewritten"
+	if r.DryRun {
+		verb = "would be rewritten"
+	}
+
+	return fmt.Sprintf(
+		"scanned %d packs. %d packs %s, keeping %d objects and dropping %d unreferenced objects (%s)",
+		r.PacksScanned,
+		r.PacksRewritten,
+		verb,
+		r.ObjectsKept,
+		r.ObjectsDropped,
+		humanise.HumaniseBytes(r.ReclaimedBytes),
+	)
+}
@@ -0,0 +1,389 @@
+package dal
+
+import (
+	"io"
+	"log"
+	"sort"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// ReplicationDestination is a store that revisions are replicated to. It is either another store opened in this process (see NewStoreReplicationDestination), or a store behind a web server.
+type ReplicationDestination interface {
+	// GetRevisionVersions gets the versions of the revisions in the bucket. If the bucket doesn't exist, the cause of the returned error is ErrBucketDoesNotExist.
+	GetRevisionVersions(bucketName string) ([]intelligentstore.RevisionVersion, errorsx.Error)
+	CreateBucket(bucketName string) errorsx.Error
+	// OpenTransaction starts a transaction for a revision with this version in the bucket.
+	// The revision info (nil if the revision doesn't have one) is written unchanged in the destination when the transaction is committed.
+	OpenTransaction(bucketName string, revisionVersion intelligentstore.RevisionVersion, fileInfos []*intelligentstore.FileInfo, revisionInfo *intelligentstore.RevisionInfo) (ReplicationTransaction, errorsx.Error)
+}
+
+// ReplicationTransaction is a transaction in a replication destination. It goes through the same stages as a backup: symlinks and hashes are sent, then the contents the destination doesn't have, and then it is committed.
+type ReplicationTransaction interface {
+	// RequiredRelativePaths are the files the destination doesn't have from its latest revision
+	RequiredRelativePaths() []intelligentstore.RelativePath
+	// HashAlgorithm is the algorithm the destination hashes contents with
+	HashAlgorithm() intelligentstore.HashAlgorithm
+	UploadSymlinks(symlinks []*intelligentstore.SymlinkWithRelativePath) errorsx.Error
+	// UploadHashes sends the hashes of the required regular files, and returns the hashes of the contents the destination doesn't have yet
+	UploadHashes(relativePathsWithHashes []*intelligentstore.RelativePathWithHash) ([]intelligentstore.Hash, errorsx.Error)
+	// UploadContents sends contents the destination doesn't have yet. The destination hashes them again, and refuses them if they aren't contents it needs.
+	UploadContents(contents io.ReadSeeker) errorsx.Error
+	// Commit writes the revision manifest in the destination. It is only done once all the contents are there, so a revision in the destination never references missing contents.
+	Commit() errorsx.Error
+	// Rollback aborts the transaction in the destination, and releases the lock on the bucket
+	Rollback() errorsx.Error
+}
+
+// ReplicateOptions are options for replicating the store
+type ReplicateOptions struct {
+	// BucketNames are the buckets to replicate. Every bucket is replicated if it is empty.
+	BucketNames []string
+}
+
+// ReplicateResult is a report of replicating the store
+type ReplicateResult struct {
+	BucketsCreated []string `json:"bucketsCreated"`
+	// RevisionsReplicated is the amount of revisions copied to the destination
+	RevisionsReplicated int64 `json:"revisionsReplicated"`
+	// RevisionsSkipped is the amount of revisions the destination had already
+	RevisionsSkipped int64 `json:"revisionsSkipped"`
+	// ObjectsTransferred is the amount of contents sent to the destination
+	ObjectsTransferred int64 `json:"objectsTransferred"`
+	// BytesTransferred is the size of the contents sent to the destination, before they are compressed
+	BytesTransferred int64 `json:"bytesTransferred"`
+}
+
+// Replicate copies the revisions the destination doesn't have to it, oldest first, and keeps their versions.
+// Only the contents the destination doesn't have already are sent, and each of them is checked against its hash before it is sent.
+// It can be run again to continue after it is interrupted; revisions are either replicated in full, or not at all.
+func (s *IntelligentStoreDAL) Replicate(destination ReplicationDestination, options ReplicateOptions) (*ReplicateResult, errorsx.Error) {
+	sharedLock, err := s.LockDAL.AcquireSharedLock("lock from replicating")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	defer func() {
+		releaseErr := s.LockDAL.ReleaseSharedLock(sharedLock)
+		if releaseErr != nil {
+			log.Printf("failed to release shared lock after replicating. Error: %q\n", releaseErr)
+		}
+	}()
+
+	buckets, err := s.getBucketsToReplicate(options.BucketNames)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	result := &ReplicateResult{
+		BucketsCreated: []string{},
+	}
+
+	for _, bucket := range buckets {
+		err = s.replicateBucket(destination, bucket, result)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "bucket", bucket.BucketName)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *IntelligentStoreDAL) getBucketsToReplicate(bucketNames []string) ([]*intelligentstore.Bucket, errorsx.Error) {
+	if len(bucketNames) == 0 {
+		return s.BucketDAL.GetAllBuckets()
+	}
+
+	var buckets []*intelligentstore.Bucket
+	for _, bucketName := range bucketNames {
+		bucket, err := s.BucketDAL.GetBucketByName(bucketName)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "bucketName", bucketName)
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+func (s *IntelligentStoreDAL) replicateBucket(destination ReplicationDestination, bucket *intelligentstore.Bucket, result *ReplicateResult) errorsx.Error {
+	destinationRevisionVersions, err := destination.GetRevisionVersions(bucket.BucketName)
+	if err != nil {
+		if errorsx.Cause(err) != ErrBucketDoesNotExist {
+			return errorsx.Wrap(err)
+		}
+
+		err = destination.CreateBucket(bucket.BucketName)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		result.BucketsCreated = append(result.BucketsCreated, bucket.BucketName)
+	}
+
+	destinationRevisionVersionsSet := make(map[intelligentstore.RevisionVersion]struct{})
+	for _, revisionVersion := range destinationRevisionVersions {
+		destinationRevisionVersionsSet[revisionVersion] = struct{}{}
+	}
+
+	revisions, err := s.BucketDAL.GetRevisions(bucket)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	// oldest first, so that the destination can use each revision it has replicated to skip the unchanged files of the next one
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].VersionTimestamp < revisions[j].VersionTimestamp
+	})
+
+	for _, revision := range revisions {
+		_, ok := destinationRevisionVersionsSet[revision.VersionTimestamp]
+		if ok {
+			result.RevisionsSkipped++
+			continue
+		}
+
+		err = s.replicateRevision(destination, bucket, revision, result)
+		if err != nil {
+			return errorsx.Wrap(err, "revision", revision.VersionTimestamp)
+		}
+
+		result.RevisionsReplicated++
+	}
+
+	return nil
+}
+
+func (s *IntelligentStoreDAL) replicateRevision(destination ReplicationDestination, bucket *intelligentstore.Bucket, revision *intelligentstore.Revision, result *ReplicateResult) errorsx.Error {
+	descriptors, err := s.RevisionDAL.GetFilesInRevision(bucket, revision)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	var fileInfos []*intelligentstore.FileInfo
+	descriptorsMap := make(map[intelligentstore.RelativePath]intelligentstore.FileDescriptor)
+	for _, descriptor := range descriptors {
+		fileInfos = append(fileInfos, descriptor.GetFileInfo())
+		descriptorsMap[descriptor.GetFileInfo().RelativePath] = descriptor
+	}
+
+	revisionInfo, err := s.RevisionDAL.GetRevisionInfo(revision)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	tx, err := destination.OpenTransaction(bucket.BucketName, revision.VersionTimestamp, fileInfos, revisionInfo)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	err = s.uploadRevisionToDestination(tx, descriptorsMap, result)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			log.Printf("failed to roll back the transaction in the destination after failing to replicate revision %d. Error: %q\n", revision.VersionTimestamp, rollbackErr)
+		}
+		return errorsx.Wrap(err)
+	}
+
+	return tx.Commit()
+}
+
+// uploadRevisionToDestination sends the symlinks and hashes of the files the destination requires, and then the contents it doesn't have
+func (s *IntelligentStoreDAL) uploadRevisionToDestination(tx ReplicationTransaction, descriptorsMap map[intelligentstore.RelativePath]intelligentstore.FileDescriptor, result *ReplicateResult) errorsx.Error {
+	var symlinks []*intelligentstore.SymlinkWithRelativePath
+	var relativePathsWithHashes []*intelligentstore.RelativePathWithHash
+
+	for _, relativePath := range tx.RequiredRelativePaths() {
+		descriptor, ok := descriptorsMap[relativePath]
+		if !ok {
+			return errorsx.Errorf("the destination requires %q, which is not in the revision", relativePath)
+		}
+
+		switch typedDescriptor := descriptor.(type) {
+		case *intelligentstore.SymlinkFileDescriptor:
+			symlinks = append(symlinks, &intelligentstore.SymlinkWithRelativePath{
+				RelativePath: relativePath,
+				Dest:         typedDescriptor.Dest,
+			})
+		case *intelligentstore.RegularFileDescriptor:
+			if typedDescriptor.Hash.Algorithm() != tx.HashAlgorithm() {
+				return errorsx.Errorf(
+					"%q was hashed with %q, but the destination hashes contents with %q. Revisions can only be replicated to a store with the same hash algorithm",
+					relativePath,
+					typedDescriptor.Hash.Algorithm(),
+					tx.HashAlgorithm(),
+				)
+			}
+
+			relativePathsWithHashes = append(relativePathsWithHashes, intelligentstore.NewRelativePathWithHash(relativePath, typedDescriptor.Hash))
+		default:
+			return errorsx.Errorf("unsupported file type: %s (%q)", descriptor.GetFileInfo().Type, relativePath)
+		}
+	}
+
+	if len(symlinks) != 0 {
+		err := tx.UploadSymlinks(symlinks)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	// the hashes are sent even if there are none, to move the transaction on to the stage where it can be committed
+	requiredHashes, err := tx.UploadHashes(relativePathsWithHashes)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, hash := range requiredHashes {
+		size, err := s.uploadContentsToDestination(tx, hash)
+		if err != nil {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+
+		result.ObjectsTransferred++
+		result.BytesTransferred += size
+	}
+
+	return nil
+}
+
+// uploadContentsToDestination copies the contents to the temp store, checks them against their hash, and sends them to the destination.
+// They are checked before they are sent, so that contents that are corrupt in this store are reported as such, rather than as refused by the destination.
+func (s *IntelligentStoreDAL) uploadContentsToDestination(tx ReplicationTransaction, hash intelligentstore.Hash) (int64, errorsx.Error) {
+	contents, err := s.GetObjectByHash(hash)
+	if err != nil {
+		return 0, errorsx.Wrap(err)
+	}
+	defer contents.Close()
+
+	tempFile, tempFilePath, err := s.TempStoreDAL.CreateTempFile()
+	if err != nil {
+		return 0, errorsx.Wrap(err)
+	}
+	defer func() {
+		tempFile.Close()
+		removeErr := s.fs.Remove(tempFilePath)
+		if removeErr != nil {
+			log.Printf("failed to remove temp file %q after replicating it. Error: %q\n", tempFilePath, removeErr)
+		}
+	}()
+
+	size, copyErr := io.Copy(tempFile, contents)
+	if copyErr != nil {
+		return 0, errorsx.Wrap(copyErr)
+	}
+
+	_, seekErr := tempFile.Seek(0, io.SeekStart)
+	if seekErr != nil {
+		return 0, errorsx.Wrap(seekErr)
+	}
+
+	actualHash, hashErr := intelligentstore.NewHashWithAlgorithm(tempFile, hash.Algorithm())
+	if hashErr != nil {
+		return 0, errorsx.Wrap(hashErr)
+	}
+
+	if actualHash != hash {
+		return 0, errorsx.Errorf("the contents are corrupt in this store: they have the hash %q. Run the fsck command to find out what is affected", actualHash)
+	}
+
+	_, seekErr = tempFile.Seek(0, io.SeekStart)
+	if seekErr != nil {
+		return 0, errorsx.Wrap(seekErr)
+	}
+
+	err = tx.UploadContents(tempFile)
+	if err != nil {
+		return 0, errorsx.Wrap(err)
+	}
+
+	return size, nil
+}
+
+// storeReplicationDestination replicates to another store opened in this process, for example on another disk or in object storage
+type storeReplicationDestination struct {
+	store *IntelligentStoreDAL
+}
+
+// NewStoreReplicationDestination creates a replication destination for a store opened in this process
+func NewStoreReplicationDestination(store *IntelligentStoreDAL) ReplicationDestination {
+	return &storeReplicationDestination{store}
+}
+
+func (d *storeReplicationDestination) GetRevisionVersions(bucketName string) ([]intelligentstore.RevisionVersion, errorsx.Error) {
+	bucket, err := d.store.BucketDAL.GetBucketByName(bucketName)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	revisions, err := d.store.BucketDAL.GetRevisions(bucket)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var revisionVersions []intelligentstore.RevisionVersion
+	for _, revision := range revisions {
+		revisionVersions = append(revisionVersions, revision.VersionTimestamp)
+	}
+
+	return revisionVersions, nil
+}
+
+func (d *storeReplicationDestination) CreateBucket(bucketName string) errorsx.Error {
+	_, err := d.store.BucketDAL.CreateBucket(bucketName)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *storeReplicationDestination) OpenTransaction(bucketName string, revisionVersion intelligentstore.RevisionVersion, fileInfos []*intelligentstore.FileInfo, revisionInfo *intelligentstore.RevisionInfo) (ReplicationTransaction, errorsx.Error) {
+	bucket, err := d.store.BucketDAL.GetBucketByName(bucketName)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	tx, err := d.store.TransactionDAL.CreateTransactionForRevisionVersion(bucket, revisionVersion, fileInfos, revisionInfo)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return &storeReplicationTransaction{d.store, tx}, nil
+}
+
+type storeReplicationTransaction struct {
+	store *IntelligentStoreDAL
+	tx    *intelligentstore.Transaction
+}
+
+func (t *storeReplicationTransaction) RequiredRelativePaths() []intelligentstore.RelativePath {
+	return t.tx.GetRelativePathsRequired()
+}
+
+func (t *storeReplicationTransaction) HashAlgorithm() intelligentstore.HashAlgorithm {
+	return t.tx.HashAlgorithm
+}
+
+func (t *storeReplicationTransaction) UploadSymlinks(symlinks []*intelligentstore.SymlinkWithRelativePath) errorsx.Error {
+	return t.tx.ProcessSymlinks(symlinks)
+}
+
+func (t *storeReplicationTransaction) UploadHashes(relativePathsWithHashes []*intelligentstore.RelativePathWithHash) ([]intelligentstore.Hash, errorsx.Error) {
+	return t.store.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(t.tx, relativePathsWithHashes)
+}
+
+func (t *storeReplicationTransaction) UploadContents(contents io.ReadSeeker) errorsx.Error {
+	return t.store.TransactionDAL.BackupFile(t.tx, contents)
+}
+
+func (t *storeReplicationTransaction) Commit() errorsx.Error {
+	return t.store.TransactionDAL.Commit(t.tx)
+}
+
+func (t *storeReplicationTransaction) Rollback() errorsx.Error {
+	return t.store.TransactionDAL.Abort(t.tx)
+}
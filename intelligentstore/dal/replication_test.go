@@ -0,0 +1,178 @@
+package dal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Replicate(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	sourceStore := NewMockStore(t, mockNowProvider, mockfs.NewMockFs())
+	docsBucket := sourceStore.CreateBucket(t, "docs")
+	photosBucket := sourceStore.CreateBucket(t, "photos")
+
+	aFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte("file a"))
+	bFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", mockNow, FileMode600, []byte("file b"))
+	photoFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "photo.jpg", mockNow, FileMode600, []byte("photo"))
+
+	firstDocsRevision := sourceStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{aFile})
+	mockNow = mockNow.Add(time.Hour)
+	secondDocsRevision := sourceStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{aFile, bFile})
+	mockNow = mockNow.Add(time.Hour)
+	photosRevision := sourceStore.CreateRevision(t, photosBucket, []*intelligentstore.RegularFileDescriptorWithContents{photoFile})
+
+	// like a revision committed before revision infos were recorded
+	require.Nil(t, sourceStore.Store.RevisionDAL.deleteRevisionInfo(firstDocsRevision))
+
+	destinationStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	destination := NewStoreReplicationDestination(destinationStore.Store)
+
+	t.Run("replicate everything", func(t *testing.T) {
+		result, err := sourceStore.Store.Replicate(destination, ReplicateOptions{})
+		require.Nil(t, err)
+
+		assert.Equal(t, &ReplicateResult{
+			BucketsCreated:      []string{"docs", "photos"},
+			RevisionsReplicated: 3,
+			ObjectsTransferred:  3,
+			BytesTransferred:    int64(len("file a") + len("file b") + len("photo")),
+		}, result)
+
+		destinationDocsBucket, err := destinationStore.Store.BucketDAL.GetBucketByName("docs")
+		require.Nil(t, err)
+
+		revisions, err := destinationStore.Store.BucketDAL.GetRevisions(destinationDocsBucket)
+		require.Nil(t, err)
+		require.Len(t, revisions, 2)
+
+		revision, err := destinationStore.Store.BucketDAL.GetRevision(destinationDocsBucket, secondDocsRevision.VersionTimestamp)
+		require.Nil(t, err)
+
+		descriptors, err := destinationStore.Store.RevisionDAL.GetFilesInRevision(destinationDocsBucket, revision)
+		require.Nil(t, err)
+		require.Len(t, descriptors, 2)
+
+		contents, contentsErr := destinationStore.Store.RevisionDAL.GetFileContentsInRevision(destinationDocsBucket, revision, "b.txt")
+		require.NoError(t, contentsErr)
+		defer contents.Close()
+
+		b, readErr := ioutil.ReadAll(contents)
+		require.NoError(t, readErr)
+		assert.Equal(t, "file b", string(b))
+
+		// revisions keep their info
+		sourceRevisionInfo, err := sourceStore.Store.RevisionDAL.GetRevisionInfo(secondDocsRevision)
+		require.Nil(t, err)
+		require.NotNil(t, sourceRevisionInfo)
+
+		revisionInfo, err := destinationStore.Store.RevisionDAL.GetRevisionInfo(revision)
+		require.Nil(t, err)
+		assert.Equal(t, sourceRevisionInfo, revisionInfo)
+
+		firstRevision, err := destinationStore.Store.BucketDAL.GetRevision(destinationDocsBucket, firstDocsRevision.VersionTimestamp)
+		require.Nil(t, err)
+
+		revisionInfo, err = destinationStore.Store.RevisionDAL.GetRevisionInfo(firstRevision)
+		require.Nil(t, err)
+		assert.Nil(t, revisionInfo)
+
+		lock, lockErr := destinationStore.Store.LockDAL.GetBucketLockInformation(destinationDocsBucket)
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+	})
+
+	t.Run("only the new revisions and contents are replicated", func(t *testing.T) {
+		mockNow = mockNow.Add(time.Hour)
+		cFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", mockNow, FileMode600, []byte("file c"))
+		sourceStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{aFile, bFile, cFile})
+
+		result, err := sourceStore.Store.Replicate(destination, ReplicateOptions{})
+		require.Nil(t, err)
+
+		assert.Equal(t, &ReplicateResult{
+			BucketsCreated:      []string{},
+			RevisionsReplicated: 1,
+			RevisionsSkipped:    3,
+			ObjectsTransferred:  1,
+			BytesTransferred:    int64(len("file c")),
+		}, result)
+	})
+
+	t.Run("filter by bucket", func(t *testing.T) {
+		photosDestinationStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+
+		result, err := sourceStore.Store.Replicate(NewStoreReplicationDestination(photosDestinationStore.Store), ReplicateOptions{BucketNames: []string{"photos"}})
+		require.Nil(t, err)
+		assert.Equal(t, []string{"photos"}, result.BucketsCreated)
+		assert.Equal(t, int64(1), result.RevisionsReplicated)
+
+		buckets, err := photosDestinationStore.Store.BucketDAL.GetAllBuckets()
+		require.Nil(t, err)
+		require.Len(t, buckets, 1)
+
+		revisions, err := photosDestinationStore.Store.BucketDAL.GetRevisions(buckets[0])
+		require.Nil(t, err)
+		require.Len(t, revisions, 1)
+		assert.Equal(t, photosRevision.VersionTimestamp, revisions[0].VersionTimestamp)
+	})
+
+	t.Run("corrupt contents are not replicated", func(t *testing.T) {
+		corruptDestinationStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+
+		// replace the object contents with different (but validly encoded) contents
+		photoObject, err := sourceStore.Store.findObject(photoFile.Descriptor.Hash)
+		require.Nil(t, err)
+		encodedObject := bytes.NewBuffer(nil)
+		_, _, err = sourceStore.Store.encodeObjectWithEncryption(encodedObject, photoObject.Hash, bytes.NewReader([]byte("phot0")), photoObject.Codec, 0)
+		require.Nil(t, err)
+		require.Nil(t, sourceStore.Store.backend.Put(photoObject.Key, encodedObject))
+
+		_, err = sourceStore.Store.Replicate(NewStoreReplicationDestination(corruptDestinationStore.Store), ReplicateOptions{BucketNames: []string{"photos"}})
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "corrupt")
+
+		// the bucket is created, but the revision isn't written, and the transaction is rolled back
+		destinationPhotosBucket, err := corruptDestinationStore.Store.BucketDAL.GetBucketByName("photos")
+		require.Nil(t, err)
+
+		revisions, err := corruptDestinationStore.Store.BucketDAL.GetRevisions(destinationPhotosBucket)
+		require.Nil(t, err)
+		assert.Len(t, revisions, 0)
+
+		lock, lockErr := corruptDestinationStore.Store.LockDAL.GetBucketLockInformation(destinationPhotosBucket)
+		require.Nil(t, lockErr)
+		assert.Nil(t, lock)
+	})
+}
+
+func Test_CreateTransactionForRevisionVersion(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	revision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{
+		intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", MockNowProvider(), FileMode600, []byte("file a")),
+	})
+
+	_, err := mockStore.Store.TransactionDAL.CreateTransactionForRevisionVersion(bucket, revision.VersionTimestamp, nil, nil)
+	require.NotNil(t, err)
+	assert.Equal(t, ErrRevisionAlreadyExists, errorsx.Cause(err))
+
+	tx, err := mockStore.Store.TransactionDAL.CreateTransactionForRevisionVersion(bucket, revision.VersionTimestamp-1, nil, nil)
+	require.Nil(t, err)
+	assert.Equal(t, revision.VersionTimestamp-1, tx.Revision.VersionTimestamp)
+
+	err = mockStore.Store.TransactionDAL.Rollback(tx)
+	require.Nil(t, err)
+}
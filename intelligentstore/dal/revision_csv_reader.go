@@ -1,10 +1,14 @@
 package dal
 
 import (
+	"bufio"
+	"encoding/base64"
 	"encoding/csv"
 	"io"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jamesrr39/csvx"
@@ -20,8 +24,31 @@ type revisionCSVReader struct {
 	revisionFile io.ReadSeekCloser
 }
 
+// csvChunksColumnHeader is the header of the optional last column, listing the chunks of files stored as chunks
+const csvChunksColumnHeader = "chunks"
+
+// csvManifestVersion is the version of the CSV manifest format that is written.
+// Version 1 manifests have no version line, modification times to the millisecond, and only the permission bits of the file mode.
+// Version 2 manifests start with a version line, have modification times to the nanosecond, the setuid, setgid and sticky bits in the file mode, and the ownership and extended attributes columns.
+// Version 3 manifests have the hard link group column.
+// Version 4 manifests can have FIFOs, device files and sockets, and have the device number column.
+// Version 5 manifests have the holes column, with the holes in sparse files.
+// Columns added in later versions are always added after the existing columns (and before the optional chunks column).
+const csvManifestVersion = 5
+
+// csvManifestVersionLinePrefix starts the line before the header row, that gives the version of the manifest format
+const csvManifestVersionLinePrefix = "# manifest version "
+
 func getCSVHeaders() []string {
-	return []string{"path", "type", "modTime_unix_ms", "size", "fileMode", "contents_hash_or_symlink_target"}
+	return []string{"path", "type", "modTime_unix", "size", "fileMode", "contents_hash_or_symlink_target", "uid_gid", "extended_attributes", "hard_link_group", "device_number", "holes"}
+}
+
+// csvNoContentsTag is the tag of the contents hash or symlink target column for directories and special files. No field has it, as it is empty for them.
+const csvNoContentsTag = "noContents"
+
+// getCSVTags gets the tags of the columns in a manifest, with the tag of the contents hash or symlink target column given
+func getCSVTags(contentsTag string) []string {
+	return append(getCSVBaseTags(), contentsTag, "ownership", "extendedAttributes", "hardLinkGroup", "deviceNumber", "holes")
 }
 
 func getCSVBaseTags() []string {
@@ -50,24 +77,124 @@ func (r *revisionCSVReader) Iterator() (Iterator, errorsx.Error) {
 		return nil, errorsx.Wrap(err)
 	}
 
-	csvReader := csv.NewReader(r.revisionFile)
+	bufferedReader := bufio.NewReader(r.revisionFile)
+
+	manifestVersion, err := readCSVManifestVersion(bufferedReader)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	csvReader := csv.NewReader(bufferedReader)
 
 	// header row
-	_, err = csvReader.Read()
+	headerRow, err := csvReader.Read()
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
+	hasChunksColumn := len(headerRow) != 0 && headerRow[len(headerRow)-1] == csvChunksColumnHeader
+
 	customDecoderMap := map[string]csvx.CustomDecoderFunc{
 		"fileMode": func(val string) (interface{}, error) {
-			v, err := strconv.ParseInt(val, 8, 32)
+			v, err := strconv.ParseUint(val, 8, 32)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			return unixPermissionsToFileMode(uint32(v)), nil
+		},
+		"modTime": decodeCSVModTime,
+		"ownership": func(val string) (interface{}, error) {
+			if val == "" {
+				// the ownership was not recorded
+				return (*intelligentstore.FileOwnership)(nil), nil
+			}
+
+			uidField, gidField, ok := strings.Cut(val, ":")
+			if !ok {
+				return nil, errorsx.Errorf("expected an ownership in the format <uid>:<gid>, but got %q", val)
+			}
+
+			uid, err := strconv.ParseUint(uidField, 10, 32)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			gid, err := strconv.ParseUint(gidField, 10, 32)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			return intelligentstore.NewFileOwnership(uint32(uid), uint32(gid)), nil
+		},
+		"extendedAttributes": func(val string) (interface{}, error) {
+			if val == "" {
+				return intelligentstore.ExtendedAttributes(nil), nil
+			}
+
+			values, err := url.ParseQuery(val)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			extendedAttributes := make(intelligentstore.ExtendedAttributes)
+			for name := range values {
+				extendedAttributes[name], err = base64.StdEncoding.DecodeString(values.Get(name))
+				if err != nil {
+					return nil, errorsx.Wrap(err, "extended attribute", name)
+				}
+			}
+
+			return extendedAttributes, nil
+		},
+		"hardLinkGroup": func(val string) (interface{}, error) {
+			if val == "" {
+				// not hard linked to another file in the revision
+				return uint64(0), nil
+			}
+
+			v, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			return v, nil
+		},
+		"deviceNumber": func(val string) (interface{}, error) {
+			if val == "" {
+				// not a device file
+				return (*intelligentstore.DeviceNumber)(nil), nil
+			}
+
+			majorField, minorField, ok := strings.Cut(val, ":")
+			if !ok {
+				return nil, errorsx.Errorf("expected a device number in the format <major>:<minor>, but got %q", val)
+			}
+
+			major, err := strconv.ParseUint(majorField, 10, 32)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			minor, err := strconv.ParseUint(minorField, 10, 32)
+			if err != nil {
+				return nil, errorsx.Wrap(err)
+			}
+
+			return intelligentstore.NewDeviceNumber(uint32(major), uint32(minor)), nil
+		},
+		"holes": func(val string) (interface{}, error) {
+			holes, err := intelligentstore.ParseFileRangeList(val)
 			if err != nil {
 				return nil, errorsx.Wrap(err)
 			}
 
-			return os.FileMode(v), nil
+			return holes, nil
 		},
-		"modTime": func(val string) (interface{}, error) {
+	}
+
+	if manifestVersion == 1 {
+		customDecoderMap["modTime"] = func(val string) (interface{}, error) {
 			v, err := strconv.ParseInt(val, 10, 64)
 			if err != nil {
 				return nil, errorsx.Wrap(err)
@@ -77,26 +204,79 @@ func (r *revisionCSVReader) Iterator() (Iterator, errorsx.Error) {
 			ms := v % 1000
 
 			return time.Unix(seconds, ms*1000*1000), nil
-		},
+		}
 	}
 
-	regularFileDecoder := csvx.NewDecoder(append(getCSVBaseTags(), "hash"))
+	regularFileDecoder := csvx.NewDecoder(getCSVTags("hash"))
 	regularFileDecoder.CustomDecoderMap = customDecoderMap
-	symlinkDecoder := csvx.NewDecoder(append(getCSVBaseTags(), "dest"))
+	symlinkDecoder := csvx.NewDecoder(getCSVTags("dest"))
 	symlinkDecoder.CustomDecoderMap = customDecoderMap
+	noContentsDecoder := csvx.NewDecoder(getCSVTags(csvNoContentsTag))
+	noContentsDecoder.CustomDecoderMap = customDecoderMap
 
 	return &csvIteratorType{
 		regularFileDecoder: regularFileDecoder,
 		symlinkFileDecoder: symlinkDecoder,
+		noContentsDecoder:  noContentsDecoder,
 		csvReader:          csvReader,
+		hasChunksColumn:    hasChunksColumn,
 	}, nil
 }
 
+// readCSVManifestVersion reads the version line of a manifest, if there is one. Manifests without a version line are version 1.
+func readCSVManifestVersion(reader *bufio.Reader) (int, errorsx.Error) {
+	prefix, err := reader.Peek(len(csvManifestVersionLinePrefix))
+	if err != nil && err != io.EOF {
+		return 0, errorsx.Wrap(err)
+	}
+
+	if string(prefix) != csvManifestVersionLinePrefix {
+		return 1, nil
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, errorsx.Wrap(err)
+	}
+
+	manifestVersion, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, csvManifestVersionLinePrefix)))
+	if err != nil {
+		return 0, errorsx.Wrap(err, "line", line)
+	}
+
+	if manifestVersion > csvManifestVersion {
+		return 0, errorsx.Errorf("the manifest is version %d, but the newest version this program can read is %d", manifestVersion, csvManifestVersion)
+	}
+
+	return manifestVersion, nil
+}
+
+// decodeCSVModTime decodes a modification time written as the Unix time in seconds, a dot, and the nanoseconds after it
+func decodeCSVModTime(val string) (interface{}, error) {
+	secondsField, nanosecondsField, ok := strings.Cut(val, ".")
+	if !ok {
+		return nil, errorsx.Errorf("expected a modification time in the format <seconds>.<nanoseconds>, but got %q", val)
+	}
+
+	seconds, err := strconv.ParseInt(secondsField, 10, 64)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	nanoseconds, err := strconv.ParseInt(nanosecondsField, 10, 64)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return time.Unix(seconds, nanoseconds), nil
+}
+
 type csvIteratorType struct {
-	regularFileDecoder, symlinkFileDecoder *csvx.Decoder
-	csvReader                              *csv.Reader
-	nextRow                                []string
-	err                                    errorsx.Error
+	regularFileDecoder, symlinkFileDecoder, noContentsDecoder *csvx.Decoder
+	csvReader                                                 *csv.Reader
+	hasChunksColumn                                           bool
+	nextRow                                                   []string
+	err                                                       errorsx.Error
 }
 
 func (c *csvIteratorType) Next() bool {
@@ -125,21 +305,52 @@ func (c *csvIteratorType) Scan() (intelligentstore.FileDescriptor, errorsx.Error
 		return nil, c.err
 	}
 
-	fileTypeID, err := strconv.Atoi(c.nextRow[1])
+	row := c.nextRow
+	var chunksField string
+	if c.hasChunksColumn {
+		chunksField = row[len(row)-1]
+		row = row[:len(row)-1]
+	}
+
+	// manifests from older versions don't have the columns added since then, so they are left empty
+	for len(row) < len(getCSVHeaders()) {
+		row = append(row, "")
+	}
+
+	fileTypeID, err := strconv.Atoi(row[1])
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 	var desc intelligentstore.FileDescriptor
 	switch intelligentstore.FileType(fileTypeID) {
 	case intelligentstore.FileTypeRegular:
-		desc = &intelligentstore.RegularFileDescriptor{FileInfo: new(intelligentstore.FileInfo)}
-		err = c.regularFileDecoder.Decode(c.nextRow, desc)
+		regularFileDescriptor := &intelligentstore.RegularFileDescriptor{FileInfo: new(intelligentstore.FileInfo)}
+		err = c.regularFileDecoder.Decode(row, regularFileDescriptor)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+
+		regularFileDescriptor.Chunks, err = intelligentstore.ParseChunkList(chunksField)
 		if err != nil {
 			return nil, errorsx.Wrap(err)
 		}
+
+		desc = regularFileDescriptor
 	case intelligentstore.FileTypeSymlink:
 		desc = &intelligentstore.SymlinkFileDescriptor{FileInfo: new(intelligentstore.FileInfo)}
-		err = c.symlinkFileDecoder.Decode(c.nextRow, desc)
+		err = c.symlinkFileDecoder.Decode(row, desc)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	case intelligentstore.FileTypeDir:
+		desc = &intelligentstore.DirectoryFileDescriptor{FileInfo: new(intelligentstore.FileInfo)}
+		err = c.noContentsDecoder.Decode(row, desc)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	case intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
+		desc = &intelligentstore.SpecialFileDescriptor{FileInfo: new(intelligentstore.FileInfo)}
+		err = c.noContentsDecoder.Decode(row, desc)
 		if err != nil {
 			return nil, errorsx.Wrap(err)
 		}
@@ -157,3 +368,19 @@ func (c csvIteratorType) Err() errorsx.Error {
 func (r *revisionCSVReader) Close() errorsx.Error {
 	return errorsx.Wrap(r.revisionFile.Close())
 }
+
+// unixPermissionsToFileMode gets the file mode of Unix permission bits, with the setuid, setgid and sticky bits (e.g. 04755)
+func unixPermissionsToFileMode(permissions uint32) os.FileMode {
+	fileMode := os.FileMode(permissions).Perm()
+	if permissions&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if permissions&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if permissions&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	return fileMode
+}
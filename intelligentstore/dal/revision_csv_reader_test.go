@@ -50,14 +50,69 @@ func Test_readCSV(t *testing.T) {
 			},
 			Dest: "/a/b",
 		},
+		&intelligentstore.DirectoryFileDescriptor{
+			FileInfo: &intelligentstore.FileInfo{
+				RelativePath: "/a",
+				Type:         intelligentstore.FileTypeDir,
+				ModTime:      time.Unix(10000, 0),
+				Size:         0,
+				FileMode:     os.FileMode(0755),
+			},
+		},
 	}
 
 	assert.Equal(t, expected, descs)
 }
 
+func Test_revisionCSVReader_ReadDirAndStat(t *testing.T) {
+	csvReader := &revisionCSVReader{
+		revisionFile: readSeekCloserType{
+			bytes.NewReader([]byte(`path,type,modTime_unix_ms,size,fileMode,contents_hash_or_symlink_target
+dir1/b.txt,1,10000000,1024,644,abcdef
+dir1,3,20000000,0,750,
+dir2/c.txt,1,10000000,1024,644,abcdef
+empty-dir,3,30000000,0,700,
+`)),
+		},
+	}
+
+	recordedDir1 := intelligentstore.NewDirectoryFileDescriptorFromFileInfo(
+		intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, "dir1", time.Unix(20000, 0), 0, 0750),
+	)
+	emptyDir := intelligentstore.NewDirectoryFileDescriptorFromFileInfo(
+		intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, "empty-dir", time.Unix(30000, 0), 0, 0700),
+	)
+
+	// dir2 isn't recorded (as in revisions from before directories were recorded), so it is made up from the file in it
+	descs, err := csvReader.ReadDir("")
+	require.NoError(t, err)
+	assert.Equal(t, []intelligentstore.FileDescriptor{
+		recordedDir1,
+		intelligentstore.NewDirectoryFileDescriptor("dir2"),
+		emptyDir,
+	}, descs)
+
+	// the recorded directory is found, even though a file in it comes first
+	desc, err := csvReader.Stat("dir1")
+	require.NoError(t, err)
+	assert.Equal(t, recordedDir1, desc)
+
+	desc, err = csvReader.Stat("dir2")
+	require.NoError(t, err)
+	assert.Equal(t, intelligentstore.NewDirectoryFileDescriptor("dir2"), desc)
+
+	descs, err = csvReader.ReadDir("empty-dir")
+	require.NoError(t, err)
+	assert.Empty(t, descs)
+
+	_, err = csvReader.Stat("dir3/d.txt")
+	assert.True(t, os.IsNotExist(err))
+}
+
 const revisionFile = `path,type,modTime_unix_ms,size,fileMode,contents_hash_or_symlink_target
 /a/b.txt,1,10000000,1024,644,abcdef
 /a/c.txt,2,10000002,1024,644,/a/b
+/a,3,10000000,0,755,
 `
 
 type readSeekCloserType struct {
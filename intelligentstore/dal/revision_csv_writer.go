@@ -1,10 +1,12 @@
 package dal
 
 import (
+	"encoding/base64"
 	"encoding/csv"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
@@ -18,8 +20,28 @@ var _ revisionManifestWriter = &revisionCSVWriter{}
 type revisionCSVWriter struct{}
 
 func (w *revisionCSVWriter) Write(file io.Writer, files []intelligentstore.FileDescriptor) errorsx.Error {
+	// the chunks column is only written if there are chunked files in the revision, so that revisions without chunked files keep the same format
+	hasChunksColumn := false
+	for _, file := range files {
+		regularFileDescriptor, ok := file.(*intelligentstore.RegularFileDescriptor)
+		if ok && len(regularFileDescriptor.Chunks) != 0 {
+			hasChunksColumn = true
+			break
+		}
+	}
+
+	_, err := fmt.Fprintf(file, "%s%d\n", csvManifestVersionLinePrefix, csvManifestVersion)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	headers := getCSVHeaders()
+	if hasChunksColumn {
+		headers = append(headers, csvChunksColumnHeader)
+	}
+
 	csvWriter := csv.NewWriter(file)
-	err := csvWriter.Write(getCSVHeaders())
+	err = csvWriter.Write(headers)
 	if err != nil {
 		return errorsx.Wrap(err)
 	}
@@ -27,19 +49,53 @@ func (w *revisionCSVWriter) Write(file io.Writer, files []intelligentstore.FileD
 	customEncoderMap := map[string]csvx.CustomEncoderFunc{
 		"fileMode": func(val interface{}) (string, error) {
 			v := val.(os.FileMode)
-			return strconv.FormatInt(int64(v.Perm()), 8), nil
-
+			return strconv.FormatUint(uint64(fileModeToUnixPermissions(v)), 8), nil
 		},
 		"modTime": func(val interface{}) (string, error) {
 			v := val.(time.Time)
-			return strconv.FormatInt(v.UnixMilli(), 10), nil
+			return fmt.Sprintf("%d.%09d", v.Unix(), v.Nanosecond()), nil
+		},
+		"ownership": func(val interface{}) (string, error) {
+			v := val.(intelligentstore.FileOwnership)
+			return fmt.Sprintf("%d:%d", v.UID, v.GID), nil
+		},
+		"extendedAttributes": func(val interface{}) (string, error) {
+			// the names are the keys, and the base64 encoded values are the values, of a URL query
+			values := make(url.Values)
+			for name, value := range val.(intelligentstore.ExtendedAttributes) {
+				values.Set(name, base64.StdEncoding.EncodeToString(value))
+			}
+
+			return values.Encode(), nil
+		},
+		"hardLinkGroup": func(val interface{}) (string, error) {
+			v := val.(uint64)
+			if v == 0 {
+				return "", nil
+			}
+
+			return strconv.FormatUint(v, 10), nil
+		},
+		"deviceNumber": func(val interface{}) (string, error) {
+			v := val.(intelligentstore.DeviceNumber)
+			return fmt.Sprintf("%d:%d", v.Major, v.Minor), nil
+		},
+		"holes": func(val interface{}) (string, error) {
+			return intelligentstore.FileRangeListToString(val.([]*intelligentstore.FileRange)), nil
 		},
 	}
 
-	regularFileEncoder := csvx.NewEncoder([]string{"path", "type", "modTime", "size", "fileMode", "hash"})
+	// the ownership is left empty if it was not recorded
+	regularFileEncoder := csvx.NewEncoder(getCSVTags("hash"))
 	regularFileEncoder.CustomEncoderMap = customEncoderMap
-	symlinkEncoder := csvx.NewEncoder([]string{"path", "type", "modTime", "size", "fileMode", "target"})
+	regularFileEncoder.NullText = ""
+	symlinkEncoder := csvx.NewEncoder(getCSVTags("dest"))
 	symlinkEncoder.CustomEncoderMap = customEncoderMap
+	symlinkEncoder.NullText = ""
+	// directories and special files have nothing in the contents hash or symlink target column
+	noContentsEncoder := csvx.NewEncoder(getCSVTags(csvNoContentsTag))
+	noContentsEncoder.CustomEncoderMap = customEncoderMap
+	noContentsEncoder.NullText = ""
 
 	for _, file := range files {
 		var fields []string
@@ -50,11 +106,37 @@ func (w *revisionCSVWriter) Write(file io.Writer, files []intelligentstore.FileD
 			if err != nil {
 				return errorsx.Wrap(err)
 			}
+
+			if hasChunksColumn {
+				fields = append(fields, intelligentstore.ChunkListToString(fd.Chunks))
+			}
 		case *intelligentstore.SymlinkFileDescriptor:
 			fields, err = symlinkEncoder.Encode(fd)
 			if err != nil {
 				return errorsx.Wrap(err)
 			}
+
+			if hasChunksColumn {
+				fields = append(fields, "")
+			}
+		case *intelligentstore.DirectoryFileDescriptor:
+			fields, err = noContentsEncoder.Encode(fd)
+			if err != nil {
+				return errorsx.Wrap(err)
+			}
+
+			if hasChunksColumn {
+				fields = append(fields, "")
+			}
+		case *intelligentstore.SpecialFileDescriptor:
+			fields, err = noContentsEncoder.Encode(fd)
+			if err != nil {
+				return errorsx.Wrap(err)
+			}
+
+			if hasChunksColumn {
+				fields = append(fields, "")
+			}
 		default:
 			return errorsx.Errorf("not implemented type: %d", file.GetFileInfo().Type)
 		}
@@ -75,13 +157,22 @@ func (w *revisionCSVWriter) Write(file io.Writer, files []intelligentstore.FileD
 	return nil
 }
 
-func (w *revisionCSVWriter) GetManifestFilePath(storeBasePath string, revision *intelligentstore.Revision) string {
-	return filepath.Join(
-		storeBasePath,
-		".backup_data",
-		"buckets",
-		strconv.Itoa(revision.Bucket.ID),
-		"versions",
-		revision.VersionTimestamp.String()+".csv",
-	)
+// fileModeToUnixPermissions gets the permission bits of a file mode, and its setuid, setgid and sticky bits, as they are in Unix (e.g. 04755)
+func fileModeToUnixPermissions(fileMode os.FileMode) uint32 {
+	permissions := uint32(fileMode.Perm())
+	if fileMode&os.ModeSetuid != 0 {
+		permissions |= 04000
+	}
+	if fileMode&os.ModeSetgid != 0 {
+		permissions |= 02000
+	}
+	if fileMode&os.ModeSticky != 0 {
+		permissions |= 01000
+	}
+
+	return permissions
+}
+
+func (w *revisionCSVWriter) GetManifestFileKey(revision *intelligentstore.Revision) string {
+	return getRevisionManifestKey(revision.Bucket, revision.VersionTimestamp, ".csv")
 }
@@ -2,6 +2,7 @@ package dal
 
 import (
 	"bytes"
+	"os"
 	"testing"
 	"time"
 
@@ -32,6 +33,101 @@ func Test_revisionCSVWriter_Write(t *testing.T) {
 			),
 			"abcdefg",
 		),
+		intelligentstore.NewSymlinkFileDescriptor(
+			intelligentstore.NewFileInfo(
+				intelligentstore.FileTypeSymlink,
+				"/a/d.txt",
+				time.Unix(10000, 0),
+				8,
+				0777,
+			),
+			"/a/b.txt",
+		),
+		intelligentstore.NewDirectoryFileDescriptorFromFileInfo(
+			intelligentstore.NewFileInfo(
+				intelligentstore.FileTypeDir,
+				"/a",
+				time.Unix(10000, 0),
+				0,
+				0755,
+			),
+		),
+		&intelligentstore.RegularFileDescriptor{
+			FileInfo: &intelligentstore.FileInfo{
+				Type:         intelligentstore.FileTypeRegular,
+				RelativePath: "/a/e.sh",
+				ModTime:      time.Unix(10000, 123456789),
+				Size:         2048,
+				FileMode:     os.ModeSetuid | 0755,
+				Ownership:    intelligentstore.NewFileOwnership(0, 50),
+				ExtendedAttributes: intelligentstore.ExtendedAttributes{
+					"user.comment":            []byte("a, b"),
+					"system.posix_acl_access": {2, 0, 0, 0},
+				},
+			},
+			Hash: "abcdefgh",
+		},
+		&intelligentstore.RegularFileDescriptor{
+			FileInfo: &intelligentstore.FileInfo{
+				Type:          intelligentstore.FileTypeRegular,
+				RelativePath:  "/a/f.txt",
+				ModTime:       time.Unix(10000, 0),
+				Size:          1024,
+				FileMode:      0644,
+				HardLinkGroup: 1,
+			},
+			Hash: "abcdef",
+		},
+		&intelligentstore.RegularFileDescriptor{
+			FileInfo: &intelligentstore.FileInfo{
+				Type:          intelligentstore.FileTypeRegular,
+				RelativePath:  "/g.txt",
+				ModTime:       time.Unix(10000, 0),
+				Size:          1024,
+				FileMode:      0644,
+				HardLinkGroup: 1,
+			},
+			Hash: "abcdef",
+		},
+		intelligentstore.NewSpecialFileDescriptor(
+			intelligentstore.NewFileInfo(
+				intelligentstore.FileTypeFIFO,
+				"/h.fifo",
+				time.Unix(10000, 0),
+				0,
+				0640,
+			),
+		),
+		intelligentstore.NewSpecialFileDescriptor(&intelligentstore.FileInfo{
+			Type:         intelligentstore.FileTypeCharDevice,
+			RelativePath: "/dev/null",
+			ModTime:      time.Unix(10000, 0),
+			FileMode:     0666,
+			DeviceNumber: intelligentstore.NewDeviceNumber(1, 3),
+		}),
+		intelligentstore.NewSpecialFileDescriptor(
+			intelligentstore.NewFileInfo(
+				intelligentstore.FileTypeSocket,
+				"/i.sock",
+				time.Unix(10000, 0),
+				0,
+				0755,
+			),
+		),
+		&intelligentstore.RegularFileDescriptor{
+			FileInfo: &intelligentstore.FileInfo{
+				Type:         intelligentstore.FileTypeRegular,
+				RelativePath: "/j.img",
+				ModTime:      time.Unix(10000, 0),
+				Size:         1048576,
+				FileMode:     0600,
+				Holes: []*intelligentstore.FileRange{
+					intelligentstore.NewFileRange(0, 4096),
+					intelligentstore.NewFileRange(8192, 1040384),
+				},
+			},
+			Hash: "abcdefghi",
+		},
 	}
 
 	writer := bytes.NewBuffer(nil)
@@ -41,9 +137,39 @@ func Test_revisionCSVWriter_Write(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, expected, writer.String())
+
+	// read back
+	csvReader := &revisionCSVReader{
+		revisionFile: readSeekCloserType{
+			bytes.NewReader(writer.Bytes()),
+		},
+	}
+	iterator, err := csvReader.Iterator()
+	require.NoError(t, err)
+
+	var descs []intelligentstore.FileDescriptor
+	for iterator.Next() {
+		desc, err := iterator.Scan()
+		require.NoError(t, err)
+
+		descs = append(descs, desc)
+	}
+	require.NoError(t, iterator.Err())
+
+	assert.Equal(t, files, descs)
 }
 
-const expected = `path,type,modTime_unix_ms,size,fileMode,contents_hash_or_symlink_target
-/a/b.txt,1,10000000,1024,644,abcdef
-/a/c.txt,1,10000000,1024,644,abcdefg
+const expected = `# manifest version 5
+path,type,modTime_unix,size,fileMode,contents_hash_or_symlink_target,uid_gid,extended_attributes,hard_link_group,device_number,holes
+/a/b.txt,1,10000.000000000,1024,644,abcdef,,,,,
+/a/c.txt,1,10000.000000000,1024,644,abcdefg,,,,,
+/a/d.txt,2,10000.000000000,8,777,/a/b.txt,,,,,
+/a,3,10000.000000000,0,755,,,,,,
+/a/e.sh,1,10000.123456789,2048,4755,abcdefgh,0:50,system.posix_acl_access=AgAAAA%3D%3D&user.comment=YSwgYg%3D%3D,,,
+/a/f.txt,1,10000.000000000,1024,644,abcdef,,,1,,
+/g.txt,1,10000.000000000,1024,644,abcdef,,,1,,
+/h.fifo,4,10000.000000000,0,640,,,,,,
+/dev/null,5,10000.000000000,0,666,,,,,1:3,
+/i.sock,7,10000.000000000,0,755,,,,,,
+/j.img,1,10000.000000000,1048576,600,abcdefghi,,,,,0:4096 8192:1040384
 `
@@ -4,14 +4,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jamesrr39/goutil/errorsx"
-	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
 	"github.com/jamesrr39/semaphore"
 	"github.com/pkg/errors"
@@ -44,59 +45,57 @@ func NewRevisionDAL(
 	return &RevisionDAL{intelligentStoreDAL, bucketDAL, maxConcurrentOpenFiles}
 }
 
-func (r *RevisionDAL) getRevisionJSONFilePath(bucket *intelligentstore.Bucket, revisionTimeStamp intelligentstore.RevisionVersion) string {
-	return filepath.Join(
-		r.bucketPath(bucket),
+// getRevisionManifestKey gets the key of a revision manifest. The extension is the format of the manifest (".csv" or ".json").
+func getRevisionManifestKey(bucket *intelligentstore.Bucket, revisionTimeStamp intelligentstore.RevisionVersion, extension string) string {
+	return path.Join(
+		"buckets",
+		strconv.Itoa(bucket.ID),
 		"versions",
-		strconv.FormatInt(int64(revisionTimeStamp), 10)+".json")
+		strconv.FormatInt(int64(revisionTimeStamp), 10)+extension)
 }
 
-func (r *RevisionDAL) getRevisionCSVFilePath(bucket *intelligentstore.Bucket, revisionTimeStamp intelligentstore.RevisionVersion) string {
-	return filepath.Join(
-		r.bucketPath(bucket),
-		"versions",
-		strconv.FormatInt(int64(revisionTimeStamp), 10)+".csv")
-}
-
-type revisionFilePathWithReaderCreator struct {
-	FilePath         string
-	CreateReaderFunc func(file gofs.File) revisionReader
+type revisionFileKeyWithReaderCreator struct {
+	FileKey          string
+	CreateReaderFunc func(file io.ReadSeekCloser) revisionReader
 }
 
-func (r *RevisionDAL) getRevisionReader(revision *intelligentstore.Revision) (revisionFilePathWithReaderCreator, errorsx.Error) {
-	possibleReaders := []revisionFilePathWithReaderCreator{
+func (r *RevisionDAL) getRevisionReader(revision *intelligentstore.Revision) (revisionFileKeyWithReaderCreator, errorsx.Error) {
+	possibleReaders := []revisionFileKeyWithReaderCreator{
 		{
-			FilePath:         r.getRevisionCSVFilePath(revision.Bucket, revision.VersionTimestamp),
-			CreateReaderFunc: func(file gofs.File) revisionReader { return &revisionCSVReader{file} },
+			FileKey:          getRevisionManifestKey(revision.Bucket, revision.VersionTimestamp, ".csv"),
+			CreateReaderFunc: func(file io.ReadSeekCloser) revisionReader { return &revisionCSVReader{file} },
 		},
 		{
-			FilePath:         r.getRevisionJSONFilePath(revision.Bucket, revision.VersionTimestamp),
-			CreateReaderFunc: func(file gofs.File) revisionReader { return &revisionJSONReader{file} },
+			FileKey:          getRevisionManifestKey(revision.Bucket, revision.VersionTimestamp, ".json"),
+			CreateReaderFunc: func(file io.ReadSeekCloser) revisionReader { return &revisionJSONReader{file} },
 		},
 	}
 
 	for _, reader := range possibleReaders {
-		_, err := r.fs.Stat(reader.FilePath)
+		_, err := r.backend.Stat(reader.FileKey)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if os.IsNotExist(errorsx.Cause(err)) {
 				// revision file for this type does not exist. Try the next type.
 				continue
 			}
 
-			return revisionFilePathWithReaderCreator{}, errorsx.Wrap(err)
+			return revisionFileKeyWithReaderCreator{}, errorsx.Wrap(err)
 		}
 
 		return reader, nil
 	}
 
-	return revisionFilePathWithReaderCreator{}, errorsx.Wrap(ErrRevisionDoesNotExist)
+	return revisionFileKeyWithReaderCreator{}, errorsx.Wrap(ErrRevisionDoesNotExist)
 }
 
 func (r *RevisionDAL) createReader(revision *intelligentstore.Revision) (revisionReader, errorsx.Error) {
 	var err error
 	revisionReader, err := r.getRevisionReader(revision)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
 
-	f, err := r.fs.Open(revisionReader.FilePath)
+	f, err := r.openEncryptedFileForSeeking(revisionReader.FileKey)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
@@ -184,14 +183,77 @@ func (r *RevisionDAL) GetFileContentsInRevision(
 	return nil, ErrNoFileWithThisRelativePathInRevision
 }
 
+// VerifyOptions configures how thoroughly a revision is verified
+type VerifyOptions struct {
+	// Deep decompresses and re-hashes every object, instead of only checking the object exists
+	Deep bool
+	// SamplePercent is the percentage of files (chosen at random) to verify. 0 or 100 verifies every file.
+	SamplePercent float64
+}
+
+// VerificationFailure describes a file in a revision that failed verification
+type VerificationFailure struct {
+	BucketName      string                           `json:"bucketName"`
+	RevisionVersion intelligentstore.RevisionVersion `json:"revisionVersion"`
+	RelativePath    intelligentstore.RelativePath    `json:"relativePath"`
+	Hash            intelligentstore.Hash            `json:"hash"`
+	Reason          string                           `json:"reason"`
+}
+
+func (f *VerificationFailure) String() string {
+	return fmt.Sprintf("bucket: %q, revision: %d, path: %q, hash: %q. Reason: %s", f.BucketName, f.RevisionVersion, f.RelativePath, f.Hash, f.Reason)
+}
+
+// VerificationResult is the report of verifying a revision
+type VerificationResult struct {
+	FilesInRevision int64                  `json:"filesInRevision"`
+	FilesVerified   int64                  `json:"filesVerified"`
+	Failures        []*VerificationFailure `json:"failures"`
+}
+
+// VerifyRevision checks the objects of the files in a revision.
+// Every failure is collected into the result, rather than stopping at the first failure.
+// The returned error is only for errors that stopped the verification from running.
 func (r *RevisionDAL) VerifyRevision(
 	bucket *intelligentstore.Bucket,
-	revision *intelligentstore.Revision) errorsx.Error {
+	revision *intelligentstore.Revision,
+	options VerifyOptions) (*VerificationResult, errorsx.Error) {
+	sharedLock, err := r.LockDAL.AcquireSharedLock("lock from verifying a revision")
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	defer func() {
+		releaseErr := r.LockDAL.ReleaseSharedLock(sharedLock)
+		if releaseErr != nil {
+			log.Printf("failed to release shared lock after verifying a revision. Error: %q\n", releaseErr)
+		}
+	}()
+
 	files, err := r.GetFilesInRevision(bucket, revision)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// counted before sampling, so that the report shows how much of the revision was verified
+	result := &VerificationResult{
+		FilesInRevision: int64(len(files)),
+		Failures:        []*VerificationFailure{},
+	}
+
+	if options.SamplePercent > 0 && options.SamplePercent < 100 {
+		var sampledFiles []intelligentstore.FileDescriptor
+		for _, file := range files {
+			if rand.Float64()*100 < options.SamplePercent {
+				sampledFiles = append(sampledFiles, file)
+			}
+		}
+		log.Printf("sampled %d of %d files (%.02f%%)\n", len(sampledFiles), len(files), options.SamplePercent)
+		files = sampledFiles
 	}
 
+	var mu sync.Mutex
+
 	openFileSema := semaphore.NewSemaphore(r.maxConcurrentOpenFiles)
 
 	lenFiles := len(files)
@@ -199,37 +261,101 @@ func (r *RevisionDAL) VerifyRevision(
 	for i, file := range files {
 		openFileSema.Add()
 		go func(i int, file intelligentstore.FileDescriptor) {
-			err = r.verifyFile(i, file, lenFiles)
-			openFileSema.Done()
+			defer openFileSema.Done()
+
+			reason := r.verifyFile(i, file, lenFiles, options.Deep)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.FilesVerified++
+			if reason == "" {
+				return
+			}
+
+			failure := &VerificationFailure{
+				BucketName:      bucket.BucketName,
+				RevisionVersion: revision.VersionTimestamp,
+				RelativePath:    file.GetFileInfo().RelativePath,
+				Reason:          reason,
+			}
+			regularFileDescriptor, ok := file.(*intelligentstore.RegularFileDescriptor)
+			if ok {
+				failure.Hash = regularFileDescriptor.Hash
+			}
+			result.Failures = append(result.Failures, failure)
 		}(i, file)
-		if err != nil {
-			return err
-		}
 	}
 	openFileSema.Wait()
 
-	return nil
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].RelativePath < result.Failures[j].RelativePath
+	})
+
+	return result, nil
 }
 
-func (r *RevisionDAL) verifyFile(i int, file intelligentstore.FileDescriptor, lenFiles int) errorsx.Error {
+// verifyFile verifies a file. It returns the reason it failed verification, or an empty string if it passed verification.
+func (r *RevisionDAL) verifyFile(i int, file intelligentstore.FileDescriptor, lenFiles int, deep bool) string {
 	if i != 0 && i%100 == 0 {
 		percentageThrough := float64(i) * 100 / float64(lenFiles)
 		log.Printf("progress update: verified %d of %d files (%.02f%%)\n", i, lenFiles, percentageThrough)
 	}
 	fileInfo := file.GetFileInfo()
 	switch fileInfo.Type {
-	case fileInfo.Type:
-		descriptor := file.(*intelligentstore.RegularFileDescriptor)
+	case intelligentstore.FileTypeRegular:
+		descriptor, ok := file.(*intelligentstore.RegularFileDescriptor)
+		if !ok {
+			return "bad type assertion (expected RegularFileDescriptor)"
+		}
 
-		_, err := r.StatFile(descriptor.Hash)
-		if err != nil {
-			return errorsx.Wrap(err, "filehash", descriptor.Hash, "relative path", descriptor.RelativePath, "size", descriptor.Size, "type", descriptor.Type)
+		if !deep {
+			_, err := r.StatFile(descriptor.Hash)
+			if err != nil {
+				return fmt.Sprintf("couldn't stat object: %s", err)
+			}
+
+			for _, chunk := range descriptor.Chunks {
+				_, err = r.StatFile(chunk.Hash)
+				if err != nil {
+					return fmt.Sprintf("couldn't stat chunk %q: %s", chunk.Hash, err)
+				}
+			}
+
+			return ""
 		}
+
+		return r.verifyObjectContents(descriptor)
+	case intelligentstore.FileTypeSymlink, intelligentstore.FileTypeDir, intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
+		// symlinks, directories and special files are stored entirely in the revision manifest, so there is no object to verify
+		return ""
 	default:
-		return errorsx.Errorf("unknown file type: %q", fileInfo.Type)
+		return fmt.Sprintf("unknown file type: %q", fileInfo.Type)
+	}
+}
+
+// verifyObjectContents decompresses the object, re-hashes it and compares the hash and size to the descriptor.
+// It returns the reason it failed verification, or an empty string if it passed verification.
+func (r *RevisionDAL) verifyObjectContents(descriptor *intelligentstore.RegularFileDescriptor) string {
+	object, err := r.GetObjectByHash(descriptor.Hash)
+	if err != nil {
+		return fmt.Sprintf("couldn't open object: %s", err)
+	}
+	defer object.Close()
+
+	actualDescriptor, err := intelligentstore.NewRegularFileDescriptorFromReaderWithHashAlgorithm(descriptor.RelativePath, descriptor.ModTime, descriptor.FileMode, object, descriptor.Hash.Algorithm())
+	if err != nil {
+		return fmt.Sprintf("couldn't read object: %s", err)
 	}
 
-	return nil
+	if actualDescriptor.Hash != descriptor.Hash {
+		return fmt.Sprintf("hash mismatch: object contents hash to %q", actualDescriptor.Hash)
+	}
+
+	if actualDescriptor.Size != descriptor.Size {
+		return fmt.Sprintf("size mismatch: expected %d bytes but the object contains %d bytes", descriptor.Size, actualDescriptor.Size)
+	}
+
+	return ""
 }
 
 // filterInDescriptorChildren checks if a descriptor should be filtered in. Returns (filtered in, error)
@@ -286,9 +412,20 @@ func iteratorReadDir(iterator Iterator, searchPath intelligentstore.RelativePath
 			return nil, errorsx.Wrap(err)
 		}
 
-		if filteredInDescriptor != nil {
-			descriptorMap[filteredInDescriptor.GetFileInfo().RelativePath.String()] = filteredInDescriptor
+		if filteredInDescriptor == nil {
+			continue
 		}
+
+		key := filteredInDescriptor.GetFileInfo().RelativePath.String()
+		if filteredInDescriptor != descriptor {
+			// a directory made up from the path of a file further down. The directory recorded in the revision (if there is one) is used instead
+			_, ok := descriptorMap[key]
+			if ok {
+				continue
+			}
+		}
+
+		descriptorMap[key] = filteredInDescriptor
 	}
 
 	descriptors := []intelligentstore.FileDescriptor{}
@@ -308,12 +445,15 @@ func iteratorReadDir(iterator Iterator, searchPath intelligentstore.RelativePath
 	return descriptors, nil
 }
 
+// iteratorStat finds the descriptor for the relative path.
+// If the revision doesn't record the path, but has files under it, the path is a directory from a revision made before directories were recorded, and a descriptor is made up for it.
 func iteratorStat(iterator Iterator, searchPath intelligentstore.RelativePath) (intelligentstore.FileDescriptor, error) {
 	var relativePathFragments []string
 	if searchPath != "" {
 		relativePathFragments = searchPath.Fragments()
 	}
 
+	var hasFilesUnderSearchPath bool
 	for iterator.Next() {
 		descriptor, err := iterator.Scan()
 		if err != nil {
@@ -324,7 +464,15 @@ func iteratorStat(iterator Iterator, searchPath intelligentstore.RelativePath) (
 			return descriptor, nil
 		}
 
+		if hasFilesUnderSearchPath {
+			// keep looking, in case the directory is recorded further on
+			continue
+		}
+
 		descFragments := descriptor.GetFileInfo().RelativePath.Fragments()
+		if len(descFragments) <= len(relativePathFragments) {
+			continue
+		}
 
 		var isDifferent bool
 		for i, relativePathFragment := range relativePathFragments {
@@ -336,9 +484,18 @@ func iteratorStat(iterator Iterator, searchPath intelligentstore.RelativePath) (
 
 		if !isDifferent {
 			// "descriptor" is a file in a sub directory
-			return intelligentstore.NewDirectoryFileDescriptor(searchPath), nil
+			hasFilesUnderSearchPath = true
 		}
 	}
 
+	err := iterator.Err()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if hasFilesUnderSearchPath {
+		return intelligentstore.NewDirectoryFileDescriptor(searchPath), nil
+	}
+
 	return nil, os.ErrNotExist
 }
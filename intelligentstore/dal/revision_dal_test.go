@@ -0,0 +1,80 @@
+package dal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VerifyRevision(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, MockNowProvider, fs)
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	goodFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	bitRotFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte("b text"))
+	truncatedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", time.Unix(0, 0), FileMode600, []byte("c text"))
+	revision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{goodFile, bitRotFile, truncatedFile})
+
+	// replace the object contents with different (but validly encoded) contents
+	bitRotObject, err := mockStore.Store.findObject(bitRotFile.Descriptor.Hash)
+	require.Nil(t, err)
+	encodedObject := bytes.NewBuffer(nil)
+	_, _, err = mockStore.Store.encodeObjectWithEncryption(encodedObject, bitRotObject.Hash, bytes.NewReader([]byte("b texx")), bitRotObject.Codec, 0)
+	require.Nil(t, err)
+	require.Nil(t, mockStore.Store.backend.Put(bitRotObject.Key, encodedObject))
+
+	// truncate the object
+	truncatedObject, err := mockStore.Store.findObject(truncatedFile.Descriptor.Hash)
+	require.Nil(t, err)
+	truncatedObjectPath := mockStore.GetPathOfKey(truncatedObject.Key)
+	objectBytes, readErr := fs.ReadFile(truncatedObjectPath)
+	require.NoError(t, readErr)
+	require.Nil(t, fs.WriteFile(truncatedObjectPath, objectBytes[:len(objectBytes)/2], 0600))
+
+	t.Run("shallow", func(t *testing.T) {
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(bucket, revision, VerifyOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, int64(3), result.FilesVerified)
+		assert.Empty(t, result.Failures)
+	})
+
+	t.Run("deep", func(t *testing.T) {
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(bucket, revision, VerifyOptions{Deep: true})
+		require.Nil(t, err)
+		assert.Equal(t, int64(3), result.FilesVerified)
+		require.Len(t, result.Failures, 2)
+
+		assert.Equal(t, intelligentstore.RelativePath("b.txt"), result.Failures[0].RelativePath)
+		assert.Equal(t, bitRotFile.Descriptor.Hash, result.Failures[0].Hash)
+		assert.Equal(t, "docs", result.Failures[0].BucketName)
+		assert.Equal(t, revision.VersionTimestamp, result.Failures[0].RevisionVersion)
+		assert.Contains(t, result.Failures[0].Reason, "hash mismatch")
+
+		assert.Equal(t, intelligentstore.RelativePath("c.txt"), result.Failures[1].RelativePath)
+	})
+
+	t.Run("sampled", func(t *testing.T) {
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(bucket, revision, VerifyOptions{SamplePercent: 50})
+		require.Nil(t, err)
+		// every file in the revision is counted, not just the sampled ones
+		assert.Equal(t, int64(3), result.FilesInRevision)
+		assert.LessOrEqual(t, result.FilesVerified, result.FilesInRevision)
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		goodObject, err := mockStore.Store.findObject(goodFile.Descriptor.Hash)
+		require.Nil(t, err)
+		require.Nil(t, fs.Remove(mockStore.GetPathOfKey(goodObject.Key)))
+
+		result, err := mockStore.Store.RevisionDAL.VerifyRevision(bucket, revision, VerifyOptions{})
+		require.Nil(t, err)
+		require.Len(t, result.Failures, 1)
+		assert.Equal(t, intelligentstore.RelativePath("a.txt"), result.Failures[0].RelativePath)
+	})
+}
@@ -0,0 +1,57 @@
+package dal
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+const revisionInfosFolderName = "revision-info"
+
+// getRevisionInfoKey gets the key of the info of a revision.
+// The infos are in a folder of their own in the bucket, so that they aren't listed with the manifests.
+func getRevisionInfoKey(revision *intelligentstore.Revision) string {
+	return path.Join(
+		"buckets",
+		strconv.Itoa(revision.Bucket.ID),
+		revisionInfosFolderName,
+		strconv.FormatInt(int64(revision.VersionTimestamp), 10)+".json")
+}
+
+// GetRevisionInfo gets the info of a revision. It returns nil if the revision doesn't have one, as revisions committed before revision infos were recorded don't.
+func (r *RevisionDAL) GetRevisionInfo(revision *intelligentstore.Revision) (*intelligentstore.RevisionInfo, errorsx.Error) {
+	key := getRevisionInfoKey(revision)
+
+	b, err := r.readEncryptedFile(key)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil, nil
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	revisionInfo := new(intelligentstore.RevisionInfo)
+	unmarshalErr := json.Unmarshal(b, revisionInfo)
+	if unmarshalErr != nil {
+		return nil, errorsx.Wrap(unmarshalErr, "key", key)
+	}
+
+	return revisionInfo, nil
+}
+
+func (r *RevisionDAL) writeRevisionInfo(revision *intelligentstore.Revision, revisionInfo *intelligentstore.RevisionInfo) errorsx.Error {
+	b, err := json.Marshal(revisionInfo)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return r.writeEncryptedFile(getRevisionInfoKey(revision), b)
+}
+
+func (r *RevisionDAL) deleteRevisionInfo(revision *intelligentstore.Revision) errorsx.Error {
+	return errorsx.Wrap(r.backend.Delete(getRevisionInfoKey(revision)))
+}
@@ -78,6 +78,10 @@ func (r *JSONIterator) Scan() (intelligentstore.FileDescriptor, errorsx.Error) {
 		objToUnmarshalTo = &intelligentstore.RegularFileDescriptor{}
 	case intelligentstore.FileTypeSymlink:
 		objToUnmarshalTo = &intelligentstore.SymlinkFileDescriptor{}
+	case intelligentstore.FileTypeDir:
+		objToUnmarshalTo = &intelligentstore.DirectoryFileDescriptor{}
+	case intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
+		objToUnmarshalTo = &intelligentstore.SpecialFileDescriptor{}
 	default:
 		return nil, errorsx.Errorf("unrecognised file descriptor type. JSON: %q", string(fdJSON))
 	}
@@ -48,9 +48,7 @@ func Test_revisionJSONReader_ReadDir(t *testing.T) {
 						Type:         intelligentstore.FileTypeRegular,
 					},
 				},
-				&intelligentstore.DirectoryFileDescriptor{
-					RelativePath: "dir1",
-				},
+				intelligentstore.NewDirectoryFileDescriptor("dir1"),
 			},
 		}, {
 			name: "sub dir",
@@ -75,9 +73,7 @@ func Test_revisionJSONReader_ReadDir(t *testing.T) {
 						Type:         intelligentstore.FileTypeRegular,
 					},
 				},
-				&intelligentstore.DirectoryFileDescriptor{
-					RelativePath: "dir1/dir2",
-				},
+				intelligentstore.NewDirectoryFileDescriptor("dir1/dir2"),
 			},
 		},
 	}
@@ -3,8 +3,6 @@ package dal
 import (
 	"encoding/json"
 	"io"
-	"path/filepath"
-	"strconv"
 
 	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
@@ -18,13 +16,6 @@ func (w *revisionJSONWriter) Write(file io.Writer, files []intelligentstore.File
 	return errorsx.Wrap(json.NewEncoder(file).Encode(files))
 }
 
-func (w *revisionJSONWriter) GetManifestFilePath(storeBasePath string, revision *intelligentstore.Revision) string {
-	return filepath.Join(
-		storeBasePath,
-		".backup_data",
-		"buckets",
-		strconv.Itoa(revision.Bucket.ID),
-		"versions",
-		revision.VersionTimestamp.String()+".json",
-	)
+func (w *revisionJSONWriter) GetManifestFileKey(revision *intelligentstore.Revision) string {
+	return getRevisionManifestKey(revision.Bucket, revision.VersionTimestamp, ".json")
 }
@@ -0,0 +1,310 @@
+package dal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/humanise"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// StoreStats are statistics of the space used by the store
+type StoreStats struct {
+	// LogicalBytes is the total size of the files in every revision of every bucket, as if nothing was deduplicated
+	LogicalBytes int64 `json:"logicalBytes"`
+	// UniqueBytes is the size of the distinct contents referenced by the revisions, before they are compressed
+	UniqueBytes int64 `json:"uniqueBytes"`
+	// DeduplicatedBytes is how much space is saved by storing each content only once (LogicalBytes - UniqueBytes)
+	DeduplicatedBytes int64 `json:"deduplicatedBytes"`
+	// StoredBytes is the size of the objects (including packed objects) as they are stored, after compression.
+	// It includes objects that are no longer referenced and haven't been garbage collected yet.
+	StoredBytes int64 `json:"storedBytes"`
+	// CompressionRatio is UniqueBytes / StoredBytes. It is 0 if nothing is stored.
+	CompressionRatio float64 `json:"compressionRatio"`
+	// ObjectCount is the amount of objects stored, including packed objects
+	ObjectCount int64          `json:"objectCount"`
+	Buckets     []*BucketStats `json:"buckets"`
+}
+
+// BucketStats are statistics of the space used by a bucket
+type BucketStats struct {
+	BucketID      int    `json:"bucketId"`
+	BucketName    string `json:"bucketName"`
+	RevisionCount int    `json:"revisionCount"`
+	// LogicalBytes is the total size of the files in every revision of the bucket
+	LogicalBytes int64 `json:"logicalBytes"`
+	// UniqueBytes is the size of the distinct contents referenced by the revisions of the bucket, before they are compressed
+	UniqueBytes int64 `json:"uniqueBytes"`
+	// FreedIfDeletedBytes is the size of the contents only referenced by this bucket, before they are compressed. This is how much deleting the bucket would free.
+	FreedIfDeletedBytes int64 `json:"freedIfDeletedBytes"`
+	// Revisions are the statistics of each revision, oldest first, so that they can be used to chart how the bucket grows.
+	// They are left out if the statistics were got from the object reference index (see StatsOptions).
+	Revisions []*RevisionStats `json:"revisions,omitempty"`
+}
+
+// RevisionStats are statistics of the space used by a revision
+type RevisionStats struct {
+	RevisionVersion intelligentstore.RevisionVersion `json:"revisionVersion"`
+	FileCount       int64                            `json:"fileCount"`
+	// LogicalBytes is the total size of the files in the revision
+	LogicalBytes int64 `json:"logicalBytes"`
+	// NewBytes is the size of the contents not in any earlier revision of the bucket
+	NewBytes int64 `json:"newBytes"`
+	// CumulativeUniqueBytes is the size of the distinct contents in the bucket, up to and including this revision
+	CumulativeUniqueBytes int64 `json:"cumulativeUniqueBytes"`
+}
+
+// StatsOptions configures which statistics GetStats gets
+type StatsOptions struct {
+	// Revisions gets the statistics of each revision as well. They can only be got from the revision manifests, so every revision is read, even if the store has an object reference index.
+	Revisions bool
+}
+
+// GetStats gets statistics of the space used by the store.
+// If the store has an object reference index, and the statistics of each revision aren't asked for, the references are counted from the index. Otherwise every revision of every bucket is read.
+// The objects in the store are listed either way.
+func (s *IntelligentStoreDAL) GetStats(options StatsOptions) (*StoreStats, errorsx.Error) {
+	buckets, err := s.BucketDAL.GetAllBuckets()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var stats *StoreStats
+	var index *objectReferenceIndex
+	if !options.Revisions {
+		index, err = s.readObjectReferenceIndex()
+		if err != nil && !os.IsNotExist(errorsx.Cause(err)) {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	if index != nil {
+		stats, err = s.getStatsFromIndex(buckets, index)
+	} else {
+		// the references are counted in memory, as the revisions are read
+		index = newObjectReferenceIndex()
+		stats, err = s.getStatsFromRevisions(buckets, index)
+	}
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	freedIfDeletedBytes := index.bucketUniqueSizes()
+	for _, bucketStats := range stats.Buckets {
+		bucketStats.FreedIfDeletedBytes = freedIfDeletedBytes[bucketStats.BucketID]
+	}
+
+	stats.UniqueBytes = index.storedSize()
+	stats.DeduplicatedBytes = stats.LogicalBytes - stats.UniqueBytes
+
+	err = s.addStoredObjectStats(stats)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if stats.StoredBytes != 0 {
+		stats.CompressionRatio = float64(stats.UniqueBytes) / float64(stats.StoredBytes)
+	}
+
+	return stats, nil
+}
+
+// getStatsFromRevisions reads every revision of every bucket, and adds their references to the index
+func (s *IntelligentStoreDAL) getStatsFromRevisions(buckets []*intelligentstore.Bucket, index *objectReferenceIndex) (*StoreStats, errorsx.Error) {
+	stats := &StoreStats{
+		Buckets: []*BucketStats{},
+	}
+
+	for _, bucket := range buckets {
+		bucketStats, err := s.getBucketStats(bucket, index)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "bucket", bucket.ID)
+		}
+
+		stats.LogicalBytes += bucketStats.LogicalBytes
+		stats.Buckets = append(stats.Buckets, bucketStats)
+	}
+
+	return stats, nil
+}
+
+// getStatsFromIndex counts the sizes of the buckets from the object reference index, without reading the revisions.
+// Contents stored as chunks are counted by their chunks, which add up to the size of the contents.
+func (s *IntelligentStoreDAL) getStatsFromIndex(buckets []*intelligentstore.Bucket, index *objectReferenceIndex) (*StoreStats, errorsx.Error) {
+	stats := &StoreStats{
+		Buckets: []*BucketStats{},
+	}
+
+	bucketStatsByID := make(map[int]*BucketStats)
+	for _, bucket := range buckets {
+		revisions, err := s.BucketDAL.GetRevisions(bucket)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "bucket", bucket.ID)
+		}
+
+		bucketStats := &BucketStats{
+			BucketID:      bucket.ID,
+			BucketName:    bucket.BucketName,
+			RevisionCount: len(revisions),
+		}
+		bucketStatsByID[bucket.ID] = bucketStats
+		stats.Buckets = append(stats.Buckets, bucketStats)
+	}
+
+	for _, references := range index.Objects {
+		if references.Chunked {
+			continue
+		}
+
+		stats.LogicalBytes += references.Size * references.Count
+
+		for bucketID, count := range references.BucketCounts {
+			bucketStats, ok := bucketStatsByID[bucketID]
+			if !ok {
+				continue
+			}
+
+			bucketStats.LogicalBytes += references.Size * count
+			bucketStats.UniqueBytes += references.Size
+		}
+	}
+
+	return stats, nil
+}
+
+// GetBucketStats reads every revision of the bucket to get statistics of the space it uses, including the statistics of each revision.
+// How much deleting the bucket would free depends on the other buckets, so it is only counted if the store has an object reference index.
+func (s *IntelligentStoreDAL) GetBucketStats(bucket *intelligentstore.Bucket) (*BucketStats, errorsx.Error) {
+	bucketStats, err := s.getBucketStats(bucket, newObjectReferenceIndex())
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	storeIndex, err := s.readObjectReferenceIndex()
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return bucketStats, nil
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	bucketStats.FreedIfDeletedBytes = storeIndex.bucketUniqueSizes()[bucket.ID]
+
+	return bucketStats, nil
+}
+
+// getBucketStats reads the revisions of the bucket, oldest first, and adds their references to the index
+func (s *IntelligentStoreDAL) getBucketStats(bucket *intelligentstore.Bucket, index *objectReferenceIndex) (*BucketStats, errorsx.Error) {
+	revisions, err := s.BucketDAL.GetRevisions(bucket)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].VersionTimestamp < revisions[j].VersionTimestamp
+	})
+
+	bucketStats := &BucketStats{
+		BucketID:      bucket.ID,
+		BucketName:    bucket.BucketName,
+		RevisionCount: len(revisions),
+		Revisions:     []*RevisionStats{},
+	}
+
+	seenHashes := make(map[intelligentstore.Hash]struct{})
+	for _, revision := range revisions {
+		descriptors, err := s.RevisionDAL.GetFilesInRevision(bucket, revision)
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+
+		index.addRevision(revision, descriptors)
+
+		revisionStats := &RevisionStats{
+			RevisionVersion: revision.VersionTimestamp,
+		}
+
+		for _, descriptor := range descriptors {
+			regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+			if !ok {
+				continue
+			}
+
+			revisionStats.FileCount++
+			revisionStats.LogicalBytes += regularFileDescriptor.Size
+
+			for _, storedContents := range getStoredContents(regularFileDescriptor) {
+				_, seen := seenHashes[storedContents.Hash]
+				if seen {
+					continue
+				}
+
+				seenHashes[storedContents.Hash] = struct{}{}
+				revisionStats.NewBytes += storedContents.Size
+			}
+		}
+
+		bucketStats.LogicalBytes += revisionStats.LogicalBytes
+		bucketStats.UniqueBytes += revisionStats.NewBytes
+		revisionStats.CumulativeUniqueBytes = bucketStats.UniqueBytes
+
+		bucketStats.Revisions = append(bucketStats.Revisions, revisionStats)
+	}
+
+	return bucketStats, nil
+}
+
+// getStoredContents gets the contents the file is stored as: it's chunks if it is stored as chunks, otherwise the whole file
+func getStoredContents(descriptor *intelligentstore.RegularFileDescriptor) []*intelligentstore.Chunk {
+	if len(descriptor.Chunks) != 0 {
+		return descriptor.Chunks
+	}
+
+	return []*intelligentstore.Chunk{{Hash: descriptor.Hash, Size: descriptor.Size}}
+}
+
+// addStoredObjectStats adds the count and stored size of the objects, and of the packed objects
+func (s *IntelligentStoreDAL) addStoredObjectStats(stats *StoreStats) errorsx.Error {
+	err := s.walkObjects(func(object *storedObject) errorsx.Error {
+		stats.ObjectCount++
+		stats.StoredBytes += object.Info.Size
+		return nil
+	})
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	packIDs, err := s.listPackIDs()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, packID := range packIDs {
+		index, err := s.readPackIndex(packID)
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+
+		for _, entry := range index.Entries {
+			stats.ObjectCount++
+			stats.StoredBytes += entry.Length
+		}
+	}
+
+	return nil
+}
+
+func (s *StoreStats) String() string {
+	return fmt.Sprintf(
+		"%d bucket(s). Logical size: %s, unique: %s (%s saved by deduplication), stored: %s in %d object(s) (compression ratio: %.2f)",
+		len(s.Buckets),
+		humanise.HumaniseBytes(s.LogicalBytes),
+		humanise.HumaniseBytes(s.UniqueBytes),
+		humanise.HumaniseBytes(s.DeduplicatedBytes),
+		humanise.HumaniseBytes(s.StoredBytes),
+		s.ObjectCount,
+		s.CompressionRatio,
+	)
+}
@@ -0,0 +1,120 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetStats(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+
+	stats, err := mockStore.Store.GetStats(StatsOptions{Revisions: true})
+	require.Nil(t, err)
+	assert.Equal(t, &StoreStats{Buckets: []*BucketStats{}}, stats)
+
+	docsBucket := mockStore.CreateBucket(t, "docs")
+	photosBucket := mockStore.CreateBucket(t, "photos")
+
+	sharedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "shared.txt", mockNow, FileMode600, []byte("shared contents"))
+	docsFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte("docs contents"))
+	copiedDocsFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", mockNow, FileMode600, []byte("docs contents"))
+
+	firstRevision := mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+	mockNow = mockNow.Add(time.Hour)
+	secondRevision := mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile, docsFile, copiedDocsFile})
+	mockNow = mockNow.Add(time.Hour)
+	mockStore.CreateRevision(t, photosBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+
+	sharedSize := int64(len("shared contents"))
+	docsSize := int64(len("docs contents"))
+
+	stats, err = mockStore.Store.GetStats(StatsOptions{Revisions: true})
+	require.Nil(t, err)
+
+	assert.Equal(t, 3*sharedSize+2*docsSize, stats.LogicalBytes)
+	assert.Equal(t, sharedSize+docsSize, stats.UniqueBytes)
+	assert.Equal(t, 2*sharedSize+docsSize, stats.DeduplicatedBytes)
+	assert.Equal(t, int64(2), stats.ObjectCount)
+	assert.True(t, stats.StoredBytes > 0)
+	assert.Equal(t, float64(stats.UniqueBytes)/float64(stats.StoredBytes), stats.CompressionRatio)
+
+	require.Len(t, stats.Buckets, 2)
+
+	docsStats := stats.Buckets[0]
+	assert.Equal(t, "docs", docsStats.BucketName)
+	assert.Equal(t, 2, docsStats.RevisionCount)
+	assert.Equal(t, 2*sharedSize+2*docsSize, docsStats.LogicalBytes)
+	assert.Equal(t, sharedSize+docsSize, docsStats.UniqueBytes)
+	assert.Equal(t, docsSize, docsStats.FreedIfDeletedBytes)
+	assert.Equal(t, []*RevisionStats{
+		{RevisionVersion: firstRevision.VersionTimestamp, FileCount: 1, LogicalBytes: sharedSize, NewBytes: sharedSize, CumulativeUniqueBytes: sharedSize},
+		{RevisionVersion: secondRevision.VersionTimestamp, FileCount: 3, LogicalBytes: sharedSize + 2*docsSize, NewBytes: docsSize, CumulativeUniqueBytes: sharedSize + docsSize},
+	}, docsStats.Revisions)
+
+	photosStats := stats.Buckets[1]
+	assert.Equal(t, "photos", photosStats.BucketName)
+	assert.Equal(t, int64(0), photosStats.FreedIfDeletedBytes)
+	require.Len(t, photosStats.Revisions, 1)
+	assert.Equal(t, sharedSize, photosStats.Revisions[0].NewBytes)
+}
+
+func Test_GetStats_fromObjectReferenceIndex(t *testing.T) {
+	mockNow := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	mockNowProvider := func() time.Time {
+		return mockNow
+	}
+
+	fs := mockfs.NewMockFs()
+	mockStore := NewMockStore(t, mockNowProvider, fs)
+
+	docsBucket := mockStore.CreateBucket(t, "docs")
+	photosBucket := mockStore.CreateBucket(t, "photos")
+
+	sharedFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "shared.txt", mockNow, FileMode600, []byte("shared contents"))
+	docsFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", mockNow, FileMode600, []byte("docs contents"))
+	copiedDocsFile := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", mockNow, FileMode600, []byte("docs contents"))
+
+	mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+	mockNow = mockNow.Add(time.Hour)
+	mockStore.CreateRevision(t, docsBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile, docsFile, copiedDocsFile})
+	mockNow = mockNow.Add(time.Hour)
+	mockStore.CreateRevision(t, photosBucket, []*intelligentstore.RegularFileDescriptorWithContents{sharedFile})
+
+	statsFromRevisions, err := mockStore.Store.GetStats(StatsOptions{Revisions: true})
+	require.Nil(t, err)
+
+	for _, bucketStats := range statsFromRevisions.Buckets {
+		bucketStats.Revisions = nil
+	}
+
+	t.Run("figures from the index are the same as from the revisions", func(t *testing.T) {
+		_, err := mockStore.Store.readObjectReferenceIndex()
+		require.Nil(t, err)
+
+		statsFromIndex, err := mockStore.Store.GetStats(StatsOptions{})
+		require.Nil(t, err)
+
+		assert.Equal(t, statsFromRevisions, statsFromIndex)
+	})
+
+	t.Run("bucket stats", func(t *testing.T) {
+		docsStats, err := mockStore.Store.GetBucketStats(docsBucket)
+		require.Nil(t, err)
+
+		assert.Equal(t, statsFromRevisions.Buckets[0].LogicalBytes, docsStats.LogicalBytes)
+		assert.Equal(t, statsFromRevisions.Buckets[0].UniqueBytes, docsStats.UniqueBytes)
+		assert.Equal(t, statsFromRevisions.Buckets[0].FreedIfDeletedBytes, docsStats.FreedIfDeletedBytes)
+		assert.Len(t, docsStats.Revisions, 2)
+	})
+}
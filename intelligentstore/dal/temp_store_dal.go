@@ -1,10 +1,14 @@
 package dal
 
 import (
-	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync/atomic"
 
 	"github.com/jamesrr39/goutil/errorsx"
@@ -15,59 +19,80 @@ import (
 type TempFile struct {
 	FilePath string
 	Hash     intelligentstore.Hash
+	// Size is the size of the (uncompressed) contents
+	Size int64
 }
 
+// TempStoreDAL manages the temp store. The temp store can be shared by several processes using the store at the same time,
+// so every entry is named with the pid of the process that created it, and a prefix unique to the TempStoreDAL.
 type TempStoreDAL struct {
-	latestID uint64
-	basePath string
-	fs       gofs.Fs
+	latestID   uint64
+	basePath   string
+	fs         gofs.Fs
+	namePrefix string
 }
 
-func NewTempStoreDAL(storeBasePath string, fs gofs.Fs) (*TempStoreDAL, errorsx.Error) {
+// NewTempStoreDAL creates a TempStoreDAL with the temp store in the directory at basePath, on the local filesystem.
+// If clearExisting is true, anything left in the temp store by processes that are no longer running (for example crashed processes) is removed.
+func NewTempStoreDAL(basePath string, fs gofs.Fs, clearExisting bool) (*TempStoreDAL, errorsx.Error) {
 	var err error
 
-	tempStoreDAL := &TempStoreDAL{0, filepath.Join(storeBasePath, BackupDataFolderName, "tmp"), fs}
-
-	err = tempStoreDAL.Clear()
+	b := make([]byte, 4)
+	_, err = rand.Read(b)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
-	err = fs.Mkdir(tempStoreDAL.basePath, 0700)
+	tempStoreDAL := &TempStoreDAL{0, basePath, fs, fmt.Sprintf("%d-%s-", os.Getpid(), hex.EncodeToString(b))}
+
+	err = fs.MkdirAll(tempStoreDAL.basePath, 0700)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
+	if clearExisting {
+		err = tempStoreDAL.Clear()
+		if err != nil {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
 	return tempStoreDAL, nil
 }
 
+// ListContents lists the files and directories currently in the temp store
+func (dal *TempStoreDAL) ListContents() ([]os.FileInfo, errorsx.Error) {
+	fileInfos, err := dal.fs.ReadDir(dal.basePath)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return fileInfos, nil
+}
+
 func (dal *TempStoreDAL) CreateTempFileFromReader(reader io.Reader, hash intelligentstore.Hash) (*TempFile, errorsx.Error) {
-	newID := atomic.AddUint64(&dal.latestID, 1)
-	dal.latestID = newID
-	filePath := filepath.Join(dal.basePath, strconv.FormatUint(newID, 10))
+	filePath := dal.newPath()
 	file, err := dal.fs.Create(filePath)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 	defer file.Close()
 
-	writer := gzip.NewWriter(file)
-	defer writer.Close()
-
-	_, err = io.Copy(writer, reader)
+	size, err := io.Copy(file, reader)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
-	writer.Flush()
-
-	return &TempFile{filePath, hash}, nil
+	return &TempFile{filePath, hash, size}, nil
 }
 
 func (dal *TempStoreDAL) CreateTempRevisionManifestFile() (gofs.File, string, errorsx.Error) {
-	newID := atomic.AddUint64(&dal.latestID, 1)
-	dal.latestID = newID
-	filePath := filepath.Join(dal.basePath, strconv.FormatUint(newID, 10))
+	return dal.CreateTempFile()
+}
+
+// CreateTempFile creates a new, empty, file inside the temp store, and returns it with it's path
+func (dal *TempStoreDAL) CreateTempFile() (gofs.File, string, errorsx.Error) {
+	filePath := dal.newPath()
 	file, err := dal.fs.Create(filePath)
 	if err != nil {
 		return nil, "", errorsx.Wrap(err)
@@ -76,6 +101,52 @@ func (dal *TempStoreDAL) CreateTempRevisionManifestFile() (gofs.File, string, er
 	return file, filePath, nil
 }
 
+// CreateTempDir creates a new, empty, directory inside the temp store
+func (dal *TempStoreDAL) CreateTempDir() (string, errorsx.Error) {
+	dirPath := dal.newPath()
+	err := dal.fs.Mkdir(dirPath, 0700)
+	if err != nil {
+		return "", errorsx.Wrap(err)
+	}
+
+	return dirPath, nil
+}
+
+// newPath gets a new path in the temp store, that nothing else uses
+func (dal *TempStoreDAL) newPath() string {
+	newID := atomic.AddUint64(&dal.latestID, 1)
+	return filepath.Join(dal.basePath, dal.namePrefix+strconv.FormatUint(newID, 10))
+}
+
+// IsLeftover returns true if the entry in the temp store with this name was left by a process that is no longer running.
+// Entries without a pid in their name are from before the temp store was shared between processes, and are always leftovers.
+func (dal *TempStoreDAL) IsLeftover(name string) bool {
+	pidStr := strings.SplitN(name, "-", 2)[0]
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return true
+	}
+
+	return !isProcessRunning(pid)
+}
+
+// Clear removes everything left in the temp store by processes that are no longer running
 func (dal *TempStoreDAL) Clear() errorsx.Error {
-	return errorsx.Wrap(dal.fs.RemoveAll(dal.basePath))
+	fileInfos, err := dal.ListContents()
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	for _, fileInfo := range fileInfos {
+		if !dal.IsLeftover(fileInfo.Name()) {
+			continue
+		}
+
+		err := dal.fs.RemoveAll(filepath.Join(dal.basePath, fileInfo.Name()))
+		if err != nil {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
 }
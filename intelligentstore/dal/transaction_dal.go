@@ -1,12 +1,12 @@
 package dal
 
 import (
-	"compress/gzip"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/jamesrr39/goutil/dirtraversal"
 	"github.com/jamesrr39/goutil/errorsx"
@@ -17,19 +17,144 @@ import (
 var (
 	ErrFileNotRequiredForTransaction = errors.New("file is not scheduled for upload. Perhaps it is a file that has changed (and it's hash has change) since it was evaluated in the listing")
 	ErrFileAlreadyUploaded           = errors.New("file has already been uploaded")
+	ErrRevisionAlreadyExists         = errors.New("the bucket already has a revision with this version")
+	ErrDryRunTransaction             = errors.New("a dry run transaction can't be committed")
+	ErrUploadedContentsMissing       = errors.New("contents uploaded in the transaction are missing from the store, and must be uploaded again")
 )
 
 type TransactionDAL struct {
 	IntelligentStoreDAL    *IntelligentStoreDAL
 	revisionManifestWriter revisionManifestWriter
+	// stateSaveTimes are when the state of each open transaction was last saved
+	stateSaveTimes   map[*intelligentstore.Transaction]time.Time
+	stateSaveTimesMu *sync.Mutex
+}
+
+func newTransactionDAL(storeDAL *IntelligentStoreDAL, revisionManifestWriter revisionManifestWriter) *TransactionDAL {
+	return &TransactionDAL{
+		storeDAL,
+		revisionManifestWriter,
+		make(map[*intelligentstore.Transaction]time.Time),
+		&sync.Mutex{},
+	}
+}
+
+// TransactionOptions are options for creating a transaction
+type TransactionOptions struct {
+	// Checksum hashes every regular file again, instead of reusing the hash from the previous revision for files whose type, modification time, size and mode haven't changed
+	Checksum bool
+	// RehashOlderThan hashes a rotating part of the files that look unchanged again in each revision, so that every file is hashed again at least once in this period (as long as a revision is made in it). It is off if it is 0.
+	RehashOlderThan time.Duration
+	// Source describes where the revision is made from, and why. It is recorded in the revision info.
+	Source intelligentstore.RevisionSource
+	// DryRun makes a transaction that can't be committed, and doesn't save its state (see Transaction.DryRun)
+	DryRun bool
 }
 
 // CreateTransaction starts a transaction. It is the first part of a transaction; after that, the files that are required must be backed up and then the transaction committed
+// If a transaction on the bucket was interrupted (or rolled back), and no revision has been committed since, it is resumed: the new transaction is for the same revision, and the hashes it had are in KnownHashes.
 func (dal *TransactionDAL) CreateTransaction(bucket *intelligentstore.Bucket, fileInfos []*intelligentstore.FileInfo) (*intelligentstore.Transaction, errorsx.Error) {
+	return dal.CreateTransactionWithOptions(bucket, fileInfos, TransactionOptions{})
+}
+
+// CreateTransactionWithOptions starts a transaction, like CreateTransaction. The options decide which files that look unchanged since the previous revision are required again, so their contents can be checked.
+func (dal *TransactionDAL) CreateTransactionWithOptions(bucket *intelligentstore.Bucket, fileInfos []*intelligentstore.FileInfo, options TransactionOptions) (*intelligentstore.Transaction, errorsx.Error) {
 	revisionVersion := intelligentstore.RevisionVersion(dal.IntelligentStoreDAL.nowProvider().Unix())
+
+	return dal.createTransaction(bucket, revisionVersion, true, fileInfos, options)
+}
+
+// CreateTransactionForRevisionVersion starts a transaction for a revision with the version given, instead of the current time.
+// It is used to replicate revisions from another store, so that they keep their version, and their info: the revision info given (the info of the revision in the other store) is written unchanged when the transaction is committed. If it is nil, no info is written.
+// If the bucket already has a revision with this version, the cause of the returned error is ErrRevisionAlreadyExists.
+// An interrupted transaction is only resumed if it was for the same revision version.
+func (dal *TransactionDAL) CreateTransactionForRevisionVersion(bucket *intelligentstore.Bucket, revisionVersion intelligentstore.RevisionVersion, fileInfos []*intelligentstore.FileInfo, revisionInfo *intelligentstore.RevisionInfo) (*intelligentstore.Transaction, errorsx.Error) {
+	revisions, err := dal.IntelligentStoreDAL.BucketDAL.GetRevisions(bucket)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	for _, revision := range revisions {
+		if revision.VersionTimestamp == revisionVersion {
+			return nil, errorsx.Wrap(ErrRevisionAlreadyExists, "revisionVersion", revisionVersion)
+		}
+	}
+
+	tx, err := dal.createTransaction(bucket, revisionVersion, false, fileInfos, TransactionOptions{})
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	tx.Replicated = true
+	tx.ReplicatedRevisionInfo = revisionInfo
+
+	return tx, nil
+}
+
+func (dal *TransactionDAL) createTransaction(bucket *intelligentstore.Bucket, revisionVersion intelligentstore.RevisionVersion, resumeAnyVersion bool, fileInfos []*intelligentstore.FileInfo, options TransactionOptions) (*intelligentstore.Transaction, errorsx.Error) {
+	_, err := dal.IntelligentStoreDAL.LockDAL.acquireBucketLock(bucket, fmt.Sprintf("lock from transaction. Bucket: %d (%s), revision version: %d",
+		bucket.ID,
+		bucket.BucketName,
+		revisionVersion,
+	))
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	tx, err := dal.newTransaction(bucket, revisionVersion, resumeAnyVersion, fileInfos, options)
+	if err != nil {
+		removeLockErr := dal.IntelligentStoreDAL.LockDAL.removeBucketLock(bucket)
+		if removeLockErr != nil {
+			slog.Error("failed to remove bucket lock after failing to create a transaction", "error", removeLockErr)
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	dal.IntelligentStoreDAL.transactionOpened()
+
+	return tx, nil
+}
+
+// newTransaction builds a transaction, while the bucket is locked.
+// If a transaction on the bucket was interrupted, and it can be resumed, the new transaction takes over its revision version, and the hashes it had for files that haven't changed since.
+// If resumeAnyVersion is false, an interrupted transaction is only resumed if it was for the revision version given.
+func (dal *TransactionDAL) newTransaction(bucket *intelligentstore.Bucket, revisionVersion intelligentstore.RevisionVersion, resumeAnyVersion bool, fileInfos []*intelligentstore.FileInfo, options TransactionOptions) (*intelligentstore.Transaction, errorsx.Error) {
+	hashAlgorithm := dal.IntelligentStoreDAL.HashAlgorithm()
+
+	var resumedFiles map[intelligentstore.RelativePath]*transactionStateFile
+
+	state, err := dal.readTransactionState(bucket)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if state != nil {
+		revisions, err := dal.IntelligentStoreDAL.BucketDAL.GetRevisions(bucket)
+		if nil != err {
+			return nil, errorsx.Wrap(err)
+		}
+
+		resumable, reason := state.isResumable(hashAlgorithm, revisionVersion, resumeAnyVersion, revisions)
+		if resumable {
+			slog.Info("resuming interrupted transaction",
+				"bucket", bucket.BucketName,
+				"revisionVersion", state.RevisionVersion,
+				"uploadedContents", state.countUploadedContents(),
+				"requiredContents", len(state.UploadStatuses),
+			)
+			revisionVersion = state.RevisionVersion
+			resumedFiles = state.filesWithKnownHashes()
+		} else {
+			slog.Info("discarding interrupted transaction", "bucket", bucket.BucketName, "revisionVersion", state.RevisionVersion, "reason", reason)
+		}
+	}
+
 	revision := intelligentstore.NewRevision(bucket, revisionVersion)
 
-	tx := intelligentstore.NewTransaction(revision, FsHashPresentResolver{dal.IntelligentStoreDAL})
+	tx := intelligentstore.NewTransaction(revision, hashAlgorithm, FsHashPresentResolver{dal.IntelligentStoreDAL})
+	tx.RevisionInfo.RevisionSource = options.Source
+	tx.RevisionInfo.StartTime = dal.IntelligentStoreDAL.nowProvider()
+	tx.DryRun = options.DryRun
 
 	previousRevisionMap := make(map[intelligentstore.RelativePath]intelligentstore.FileDescriptor)
 
@@ -57,14 +182,37 @@ func (dal *TransactionDAL) CreateTransaction(bucket *intelligentstore.Bucket, fi
 
 		descriptorFromPreviousRevision := previousRevisionMap[fileInfo.RelativePath]
 		fileAlreadyExistsInStore := (nil != descriptorFromPreviousRevision &&
-			descriptorFromPreviousRevision.GetFileInfo().Type == fileInfo.Type &&
-			descriptorFromPreviousRevision.GetFileInfo().ModTime.Equal(fileInfo.ModTime) &&
-			descriptorFromPreviousRevision.GetFileInfo().Size == fileInfo.Size &&
-			descriptorFromPreviousRevision.GetFileInfo().FileMode == fileInfo.FileMode)
+			isSameFileInfo(descriptorFromPreviousRevision.GetFileInfo(), fileInfo))
+
+		switch {
+		case nil == descriptorFromPreviousRevision:
+			tx.RevisionInfo.NewFiles++
+		case fileAlreadyExistsInStore:
+			// files that are hashed again, and turn out to have changed, are counted as changed when the transaction is committed
+			tx.RevisionInfo.UnchangedFiles++
+		default:
+			tx.RevisionInfo.ChangedFiles++
+		}
+
+		if fileAlreadyExistsInStore && options.shouldRehash(fileInfo.RelativePath, previousRevision, revision.VersionTimestamp) {
+			// the file looks unchanged, but its contents are checked again. Tools that keep the modification time, or edits in place that keep the size, don't show up in the file info
+			regularFileDescriptor, ok := descriptorFromPreviousRevision.(*intelligentstore.RegularFileDescriptor)
+			if ok {
+				tx.FileInfosMissingHashes[fileInfo.RelativePath] = fileInfo
+				tx.HashesToVerify[fileInfo.RelativePath] = regularFileDescriptor.Hash
+				continue
+			}
+		}
 
 		if fileAlreadyExistsInStore {
-			// same as previous version, so just use that
-			tx.FilesInVersion = append(tx.FilesInVersion, descriptorFromPreviousRevision)
+			// same contents as in the previous version, so just use them.
+			// The new file info is kept, as the ownership or extended attributes could have changed, and the modification time could be more precise
+			descriptor, err := descriptorWithFileInfo(descriptorFromPreviousRevision, fileInfo)
+			if nil != err {
+				return nil, errorsx.Wrap(err)
+			}
+
+			tx.FilesInVersion = append(tx.FilesInVersion, descriptor)
 		} else {
 			// file not in previous version, so mark for hash calculation
 			switch fileInfo.Type {
@@ -72,17 +220,35 @@ func (dal *TransactionDAL) CreateTransaction(bucket *intelligentstore.Bucket, fi
 				tx.FileInfosMissingSymlinks[fileInfo.RelativePath] = fileInfo
 			case intelligentstore.FileTypeRegular:
 				tx.FileInfosMissingHashes[fileInfo.RelativePath] = fileInfo
+
+				// the hash is still required, but if the file hasn't changed since the interrupted transaction, the uploader doesn't need to calculate it again.
+				// (files that are only hashed again to check their contents are not in here, as the interrupted transaction could have reused the hash from the previous revision for them)
+				resumedFile := resumedFiles[fileInfo.RelativePath]
+				if nil != resumedFile && isSameFileInfo(resumedFile.FileInfo, fileInfo) {
+					tx.KnownHashes[fileInfo.RelativePath] = resumedFile.Hash
+				}
+			case intelligentstore.FileTypeDir:
+				// everything recorded about a directory is in its file info, so nothing more is required from the uploader
+				tx.FilesInVersion = append(tx.FilesInVersion, intelligentstore.NewDirectoryFileDescriptorFromFileInfo(fileInfo))
+			case intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
+				// the same goes for special files
+				tx.FilesInVersion = append(tx.FilesInVersion, intelligentstore.NewSpecialFileDescriptor(fileInfo))
 			default:
 				return nil, errorsx.Errorf("unknown file type: %d (%s)", fileInfo.Type, fileInfo.Type)
 			}
 		}
 	}
 
-	_, err = dal.IntelligentStoreDAL.LockDAL.acquireStoreLock(fmt.Sprintf("lock from transaction. Bucket: %d (%s), revision version: %d",
-		bucket.ID,
-		bucket.BucketName,
-		revisionVersion,
-	))
+	// every path from the previous revision that is still there is either changed or unchanged
+	tx.RevisionInfo.DeletedFiles = int64(len(previousRevisionMap)) - tx.RevisionInfo.ChangedFiles - tx.RevisionInfo.UnchangedFiles
+
+	// another process could have added packs since the pack indexes were loaded
+	err = dal.IntelligentStoreDAL.reloadPackIndexes()
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	err = dal.saveTransactionState(tx)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
@@ -90,19 +256,135 @@ func (dal *TransactionDAL) CreateTransaction(bucket *intelligentstore.Bucket, fi
 	return tx, nil
 }
 
+// shouldRehash returns whether a file that looks unchanged since the previous revision should be hashed again for this revision
+func (options TransactionOptions) shouldRehash(relativePath intelligentstore.RelativePath, previousRevision *intelligentstore.Revision, revisionVersion intelligentstore.RevisionVersion) bool {
+	if options.Checksum {
+		return true
+	}
+
+	if options.RehashOlderThan <= 0 || previousRevision == nil {
+		return false
+	}
+
+	return isDueForRehash(relativePath, previousRevision.VersionTimestamp, revisionVersion, options.RehashOlderThan)
+}
+
+// isDueForRehash returns whether the file is due to be hashed again, in a revision made after the previous revision.
+// Each file has a fixed point in the period (from a hash of its relative path). It is due if that point was passed since the previous revision, so each revision hashes the part of the files whose points were passed, and every file is hashed again once per period.
+func isDueForRehash(relativePath intelligentstore.RelativePath, previousRevisionVersion, revisionVersion intelligentstore.RevisionVersion, period time.Duration) bool {
+	periodSeconds := int64(period / time.Second)
+	if periodSeconds < 1 {
+		periodSeconds = 1
+	}
+
+	elapsed := int64(revisionVersion) - int64(previousRevisionVersion)
+	if elapsed >= periodSeconds {
+		return true
+	}
+
+	if elapsed <= 0 {
+		return false
+	}
+
+	hasher := fnv.New64a()
+	hasher.Write([]byte(relativePath))
+	point := int64(hasher.Sum64() % uint64(periodSeconds))
+
+	previousPoint := int64(previousRevisionVersion) % periodSeconds
+	currentPoint := int64(revisionVersion) % periodSeconds
+
+	if previousPoint < currentPoint {
+		return point > previousPoint && point <= currentPoint
+	}
+
+	// the period started again since the previous revision
+	return point > previousPoint || point <= currentPoint
+}
+
+// isSameFileInfo returns whether a recorded file info and a new file info describe the same, unchanged, file.
+// Only what changes with the contents of the file is compared; a file whose owner or extended attributes changed still has the same contents.
+// Only the permissions of the modes are compared, as manifests don't record the type bits of the mode (the type is recorded on its own).
+func isSameFileInfo(recordedFileInfo, fileInfo *intelligentstore.FileInfo) bool {
+	return recordedFileInfo.Type == fileInfo.Type &&
+		isSameModTime(recordedFileInfo.ModTime, fileInfo.ModTime) &&
+		recordedFileInfo.Size == fileInfo.Size &&
+		fileModeToUnixPermissions(recordedFileInfo.FileMode) == fileModeToUnixPermissions(fileInfo.FileMode)
+}
+
+// isSameModTime returns whether a recorded modification time is the same as a new one.
+// Manifests from before modification times were recorded to the nanosecond have them to the millisecond, so a recorded time with nothing after the millisecond is also the same as a new time cut to the millisecond.
+func isSameModTime(recordedModTime, modTime time.Time) bool {
+	if recordedModTime.Equal(modTime) {
+		return true
+	}
+
+	return recordedModTime.Nanosecond()%int(time.Millisecond) == 0 && recordedModTime.Equal(modTime.Truncate(time.Millisecond))
+}
+
+// descriptorWithFileInfo gets a copy of a file descriptor, with a new file info
+func descriptorWithFileInfo(descriptor intelligentstore.FileDescriptor, fileInfo *intelligentstore.FileInfo) (intelligentstore.FileDescriptor, errorsx.Error) {
+	switch fd := descriptor.(type) {
+	case *intelligentstore.RegularFileDescriptor:
+		return &intelligentstore.RegularFileDescriptor{FileInfo: fileInfo, Hash: fd.Hash, Chunks: fd.Chunks}, nil
+	case *intelligentstore.SymlinkFileDescriptor:
+		return intelligentstore.NewSymlinkFileDescriptor(fileInfo, fd.Dest), nil
+	case *intelligentstore.DirectoryFileDescriptor:
+		return intelligentstore.NewDirectoryFileDescriptorFromFileInfo(fileInfo), nil
+	case *intelligentstore.SpecialFileDescriptor:
+		return intelligentstore.NewSpecialFileDescriptor(fileInfo), nil
+	default:
+		return nil, errorsx.Errorf("unknown file type: %d (%s)", descriptor.GetFileInfo().Type, descriptor.GetFileInfo().Type)
+	}
+}
+
+// ProcessUploadHashesAndGetRequiredHashes takes the list of relative paths and hashes for the transaction, and figures out which hashes need to be uploaded.
+// Files that were hashed again to check their contents, and turned out to have changed without their file info changing, are logged.
+// It saves the state of the transaction, so that if the transaction is interrupted, the hashes don't have to be calculated again.
+func (dal *TransactionDAL) ProcessUploadHashesAndGetRequiredHashes(transaction *intelligentstore.Transaction, relativePathsWithHashes []*intelligentstore.RelativePathWithHash) ([]intelligentstore.Hash, errorsx.Error) {
+	hashes, err := transaction.ProcessUploadHashesAndGetRequiredHashes(relativePathsWithHashes)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	for _, relativePath := range transaction.ChangedWithSameFileInfo {
+		slog.Warn("the contents of the file changed, but its modification time and size didn't",
+			"bucket", transaction.Revision.BucketName,
+			"relativePath", relativePath,
+		)
+	}
+
+	err = dal.saveTransactionState(transaction)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	dal.setTransactionStateSaveTime(transaction)
+
+	return hashes, nil
+}
+
 // BackupFromTempFile copies a known tempfile into the store. It moves the file, so the temp file will not exist in the "temp store" after this.
 func (dal *TransactionDAL) BackupFromTempFile(transaction *intelligentstore.Transaction, tempfile *TempFile) error {
-	createFileFunc := func(destinationFilePath string) error {
-		return dal.IntelligentStoreDAL.fs.Rename(tempfile.FilePath, destinationFilePath)
+	createFileFunc := func() error {
+		if dal.IntelligentStoreDAL.chunkingSettings.ShouldChunk(tempfile.Size) {
+			return dal.createChunkedStoreFileFromTempFile(tempfile)
+		}
+
+		return dal.createStoreFileFromTempFile(tempfile)
 	}
 
-	return dal.backupFile(transaction, tempfile.Hash, createFileFunc)
+	return dal.backupFile(transaction, tempfile.Hash, tempfile.Size, createFileFunc)
 }
 
 // BackupFile backs up a file from a read-seeker
 func (dal *TransactionDAL) BackupFile(transaction *intelligentstore.Transaction, sourceFile io.ReadSeeker) errorsx.Error {
 
-	hash, err := intelligentstore.NewHash(sourceFile)
+	hash, err := intelligentstore.NewHashWithAlgorithm(sourceFile, transaction.HashAlgorithm)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	size, err := sourceFile.Seek(0, io.SeekEnd)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
@@ -112,40 +394,44 @@ func (dal *TransactionDAL) BackupFile(transaction *intelligentstore.Transaction,
 		return errorsx.Wrap(err)
 	}
 
-	createFileFunc := func(destinationFilePath string) error {
-		return dal.createNewStoreFile(sourceFile, destinationFilePath)
+	createFileFunc := func() error {
+		if dal.IntelligentStoreDAL.chunkingSettings.ShouldChunk(size) {
+			return dal.createChunkedStoreFile(sourceFile, hash)
+		}
+
+		return dal.IntelligentStoreDAL.writeObject(sourceFile, hash)
 	}
 
-	return dal.backupFile(transaction, hash, createFileFunc)
+	return dal.backupFile(transaction, hash, size, createFileFunc)
 }
 
-func (dal *TransactionDAL) createNewStoreFile(sourceFile io.ReadSeeker, destinationFilePath string) error {
-	newFile, err := dal.IntelligentStoreDAL.fs.Create(destinationFilePath)
-	if err != nil {
-		return err
-	}
-	defer newFile.Close()
+// createStoreFileFromTempFile stores the (uncompressed) contents of a temp file as an object, and then removes the temp file
+func (dal *TransactionDAL) createStoreFileFromTempFile(tempfile *TempFile) errorsx.Error {
+	store := dal.IntelligentStoreDAL
 
-	gzipWriter := gzip.NewWriter(newFile)
-	defer gzipWriter.Close()
+	file, openErr := store.fs.Open(tempfile.FilePath)
+	if openErr != nil {
+		return errorsx.Wrap(openErr)
+	}
+	defer file.Close()
 
-	_, err = io.Copy(gzipWriter, sourceFile)
-	if nil != err {
-		return err
+	err := store.writeObject(file, tempfile.Hash)
+	if err != nil {
+		return errorsx.Wrap(err)
 	}
 
-	err = gzipWriter.Flush()
-	if nil != err {
-		return err
+	removeErr := store.fs.Remove(tempfile.FilePath)
+	if removeErr != nil {
+		return errorsx.Wrap(removeErr)
 	}
 
 	return nil
 }
 
-type createFileFuncType func(destinationFilePath string) error
+type createFileFuncType func() error
 
 // TODO: test for >4GB file
-func (dal *TransactionDAL) backupFile(transaction *intelligentstore.Transaction, hash intelligentstore.Hash, createFileFunc createFileFuncType) errorsx.Error {
+func (dal *TransactionDAL) backupFile(transaction *intelligentstore.Transaction, hash intelligentstore.Hash, size int64, createFileFunc createFileFuncType) errorsx.Error {
 	var err error
 
 	err = transaction.CheckStage(intelligentstore.TransactionStageReadyToUploadFiles)
@@ -153,8 +439,6 @@ func (dal *TransactionDAL) backupFile(transaction *intelligentstore.Transaction,
 		return errorsx.Wrap(err)
 	}
 
-	filePath := dal.IntelligentStoreDAL.RevisionDAL.getObjectPath(hash)
-
 	transaction.Mu.RLock()
 	status, ok := transaction.UploadStatusMap[hash]
 	transaction.Mu.RUnlock()
@@ -166,35 +450,35 @@ func (dal *TransactionDAL) backupFile(transaction *intelligentstore.Transaction,
 		return errorsx.Wrap(ErrFileAlreadyUploaded, "hash", hash)
 	}
 
-	fs := dal.IntelligentStoreDAL.fs
-
-	// check if file exist in store already
-	_, err = fs.Stat(filePath)
+	// check if file exist in store already (either as a single object, or as chunks)
+	isPresent, err := dal.IntelligentStoreDAL.IsObjectPresent(hash)
 	if nil != err {
-		if !os.IsNotExist(err) {
-			// permissions issue or something.
-			return errorsx.Wrap(err, "hash", hash)
-		}
-		// file doesn't exist in store already. Write it to store.
-		err := fs.MkdirAll(filepath.Dir(filePath), 0700)
-		if nil != err {
-			return errorsx.Wrap(err, "hash", hash)
-		}
+		// permissions issue or something.
+		return errorsx.Wrap(err, "hash", hash)
+	}
 
-		err = createFileFunc(filePath)
+	if !isPresent {
+		// file doesn't exist in store already. Write it to store.
+		err = createFileFunc()
 		if err != nil {
 			return errorsx.Wrap(err, "hash", hash)
 		}
 	}
 
 	transaction.Mu.Lock()
-	defer transaction.Mu.Unlock()
 	transaction.UploadStatusMap[hash] = intelligentstore.UploadStatusCompleted
+	transaction.RevisionInfo.BytesUploaded += size
+	transaction.Mu.Unlock()
 
 	slog.Debug("file uploaded", "hash", hash)
 
-	return nil
+	// the contents are uploaded, so failing to record it only means a resumed transaction checks for them again
+	err = dal.saveTransactionStateIfDue(transaction)
+	if nil != err {
+		slog.Warn("failed to save the transaction state", "error", err)
+	}
 
+	return nil
 }
 
 func getRemainingFileCountToUpload(transaction *intelligentstore.Transaction) int {
@@ -208,7 +492,7 @@ func getRemainingFileCountToUpload(transaction *intelligentstore.Transaction) in
 }
 
 type revisionManifestWriter interface {
-	GetManifestFilePath(storeBasePath string, revision *intelligentstore.Revision) string
+	GetManifestFileKey(revision *intelligentstore.Revision) string
 	Write(writer io.Writer, filesInVersion []intelligentstore.FileDescriptor) errorsx.Error
 }
 
@@ -221,6 +505,16 @@ func (dal *TransactionDAL) Commit(transaction *intelligentstore.Transaction) err
 		return errorsx.Wrap(err)
 	}
 
+	if transaction.DryRun {
+		return errorsx.Wrap(ErrDryRunTransaction)
+	}
+
+	// a transaction that has lost it's bucket lock could be committing over another process's transaction on the bucket
+	err = dal.IntelligentStoreDAL.LockDAL.checkBucketLockHeld(transaction.Revision.Bucket)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
 	if len(transaction.FileInfosMissingSymlinks) != 0 {
 		return errorsx.Errorf(
 			"tried to commit the transaction but there are %d symlinks left to upload",
@@ -234,37 +528,99 @@ func (dal *TransactionDAL) Commit(transaction *intelligentstore.Transaction) err
 			amountOfFilesRemainingToUpload)
 	}
 
+	// the objects in the open pack are only in the store once the pack is finished, so it has to be finished before the manifest refers to them
+	err = dal.IntelligentStoreDAL.finishOpenPack()
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	err = dal.requireUploadedContentsPresent(transaction)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	err = dal.fillChunkLists(transaction)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
 	tmpFile, tmpFilePath, err := dal.IntelligentStoreDAL.TempStoreDAL.CreateTempRevisionManifestFile()
 	if err != nil {
 		return errorsx.Wrap(err)
 	}
 	defer tmpFile.Close()
 
-	err = dal.revisionManifestWriter.Write(tmpFile, transaction.FilesInVersion)
+	// the manifest is encrypted for the key it is stored at once it is complete
+	revisionManifestFileKey := dal.revisionManifestWriter.GetManifestFileKey(transaction.Revision)
+
+	manifestWriter, err := dal.IntelligentStoreDAL.newEncryptingWriteCloser(tmpFile, revisionManifestFileKey)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
 
-	err = tmpFile.Sync()
+	err = dal.revisionManifestWriter.Write(manifestWriter, transaction.FilesInVersion)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
 
-	err = tmpFile.Close()
+	err = manifestWriter.Close()
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	// the references are counted before the manifest is stored. If storing the manifest fails, the index over-counts, which only stops the garbage collector removing the objects
+	err = dal.IntelligentStoreDAL.updateObjectReferenceIndex(func(index *objectReferenceIndex) {
+		index.addRevision(transaction.Revision, transaction.FilesInVersion)
+	})
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	// the info is stored before the manifest, so every revision committed from now on has one (apart from replicas of revisions that don't)
+	revisionInfo := dal.newRevisionInfoForCommit(transaction)
+	if transaction.Replicated {
+		revisionInfo = transaction.ReplicatedRevisionInfo
+	}
+
+	if revisionInfo != nil {
+		err = dal.IntelligentStoreDAL.RevisionDAL.writeRevisionInfo(transaction.Revision, revisionInfo)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	// the manifest is only stored once it is complete, so a revision is either there in full, or not at all
+	err = dal.IntelligentStoreDAL.backend.Put(revisionManifestFileKey, tmpFile)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
 
-	revisionManifestFilePath := dal.revisionManifestWriter.GetManifestFilePath(dal.IntelligentStoreDAL.StoreBasePath, transaction.Revision)
+	err = tmpFile.Close()
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
 
-	err = dal.IntelligentStoreDAL.fs.Rename(tmpFilePath, revisionManifestFilePath)
+	err = dal.IntelligentStoreDAL.fs.Remove(tmpFilePath)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
 
-	transaction.Stage = intelligentstore.TransactionStageCommitted
+	transaction.SetStage(intelligentstore.TransactionStageCommitted)
+	dal.IntelligentStoreDAL.transactionCommitted()
+	dal.forgetTransactionStateSaveTime(transaction)
 
-	err = dal.IntelligentStoreDAL.LockDAL.removeStoreLock()
+	// the revision is committed, so a leftover state is discarded by the next transaction anyway
+	err = dal.removeTransactionState(transaction.Revision.Bucket)
+	if nil != err {
+		slog.Warn("failed to remove the transaction state", "error", err)
+	}
+
+	err = dal.IntelligentStoreDAL.LockDAL.removeBucketLock(transaction.Revision.Bucket)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
@@ -272,18 +628,153 @@ func (dal *TransactionDAL) Commit(transaction *intelligentstore.Transaction) err
 	return nil
 }
 
-// Rollback aborts the current transaction and removes the lock.
-// It doesn't remove files inside the object store
+// requireUploadedContentsPresent checks that the contents of every file the transaction got the hash of are in the store, before the manifest refers to them.
+// Contents can go missing after they were uploaded (or found to be in the store already), if the pack they were added to couldn't be stored.
+// Missing contents are marked as pending again, so the transaction can't be committed until they are uploaded again, and the cause of the returned error is ErrUploadedContentsMissing.
+// Files that are unchanged since the previous revision are not checked; their contents were in the store when that revision was committed.
+func (dal *TransactionDAL) requireUploadedContentsPresent(transaction *intelligentstore.Transaction) errorsx.Error {
+	transaction.Mu.RLock()
+	hashesToCheck := make(map[intelligentstore.Hash]struct{})
+	for _, descriptor := range transaction.FilesInVersion {
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if !ok {
+			continue
+		}
+
+		_, wasHashed := transaction.FileInfosMissingHashes[regularFileDescriptor.RelativePath]
+		if wasHashed {
+			hashesToCheck[regularFileDescriptor.Hash] = struct{}{}
+		}
+	}
+	transaction.Mu.RUnlock()
+
+	var missingHashes []intelligentstore.Hash
+	for hash := range hashesToCheck {
+		isComplete, err := dal.IntelligentStoreDAL.isObjectComplete(hash)
+		if nil != err {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+
+		if !isComplete {
+			missingHashes = append(missingHashes, hash)
+		}
+	}
+
+	if len(missingHashes) == 0 {
+		return nil
+	}
+
+	for _, hash := range missingHashes {
+		// a chunk list whose chunks are missing would make the contents look present when they are uploaded again
+		err := dal.IntelligentStoreDAL.backend.Delete(dal.IntelligentStoreDAL.getChunkListKey(hash))
+		if nil != err {
+			return errorsx.Wrap(err, "hash", hash)
+		}
+	}
+
+	transaction.Mu.Lock()
+	for _, hash := range missingHashes {
+		transaction.UploadStatusMap[hash] = intelligentstore.UploadStatusPending
+	}
+	transaction.Mu.Unlock()
+
+	err := dal.saveTransactionState(transaction)
+	if nil != err {
+		slog.Warn("failed to save the transaction state", "error", err)
+	}
+
+	return errorsx.Wrap(ErrUploadedContentsMissing, "missingContents", len(missingHashes))
+}
+
+// newRevisionInfoForCommit gets the info of the revision of a transaction that is being committed.
+// The files that looked unchanged, but whose contents changed, are counted as changed.
+func (dal *TransactionDAL) newRevisionInfoForCommit(transaction *intelligentstore.Transaction) *intelligentstore.RevisionInfo {
+	transaction.Mu.RLock()
+	defer transaction.Mu.RUnlock()
+
+	revisionInfo := *transaction.RevisionInfo
+
+	changedWithSameFileInfoCount := int64(len(transaction.ChangedWithSameFileInfo))
+	revisionInfo.UnchangedFiles -= changedWithSameFileInfoCount
+	revisionInfo.ChangedFiles += changedWithSameFileInfoCount
+
+	revisionInfo.Duration = dal.IntelligentStoreDAL.nowProvider().Sub(revisionInfo.StartTime)
+
+	return &revisionInfo
+}
+
+// Rollback aborts the current transaction and removes the bucket lock.
+// It doesn't remove files inside the object store, apart from the open pack, which is discarded (unless other transactions in this process are still using it).
+// The state of the transaction is kept, so the next transaction on the bucket resumes it. Use Abort to discard it as well.
 func (dal *TransactionDAL) Rollback(transaction *intelligentstore.Transaction) errorsx.Error {
 	err := transaction.CheckStage(intelligentstore.TransactionStageAwaitingFileHashes, intelligentstore.TransactionStageReadyToUploadFiles)
 	if nil != err {
 		return err
 	}
 
-	err = dal.IntelligentStoreDAL.LockDAL.removeStoreLock()
+	err = dal.saveTransactionState(transaction)
+	if nil != err {
+		slog.Warn("failed to save the transaction state", "error", err)
+	}
+
+	transaction.SetStage(intelligentstore.TransactionStageAborted)
+	dal.IntelligentStoreDAL.transactionRolledBack()
+	dal.forgetTransactionStateSaveTime(transaction)
+
+	err = dal.IntelligentStoreDAL.LockDAL.removeBucketLock(transaction.Revision.Bucket)
 	if nil != err {
 		return errorsx.Wrap(err)
 	}
 
 	return nil
 }
+
+// Abort rolls back the transaction, and discards its state, so that the next transaction on the bucket starts a new revision.
+// A dry run transaction has no state of its own, so an interrupted transaction it resumed is left to be resumed.
+func (dal *TransactionDAL) Abort(transaction *intelligentstore.Transaction) errorsx.Error {
+	err := transaction.CheckStage(intelligentstore.TransactionStageAwaitingFileHashes, intelligentstore.TransactionStageReadyToUploadFiles)
+	if nil != err {
+		return err
+	}
+
+	if !transaction.DryRun {
+		// the state is removed while the bucket is still locked. If the lock was lost, the state may be another process's
+		err = dal.IntelligentStoreDAL.LockDAL.checkBucketLockHeld(transaction.Revision.Bucket)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+
+		err = dal.removeTransactionState(transaction.Revision.Bucket)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	transaction.SetStage(intelligentstore.TransactionStageAborted)
+	dal.IntelligentStoreDAL.transactionRolledBack()
+	dal.forgetTransactionStateSaveTime(transaction)
+
+	return dal.IntelligentStoreDAL.LockDAL.removeBucketLock(transaction.Revision.Bucket)
+}
+
+// DiscardInterruptedTransaction discards the state of an interrupted transaction on the bucket (if there is one), so that the next transaction on the bucket starts a new revision
+func (dal *TransactionDAL) DiscardInterruptedTransaction(bucket *intelligentstore.Bucket) errorsx.Error {
+	_, err := dal.IntelligentStoreDAL.LockDAL.acquireBucketLock(bucket, fmt.Sprintf("lock to discard the interrupted transaction. Bucket: %d (%s)",
+		bucket.ID,
+		bucket.BucketName,
+	))
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	err = dal.removeTransactionState(bucket)
+	if nil != err {
+		removeLockErr := dal.IntelligentStoreDAL.LockDAL.removeBucketLock(bucket)
+		if removeLockErr != nil {
+			slog.Error("failed to remove bucket lock after failing to discard the interrupted transaction", "error", removeLockErr)
+		}
+		return errorsx.Wrap(err)
+	}
+
+	return dal.IntelligentStoreDAL.LockDAL.removeBucketLock(bucket)
+}
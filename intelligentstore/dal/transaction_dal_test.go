@@ -2,6 +2,7 @@ package dal
 
 import (
 	"bytes"
+	"os"
 	"testing"
 	"time"
 
@@ -223,3 +224,337 @@ func Test_ProcessSymlinks(t *testing.T) {
 	err = mockStore.Store.TransactionDAL.Commit(tx)
 	require.Nil(t, err)
 }
+
+func Test_ResumeTransaction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowProvider := func() time.Time {
+		return now
+	}
+
+	mockStore := NewMockStore(t, nowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+	transactionDAL := mockStore.Store.TransactionDAL
+
+	aFileContents, bFileContents, newBFileContents := "a text", "b text", "new b text"
+
+	aDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte(aFileContents))
+	bDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte(bFileContents))
+	newBDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(1, 0), FileMode600, []byte(newBFileContents))
+
+	// start a transaction, upload one file, and interrupt it
+	tx1, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo, bDescriptor.Descriptor.FileInfo})
+	require.Nil(t, err)
+	assert.Empty(t, tx1.KnownHashes)
+
+	_, err = transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx1, []*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash("a.txt", aDescriptor.Descriptor.Hash),
+		intelligentstore.NewRelativePathWithHash("b.txt", bDescriptor.Descriptor.Hash),
+	})
+	require.Nil(t, err)
+
+	err = transactionDAL.BackupFile(tx1, bytes.NewReader(aDescriptor.Contents))
+	require.Nil(t, err)
+
+	err = transactionDAL.Rollback(tx1)
+	require.Nil(t, err)
+
+	// b.txt changed since, so only the hash of a.txt is known
+	now = time.Unix(2000, 0)
+	tx2, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo, newBDescriptor.Descriptor.FileInfo})
+	require.Nil(t, err)
+
+	assert.Equal(t, intelligentstore.RevisionVersion(1000), tx2.Revision.VersionTimestamp)
+	assert.Equal(t, map[intelligentstore.RelativePath]intelligentstore.Hash{"a.txt": aDescriptor.Descriptor.Hash}, tx2.KnownHashes)
+	assert.ElementsMatch(t, []intelligentstore.RelativePath{"a.txt", "b.txt"}, tx2.GetRelativePathsRequired())
+
+	// the contents of a.txt were uploaded before the interruption
+	hashes, err := transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx2, []*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash("a.txt", tx2.KnownHashes["a.txt"]),
+		intelligentstore.NewRelativePathWithHash("b.txt", newBDescriptor.Descriptor.Hash),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []intelligentstore.Hash{newBDescriptor.Descriptor.Hash}, hashes)
+
+	err = transactionDAL.BackupFile(tx2, bytes.NewReader(newBDescriptor.Contents))
+	require.Nil(t, err)
+
+	err = transactionDAL.Commit(tx2)
+	require.Nil(t, err)
+
+	state, err := transactionDAL.readTransactionState(bucket)
+	require.Nil(t, err)
+	assert.Nil(t, state)
+
+	// an aborted transaction isn't resumed
+	now = time.Unix(3000, 0)
+	tx3, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo})
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.RevisionVersion(3000), tx3.Revision.VersionTimestamp)
+
+	err = transactionDAL.Abort(tx3)
+	require.Nil(t, err)
+
+	state, err = transactionDAL.readTransactionState(bucket)
+	require.Nil(t, err)
+	assert.Nil(t, state)
+
+	// neither is a discarded one
+	tx4, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo})
+	require.Nil(t, err)
+
+	err = transactionDAL.Rollback(tx4)
+	require.Nil(t, err)
+
+	err = transactionDAL.DiscardInterruptedTransaction(bucket)
+	require.Nil(t, err)
+
+	now = time.Unix(4000, 0)
+	tx5, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo})
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.RevisionVersion(4000), tx5.Revision.VersionTimestamp)
+
+	// a transaction for a given revision version only resumes one for the same version
+	err = transactionDAL.Rollback(tx5)
+	require.Nil(t, err)
+
+	tx6, err := transactionDAL.CreateTransactionForRevisionVersion(bucket, 3500, []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.RevisionVersion(3500), tx6.Revision.VersionTimestamp)
+
+	err = transactionDAL.Abort(tx6)
+	require.Nil(t, err)
+}
+
+func Test_CreateTransactionWithOptions_checksum(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowProvider := func() time.Time {
+		return now
+	}
+
+	mockStore := NewMockStore(t, nowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+	transactionDAL := mockStore.Store.TransactionDAL
+
+	aDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	bDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte("b text"))
+	// edited in place, keeping the size and modification time
+	editedBDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte("B TEXT"))
+
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{aDescriptor, bDescriptor})
+
+	fileInfos := []*intelligentstore.FileInfo{aDescriptor.Descriptor.FileInfo, editedBDescriptor.Descriptor.FileInfo}
+
+	// without the checksum option, the files look unchanged
+	now = time.Unix(2000, 0)
+	tx, err := transactionDAL.CreateTransaction(bucket, fileInfos)
+	require.Nil(t, err)
+	assert.Empty(t, tx.GetRelativePathsRequired())
+
+	err = transactionDAL.Abort(tx)
+	require.Nil(t, err)
+
+	// with it, every file is hashed again
+	tx, err = transactionDAL.CreateTransactionWithOptions(bucket, fileInfos, TransactionOptions{Checksum: true})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []intelligentstore.RelativePath{"a.txt", "b.txt"}, tx.GetRelativePathsRequired())
+
+	hashes, err := transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, []*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash("a.txt", aDescriptor.Descriptor.Hash),
+		intelligentstore.NewRelativePathWithHash("b.txt", editedBDescriptor.Descriptor.Hash),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []intelligentstore.Hash{editedBDescriptor.Descriptor.Hash}, hashes)
+	assert.Equal(t, []intelligentstore.RelativePath{"b.txt"}, tx.ChangedWithSameFileInfo)
+
+	err = transactionDAL.BackupFile(tx, bytes.NewReader(editedBDescriptor.Contents))
+	require.Nil(t, err)
+
+	err = transactionDAL.Commit(tx)
+	require.Nil(t, err)
+
+	files, err := mockStore.Store.RevisionDAL.GetFilesInRevision(bucket, tx.Revision)
+	require.Nil(t, err)
+
+	hashesByRelativePath := make(map[intelligentstore.RelativePath]intelligentstore.Hash)
+	for _, file := range files {
+		hashesByRelativePath[file.GetFileInfo().RelativePath] = file.(*intelligentstore.RegularFileDescriptor).Hash
+	}
+	assert.Equal(t, map[intelligentstore.RelativePath]intelligentstore.Hash{
+		"a.txt": aDescriptor.Descriptor.Hash,
+		"b.txt": editedBDescriptor.Descriptor.Hash,
+	}, hashesByRelativePath)
+}
+
+func Test_Commit_revisionInfo(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowProvider := func() time.Time {
+		return now
+	}
+
+	mockStore := NewMockStore(t, nowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+	transactionDAL := mockStore.Store.TransactionDAL
+
+	aDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	bDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte("b text"))
+	cDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", time.Unix(0, 0), FileMode600, []byte("c text"))
+	eDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "e.txt", time.Unix(0, 0), FileMode600, []byte("e text"))
+
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{aDescriptor, bDescriptor, cDescriptor, eDescriptor})
+
+	// b is edited in place, keeping the size and modification time, c is edited, d is new and e is deleted
+	editedBDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "b.txt", time.Unix(0, 0), FileMode600, []byte("B TEXT"))
+	editedCDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "c.txt", time.Unix(10, 0), FileMode600, []byte("c text, edited"))
+	dDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "d.txt", time.Unix(0, 0), FileMode600, []byte("d text"))
+
+	fileInfos := []*intelligentstore.FileInfo{
+		aDescriptor.Descriptor.FileInfo,
+		editedBDescriptor.Descriptor.FileInfo,
+		editedCDescriptor.Descriptor.FileInfo,
+		dDescriptor.Descriptor.FileInfo,
+	}
+
+	source := intelligentstore.RevisionSource{
+		Message:      "before the upgrade",
+		Tags:         []string{"daily", "laptop"},
+		Hostname:     "laptop",
+		SourcePath:   "/home/user/docs",
+		UploaderType: intelligentstore.UploaderTypeLocal,
+	}
+
+	now = time.Unix(2000, 0)
+	tx, err := transactionDAL.CreateTransactionWithOptions(bucket, fileInfos, TransactionOptions{Checksum: true, Source: source})
+	require.Nil(t, err)
+
+	_, err = transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, []*intelligentstore.RelativePathWithHash{
+		intelligentstore.NewRelativePathWithHash("a.txt", aDescriptor.Descriptor.Hash),
+		intelligentstore.NewRelativePathWithHash("b.txt", editedBDescriptor.Descriptor.Hash),
+		intelligentstore.NewRelativePathWithHash("c.txt", editedCDescriptor.Descriptor.Hash),
+		intelligentstore.NewRelativePathWithHash("d.txt", dDescriptor.Descriptor.Hash),
+	})
+	require.Nil(t, err)
+
+	for _, descriptor := range []*intelligentstore.RegularFileDescriptorWithContents{editedBDescriptor, editedCDescriptor, dDescriptor} {
+		err = transactionDAL.BackupFile(tx, bytes.NewReader(descriptor.Contents))
+		require.Nil(t, err)
+	}
+
+	now = time.Unix(2010, 0)
+	err = transactionDAL.Commit(tx)
+	require.Nil(t, err)
+
+	revisionInfo, err := mockStore.Store.RevisionDAL.GetRevisionInfo(tx.Revision)
+	require.Nil(t, err)
+
+	assert.Equal(t, &intelligentstore.RevisionInfo{
+		RevisionSource: source,
+		NewFiles:       1,
+		// b is only found to have changed once it is hashed again
+		ChangedFiles:   2,
+		UnchangedFiles: 1,
+		DeletedFiles:   1,
+		BytesUploaded:  int64(len(editedBDescriptor.Contents) + len(editedCDescriptor.Contents) + len(dDescriptor.Contents)),
+		StartTime:      time.Unix(2000, 0).UTC(),
+		Duration:       10 * time.Second,
+	}, revisionInfo)
+
+	// revisions from before revision infos were recorded don't have one
+	revisionInfo, err = mockStore.Store.RevisionDAL.GetRevisionInfo(intelligentstore.NewRevision(bucket, 500))
+	require.Nil(t, err)
+	assert.Nil(t, revisionInfo)
+}
+
+func Test_Commit_revisionInfoUnchangedDirectoryAndFIFO(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+	transactionDAL := mockStore.Store.TransactionDAL
+
+	// the modes of the file infos from the uploader have the type bits set
+	fileInfos := []*intelligentstore.FileInfo{
+		intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, "dir", time.Unix(0, 0), 4096, os.ModeDir|0755),
+		intelligentstore.NewFileInfo(intelligentstore.FileTypeFIFO, "dir/fifo", time.Unix(0, 0), 0, os.ModeNamedPipe|0644),
+	}
+
+	commit := func() *intelligentstore.RevisionInfo {
+		tx, err := transactionDAL.CreateTransaction(bucket, fileInfos)
+		require.Nil(t, err)
+
+		_, err = transactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, nil)
+		require.Nil(t, err)
+
+		err = transactionDAL.Commit(tx)
+		require.Nil(t, err)
+
+		revisionInfo, err := mockStore.Store.RevisionDAL.GetRevisionInfo(tx.Revision)
+		require.Nil(t, err)
+		return revisionInfo
+	}
+
+	revisionInfo := commit()
+	assert.Equal(t, int64(2), revisionInfo.NewFiles)
+
+	revisionInfo = commit()
+	assert.Equal(t, int64(0), revisionInfo.NewFiles)
+	assert.Equal(t, int64(0), revisionInfo.ChangedFiles)
+	assert.Equal(t, int64(2), revisionInfo.UnchangedFiles)
+}
+
+func Test_isDueForRehash(t *testing.T) {
+	period := time.Hour * 24 * 30
+	const backupInterval = 60 * 60 * 24
+
+	relativePaths := []intelligentstore.RelativePath{"a.txt", "b.txt", "dir/c.txt", "dir/d.txt", "e.bin"}
+
+	// with a backup every day, every file is due exactly once in each period
+	dueCounts := make(map[intelligentstore.RelativePath]int)
+	for day := int64(1); day <= 30; day++ {
+		previousRevisionVersion := intelligentstore.RevisionVersion(1600000000 + (day-1)*backupInterval)
+		revisionVersion := intelligentstore.RevisionVersion(1600000000 + day*backupInterval)
+
+		for _, relativePath := range relativePaths {
+			if isDueForRehash(relativePath, previousRevisionVersion, revisionVersion, period) {
+				dueCounts[relativePath]++
+			}
+		}
+	}
+
+	for _, relativePath := range relativePaths {
+		assert.Equal(t, 1, dueCounts[relativePath], relativePath)
+	}
+
+	// every file is due if the previous revision is older than the period
+	for _, relativePath := range relativePaths {
+		assert.True(t, isDueForRehash(relativePath, 1600000000, 1600000000+intelligentstore.RevisionVersion(period/time.Second), period))
+	}
+}
+
+func Test_CreateTransaction_keepsNewOwnership(t *testing.T) {
+	mockStore := NewMockStore(t, MockNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+	transactionDAL := mockStore.Store.TransactionDAL
+
+	aDescriptor := intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", time.Unix(0, 0), FileMode600, []byte("a text"))
+	mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{aDescriptor})
+
+	// the owner changed, but the contents didn't
+	chownedFileInfo := *aDescriptor.Descriptor.FileInfo
+	chownedFileInfo.Ownership = intelligentstore.NewFileOwnership(1000, 1000)
+
+	tx, err := transactionDAL.CreateTransaction(bucket, []*intelligentstore.FileInfo{&chownedFileInfo})
+	require.Nil(t, err)
+	assert.Empty(t, tx.GetRelativePathsRequired())
+
+	require.Len(t, tx.FilesInVersion, 1)
+	assert.Equal(t, &intelligentstore.RegularFileDescriptor{FileInfo: &chownedFileInfo, Hash: aDescriptor.Descriptor.Hash}, tx.FilesInVersion[0])
+}
+
+func Test_isSameModTime(t *testing.T) {
+	modTime := time.Unix(1000, 123456789)
+
+	assert.True(t, isSameModTime(modTime, modTime))
+	assert.False(t, isSameModTime(modTime, modTime.Add(time.Nanosecond)))
+
+	// recorded to the millisecond, by an older version
+	assert.True(t, isSameModTime(time.Unix(1000, 123000000), modTime))
+	assert.False(t, isSameModTime(time.Unix(1000, 124000000), modTime))
+}
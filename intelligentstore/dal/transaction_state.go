@@ -0,0 +1,218 @@
+package dal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+const transactionStatesKeyPrefix = "transactions/"
+
+// transactionStateSaveInterval is how often the state of a transaction is saved while its files are uploaded
+const transactionStateSaveInterval = 30 * time.Second
+
+// transactionState is the state of an open transaction, kept in the store so that an interrupted transaction can be resumed.
+// It is written when the transaction is created and when the hashes of its files are uploaded, and removed when the transaction is committed or aborted.
+type transactionState struct {
+	RevisionVersion intelligentstore.RevisionVersion `json:"revisionVersion"`
+	HashAlgorithm   intelligentstore.HashAlgorithm   `json:"hashAlgorithm"`
+	// Files are all the files in the revision. Regular files have their hash, once it is known.
+	Files []*transactionStateFile `json:"files"`
+	// UploadStatuses are the statuses of the contents the transaction needs.
+	// Contents that were uploaded can still be missing from the store (e.g. if they were in a pack that was never finished), so they are checked again when the transaction is resumed.
+	UploadStatuses map[intelligentstore.Hash]intelligentstore.UploadStatus `json:"uploadStatuses"`
+}
+
+type transactionStateFile struct {
+	*intelligentstore.FileInfo
+	Hash intelligentstore.Hash `json:"hash,omitempty"`
+}
+
+func transactionStateKey(bucket *intelligentstore.Bucket) string {
+	return fmt.Sprintf("%s%d.json", transactionStatesKeyPrefix, bucket.ID)
+}
+
+func newTransactionState(tx *intelligentstore.Transaction) *transactionState {
+	tx.Mu.RLock()
+	defer tx.Mu.RUnlock()
+
+	state := &transactionState{
+		RevisionVersion: tx.Revision.VersionTimestamp,
+		HashAlgorithm:   tx.HashAlgorithm,
+		UploadStatuses:  make(map[intelligentstore.Hash]intelligentstore.UploadStatus),
+	}
+
+	hashesByRelativePath := make(map[intelligentstore.RelativePath]intelligentstore.Hash)
+	for _, descriptor := range tx.FilesInVersion {
+		fileInfo := descriptor.GetFileInfo()
+		file := &transactionStateFile{FileInfo: fileInfo}
+
+		regularFileDescriptor, ok := descriptor.(*intelligentstore.RegularFileDescriptor)
+		if ok {
+			file.Hash = regularFileDescriptor.Hash
+			hashesByRelativePath[fileInfo.RelativePath] = regularFileDescriptor.Hash
+		}
+
+		state.Files = append(state.Files, file)
+	}
+
+	for relativePath, fileInfo := range tx.FileInfosMissingHashes {
+		_, ok := hashesByRelativePath[relativePath]
+		if ok {
+			// the hash was uploaded, so the file was added above
+			continue
+		}
+
+		// the hash found by an earlier, interrupted, transaction is kept, in case this one is interrupted before the hashes are uploaded
+		state.Files = append(state.Files, &transactionStateFile{
+			FileInfo: fileInfo,
+			Hash:     tx.KnownHashes[relativePath],
+		})
+	}
+
+	for _, fileInfo := range tx.FileInfosMissingSymlinks {
+		state.Files = append(state.Files, &transactionStateFile{FileInfo: fileInfo})
+	}
+
+	for hash, status := range tx.UploadStatusMap {
+		state.UploadStatuses[hash] = status
+	}
+
+	return state
+}
+
+// saveTransactionState writes the state of the transaction to the store. The state of a dry run transaction is not saved, so that it isn't resumed.
+func (dal *TransactionDAL) saveTransactionState(tx *intelligentstore.Transaction) errorsx.Error {
+	if tx.DryRun {
+		return nil
+	}
+
+	// once the bucket lock is lost, another process may be saving the state of it's own transaction on the bucket
+	lockErr := dal.IntelligentStoreDAL.LockDAL.checkBucketLockHeld(tx.Revision.Bucket)
+	if lockErr != nil {
+		return errorsx.Wrap(lockErr)
+	}
+
+	b, err := json.Marshal(newTransactionState(tx))
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	return dal.IntelligentStoreDAL.writeEncryptedFile(transactionStateKey(tx.Revision.Bucket), b)
+}
+
+// readTransactionState reads the state of the interrupted transaction on the bucket. If there isn't one, it returns nil.
+func (dal *TransactionDAL) readTransactionState(bucket *intelligentstore.Bucket) (*transactionState, errorsx.Error) {
+	key := transactionStateKey(bucket)
+
+	b, err := dal.IntelligentStoreDAL.readEncryptedFile(key)
+	if err != nil {
+		if os.IsNotExist(errorsx.Cause(err)) {
+			return nil, nil
+		}
+		return nil, errorsx.Wrap(err)
+	}
+
+	state := new(transactionState)
+	unmarshalErr := json.Unmarshal(b, state)
+	if unmarshalErr != nil {
+		return nil, errorsx.Wrap(unmarshalErr, "key", key)
+	}
+
+	return state, nil
+}
+
+func (dal *TransactionDAL) removeTransactionState(bucket *intelligentstore.Bucket) errorsx.Error {
+	return errorsx.Wrap(dal.IntelligentStoreDAL.backend.Delete(transactionStateKey(bucket)))
+}
+
+// isResumable returns whether a transaction with this state can be resumed, and if not, why not.
+// If resumeAnyVersion is false, it can only be resumed for the revision version given.
+func (state *transactionState) isResumable(
+	hashAlgorithm intelligentstore.HashAlgorithm,
+	revisionVersion intelligentstore.RevisionVersion,
+	resumeAnyVersion bool,
+	revisions []*intelligentstore.Revision,
+) (bool, string) {
+	if state.HashAlgorithm != hashAlgorithm {
+		return false, fmt.Sprintf("it hashed files with %q, but the store hashes contents with %q", state.HashAlgorithm, hashAlgorithm)
+	}
+
+	if !resumeAnyVersion && state.RevisionVersion != revisionVersion {
+		return false, fmt.Sprintf("it was for revision %d, not %d", state.RevisionVersion, revisionVersion)
+	}
+
+	for _, revision := range revisions {
+		if revision.VersionTimestamp == state.RevisionVersion {
+			return false, fmt.Sprintf("revision %d was already committed", state.RevisionVersion)
+		}
+
+		if resumeAnyVersion && revision.VersionTimestamp > state.RevisionVersion {
+			return false, fmt.Sprintf("revision %d is older than the latest revision (%d)", state.RevisionVersion, revision.VersionTimestamp)
+		}
+	}
+
+	return true, ""
+}
+
+// filesWithKnownHashes returns the regular files in the state that have a hash, by relative path
+func (state *transactionState) filesWithKnownHashes() map[intelligentstore.RelativePath]*transactionStateFile {
+	files := make(map[intelligentstore.RelativePath]*transactionStateFile)
+	for _, file := range state.Files {
+		if file.FileInfo == nil || file.Type != intelligentstore.FileTypeRegular || file.Hash == "" {
+			continue
+		}
+
+		files[file.RelativePath] = file
+	}
+
+	return files
+}
+
+// countUploadedContents returns how many of the contents the transaction needed were uploaded
+func (state *transactionState) countUploadedContents() int {
+	var count int
+	for _, status := range state.UploadStatuses {
+		if status == intelligentstore.UploadStatusCompleted {
+			count++
+		}
+	}
+	return count
+}
+
+func (dal *TransactionDAL) setTransactionStateSaveTime(tx *intelligentstore.Transaction) {
+	dal.stateSaveTimesMu.Lock()
+	defer dal.stateSaveTimesMu.Unlock()
+
+	dal.stateSaveTimes[tx] = dal.IntelligentStoreDAL.nowProvider()
+}
+
+func (dal *TransactionDAL) forgetTransactionStateSaveTime(tx *intelligentstore.Transaction) {
+	dal.stateSaveTimesMu.Lock()
+	defer dal.stateSaveTimesMu.Unlock()
+
+	delete(dal.stateSaveTimes, tx)
+}
+
+// saveTransactionStateIfDue saves the state of the transaction, if it hasn't been saved for transactionStateSaveInterval.
+// The whole state is written each time, so it isn't saved after every file.
+func (dal *TransactionDAL) saveTransactionStateIfDue(tx *intelligentstore.Transaction) errorsx.Error {
+	now := dal.IntelligentStoreDAL.nowProvider()
+
+	dal.stateSaveTimesMu.Lock()
+	isDue := now.Sub(dal.stateSaveTimes[tx]) >= transactionStateSaveInterval
+	if isDue {
+		dal.stateSaveTimes[tx] = now
+	}
+	dal.stateSaveTimesMu.Unlock()
+
+	if !isDue {
+		return nil
+	}
+
+	return dal.saveTransactionState(tx)
+}
@@ -1,9 +1,8 @@
 package dal
 
 import (
+	"bytes"
 	"encoding/json"
-	"os"
-	"path/filepath"
 
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
 	"github.com/pkg/errors"
@@ -13,21 +12,10 @@ type UserDAL struct {
 	store *IntelligentStoreDAL
 }
 
-func (s *UserDAL) getUsersInformationPath() string {
-	return filepath.Join(s.store.StoreBasePath, ".backup_data", "store_metadata", "users-data.json")
-}
-
 var ErrUserNotFound = errors.New("couldn't find user")
 
 func (s *UserDAL) GetUserByUsername(username string) (*intelligentstore.User, error) {
-	file, err := s.store.fs.Open(s.getUsersInformationPath())
-	if nil != err {
-		return nil, err
-	}
-	defer file.Close()
-
-	var users []*intelligentstore.User
-	err = json.NewDecoder(file).Decode(&users)
+	users, err := s.GetAllUsers()
 	if nil != err {
 		return nil, err
 	}
@@ -42,16 +30,16 @@ func (s *UserDAL) GetUserByUsername(username string) (*intelligentstore.User, er
 }
 
 func (s *UserDAL) GetAllUsers() ([]*intelligentstore.User, error) {
-	file, err := s.store.fs.Open(s.getUsersInformationPath())
+	file, err := s.store.backend.Get(usersInformationKey)
 	if nil != err {
 		return nil, err
 	}
 	defer file.Close()
 
 	var users []*intelligentstore.User
-	err = json.NewDecoder(file).Decode(&users)
-	if nil != err {
-		return nil, err
+	decodeErr := json.NewDecoder(file).Decode(&users)
+	if nil != decodeErr {
+		return nil, decodeErr
 	}
 
 	return users, nil
@@ -76,18 +64,17 @@ func (s *UserDAL) CreateUser(user *intelligentstore.User) (*intelligentstore.Use
 
 	newUser := intelligentstore.NewUser(highestID+1, user.DisplayName, user.HashedPassword)
 
-	file, err := s.store.fs.OpenFile(s.getUsersInformationPath(), os.O_WRONLY, 0600)
-	if nil != err {
-		return nil, err
-	}
-	defer file.Close()
-
 	users = append(users, newUser)
 
-	err = json.NewEncoder(file).Encode(users)
+	b, err := json.Marshal(users)
 	if nil != err {
 		return nil, err
 	}
 
+	putErr := s.store.backend.Put(usersInformationKey, bytes.NewReader(b))
+	if nil != putErr {
+		return nil, putErr
+	}
+
 	return newUser, nil
 }
@@ -2,10 +2,11 @@ package intelligentstore
 
 // Bucket represents an organisational area of the Store.
 type Bucket struct {
-	ID         int    `json:"id"`
-	BucketName string `json:"name"`
+	ID              int              `json:"id"`
+	BucketName      string           `json:"name"`
+	RetentionPolicy *RetentionPolicy `json:"retentionPolicy,omitempty"`
 }
 
 func NewBucket(id int, bucketName string) *Bucket {
-	return &Bucket{id, bucketName}
+	return &Bucket{ID: id, BucketName: bucketName}
 }
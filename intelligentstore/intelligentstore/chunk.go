@@ -0,0 +1,74 @@
+package intelligentstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+)
+
+// Chunk is a piece of a large file, stored as it's own object
+type Chunk struct {
+	Hash Hash  `json:"hash"`
+	Size int64 `json:"size"`
+}
+
+// ChunkingSettings configures whether (and for which files) the store splits file contents into content-defined chunks
+type ChunkingSettings struct {
+	// MinFileSize is the size from which files are stored as chunks. Smaller files are stored as a single object.
+	MinFileSize int64 `json:"minFileSize"`
+}
+
+// Validate checks the settings can be used
+func (s *ChunkingSettings) Validate() errorsx.Error {
+	if s.MinFileSize <= MinChunkSize {
+		return errorsx.Errorf("the minimum file size for chunking must be bigger than the minimum chunk size (%d bytes), but was %d bytes", MinChunkSize, s.MinFileSize)
+	}
+
+	return nil
+}
+
+// ShouldChunk returns true if a file of this size should be stored as chunks
+func (s *ChunkingSettings) ShouldChunk(size int64) bool {
+	return s != nil && size >= s.MinFileSize
+}
+
+const (
+	chunkListItemSeparator = " "
+	chunkHashSizeSeparator = ":"
+)
+
+// ChunkListToString encodes a list of chunks as text, in the form "<hash>:<size> <hash>:<size> ..."
+func ChunkListToString(chunks []*Chunk) string {
+	var items []string
+	for _, chunk := range chunks {
+		items = append(items, fmt.Sprintf("%s%s%d", chunk.Hash, chunkHashSizeSeparator, chunk.Size))
+	}
+
+	return strings.Join(items, chunkListItemSeparator)
+}
+
+// ParseChunkList decodes a list of chunks encoded with ChunkListToString. An empty string gives a nil list.
+func ParseChunkList(text string) ([]*Chunk, errorsx.Error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	var chunks []*Chunk
+	for _, item := range strings.Split(text, chunkListItemSeparator) {
+		fragments := strings.Split(item, chunkHashSizeSeparator)
+		if len(fragments) != 2 {
+			return nil, errorsx.Errorf("couldn't parse chunk %q: expected <hash>%s<size>", item, chunkHashSizeSeparator)
+		}
+
+		size, err := strconv.ParseInt(fragments[1], 10, 64)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "chunk", item)
+		}
+
+		chunks = append(chunks, &Chunk{Hash(fragments[0]), size})
+	}
+
+	return chunks, nil
+}
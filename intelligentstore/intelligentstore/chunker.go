@@ -0,0 +1,89 @@
+package intelligentstore
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// MinChunkSize is the smallest chunk the Chunker will produce (apart from the last chunk of a file)
+	MinChunkSize = 512 * 1024
+	// MaxChunkSize is the largest chunk the Chunker will produce
+	MaxChunkSize = 8 * 1024 * 1024
+	// chunkBoundaryMask gives an average chunk size of around 1MiB on top of MinChunkSize
+	chunkBoundaryMask = (1 << 20) - 1
+)
+
+// gearTable maps each byte value to a pseudo-random number, for the rolling "gear" hash.
+// It is generated from a fixed seed, as the chunk boundaries (and therefore the chunk hashes) must be the same every time the same content is chunked.
+var gearTable = newGearTable()
+
+func newGearTable() [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
+
+// Chunker splits a stream into content-defined chunks.
+// Chunk boundaries are chosen with a rolling hash over the content, so inserting or appending data only changes the chunks around the change,
+// and the rest of the chunks (and their hashes) stay the same.
+type Chunker struct {
+	reader *bufio.Reader
+}
+
+// NewChunker creates a Chunker reading from reader
+func NewChunker(reader io.Reader) *Chunker {
+	return &Chunker{bufio.NewReaderSize(reader, 64*1024)}
+}
+
+// Next returns the next chunk. It returns io.EOF when there are no more chunks.
+func (c *Chunker) Next() ([]byte, error) {
+	chunk := make([]byte, MinChunkSize, MinChunkSize*2)
+
+	bytesRead, err := io.ReadFull(c.reader, chunk)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			// last (small) chunk
+			return chunk[:bytesRead], nil
+		}
+		return nil, err
+	}
+
+	// the gear hash only depends on the last 64 bytes, so start it off from there
+	var fingerprint uint64
+	for _, b := range chunk[MinChunkSize-64:] {
+		fingerprint = (fingerprint << 1) + gearTable[b]
+	}
+
+	for len(chunk) < MaxChunkSize {
+		if fingerprint&chunkBoundaryMask == 0 {
+			return chunk, nil
+		}
+
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return chunk, nil
+			}
+			return nil, err
+		}
+
+		chunk = append(chunk, b)
+		fingerprint = (fingerprint << 1) + gearTable[b]
+	}
+
+	return chunk, nil
+}
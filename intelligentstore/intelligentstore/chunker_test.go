@@ -0,0 +1,82 @@
+package intelligentstore
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkAll(t *testing.T, contents []byte) [][]byte {
+	chunker := NewChunker(bytes.NewReader(contents))
+
+	var chunks [][]byte
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+
+		chunks = append(chunks, chunk)
+	}
+}
+
+func Test_Chunker(t *testing.T) {
+	contents := make([]byte, 12*1024*1024)
+	_, err := rand.New(rand.NewSource(1)).Read(contents)
+	require.NoError(t, err)
+
+	chunks := chunkAll(t, contents)
+	require.True(t, len(chunks) > 2)
+
+	assert.Equal(t, contents, bytes.Join(chunks, nil))
+	for _, chunk := range chunks[:len(chunks)-1] {
+		assert.True(t, len(chunk) >= MinChunkSize)
+		assert.True(t, len(chunk) <= MaxChunkSize)
+	}
+
+	t.Run("same content gives the same chunks", func(t *testing.T) {
+		assert.Equal(t, chunks, chunkAll(t, contents))
+	})
+
+	t.Run("appending only changes the last chunk", func(t *testing.T) {
+		appendedContents := append(append([]byte{}, contents...), []byte("one more line\n")...)
+		appendedChunks := chunkAll(t, appendedContents)
+
+		require.Len(t, appendedChunks, len(chunks))
+		assert.Equal(t, chunks[:len(chunks)-1], appendedChunks[:len(chunks)-1])
+	})
+
+	t.Run("inserting at the start only changes the first chunks", func(t *testing.T) {
+		insertedContents := append([]byte("a new first line\n"), contents...)
+		insertedChunks := chunkAll(t, insertedContents)
+
+		assert.Equal(t, chunks[len(chunks)-2:], insertedChunks[len(insertedChunks)-2:])
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Len(t, chunkAll(t, nil), 0)
+	})
+}
+
+func Test_ParseChunkList(t *testing.T) {
+	chunks := []*Chunk{{Hash: "abcd", Size: 10}, {Hash: "ef01", Size: 20}}
+
+	text := ChunkListToString(chunks)
+	assert.Equal(t, "abcd:10 ef01:20", text)
+
+	parsed, err := ParseChunkList(text)
+	require.Nil(t, err)
+	assert.Equal(t, chunks, parsed)
+
+	parsed, err = ParseChunkList("")
+	require.Nil(t, err)
+	assert.Nil(t, parsed)
+
+	_, err = ParseChunkList("abcd")
+	assert.NotNil(t, err)
+}
@@ -10,15 +10,22 @@ type ChildInfo struct {
 	SubChildrenCount int64
 }
 
+// DirectoryFileDescriptor represents a directory.
+// Revisions record directories with their modification time and mode. Revisions from before directories were recorded only have files, so their directories are made up from the paths of the files in them.
 type DirectoryFileDescriptor struct {
-	RelativePath RelativePath
-	// ChildFilesMap ChildFilesMap
+	*FileInfo
 }
 
+// NewDirectoryFileDescriptor creates a descriptor for a directory that isn't recorded in the revision, but has files in it
 func NewDirectoryFileDescriptor(relativePath RelativePath) *DirectoryFileDescriptor {
-	return &DirectoryFileDescriptor{relativePath}
+	return &DirectoryFileDescriptor{NewFileInfo(FileTypeDir, relativePath, time.Unix(0, 0), 4*1024, 0700)}
+}
+
+// NewDirectoryFileDescriptorFromFileInfo creates a descriptor for a directory recorded in the revision
+func NewDirectoryFileDescriptorFromFileInfo(fileInfo *FileInfo) *DirectoryFileDescriptor {
+	return &DirectoryFileDescriptor{fileInfo}
 }
 
 func (fd *DirectoryFileDescriptor) GetFileInfo() *FileInfo {
-	return NewFileInfo(FileTypeDir, fd.RelativePath, time.Unix(0, 0), 4*1024, 0700)
+	return fd.FileInfo
 }
@@ -1,8 +1,13 @@
 package intelligentstore
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
 )
 
 // FileInfo represents some basic information about a file
@@ -12,9 +17,100 @@ type FileInfo struct {
 	ModTime      time.Time    `json:"modTime" csv:"modTime"`
 	Size         int64        `json:"size" csv:"size"`
 	FileMode     os.FileMode  `json:"fileMode" csv:"fileMode"`
+	// Ownership is the owner and group of the file. It is nil if they were not recorded
+	Ownership *FileOwnership `json:"ownership,omitempty" csv:"ownership"`
+	// ExtendedAttributes are the extended attributes of the file. They are only recorded if the ownership is recorded
+	ExtendedAttributes ExtendedAttributes `json:"extendedAttributes,omitempty" csv:"extendedAttributes"`
+	// HardLinkGroup is shared by the regular files in a revision that are hard links to the same file. It is 0 if the file is not hard linked to another file in the revision
+	HardLinkGroup uint64 `json:"hardLinkGroup,omitempty" csv:"hardLinkGroup"`
+	// DeviceNumber is the device a character or block device file is for. It is nil for other files
+	DeviceNumber *DeviceNumber `json:"deviceNumber,omitempty" csv:"deviceNumber"`
+	// Holes are the ranges of a sparse regular file that have no data on disk, and read as zeros. It is nil for files that aren't sparse
+	Holes []*FileRange `json:"holes,omitempty" csv:"holes"`
 }
 
 // NewFileInfo creates a new FileInfo
 func NewFileInfo(fileType FileType, relativePath RelativePath, modTime time.Time, size int64, fileMode os.FileMode) *FileInfo {
-	return &FileInfo{fileType, relativePath, modTime, size, fileMode}
+	return &FileInfo{fileType, relativePath, modTime, size, fileMode, nil, nil, 0, nil, nil}
+}
+
+// FileOwnership is the numeric owner and group of a file
+type FileOwnership struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// NewFileOwnership creates a new FileOwnership
+func NewFileOwnership(uid, gid uint32) *FileOwnership {
+	return &FileOwnership{uid, gid}
+}
+
+// ExtendedAttributes are the extended attributes of a file, by name.
+// On Linux, POSIX ACLs are kept in the "system.posix_acl_access" and "system.posix_acl_default" extended attributes, so they are recorded with them.
+type ExtendedAttributes map[string][]byte
+
+// DeviceNumber is the major and minor numbers of a device
+type DeviceNumber struct {
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+}
+
+// NewDeviceNumber creates a new DeviceNumber
+func NewDeviceNumber(major, minor uint32) *DeviceNumber {
+	return &DeviceNumber{major, minor}
+}
+
+// FileRange is a range of bytes in a file
+type FileRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// NewFileRange creates a new FileRange
+func NewFileRange(offset, length int64) *FileRange {
+	return &FileRange{offset, length}
+}
+
+const (
+	fileRangeListItemSeparator     = " "
+	fileRangeOffsetLengthSeparator = ":"
+)
+
+// FileRangeListToString encodes a list of file ranges as text, in the form "<offset>:<length> <offset>:<length> ..."
+func FileRangeListToString(fileRanges []*FileRange) string {
+	var items []string
+	for _, fileRange := range fileRanges {
+		items = append(items, fmt.Sprintf("%d%s%d", fileRange.Offset, fileRangeOffsetLengthSeparator, fileRange.Length))
+	}
+
+	return strings.Join(items, fileRangeListItemSeparator)
+}
+
+// ParseFileRangeList decodes a list of file ranges encoded with FileRangeListToString. An empty string gives a nil list.
+func ParseFileRangeList(text string) ([]*FileRange, errorsx.Error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	var fileRanges []*FileRange
+	for _, item := range strings.Split(text, fileRangeListItemSeparator) {
+		offsetField, lengthField, ok := strings.Cut(item, fileRangeOffsetLengthSeparator)
+		if !ok {
+			return nil, errorsx.Errorf("couldn't parse file range %q: expected <offset>%s<length>", item, fileRangeOffsetLengthSeparator)
+		}
+
+		offset, err := strconv.ParseInt(offsetField, 10, 64)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "file range", item)
+		}
+
+		length, err := strconv.ParseInt(lengthField, 10, 64)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "file range", item)
+		}
+
+		fileRanges = append(fileRanges, NewFileRange(offset, length))
+	}
+
+	return fileRanges, nil
 }
@@ -9,6 +9,11 @@ const (
 	FileTypeRegular FileType = 1
 	FileTypeSymlink FileType = 2
 	FileTypeDir     FileType = 3
+	// special files. Their file info is all that is recorded about them
+	FileTypeFIFO        FileType = 4
+	FileTypeCharDevice  FileType = 5
+	FileTypeBlockDevice FileType = 6
+	FileTypeSocket      FileType = 7
 )
 
 func FileTypeFromInt(i int) (FileType, errorsx.Error) {
@@ -16,9 +21,17 @@ func FileTypeFromInt(i int) (FileType, errorsx.Error) {
 	case 1:
 		return FileTypeRegular, nil
 	case 2:
-		return FileTypeDir, nil
-	case 3:
 		return FileTypeSymlink, nil
+	case 3:
+		return FileTypeDir, nil
+	case 4:
+		return FileTypeFIFO, nil
+	case 5:
+		return FileTypeCharDevice, nil
+	case 6:
+		return FileTypeBlockDevice, nil
+	case 7:
+		return FileTypeSocket, nil
 	default:
 		return FileTypeUnknown, errorsx.Errorf("unknown file type ID: %d", i)
 	}
@@ -29,6 +42,10 @@ var fileTypes = []string{
 	"REGULAR",
 	"SYMLINK",
 	"DIRECTORY",
+	"FIFO",
+	"CHAR_DEVICE",
+	"BLOCK_DEVICE",
+	"SOCKET",
 }
 
 func (t FileType) String() string {
@@ -1,34 +1,161 @@
 package intelligentstore
 
 import (
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
 	"hash"
 	"io"
+	"strings"
+
+	"github.com/jamesrr39/goutil/errorsx"
+)
+
+// HashAlgorithm is the algorithm contents are hashed with
+type HashAlgorithm string
+
+const (
+	HashAlgorithmSHA512 HashAlgorithm = "sha512"
+	HashAlgorithmSHA256 HashAlgorithm = "sha256"
 )
 
+// DefaultHashAlgorithm is the algorithm stores hash new contents with, unless the store is configured with another algorithm.
+// Every hash from before hash algorithms were configurable is a SHA-512 hash.
+const DefaultHashAlgorithm = HashAlgorithmSHA512
+
+// hashAlgorithmSeparator separates the algorithm from the digest in a hash, e.g. "sha256:abcd..."
+const hashAlgorithmSeparator = ":"
+
+// objectPathAlgorithmSeparator separates the digest from the algorithm in the object path of a hash, e.g. "ab/cd...sha256"
+const objectPathAlgorithmSeparator = "."
+
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	HashAlgorithmSHA512: sha512.New,
+	HashAlgorithmSHA256: sha256.New,
+}
+
+// HashAlgorithms are all the supported hash algorithms
+func HashAlgorithms() []HashAlgorithm {
+	return []HashAlgorithm{HashAlgorithmSHA512, HashAlgorithmSHA256}
+}
+
+// Validate checks the hash algorithm is supported
+func (a HashAlgorithm) Validate() errorsx.Error {
+	_, ok := hashAlgorithms[a]
+	if !ok {
+		return errorsx.Errorf("unsupported hash algorithm: %q", a)
+	}
+
+	return nil
+}
+
+// Hash is the hash of some contents. It is self-describing: a hash is "<algorithm>:<hex digest>".
+// SHA-512 hashes are only the hex digest, without the algorithm, so that they are the same as the hashes from before hash algorithms were configurable.
 type Hash string
 
+// NewHash hashes the contents of the reader with the default hash algorithm
 func NewHash(r io.Reader) (Hash, error) {
-	hasher := newHasher()
-	_, err := io.Copy(hasher, r)
+	return NewHashWithAlgorithm(r, DefaultHashAlgorithm)
+}
+
+// NewHashWithAlgorithm hashes the contents of the reader with the hash algorithm
+func NewHashWithAlgorithm(r io.Reader, algorithm HashAlgorithm) (Hash, error) {
+	hasher, err := newHasher(algorithm)
 	if nil != err {
 		return "", err
 	}
 
-	return Hash(hex.EncodeToString(hasher.Sum(nil))), nil
+	_, copyErr := io.Copy(hasher, r)
+	if nil != copyErr {
+		return "", copyErr
+	}
+
+	return newHashFromDigest(algorithm, hasher.Sum(nil)), nil
 }
 
-func newHasher() hash.Hash {
-	return sha512.New()
+func newHasher(algorithm HashAlgorithm) (hash.Hash, errorsx.Error) {
+	newFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, errorsx.Errorf("unsupported hash algorithm: %q", algorithm)
+	}
+
+	return newFunc(), nil
+}
+
+func newHashFromDigest(algorithm HashAlgorithm, digest []byte) Hash {
+	hexDigest := hex.EncodeToString(digest)
+	if algorithm == HashAlgorithmSHA512 {
+		return Hash(hexDigest)
+	}
+
+	return Hash(string(algorithm) + hashAlgorithmSeparator + hexDigest)
 }
 
-// FirstChunk is the first 2 tokens of the hash
+// NewHashFromObjectPath creates the hash from it's first chunk and remainder (see FirstChunk and Remainder)
+func NewHashFromObjectPath(firstChunk, remainder string) Hash {
+	lastSeparatorIndex := strings.LastIndex(remainder, objectPathAlgorithmSeparator)
+	if lastSeparatorIndex == -1 {
+		return Hash(firstChunk + remainder)
+	}
+
+	algorithm := remainder[lastSeparatorIndex+len(objectPathAlgorithmSeparator):]
+	return Hash(algorithm + hashAlgorithmSeparator + firstChunk + remainder[:lastSeparatorIndex])
+}
+
+// Algorithm is the algorithm the hash was made with
+func (h Hash) Algorithm() HashAlgorithm {
+	fragments := strings.SplitN(string(h), hashAlgorithmSeparator, 2)
+	if len(fragments) == 1 {
+		return HashAlgorithmSHA512
+	}
+
+	return HashAlgorithm(fragments[0])
+}
+
+// Digest is the hex digest of the hash, without the algorithm
+func (h Hash) Digest() string {
+	fragments := strings.SplitN(string(h), hashAlgorithmSeparator, 2)
+	return fragments[len(fragments)-1]
+}
+
+// Validate checks the hash algorithm is supported, and the digest is a hex digest of the right length for the algorithm
+func (h Hash) Validate() errorsx.Error {
+	hasher, err := newHasher(h.Algorithm())
+	if nil != err {
+		return errorsx.Wrap(err, "hash", h)
+	}
+
+	digest, decodeErr := hex.DecodeString(h.Digest())
+	if nil != decodeErr {
+		return errorsx.Errorf("hash %q is not a hex digest", h)
+	}
+
+	if len(digest) != hasher.Size() {
+		return errorsx.Errorf("hash %q has a digest of %d bytes, but %s digests are %d bytes", h, len(digest), h.Algorithm(), hasher.Size())
+	}
+
+	expectedHash := newHashFromDigest(h.Algorithm(), digest)
+	if h != expectedHash {
+		return errorsx.Errorf("hash %q should be written as %q", h, expectedHash)
+	}
+
+	return nil
+}
+
+// FirstChunk is the first 2 tokens of the digest
 func (h Hash) FirstChunk() string {
-	return string(h)[0:2]
+	return h.Digest()[0:2]
 }
 
-// Remainder is all the tokens of the hash, except the first 2 tokens
+// Remainder is all the tokens of the digest, except the first 2 tokens.
+// For hashes that have an algorithm, the algorithm is appended (e.g. "cdef.sha256"), so that the hash can be recreated with NewHashFromObjectPath.
 func (h Hash) Remainder() string {
-	return string(h)[2:]
+	remainder := h.Digest()[2:]
+
+	algorithm := h.Algorithm()
+	if algorithm == HashAlgorithmSHA512 {
+		return remainder
+	}
+
+	return remainder + objectPathAlgorithmSeparator + string(algorithm)
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // from echo "abcde123" | sha512sum
@@ -42,3 +43,31 @@ type badWriter struct{}
 func (w *badWriter) Read(b []byte) (int, error) {
 	return 0, errors.New("bad reader")
 }
+
+// from echo -n "abcde123" | sha256sum
+func Test_NewHashWithAlgorithm(t *testing.T) {
+	hash, err := NewHashWithAlgorithm(bytes.NewBuffer([]byte("abcde123")), HashAlgorithmSHA256)
+	assert.Nil(t, err)
+	assert.Equal(t, Hash("sha256:3332e5eea07ab9d93cd59e3748b9746f66c8abc3a7a126a5c1965ff8525e00ba"), hash)
+	assert.Equal(t, HashAlgorithmSHA256, hash.Algorithm())
+	assert.Equal(t, "33", hash.FirstChunk())
+	assert.Equal(t, "32e5eea07ab9d93cd59e3748b9746f66c8abc3a7a126a5c1965ff8525e00ba.sha256", hash.Remainder())
+	assert.Equal(t, hash, NewHashFromObjectPath(hash.FirstChunk(), hash.Remainder()))
+	assert.Nil(t, hash.Validate())
+
+	_, err = NewHashWithAlgorithm(bytes.NewBuffer([]byte("abcde123")), "md5")
+	assert.NotNil(t, err)
+}
+
+func Test_Hash_Validate(t *testing.T) {
+	sha512Hash, err := NewHash(bytes.NewBuffer([]byte("abcde123")))
+	require.Nil(t, err)
+	assert.Equal(t, HashAlgorithmSHA512, sha512Hash.Algorithm())
+	assert.Equal(t, sha512Hash, NewHashFromObjectPath(sha512Hash.FirstChunk(), sha512Hash.Remainder()))
+	assert.Nil(t, sha512Hash.Validate())
+
+	assert.NotNil(t, Hash("abcdef").Validate())
+	assert.NotNil(t, Hash("md5:abcdef").Validate())
+	assert.NotNil(t, Hash("sha512:"+sha512Hash).Validate())
+	assert.NotNil(t, Hash("../../etc/passwd").Validate())
+}
@@ -0,0 +1,18 @@
+package intelligentstore
+
+// ObjectEncoding is how the contents of an object are encoded (compressed) on disk
+type ObjectEncoding string
+
+const (
+	ObjectEncodingGzip ObjectEncoding = "gzip"
+	ObjectEncodingZstd ObjectEncoding = "zstd"
+	ObjectEncodingNone ObjectEncoding = "none"
+)
+
+// CompressionSettings is the store-level default for how new objects are encoded.
+// Objects are still stored uncompressed if compressing them doesn't make them smaller.
+type CompressionSettings struct {
+	Encoding ObjectEncoding `json:"encoding"`
+	// Level is the compression level for the encoding. 0 means the default level for the encoding.
+	Level int `json:"level,omitempty"`
+}
@@ -0,0 +1,49 @@
+package intelligentstore
+
+// RevisionReference identifies a revision of a bucket
+type RevisionReference struct {
+	BucketID        int             `json:"bucketId"`
+	RevisionVersion RevisionVersion `json:"revisionVersion"`
+}
+
+// ObjectReferences is how the contents with a hash are referenced by the revisions in the store
+type ObjectReferences struct {
+	// Count is how many files, across all revisions of all buckets, have these contents. Each chunk of a file stored as chunks counts as a reference to the chunk.
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+	// Chunked is true if the contents are stored as chunks. Their size is then also counted in the references to the chunks.
+	Chunked bool `json:"chunked,omitempty"`
+	// BucketCounts is the count of references, by bucket ID
+	BucketCounts map[int]int64 `json:"bucketCounts"`
+	// FirstSeen is the earliest revision the contents were seen in. It is not updated when revisions are deleted, so the revision may no longer exist.
+	FirstSeen *RevisionReference `json:"firstSeen"`
+	// LastSeen is the latest revision the contents were seen in. It is not updated when revisions are deleted, so the revision may no longer exist.
+	LastSeen *RevisionReference `json:"lastSeen"`
+}
+
+// AddReference counts a reference from a revision
+func (r *ObjectReferences) AddReference(revision *RevisionReference) {
+	r.Count++
+	r.BucketCounts[revision.BucketID]++
+
+	if r.FirstSeen == nil || revision.RevisionVersion < r.FirstSeen.RevisionVersion {
+		r.FirstSeen = revision
+	}
+
+	if r.LastSeen == nil || revision.RevisionVersion > r.LastSeen.RevisionVersion {
+		r.LastSeen = revision
+	}
+}
+
+// RemoveReference removes a reference from a revision of the bucket
+func (r *ObjectReferences) RemoveReference(bucketID int) {
+	if r.BucketCounts[bucketID] == 0 {
+		return
+	}
+
+	r.Count--
+	r.BucketCounts[bucketID]--
+	if r.BucketCounts[bucketID] == 0 {
+		delete(r.BucketCounts, bucketID)
+	}
+}
@@ -0,0 +1,28 @@
+package intelligentstore
+
+import (
+	"github.com/jamesrr39/goutil/errorsx"
+)
+
+// MaxPackedObjectSize is the largest contents that can be packed. Packs are read a whole object at a time, so packed objects are kept small.
+const MaxPackedObjectSize = 4 * 1024 * 1024
+
+// PackingSettings configures whether (and for which files) the store appends small objects into pack files, instead of storing each as it's own file
+type PackingSettings struct {
+	// MaxObjectSize is the size up to which contents are packed. Bigger contents are stored as their own object.
+	MaxObjectSize int64 `json:"maxObjectSize"`
+}
+
+// Validate checks the settings can be used
+func (s *PackingSettings) Validate() errorsx.Error {
+	if s.MaxObjectSize <= 0 || s.MaxObjectSize > MaxPackedObjectSize {
+		return errorsx.Errorf("the maximum size of packed objects must be between 1 and %d bytes, but was %d bytes", MaxPackedObjectSize, s.MaxObjectSize)
+	}
+
+	return nil
+}
+
+// ShouldPack returns true if contents of this size should be packed
+func (s *PackingSettings) ShouldPack(size int64) bool {
+	return s != nil && size <= s.MaxObjectSize
+}
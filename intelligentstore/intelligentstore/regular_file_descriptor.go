@@ -3,7 +3,6 @@ package intelligentstore
 import (
 	"bufio"
 	"encoding/gob"
-	"encoding/hex"
 	"io"
 	"os"
 	"time"
@@ -15,6 +14,9 @@ import (
 type RegularFileDescriptor struct {
 	*FileInfo
 	Hash Hash `json:"hash" csv:"hash"`
+	// Chunks is the list of chunks the file contents are stored as, if the contents are stored in chunks.
+	// It is encoded separately in CSV revision manifests.
+	Chunks []*Chunk `json:"chunks,omitempty"`
 }
 
 func init() {
@@ -23,11 +25,21 @@ func init() {
 
 // NewRegularFileDescriptor creates an instance of File.
 func NewRegularFileDescriptor(fileInfo *FileInfo, hash Hash) *RegularFileDescriptor {
-	return &RegularFileDescriptor{fileInfo, hash}
+	return &RegularFileDescriptor{FileInfo: fileInfo, Hash: hash}
 }
 
+// NewRegularFileDescriptorFromReader creates a descriptor for the contents of the reader, hashed with the default hash algorithm
 func NewRegularFileDescriptorFromReader(relativePath RelativePath, modTime time.Time, fileMode os.FileMode, file io.Reader) (*RegularFileDescriptor, errorsx.Error) {
-	hasher := newHasher()
+	return NewRegularFileDescriptorFromReaderWithHashAlgorithm(relativePath, modTime, fileMode, file, DefaultHashAlgorithm)
+}
+
+// NewRegularFileDescriptorFromReaderWithHashAlgorithm creates a descriptor for the contents of the reader, hashed with the hash algorithm
+func NewRegularFileDescriptorFromReaderWithHashAlgorithm(relativePath RelativePath, modTime time.Time, fileMode os.FileMode, file io.Reader, hashAlgorithm HashAlgorithm) (*RegularFileDescriptor, errorsx.Error) {
+	hasher, err := newHasher(hashAlgorithm)
+	if nil != err {
+		return nil, err
+	}
+
 	size := int64(0)
 	readerSize := 4096
 
@@ -35,30 +47,28 @@ func NewRegularFileDescriptorFromReader(relativePath RelativePath, modTime time.
 	for {
 		b := make([]byte, readerSize)
 
-		bytesReadCount, err := reader.Read(b)
-		if nil != err {
-			if io.EOF == err {
-				break
-			}
-			return nil, errorsx.Wrap(err)
+		bytesReadCount, readErr := reader.Read(b)
+		if nil != readErr && io.EOF != readErr {
+			return nil, errorsx.Wrap(readErr)
 		}
 
-		if bytesReadCount < readerSize {
-			b = b[:bytesReadCount]
-		}
+		// a reader may return the last bytes along with io.EOF, so the bytes are always hashed before checking for the end of the file
+		b = b[:bytesReadCount]
 
 		size += int64(len(b))
-		_, err = hasher.Write(b)
-		if nil != err {
-			return nil, errorsx.Wrap(err)
+		_, writeErr := hasher.Write(b)
+		if nil != writeErr {
+			return nil, errorsx.Wrap(writeErr)
 		}
-	}
 
-	hash := hasher.Sum(nil)
+		if io.EOF == readErr {
+			break
+		}
+	}
 
 	return NewRegularFileDescriptor(
 		NewFileInfo(FileTypeRegular, relativePath, modTime, size, fileMode),
-		Hash(hex.EncodeToString(hash)),
+		newHashFromDigest(hashAlgorithm, hasher.Sum(nil)),
 	), nil
 }
 
@@ -0,0 +1,101 @@
+package intelligentstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy describes which revisions of a bucket should be kept when the bucket is pruned.
+// A revision is kept if any of the rules match it. The latest revision is always kept.
+type RetentionPolicy struct {
+	// KeepLast keeps the most recent N revisions
+	KeepLast int `json:"keepLast"`
+	// KeepDailyForDays keeps the latest revision of each day, for revisions within the last N days
+	KeepDailyForDays int `json:"keepDailyForDays"`
+	// KeepWeeklyForWeeks keeps the latest revision of each (ISO) week, for revisions within the last N weeks
+	KeepWeeklyForWeeks int `json:"keepWeeklyForWeeks"`
+	// KeepMonthlyForMonths keeps the latest revision of each month, for revisions within the last N months
+	KeepMonthlyForMonths int `json:"keepMonthlyForMonths"`
+}
+
+// IsEmpty returns true if the policy has no rules, i.e. it would only keep the latest revision
+func (p *RetentionPolicy) IsEmpty() bool {
+	return p.KeepLast == 0 && p.KeepDailyForDays == 0 && p.KeepWeeklyForWeeks == 0 && p.KeepMonthlyForMonths == 0
+}
+
+func (p *RetentionPolicy) String() string {
+	return fmt.Sprintf(
+		"keep last: %d, keep daily for %d days, keep weekly for %d weeks, keep monthly for %d months",
+		p.KeepLast,
+		p.KeepDailyForDays,
+		p.KeepWeeklyForWeeks,
+		p.KeepMonthlyForMonths,
+	)
+}
+
+// Evaluate splits the revisions into the revisions to keep and the revisions to prune.
+// Both returned slices are sorted newest first. Day, week and month boundaries are evaluated in the location of "now".
+func (p *RetentionPolicy) Evaluate(revisions []*Revision, now time.Time) (toKeep, toPrune []*Revision) {
+	sortedRevisions := make([]*Revision, len(revisions))
+	copy(sortedRevisions, revisions)
+	sort.Slice(sortedRevisions, func(i, j int) bool {
+		return sortedRevisions[i].VersionTimestamp > sortedRevisions[j].VersionTimestamp
+	})
+
+	type periodRule struct {
+		cutoff    time.Time
+		periodKey func(t time.Time) string
+	}
+
+	var rules []periodRule
+	if p.KeepDailyForDays > 0 {
+		rules = append(rules, periodRule{now.AddDate(0, 0, -p.KeepDailyForDays), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}})
+	}
+	if p.KeepWeeklyForWeeks > 0 {
+		rules = append(rules, periodRule{now.AddDate(0, 0, -7*p.KeepWeeklyForWeeks), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}})
+	}
+	if p.KeepMonthlyForMonths > 0 {
+		rules = append(rules, periodRule{now.AddDate(0, -p.KeepMonthlyForMonths, 0), func(t time.Time) string {
+			return t.Format("2006-01")
+		}})
+	}
+
+	seenPeriodsByRule := make([]map[string]bool, len(rules))
+	for i := range rules {
+		seenPeriodsByRule[i] = make(map[string]bool)
+	}
+
+	for i, revision := range sortedRevisions {
+		keep := i == 0 || i < p.KeepLast
+
+		revisionTime := time.Unix(int64(revision.VersionTimestamp), 0).In(now.Location())
+		for ruleIndex, rule := range rules {
+			if revisionTime.Before(rule.cutoff) {
+				continue
+			}
+
+			periodKey := rule.periodKey(revisionTime)
+			if seenPeriodsByRule[ruleIndex][periodKey] {
+				continue
+			}
+
+			// newest revision in this period, since the revisions are iterated newest first
+			seenPeriodsByRule[ruleIndex][periodKey] = true
+			keep = true
+		}
+
+		if keep {
+			toKeep = append(toKeep, revision)
+		} else {
+			toPrune = append(toPrune, revision)
+		}
+	}
+
+	return toKeep, toPrune
+}
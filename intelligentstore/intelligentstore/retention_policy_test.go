@@ -0,0 +1,63 @@
+package intelligentstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RetentionPolicy_Evaluate(t *testing.T) {
+	bucket := NewBucket(1, "docs")
+	now := time.Date(2022, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	newRevisionAt := func(t time.Time) *Revision {
+		return NewRevision(bucket, RevisionVersion(t.Unix()))
+	}
+
+	todayLate := newRevisionAt(time.Date(2022, 3, 15, 11, 0, 0, 0, time.UTC))
+	todayEarly := newRevisionAt(time.Date(2022, 3, 15, 9, 0, 0, 0, time.UTC))
+	yesterday := newRevisionAt(time.Date(2022, 3, 14, 9, 0, 0, 0, time.UTC))
+	lastWeek := newRevisionAt(time.Date(2022, 3, 9, 9, 0, 0, 0, time.UTC))
+	lastMonthLate := newRevisionAt(time.Date(2022, 2, 20, 9, 0, 0, 0, time.UTC))
+	lastMonthEarly := newRevisionAt(time.Date(2022, 2, 2, 9, 0, 0, 0, time.UTC))
+	lastYear := newRevisionAt(time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC))
+
+	revisions := []*Revision{lastYear, todayEarly, lastMonthEarly, yesterday, todayLate, lastWeek, lastMonthLate}
+
+	tests := []struct {
+		name          string
+		policy        *RetentionPolicy
+		expectedKeep  []*Revision
+		expectedPrune []*Revision
+	}{
+		{
+			name:          "empty policy keeps the latest revision",
+			policy:        &RetentionPolicy{},
+			expectedKeep:  []*Revision{todayLate},
+			expectedPrune: []*Revision{todayEarly, yesterday, lastWeek, lastMonthLate, lastMonthEarly, lastYear},
+		}, {
+			name:          "keep last",
+			policy:        &RetentionPolicy{KeepLast: 3},
+			expectedKeep:  []*Revision{todayLate, todayEarly, yesterday},
+			expectedPrune: []*Revision{lastWeek, lastMonthLate, lastMonthEarly, lastYear},
+		}, {
+			name:          "keep daily",
+			policy:        &RetentionPolicy{KeepDailyForDays: 7},
+			expectedKeep:  []*Revision{todayLate, yesterday, lastWeek},
+			expectedPrune: []*Revision{todayEarly, lastMonthLate, lastMonthEarly, lastYear},
+		}, {
+			name:          "keep weekly and monthly",
+			policy:        &RetentionPolicy{KeepWeeklyForWeeks: 2, KeepMonthlyForMonths: 2},
+			expectedKeep:  []*Revision{todayLate, lastWeek, lastMonthLate},
+			expectedPrune: []*Revision{todayEarly, yesterday, lastMonthEarly, lastYear},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toKeep, toPrune := tt.policy.Evaluate(revisions, now)
+			assert.Equal(t, tt.expectedKeep, toKeep)
+			assert.Equal(t, tt.expectedPrune, toPrune)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package intelligentstore
+
+import (
+	"time"
+)
+
+// UploaderType is the kind of uploader a revision was made with
+type UploaderType string
+
+const (
+	// UploaderTypeLocal is for revisions backed up from a folder into a store opened in the same process
+	UploaderTypeLocal UploaderType = "local"
+	// UploaderTypeWeb is for revisions uploaded to the store through its web server
+	UploaderTypeWeb UploaderType = "web"
+	// UploaderTypeRemote is for revisions downloaded from a remote listing (see download-remote)
+	UploaderTypeRemote UploaderType = "remote"
+)
+
+// RevisionSource describes where a revision was made from, and why. It is given by the uploader when the transaction is created.
+type RevisionSource struct {
+	// Message is a message from the user about the revision
+	Message string   `json:"message,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// Hostname is the hostname of the machine the files were backed up from
+	Hostname string `json:"hostname,omitempty"`
+	// SourcePath is the folder (or URL) the files were backed up from
+	SourcePath   string       `json:"sourcePath,omitempty"`
+	UploaderType UploaderType `json:"uploaderType,omitempty"`
+}
+
+// RevisionInfo is a summary of a revision, written next to its manifest when the revision is committed.
+// The file counts are of every path in the revision (including directories), compared with the previous revision in the bucket.
+// Revisions committed before revision infos were recorded don't have one.
+type RevisionInfo struct {
+	RevisionSource
+	NewFiles       int64 `json:"newFiles"`
+	ChangedFiles   int64 `json:"changedFiles"`
+	UnchangedFiles int64 `json:"unchangedFiles"`
+	// DeletedFiles are the paths in the previous revision that are not in this one
+	DeletedFiles int64 `json:"deletedFiles"`
+	// BytesUploaded is the size of the contents the uploader sent, before they are compressed.
+	// If the transaction was resumed, only the contents sent after it was resumed are counted.
+	BytesUploaded int64 `json:"bytesUploaded"`
+	// StartTime is when the transaction was created, and Duration how long it was open for before it was committed
+	StartTime time.Time     `json:"startTime"`
+	Duration  time.Duration `json:"duration"`
+}
@@ -0,0 +1,112 @@
+package intelligentstore
+
+import (
+	"errors"
+	"io"
+)
+
+// sparseFileReader reads a sparse file without reading it's holes from disk. The holes are read as zeros, so the contents are the same as reading the whole file.
+type sparseFileReader struct {
+	file  io.ReadSeeker
+	size  int64
+	holes []*FileRange
+	// offset is the offset of the next read
+	offset int64
+	// fileOffset is the offset of the underlying file, so that it is only seeked when a hole has been skipped
+	fileOffset int64
+}
+
+// NewSparseFileReader creates a reader of a file with holes, that reads the data ranges from the file and gives zeros for the holes.
+// The holes must be in order and not overlap. The reader stops at size, even if the file has grown since the holes were found.
+// Files without holes are read as they are.
+func NewSparseFileReader(file io.ReadSeeker, size int64, holes []*FileRange) io.ReadSeeker {
+	if len(holes) == 0 {
+		return file
+	}
+
+	return &sparseFileReader{file: file, size: size, holes: holes}
+}
+
+func (r *sparseFileReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.size-r.offset {
+		p = p[:r.size-r.offset]
+	}
+
+	hole, nextHoleOffset := r.findHole(r.offset)
+	if hole != nil {
+		holeEnd := hole.Offset + hole.Length
+		if int64(len(p)) > holeEnd-r.offset {
+			p = p[:holeEnd-r.offset]
+		}
+
+		for i := range p {
+			p[i] = 0
+		}
+		r.offset += int64(len(p))
+
+		return len(p), nil
+	}
+
+	if int64(len(p)) > nextHoleOffset-r.offset {
+		p = p[:nextHoleOffset-r.offset]
+	}
+
+	if r.fileOffset != r.offset {
+		_, err := r.file.Seek(r.offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		r.fileOffset = r.offset
+	}
+
+	n, err := r.file.Read(p)
+	r.offset += int64(n)
+	r.fileOffset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		// the file has been truncated since the holes were found
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// findHole finds the hole the offset is in. If it isn't in a hole, it gives the offset of the next hole (or the size, if there are no more holes) instead.
+func (r *sparseFileReader) findHole(offset int64) (*FileRange, int64) {
+	for _, hole := range r.holes {
+		if offset < hole.Offset {
+			return nil, hole.Offset
+		}
+
+		if offset < hole.Offset+hole.Length {
+			return hole, 0
+		}
+	}
+
+	return nil, r.size
+}
+
+func (r *sparseFileReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		newOffset = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if newOffset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.offset = newOffset
+
+	return newOffset, nil
+}
@@ -0,0 +1,57 @@
+package intelligentstore
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewSparseFileReader(t *testing.T) {
+	contents := append(append(make([]byte, 10), []byte("data")...), make([]byte, 6)...)
+	holes := []*FileRange{NewFileRange(0, 10), NewFileRange(14, 6)}
+
+	t.Run("holes are read as zeros, and not from the file", func(t *testing.T) {
+		// the holes in the underlying file aren't zeros here, so reading them from it would show
+		file := bytes.Repeat([]byte("x"), len(contents))
+		copy(file[10:], "data")
+
+		b, err := io.ReadAll(NewSparseFileReader(bytes.NewReader(file), int64(len(contents)), holes))
+		require.NoError(t, err)
+		assert.Equal(t, contents, b)
+	})
+
+	t.Run("the hash is the same as of the whole file", func(t *testing.T) {
+		expected, err := NewHash(bytes.NewReader(contents))
+		require.NoError(t, err)
+
+		reader := NewSparseFileReader(bytes.NewReader(contents), int64(len(contents)), holes)
+		hash, err := NewHash(reader)
+		require.NoError(t, err)
+		assert.Equal(t, expected, hash)
+
+		// and the reader can be seeked back, to be read again
+		size, err := reader.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents)), size)
+
+		_, err = reader.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, contents, b)
+	})
+
+	t.Run("a truncated file is an error", func(t *testing.T) {
+		_, err := io.ReadAll(NewSparseFileReader(bytes.NewReader(contents[:12]), int64(len(contents)), []*FileRange{NewFileRange(0, 10)}))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("files without holes are read as they are", func(t *testing.T) {
+		file := bytes.NewReader(contents)
+		assert.Equal(t, file, NewSparseFileReader(file, int64(len(contents)), nil))
+	})
+}
@@ -0,0 +1,16 @@
+package intelligentstore
+
+// SpecialFileDescriptor represents a FIFO, device file or socket.
+// Everything recorded about it is in its file info, so there are no contents to store.
+type SpecialFileDescriptor struct {
+	*FileInfo
+}
+
+// NewSpecialFileDescriptor creates a new SpecialFileDescriptor
+func NewSpecialFileDescriptor(fileInfo *FileInfo) *SpecialFileDescriptor {
+	return &SpecialFileDescriptor{fileInfo}
+}
+
+func (fd *SpecialFileDescriptor) GetFileInfo() *FileInfo {
+	return fd.FileInfo
+}
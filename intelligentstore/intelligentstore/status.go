@@ -1,7 +1,12 @@
 package intelligentstore
 
 type Status struct {
-	SchemaVersion int `json:"schemaVersion"`
+	SchemaVersion int                  `json:"schemaVersion"`
+	Chunking      *ChunkingSettings    `json:"chunking,omitempty"`
+	Compression   *CompressionSettings `json:"compression,omitempty"`
+	Packing       *PackingSettings     `json:"packing,omitempty"`
+	// HashAlgorithm is the algorithm new contents are hashed with. Empty in stores from before hash algorithms were configurable, which use the default algorithm.
+	HashAlgorithm HashAlgorithm `json:"hashAlgorithm,omitempty"`
 }
 
 const (
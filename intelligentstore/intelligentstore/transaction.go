@@ -20,15 +20,31 @@ const (
 	UploadStatusCompleted
 )
 
-// TODO in-progress transaction
+// Transaction is a revision being uploaded
 type Transaction struct {
-	Revision                   *Revision
-	FilesInVersion             []FileDescriptor
-	FileInfosMissingHashes     map[RelativePath]*FileInfo
-	FileInfosMissingSymlinks   map[RelativePath]*FileInfo
-	UploadStatusMap            map[Hash]UploadStatus
-	Mu                         *sync.RWMutex
-	Stage                      TransactionStage
+	Revision                 *Revision
+	FilesInVersion           []FileDescriptor
+	FileInfosMissingHashes   map[RelativePath]*FileInfo
+	FileInfosMissingSymlinks map[RelativePath]*FileInfo
+	UploadStatusMap          map[Hash]UploadStatus
+	Mu                       *sync.RWMutex
+	Stage                    TransactionStage
+	// HashAlgorithm is the algorithm the store hashes contents with. The hashes of the files uploaded in the transaction must be made with it.
+	HashAlgorithm HashAlgorithm
+	// KnownHashes are the hashes of required files, that were already calculated by an interrupted transaction for the same revision, for files that haven't changed since.
+	// Uploaders can use them instead of hashing the files again.
+	KnownHashes map[RelativePath]Hash
+	// HashesToVerify are the hashes in the previous revision of required files that look unchanged, but are hashed again to check that their contents haven't changed.
+	HashesToVerify map[RelativePath]Hash
+	// ChangedWithSameFileInfo are the files in HashesToVerify whose contents changed, even though their modification time and size didn't
+	ChangedWithSameFileInfo []RelativePath
+	// RevisionInfo is the summary of the revision, that is written when the transaction is committed
+	RevisionInfo *RevisionInfo
+	// DryRun transactions can't be committed, and their state isn't saved. An interrupted transaction they resumed is left to be resumed by the next transaction on the bucket.
+	DryRun bool
+	// Replicated is set for transactions replicating a revision from another store. ReplicatedRevisionInfo, the info of the revision in that store, is written unchanged instead of RevisionInfo (or no info is written, if it is nil).
+	Replicated                 bool
+	ReplicatedRevisionInfo     *RevisionInfo
 	hashAlreadyPresentResolver HashAlreadyPresentResolver
 }
 
@@ -37,7 +53,7 @@ type SymlinkWithRelativePath struct {
 	Dest string
 }
 
-func NewTransaction(revision *Revision, hashAlreadyPresentResolver HashAlreadyPresentResolver) *Transaction {
+func NewTransaction(revision *Revision, hashAlgorithm HashAlgorithm, hashAlreadyPresentResolver HashAlreadyPresentResolver) *Transaction {
 	return &Transaction{
 		revision,
 		nil,
@@ -46,6 +62,14 @@ func NewTransaction(revision *Revision, hashAlreadyPresentResolver HashAlreadyPr
 		make(map[Hash]UploadStatus),
 		&sync.RWMutex{},
 		TransactionStageAwaitingFileHashes,
+		hashAlgorithm,
+		make(map[RelativePath]Hash),
+		make(map[RelativePath]Hash),
+		nil,
+		&RevisionInfo{},
+		false,
+		false,
+		nil,
 		hashAlreadyPresentResolver,
 	}
 }
@@ -86,24 +110,20 @@ func (transaction *Transaction) ProcessUploadHashesAndGetRequiredHashes(relative
 			return nil, errorsx.Errorf("file info not required for upload for '%s'", relativePathWithHash.RelativePath)
 		}
 
-		fileDescriptor := NewRegularFileDescriptor(
-			NewFileInfo(
-				FileTypeRegular,
-				relativePathWithHash.RelativePath,
-				fileInfo.ModTime,
-				fileInfo.Size,
-				fileInfo.FileMode,
-			),
-			relativePathWithHash.Hash,
-		)
+		fileDescriptor := NewRegularFileDescriptor(fileInfo, relativePathWithHash.Hash)
 
 		err := transaction.addDescriptorToTransaction(fileDescriptor)
 		if nil != err {
 			return nil, errorsx.Wrap(err)
 		}
+
+		previousHash, ok := transaction.HashesToVerify[relativePathWithHash.RelativePath]
+		if ok && previousHash != relativePathWithHash.Hash {
+			transaction.ChangedWithSameFileInfo = append(transaction.ChangedWithSameFileInfo, relativePathWithHash.RelativePath)
+		}
 	}
 
-	transaction.Stage = TransactionStageReadyToUploadFiles
+	transaction.SetStage(TransactionStageReadyToUploadFiles)
 
 	return transaction.GetHashesForRequiredContent(), nil
 }
@@ -123,6 +143,21 @@ func (transaction *Transaction) GetHashesForRequiredContent() []Hash {
 	return hashes
 }
 
+// GetUploadProgress returns how many of the pieces of content required for the transaction have been uploaded, and how many are required
+func (transaction *Transaction) GetUploadProgress() (int, int) {
+	transaction.Mu.RLock()
+	defer transaction.Mu.RUnlock()
+
+	var uploaded int
+	for _, status := range transaction.UploadStatusMap {
+		if status == UploadStatusCompleted {
+			uploaded++
+		}
+	}
+
+	return uploaded, len(transaction.UploadStatusMap)
+}
+
 func (transaction *Transaction) GetRelativePathsRequired() []RelativePath {
 	var relativePaths []RelativePath
 	for _, fileInfo := range transaction.FileInfosMissingHashes {
@@ -135,11 +170,28 @@ func (transaction *Transaction) GetRelativePathsRequired() []RelativePath {
 	return relativePaths
 }
 
+// GetStage gets the stage the transaction is in. Requests on the same transaction can run at the same time, so the stage is read under the transaction's mutex.
+func (transaction *Transaction) GetStage() TransactionStage {
+	transaction.Mu.RLock()
+	defer transaction.Mu.RUnlock()
+
+	return transaction.Stage
+}
+
+// SetStage moves the transaction to another stage
+func (transaction *Transaction) SetStage(stage TransactionStage) {
+	transaction.Mu.Lock()
+	defer transaction.Mu.Unlock()
+
+	transaction.Stage = stage
+}
+
 func (transaction *Transaction) CheckStage(expectedStages ...TransactionStage) errorsx.Error {
 	var expectedStagesString string
 
+	stage := transaction.GetStage()
 	for _, expectedStage := range expectedStages {
-		if transaction.Stage == expectedStage {
+		if stage == expectedStage {
 			return nil
 		}
 
@@ -154,7 +206,7 @@ func (transaction *Transaction) CheckStage(expectedStages ...TransactionStage) e
 
 	return errorsx.Errorf("expected transaction to be in stage '%s' but it was in stage '%s'",
 		expectedStagesString,
-		transactionStages[transaction.Stage],
+		transactionStages[stage],
 	)
 }
 
@@ -165,6 +217,15 @@ func (transaction *Transaction) addDescriptorToTransaction(fileDescriptor *Regul
 		return fmt.Errorf("%q is attempting to traverse up the filesystem tree, which is not allowed (and this is not a hash)", fileDescriptor.Hash)
 	}
 
+	validateErr := fileDescriptor.Hash.Validate()
+	if nil != validateErr {
+		return errorsx.Wrap(validateErr, "relativePath", fileDescriptor.RelativePath)
+	}
+
+	if fileDescriptor.Hash.Algorithm() != transaction.HashAlgorithm {
+		return fmt.Errorf("the hash for %q was made with %q, but the store hashes contents with %q", fileDescriptor.RelativePath, fileDescriptor.Hash.Algorithm(), transaction.HashAlgorithm)
+	}
+
 	transaction.FilesInVersion = append(transaction.FilesInVersion, fileDescriptor)
 
 	// check if it's scheduled for upload already
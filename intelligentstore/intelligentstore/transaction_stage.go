@@ -16,3 +16,7 @@ var transactionStages = [...]string{
 	"Committed",
 	"Aborted",
 }
+
+func (stage TransactionStage) String() string {
+	return transactionStages[stage]
+}
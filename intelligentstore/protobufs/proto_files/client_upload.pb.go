@@ -17,6 +17,10 @@ It has these top-level messages:
 	GetRequiredHashesResponse
 	SymlinkWithRelativePath
 	UploadSymlinksRequest
+	FileOwnershipProto
+	ExtendedAttributeProto
+	DeviceNumberProto
+	FileRangeProto
 */
 package protobufgenerated
 
@@ -40,18 +44,33 @@ type FileType int32
 const (
 	FileType_UNKNOWN FileType = 0
 	FileType_REGULAR FileType = 1
-	FileType_SYMLINK FileType = 2
+	FileType_SYMLINK   FileType = 2
+	FileType_DIRECTORY FileType = 3
+	FileType_FIFO         FileType = 4
+	FileType_CHAR_DEVICE  FileType = 5
+	FileType_BLOCK_DEVICE FileType = 6
+	FileType_SOCKET       FileType = 7
 )
 
 var FileType_name = map[int32]string{
 	0: "UNKNOWN",
 	1: "REGULAR",
 	2: "SYMLINK",
+	3: "DIRECTORY",
+	4: "FIFO",
+	5: "CHAR_DEVICE",
+	6: "BLOCK_DEVICE",
+	7: "SOCKET",
 }
 var FileType_value = map[string]int32{
 	"UNKNOWN": 0,
 	"REGULAR": 1,
-	"SYMLINK": 2,
+	"SYMLINK":   2,
+	"DIRECTORY": 3,
+	"FIFO":         4,
+	"CHAR_DEVICE":  5,
+	"BLOCK_DEVICE": 6,
+	"SOCKET":       7,
 }
 
 func (x FileType) String() string {
@@ -60,11 +79,17 @@ func (x FileType) String() string {
 func (FileType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
 type FileInfoProto struct {
-	FileType     FileType `protobuf:"varint,4,opt,name=fileType,enum=protobufgenerated.FileType" json:"fileType,omitempty"`
-	RelativePath string   `protobuf:"bytes,1,opt,name=relativePath" json:"relativePath,omitempty"`
-	ModTime      int64    `protobuf:"varint,2,opt,name=modTime" json:"modTime,omitempty"`
-	Size         int64    `protobuf:"varint,3,opt,name=size" json:"size,omitempty"`
-	Mode         uint32   `protobuf:"varint,5,opt,name=mode" json:"mode,omitempty"`
+	FileType           FileType                  `protobuf:"varint,4,opt,name=fileType,enum=protobufgenerated.FileType" json:"fileType,omitempty"`
+	RelativePath       string                    `protobuf:"bytes,1,opt,name=relativePath" json:"relativePath,omitempty"`
+	ModTime            int64                     `protobuf:"varint,2,opt,name=modTime" json:"modTime,omitempty"`
+	Size               int64                     `protobuf:"varint,3,opt,name=size" json:"size,omitempty"`
+	Mode               uint32                    `protobuf:"varint,5,opt,name=mode" json:"mode,omitempty"`
+	ModTimeNanos       int32                     `protobuf:"varint,6,opt,name=modTimeNanos" json:"modTimeNanos,omitempty"`
+	Ownership          *FileOwnershipProto       `protobuf:"bytes,7,opt,name=ownership" json:"ownership,omitempty"`
+	ExtendedAttributes []*ExtendedAttributeProto `protobuf:"bytes,8,rep,name=extendedAttributes" json:"extendedAttributes,omitempty"`
+	HardLinkGroup      uint64                    `protobuf:"varint,9,opt,name=hardLinkGroup" json:"hardLinkGroup,omitempty"`
+	DeviceNumber       *DeviceNumberProto        `protobuf:"bytes,10,opt,name=deviceNumber" json:"deviceNumber,omitempty"`
+	Holes              []*FileRangeProto         `protobuf:"bytes,11,rep,name=holes" json:"holes,omitempty"`
 }
 
 func (m *FileInfoProto) Reset()                    { *m = FileInfoProto{} }
@@ -107,6 +132,48 @@ func (m *FileInfoProto) GetMode() uint32 {
 	return 0
 }
 
+func (m *FileInfoProto) GetModTimeNanos() int32 {
+	if m != nil {
+		return m.ModTimeNanos
+	}
+	return 0
+}
+
+func (m *FileInfoProto) GetOwnership() *FileOwnershipProto {
+	if m != nil {
+		return m.Ownership
+	}
+	return nil
+}
+
+func (m *FileInfoProto) GetExtendedAttributes() []*ExtendedAttributeProto {
+	if m != nil {
+		return m.ExtendedAttributes
+	}
+	return nil
+}
+
+func (m *FileInfoProto) GetHardLinkGroup() uint64 {
+	if m != nil {
+		return m.HardLinkGroup
+	}
+	return 0
+}
+
+func (m *FileInfoProto) GetDeviceNumber() *DeviceNumberProto {
+	if m != nil {
+		return m.DeviceNumber
+	}
+	return nil
+}
+
+func (m *FileInfoProto) GetHoles() []*FileRangeProto {
+	if m != nil {
+		return m.Holes
+	}
+	return nil
+}
+
 type RelativePathAndHashProto struct {
 	RelativePath string `protobuf:"bytes,1,opt,name=relativePath" json:"relativePath,omitempty"`
 	Hash         string `protobuf:"bytes,2,opt,name=hash" json:"hash,omitempty"`
@@ -164,8 +231,10 @@ func (m *OpenTxRequest) GetFileInfos() []*FileInfoProto {
 }
 
 type OpenTxResponse struct {
-	RevisionID            int64    `protobuf:"varint,1,opt,name=revisionID" json:"revisionID,omitempty"`
-	RequiredRelativePaths []string `protobuf:"bytes,2,rep,name=requiredRelativePaths" json:"requiredRelativePaths,omitempty"`
+	RevisionID            int64                       `protobuf:"varint,1,opt,name=revisionID" json:"revisionID,omitempty"`
+	RequiredRelativePaths []string                    `protobuf:"bytes,2,rep,name=requiredRelativePaths" json:"requiredRelativePaths,omitempty"`
+	HashAlgorithm         string                      `protobuf:"bytes,3,opt,name=hashAlgorithm" json:"hashAlgorithm,omitempty"`
+	KnownHashes           []*RelativePathAndHashProto `protobuf:"bytes,4,rep,name=knownHashes" json:"knownHashes,omitempty"`
 }
 
 func (m *OpenTxResponse) Reset()                    { *m = OpenTxResponse{} }
@@ -187,6 +256,20 @@ func (m *OpenTxResponse) GetRequiredRelativePaths() []string {
 	return nil
 }
 
+func (m *OpenTxResponse) GetHashAlgorithm() string {
+	if m != nil {
+		return m.HashAlgorithm
+	}
+	return ""
+}
+
+func (m *OpenTxResponse) GetKnownHashes() []*RelativePathAndHashProto {
+	if m != nil {
+		return m.KnownHashes
+	}
+	return nil
+}
+
 type GetRequiredHashesRequest struct {
 	RelativePathsAndHashes []*RelativePathAndHashProto `protobuf:"bytes,1,rep,name=relativePathsAndHashes" json:"relativePathsAndHashes,omitempty"`
 }
@@ -259,6 +342,102 @@ func (m *UploadSymlinksRequest) GetSymlinksWithRelativePaths() []*SymlinkWithRel
 	return nil
 }
 
+type FileOwnershipProto struct {
+	Uid uint32 `protobuf:"varint,1,opt,name=uid" json:"uid,omitempty"`
+	Gid uint32 `protobuf:"varint,2,opt,name=gid" json:"gid,omitempty"`
+}
+
+func (m *FileOwnershipProto) Reset()                    { *m = FileOwnershipProto{} }
+func (m *FileOwnershipProto) String() string            { return proto.CompactTextString(m) }
+func (*FileOwnershipProto) ProtoMessage()               {}
+func (*FileOwnershipProto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *FileOwnershipProto) GetUid() uint32 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+func (m *FileOwnershipProto) GetGid() uint32 {
+	if m != nil {
+		return m.Gid
+	}
+	return 0
+}
+
+type ExtendedAttributeProto struct {
+	Name  string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *ExtendedAttributeProto) Reset()                    { *m = ExtendedAttributeProto{} }
+func (m *ExtendedAttributeProto) String() string            { return proto.CompactTextString(m) }
+func (*ExtendedAttributeProto) ProtoMessage()               {}
+func (*ExtendedAttributeProto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ExtendedAttributeProto) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ExtendedAttributeProto) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+type DeviceNumberProto struct {
+	Major uint32 `protobuf:"varint,1,opt,name=major" json:"major,omitempty"`
+	Minor uint32 `protobuf:"varint,2,opt,name=minor" json:"minor,omitempty"`
+}
+
+func (m *DeviceNumberProto) Reset()                    { *m = DeviceNumberProto{} }
+func (m *DeviceNumberProto) String() string            { return proto.CompactTextString(m) }
+func (*DeviceNumberProto) ProtoMessage()               {}
+func (*DeviceNumberProto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *DeviceNumberProto) GetMajor() uint32 {
+	if m != nil {
+		return m.Major
+	}
+	return 0
+}
+
+func (m *DeviceNumberProto) GetMinor() uint32 {
+	if m != nil {
+		return m.Minor
+	}
+	return 0
+}
+
+type FileRangeProto struct {
+	Offset int64 `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Length int64 `protobuf:"varint,2,opt,name=length" json:"length,omitempty"`
+}
+
+func (m *FileRangeProto) Reset()                    { *m = FileRangeProto{} }
+func (m *FileRangeProto) String() string            { return proto.CompactTextString(m) }
+func (*FileRangeProto) ProtoMessage()               {}
+func (*FileRangeProto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *FileRangeProto) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *FileRangeProto) GetLength() int64 {
+	if m != nil {
+		return m.Length
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*FileInfoProto)(nil), "protobufgenerated.FileInfoProto")
 	proto.RegisterType((*RelativePathAndHashProto)(nil), "protobufgenerated.RelativePathAndHashProto")
@@ -269,41 +448,66 @@ func init() {
 	proto.RegisterType((*GetRequiredHashesResponse)(nil), "protobufgenerated.GetRequiredHashesResponse")
 	proto.RegisterType((*SymlinkWithRelativePath)(nil), "protobufgenerated.SymlinkWithRelativePath")
 	proto.RegisterType((*UploadSymlinksRequest)(nil), "protobufgenerated.UploadSymlinksRequest")
+	proto.RegisterType((*FileOwnershipProto)(nil), "protobufgenerated.FileOwnershipProto")
+	proto.RegisterType((*ExtendedAttributeProto)(nil), "protobufgenerated.ExtendedAttributeProto")
+	proto.RegisterType((*DeviceNumberProto)(nil), "protobufgenerated.DeviceNumberProto")
+	proto.RegisterType((*FileRangeProto)(nil), "protobufgenerated.FileRangeProto")
 	proto.RegisterEnum("protobufgenerated.FileType", FileType_name, FileType_value)
 }
 
 func init() { proto.RegisterFile("proto_files/client_upload.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 475 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0x51, 0x4f, 0xd4, 0x40,
-	0x10, 0xb6, 0xd7, 0x13, 0xae, 0x03, 0x47, 0x8e, 0x4d, 0xc0, 0xa2, 0x89, 0x36, 0xfb, 0xd4, 0x60,
-	0x72, 0x44, 0x30, 0xf1, 0xcd, 0x84, 0xa8, 0xe0, 0x05, 0xbc, 0xc3, 0xe1, 0x2e, 0xc4, 0x27, 0x52,
-	0xae, 0x53, 0xbb, 0xb1, 0xb7, 0x2d, 0xdd, 0x3d, 0x22, 0xbe, 0x18, 0xff, 0x90, 0xbf, 0xd1, 0x74,
-	0x69, 0x8f, 0xaa, 0xd7, 0x84, 0xa7, 0xce, 0x7c, 0x33, 0xfd, 0x66, 0xbe, 0x6f, 0xb2, 0xf0, 0x22,
-	0xcb, 0x53, 0x9d, 0x5e, 0x46, 0x22, 0x21, 0xb5, 0x37, 0x4d, 0x04, 0x49, 0x7d, 0x39, 0xcf, 0x92,
-	0x34, 0x08, 0xfb, 0xa6, 0xc2, 0x36, 0xcd, 0xe7, 0x6a, 0x1e, 0x7d, 0x25, 0x49, 0x79, 0xa0, 0x29,
-	0xe4, 0xbf, 0x2d, 0xe8, 0x1e, 0x89, 0x84, 0x06, 0x32, 0x4a, 0xcf, 0x4c, 0xd3, 0x1b, 0xe8, 0x14,
-	0x0c, 0xe3, 0xdb, 0x8c, 0xdc, 0xb6, 0x67, 0xf9, 0x1b, 0xfb, 0xcf, 0xfa, 0xff, 0xfd, 0xd7, 0x3f,
-	0x2a, 0x5b, 0x70, 0xd1, 0xcc, 0x38, 0xac, 0xe7, 0x94, 0x04, 0x5a, 0xdc, 0xd0, 0x59, 0xa0, 0x63,
-	0xd7, 0xf2, 0x2c, 0xdf, 0xc1, 0xbf, 0x30, 0xe6, 0xc2, 0xea, 0x2c, 0x0d, 0xc7, 0x62, 0x46, 0x6e,
-	0xcb, 0xb3, 0x7c, 0x1b, 0xab, 0x94, 0x31, 0x68, 0x2b, 0xf1, 0x83, 0x5c, 0xdb, 0xc0, 0x26, 0x2e,
-	0xb0, 0x59, 0x1a, 0x92, 0xfb, 0xd8, 0xb3, 0xfc, 0x2e, 0x9a, 0x98, 0x23, 0xb8, 0x58, 0x63, 0x3c,
-	0x94, 0xe1, 0xc7, 0x40, 0xc5, 0x77, 0xab, 0x3f, 0x64, 0x03, 0x06, 0xed, 0x38, 0x50, 0xb1, 0x19,
-	0xef, 0xa0, 0x89, 0xf9, 0x1e, 0x6c, 0x16, 0x7a, 0xde, 0xa5, 0x52, 0x93, 0xd4, 0xea, 0x8e, 0xec,
-	0x29, 0x74, 0xa6, 0x25, 0x60, 0x88, 0xd6, 0x71, 0x91, 0xf3, 0x11, 0x74, 0x47, 0x19, 0xc9, 0xf1,
-	0x77, 0xa4, 0xeb, 0x39, 0x29, 0xcd, 0xde, 0x82, 0x13, 0x95, 0x2e, 0x16, 0xdd, 0xb6, 0xbf, 0xb6,
-	0xef, 0x35, 0xb8, 0xb6, 0x70, 0x1a, 0xef, 0x7f, 0xe1, 0x11, 0x6c, 0x54, 0x84, 0x2a, 0x4b, 0xa5,
-	0x22, 0xf6, 0x1c, 0x20, 0xa7, 0x1b, 0xa1, 0x44, 0x2a, 0x07, 0xef, 0xcd, 0x02, 0x36, 0xd6, 0x10,
-	0xf6, 0x1a, 0xb6, 0x72, 0xba, 0x9e, 0x8b, 0x9c, 0xc2, 0xba, 0x1f, 0xca, 0x6d, 0x79, 0xb6, 0xef,
-	0xe0, 0xf2, 0x22, 0xff, 0x09, 0xee, 0x31, 0x69, 0x2c, 0x6b, 0x85, 0x73, 0xa4, 0x2a, 0x0d, 0x53,
-	0xd8, 0xae, 0x3b, 0xa5, 0x4a, 0x6b, 0xa9, 0x12, 0xf4, 0x72, 0x89, 0xa0, 0xa6, 0x53, 0x60, 0x03,
-	0x15, 0x3f, 0x80, 0x9d, 0x25, 0x0b, 0x94, 0x9a, 0xb7, 0x61, 0x25, 0xbe, 0x9f, 0xe8, 0x60, 0x99,
-	0xf1, 0xcf, 0xf0, 0xe4, 0xfc, 0x76, 0x96, 0x08, 0xf9, 0xed, 0x42, 0xe8, 0xb8, 0x3e, 0xf3, 0xa1,
-	0x27, 0x0f, 0x49, 0xe9, 0xea, 0xe4, 0x45, 0xcc, 0x7f, 0x59, 0xb0, 0x35, 0x31, 0x6f, 0xa3, 0x64,
-	0x5e, 0xd8, 0x10, 0xc3, 0x8e, 0x2a, 0xa1, 0x7f, 0xa7, 0x55, 0x4e, 0xec, 0x2e, 0x71, 0xa2, 0x61,
-	0x41, 0x6c, 0x26, 0xdb, 0x7d, 0x05, 0x9d, 0xea, 0x19, 0xb1, 0x35, 0x58, 0x9d, 0x0c, 0x4f, 0x86,
-	0xa3, 0x8b, 0x61, 0xef, 0x51, 0x91, 0xe0, 0x87, 0xe3, 0xc9, 0xe9, 0x21, 0xf6, 0xac, 0x22, 0x39,
-	0xff, 0xf2, 0xe9, 0x74, 0x30, 0x3c, 0xe9, 0xb5, 0xae, 0x56, 0xcc, 0xe0, 0x83, 0x3f, 0x01, 0x00,
-	0x00, 0xff, 0xff, 0xdd, 0x76, 0x99, 0x17, 0xeb, 0x03, 0x00, 0x00,
+	// 811 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0x5f, 0x6f, 0x1b, 0x45,
+	0x10, 0xe7, 0xe2, 0x3f, 0xb1, 0xc7, 0x76, 0xb8, 0xac, 0x68, 0xb8, 0x82, 0x04, 0xc7, 0xa9, 0x48,
+	0x47, 0x91, 0x52, 0x29, 0x45, 0x2a, 0x4f, 0x80, 0xeb, 0x38, 0x89, 0x95, 0xd4, 0x2e, 0x1b, 0x87,
+	0x2a, 0x4f, 0xd1, 0x25, 0x37, 0xf6, 0x2d, 0x39, 0xef, 0xba, 0xb7, 0x7b, 0x69, 0xc3, 0x0b, 0xe2,
+	0x73, 0xf0, 0xd1, 0xf8, 0x32, 0x68, 0xf7, 0xf6, 0x12, 0xbb, 0xb1, 0xa5, 0xf6, 0xc9, 0x33, 0xbf,
+	0x9d, 0xf9, 0xcd, 0x5f, 0xcf, 0xc1, 0xb7, 0xf3, 0x4c, 0x28, 0x71, 0x31, 0x61, 0x29, 0xca, 0x67,
+	0x57, 0x29, 0x43, 0xae, 0x2e, 0xf2, 0x79, 0x2a, 0xa2, 0x78, 0xd7, 0xbc, 0x90, 0x6d, 0xf3, 0x73,
+	0x99, 0x4f, 0xa6, 0xc8, 0x31, 0x8b, 0x14, 0xc6, 0xc1, 0xbf, 0x55, 0xe8, 0x1c, 0xb0, 0x14, 0x07,
+	0x7c, 0x22, 0x5e, 0x1b, 0xa3, 0x17, 0xd0, 0xd0, 0x0c, 0xe3, 0xdb, 0x39, 0x7a, 0x55, 0xdf, 0x09,
+	0xb7, 0xf6, 0xbe, 0xde, 0x7d, 0xe0, 0xb7, 0x7b, 0x60, 0x4d, 0xe8, 0x9d, 0x31, 0x09, 0xa0, 0x9d,
+	0x61, 0x1a, 0x29, 0x76, 0x83, 0xaf, 0x23, 0x95, 0x78, 0x8e, 0xef, 0x84, 0x4d, 0xba, 0x84, 0x11,
+	0x0f, 0x36, 0x67, 0x22, 0x1e, 0xb3, 0x19, 0x7a, 0x1b, 0xbe, 0x13, 0x56, 0x68, 0xa9, 0x12, 0x02,
+	0x55, 0xc9, 0xfe, 0x42, 0xaf, 0x62, 0x60, 0x23, 0x6b, 0x6c, 0x26, 0x62, 0xf4, 0x6a, 0xbe, 0x13,
+	0x76, 0xa8, 0x91, 0x75, 0x14, 0xeb, 0x32, 0x8c, 0xb8, 0x90, 0x5e, 0xdd, 0x77, 0xc2, 0x1a, 0x5d,
+	0xc2, 0x48, 0x0f, 0x9a, 0xe2, 0x1d, 0xc7, 0x4c, 0x26, 0x6c, 0xee, 0x6d, 0xfa, 0x4e, 0xd8, 0xda,
+	0xfb, 0x7e, 0x4d, 0x0d, 0xa3, 0xd2, 0xce, 0x14, 0x4f, 0xef, 0xfd, 0xc8, 0x39, 0x10, 0x7c, 0xaf,
+	0x90, 0xc7, 0x18, 0x77, 0x95, 0xca, 0xd8, 0x65, 0xae, 0x50, 0x7a, 0x0d, 0xbf, 0x12, 0xb6, 0xf6,
+	0x7e, 0x58, 0xc1, 0xd6, 0xff, 0xd0, 0xb8, 0x60, 0x5c, 0x41, 0x42, 0x9e, 0x40, 0x27, 0x89, 0xb2,
+	0xf8, 0x84, 0xf1, 0xeb, 0xc3, 0x4c, 0xe4, 0x73, 0xaf, 0xe9, 0x3b, 0x61, 0x95, 0x2e, 0x83, 0xe4,
+	0x08, 0xda, 0x31, 0xde, 0xb0, 0x2b, 0x1c, 0xe6, 0xb3, 0x4b, 0xcc, 0x3c, 0x30, 0x85, 0x3c, 0x59,
+	0x11, 0x7a, 0x7f, 0xc1, 0xac, 0x88, 0xba, 0xe4, 0x49, 0x5e, 0x40, 0x2d, 0x11, 0x29, 0x4a, 0xaf,
+	0x65, 0xb2, 0xff, 0x6e, 0x4d, 0x2f, 0x68, 0xc4, 0xa7, 0x36, 0xeb, 0xc2, 0x3e, 0xa0, 0xe0, 0xd1,
+	0x85, 0xf1, 0x75, 0x79, 0x7c, 0x14, 0xc9, 0xa4, 0xd8, 0x93, 0x8f, 0x19, 0x37, 0x81, 0x6a, 0x12,
+	0xc9, 0xc4, 0xcc, 0xba, 0x49, 0x8d, 0x1c, 0x3c, 0x83, 0x6d, 0x1d, 0xac, 0x27, 0xb8, 0x42, 0xae,
+	0x64, 0x41, 0xf6, 0x15, 0x34, 0xae, 0x2c, 0x60, 0x88, 0xda, 0xf4, 0x4e, 0x0f, 0x46, 0xd0, 0x19,
+	0xcd, 0x91, 0x8f, 0xdf, 0x53, 0x7c, 0x9b, 0xa3, 0x54, 0xe4, 0x17, 0x68, 0x4e, 0xec, 0xca, 0x6a,
+	0x6b, 0x5d, 0x92, 0xbf, 0xa6, 0xa4, 0xbb, 0xb5, 0xa6, 0xf7, 0x2e, 0xc1, 0x7f, 0x0e, 0x6c, 0x95,
+	0x8c, 0x72, 0x2e, 0xb8, 0x44, 0xf2, 0x0d, 0x40, 0x86, 0x37, 0x4c, 0x32, 0xc1, 0x07, 0xfb, 0x26,
+	0x83, 0x0a, 0x5d, 0x40, 0xc8, 0x4f, 0xf0, 0x28, 0xc3, 0xb7, 0x39, 0xcb, 0x30, 0x5e, 0x6c, 0x88,
+	0xf4, 0x36, 0xfc, 0x4a, 0xd8, 0xa4, 0xab, 0x1f, 0x8b, 0x39, 0xcb, 0xa4, 0x9b, 0x4e, 0x45, 0xc6,
+	0x54, 0x32, 0x33, 0xcb, 0xdd, 0xa4, 0xcb, 0x20, 0x79, 0x05, 0xad, 0x6b, 0x2e, 0xde, 0x71, 0xdd,
+	0x5a, 0x94, 0x5e, 0xd5, 0x14, 0xf4, 0xe3, 0x8a, 0x82, 0xd6, 0x8d, 0x82, 0x2e, 0xfa, 0x07, 0x7f,
+	0x83, 0x77, 0x88, 0x8a, 0xda, 0x84, 0x0a, 0xb0, 0xec, 0xdc, 0x15, 0xec, 0x2c, 0xce, 0x47, 0x5a,
+	0x16, 0x2c, 0xdb, 0xf8, 0x49, 0x51, 0xd7, 0x50, 0x05, 0xcf, 0xe1, 0xf1, 0x8a, 0x04, 0x6c, 0xa3,
+	0x77, 0xa0, 0x9e, 0xdc, 0x47, 0x6c, 0x52, 0xab, 0x05, 0xbf, 0xc3, 0x97, 0xa7, 0xb7, 0xb3, 0x94,
+	0xf1, 0xeb, 0x37, 0x4c, 0x25, 0x8b, 0x31, 0x3f, 0x76, 0xd1, 0x62, 0x94, 0xaa, 0x5c, 0x34, 0x2d,
+	0x07, 0xff, 0x38, 0xf0, 0xe8, 0xcc, 0x9c, 0x3f, 0xcb, 0x7c, 0xd7, 0x86, 0x04, 0x1e, 0x4b, 0x0b,
+	0x7d, 0x18, 0xad, 0xec, 0xc4, 0xd3, 0x15, 0x9d, 0x58, 0x93, 0x20, 0x5d, 0x4f, 0x16, 0xfc, 0x0c,
+	0xe4, 0xe1, 0x95, 0x21, 0x2e, 0x54, 0x72, 0x16, 0x9b, 0x42, 0x3a, 0x54, 0x8b, 0x1a, 0x99, 0xb2,
+	0xd8, 0xa4, 0xdf, 0xa1, 0x5a, 0x0c, 0x5e, 0xc2, 0xce, 0xea, 0x8b, 0xa2, 0x6b, 0xe5, 0xd1, 0x0c,
+	0x6d, 0x1f, 0x8c, 0x4c, 0xbe, 0x80, 0xda, 0x4d, 0x94, 0xe6, 0xc5, 0x55, 0x6d, 0xd3, 0x42, 0x09,
+	0x7e, 0x85, 0xed, 0x07, 0xa7, 0x41, 0x9b, 0xce, 0xa2, 0x3f, 0x45, 0x66, 0xc3, 0x17, 0x8a, 0x41,
+	0x19, 0x17, 0x99, 0x4d, 0xa1, 0x50, 0x82, 0xdf, 0x60, 0x6b, 0xf9, 0x30, 0xe8, 0xf9, 0x89, 0xc9,
+	0x44, 0xa2, 0xb2, 0x7f, 0x12, 0xab, 0x69, 0x3c, 0x45, 0x3e, 0x55, 0x89, 0xbd, 0xeb, 0x56, 0x7b,
+	0x7a, 0x0b, 0x8d, 0xf2, 0x53, 0x41, 0x5a, 0xb0, 0x79, 0x36, 0x3c, 0x1e, 0x8e, 0xde, 0x0c, 0xdd,
+	0xcf, 0xb4, 0x42, 0xfb, 0x87, 0x67, 0x27, 0x5d, 0xea, 0x3a, 0x5a, 0x39, 0x3d, 0x7f, 0x75, 0x32,
+	0x18, 0x1e, 0xbb, 0x1b, 0xa4, 0x03, 0xcd, 0xfd, 0x01, 0xed, 0xf7, 0xc6, 0x23, 0x7a, 0xee, 0x56,
+	0x48, 0x03, 0xaa, 0x07, 0x83, 0x83, 0x91, 0x5b, 0x25, 0x9f, 0x43, 0xab, 0x77, 0xd4, 0xa5, 0x17,
+	0xfb, 0xfd, 0x3f, 0x06, 0xbd, 0xbe, 0x5b, 0x23, 0x2e, 0xb4, 0x5f, 0x9e, 0x8c, 0x7a, 0xc7, 0x25,
+	0x52, 0x27, 0x00, 0xf5, 0xd3, 0x51, 0xef, 0xb8, 0x3f, 0x76, 0x37, 0x2f, 0xeb, 0x66, 0x82, 0xcf,
+	0xff, 0x1f, 0x00, 0x5b, 0xc5, 0x56, 0xbd, 0x17, 0x07, 0x00, 0x00,
 }
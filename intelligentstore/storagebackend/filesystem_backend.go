@@ -0,0 +1,399 @@
+package storagebackend
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs"
+)
+
+// incompleteFilePrefix is the start of the name of files that are still being written by Put. They are not listed.
+const incompleteFilePrefix = ".incomplete-"
+
+var _ Backend = &FilesystemBackend{}
+
+// FilesystemBackend keeps objects as files under a base directory. Each key is the path of the file, relative to the base directory.
+// Files are written next to their final path, synced, and then moved into place, so that a crash (or a full disk) never leaves an incomplete file at a key.
+type FilesystemBackend struct {
+	fs       gofs.Fs
+	basePath string
+	// linkFunc hard links a file to a new path. It fails if there is already a file at the new path, so it is used to move complete files into place without replacing an existing file.
+	// nil if the filesystem is not the OS filesystem.
+	linkFunc func(oldPath, newPath string) error
+}
+
+func NewFilesystemBackend(fs gofs.Fs, basePath string) *FilesystemBackend {
+	var linkFunc func(oldPath, newPath string) error
+	if _, isOsFs := fs.(*gofs.OsFs); isOsFs {
+		linkFunc = os.Link
+	}
+
+	return &FilesystemBackend{fs, basePath, linkFunc}
+}
+
+func (b *FilesystemBackend) getPath(key string) string {
+	return filepath.Join(b.basePath, filepath.FromSlash(key))
+}
+
+func (b *FilesystemBackend) Get(key string) (io.ReadCloser, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, openErr := b.fs.Open(b.getPath(key))
+	if openErr != nil {
+		return nil, wrapFsError(openErr, key)
+	}
+
+	return file, nil
+}
+
+func (b *FilesystemBackend) GetRange(key string, offset, length int64) (io.ReadCloser, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, openErr := b.fs.Open(b.getPath(key))
+	if openErr != nil {
+		return nil, wrapFsError(openErr, key)
+	}
+
+	fileInfo, statErr := file.Stat()
+	if statErr != nil {
+		file.Close()
+		return nil, errorsx.Wrap(statErr, "key", key)
+	}
+
+	if offset < 0 || length < 0 || offset+length > fileInfo.Size() {
+		file.Close()
+		return nil, errorsx.Errorf("range (offset %d, length %d) is outside of %q, which is %d bytes long", offset, length, key, fileInfo.Size())
+	}
+
+	_, seekErr := file.Seek(offset, io.SeekStart)
+	if seekErr != nil {
+		file.Close()
+		return nil, errorsx.Wrap(seekErr, "key", key)
+	}
+
+	return &limitedReadCloser{io.LimitReader(file, length), file}, nil
+}
+
+func (b *FilesystemBackend) Stat(key string) (*ObjectInfo, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, statErr := b.fs.Stat(b.getPath(key))
+	if statErr != nil {
+		return nil, wrapFsError(statErr, key)
+	}
+
+	if fileInfo.IsDir() {
+		return nil, errorsx.Wrap(ErrNotFound, "key", key)
+	}
+
+	return &ObjectInfo{key, fileInfo.Size(), fileInfo.ModTime()}, nil
+}
+
+// Put writes the contents to a new file next to the final file, and then moves it into place
+func (b *FilesystemBackend) Put(key string, contents io.Reader) errorsx.Error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	filePath := b.getPath(key)
+	err = b.mkdirParent(filePath)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+
+	incompleteFilePath := getIncompleteFilePath(filePath)
+	err = b.writeFile(incompleteFilePath, contents)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+
+	renameErr := b.fs.Rename(incompleteFilePath, filePath)
+	if renameErr != nil {
+		b.fs.Remove(incompleteFilePath)
+		return errorsx.Wrap(renameErr, "key", key)
+	}
+
+	return errorsx.Wrap(b.syncDir(filepath.Dir(filePath)), "key", key)
+}
+
+// PutIfAbsent writes the contents to a new file next to the final file, and then moves it into place, only if there is no file there yet
+func (b *FilesystemBackend) PutIfAbsent(key string, contents io.Reader) errorsx.Error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	filePath := b.getPath(key)
+	err = b.mkdirParent(filePath)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+
+	// checked before the contents are written, so that they aren't written for nothing. The check when the file is moved into place is the one that counts.
+	_, statErr := b.fs.Stat(filePath)
+	if statErr == nil {
+		return errorsx.Wrap(ErrAlreadyExists, "key", key)
+	}
+
+	incompleteFilePath := getIncompleteFilePath(filePath)
+	err = b.writeFile(incompleteFilePath, contents)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+	// once the file is linked into place, the incomplete file is only a second name for it
+	defer b.fs.Remove(incompleteFilePath)
+
+	err = b.moveIntoPlaceIfAbsent(incompleteFilePath, filePath)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+
+	return errorsx.Wrap(b.syncDir(filepath.Dir(filePath)), "key", key)
+}
+
+// moveIntoPlaceIfAbsent moves the complete file to the final path, unless there is already a file there.
+// The file is hard linked into place, which fails if there is already a file there.
+// On filesystems that can't be hard linked through, the final path is first claimed by creating an empty file there exclusively, and then the complete file is renamed over it.
+// Either way, only one of two processes writing the same file at the same time can succeed.
+// Until the rename, the key has an empty object.
+func (b *FilesystemBackend) moveIntoPlaceIfAbsent(incompleteFilePath, filePath string) errorsx.Error {
+	if b.linkFunc != nil {
+		linkErr := b.linkFunc(incompleteFilePath, filePath)
+		if linkErr == nil {
+			return nil
+		}
+
+		if os.IsExist(linkErr) {
+			return errorsx.Wrap(ErrAlreadyExists)
+		}
+
+		// the filesystem doesn't support hard links (for example FAT)
+	}
+
+	placeholderFile, err := b.fs.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return errorsx.Wrap(ErrAlreadyExists)
+		}
+		return errorsx.Wrap(err)
+	}
+
+	err = placeholderFile.Close()
+	if err == nil {
+		err = b.fs.Rename(incompleteFilePath, filePath)
+	}
+
+	if err != nil {
+		b.fs.Remove(filePath)
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+// RemoveIncompleteFiles removes the incomplete files last modified before the given time.
+// Incomplete files are left behind when a process crashes while writing a file. Newer incomplete files could still be being written to, so they are left alone.
+func (b *FilesystemBackend) RemoveIncompleteFiles(modifiedBefore time.Time, dryRun bool) (int64, errorsx.Error) {
+	var count int64
+	err := b.removeIncompleteFilesInDir(b.basePath, modifiedBefore, dryRun, &count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (b *FilesystemBackend) removeIncompleteFilesInDir(dirPath string, modifiedBefore time.Time, dryRun bool, count *int64) errorsx.Error {
+	fileInfos, err := b.fs.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errorsx.Wrap(err, "path", dirPath)
+	}
+
+	for _, fileInfo := range fileInfos {
+		filePath := filepath.Join(dirPath, fileInfo.Name())
+
+		if fileInfo.IsDir() {
+			err := b.removeIncompleteFilesInDir(filePath, modifiedBefore, dryRun, count)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(fileInfo.Name(), incompleteFilePrefix) || !fileInfo.ModTime().Before(modifiedBefore) {
+			continue
+		}
+
+		*count++
+
+		if dryRun {
+			continue
+		}
+
+		removeErr := b.fs.Remove(filePath)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			return errorsx.Wrap(removeErr, "path", filePath)
+		}
+	}
+
+	return nil
+}
+
+func getIncompleteFilePath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%s%d-%s", incompleteFilePrefix, rand.Int63(), filepath.Base(filePath)))
+}
+
+// syncDir syncs a directory, so that the files moved into it are still there after a crash
+func (b *FilesystemBackend) syncDir(dirPath string) errorsx.Error {
+	dir, err := b.fs.Open(dirPath)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+	defer dir.Close()
+
+	return errorsx.Wrap(dir.Sync())
+}
+
+func (b *FilesystemBackend) mkdirParent(filePath string) errorsx.Error {
+	return errorsx.Wrap(b.fs.MkdirAll(filepath.Dir(filePath), 0700))
+}
+
+// writeFile writes the contents to a new file, and syncs it. If the contents can't be written, the file is removed again.
+func (b *FilesystemBackend) writeFile(filePath string, contents io.Reader) errorsx.Error {
+	file, err := b.fs.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errorsx.Wrap(err)
+	}
+
+	bytesWritten, err := io.Copy(file, contents)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		// make sure everything written reached the file
+		var fileInfo os.FileInfo
+		fileInfo, err = file.Stat()
+		if err == nil && fileInfo.Size() != bytesWritten {
+			err = fmt.Errorf("%d bytes were written, but the file is %d bytes", bytesWritten, fileInfo.Size())
+		}
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		b.fs.Remove(filePath)
+		return errorsx.Wrap(err, "path", filePath)
+	}
+
+	return nil
+}
+
+func (b *FilesystemBackend) List(prefix string) ([]*ObjectInfo, errorsx.Error) {
+	// only the directory the prefix is in (and the directories below it) can contain matching files
+	dirKey := ""
+	lastSlashIndex := strings.LastIndex(prefix, "/")
+	if lastSlashIndex != -1 {
+		dirKey = prefix[:lastSlashIndex]
+	}
+
+	var objectInfos []*ObjectInfo
+	err := b.listDir(dirKey, prefix, &objectInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objectInfos, func(i, j int) bool {
+		return objectInfos[i].Key < objectInfos[j].Key
+	})
+
+	return objectInfos, nil
+}
+
+func (b *FilesystemBackend) listDir(dirKey, prefix string, objectInfos *[]*ObjectInfo) errorsx.Error {
+	dirPath := b.basePath
+	if dirKey != "" {
+		dirPath = b.getPath(dirKey)
+	}
+
+	fileInfos, err := b.fs.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errorsx.Wrap(err, "path", dirPath)
+	}
+
+	for _, fileInfo := range fileInfos {
+		key := path.Join(dirKey, fileInfo.Name())
+
+		if fileInfo.IsDir() {
+			if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+				err := b.listDir(key, prefix, objectInfos)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(fileInfo.Name(), incompleteFilePrefix) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		*objectInfos = append(*objectInfos, &ObjectInfo{key, fileInfo.Size(), fileInfo.ModTime()})
+	}
+
+	return nil
+}
+
+func (b *FilesystemBackend) Delete(key string) errorsx.Error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	removeErr := b.fs.Remove(b.getPath(key))
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		return errorsx.Wrap(removeErr, "key", key)
+	}
+
+	return nil
+}
+
+func (b *FilesystemBackend) String() string {
+	return b.basePath
+}
+
+// wrapFsError makes sure a "not exists" error from the filesystem has ErrNotFound as it's cause
+func wrapFsError(err error, key string) errorsx.Error {
+	if os.IsNotExist(err) {
+		return errorsx.Wrap(ErrNotFound, "key", key)
+	}
+
+	return errorsx.Wrap(err, "key", key)
+}
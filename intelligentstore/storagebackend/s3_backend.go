@@ -0,0 +1,429 @@
+package storagebackend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+)
+
+// maxInMemoryPutSize is the largest body that is held in memory before it is uploaded. Bigger bodies are spooled to a temporary file.
+// The body has to be read completely before it is uploaded, as the request is signed with a hash of the body.
+const maxInMemoryPutSize = 8 * 1024 * 1024
+
+var _ Backend = &S3Backend{}
+
+// S3Credentials are the keys requests to the S3 server are signed with
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only needed for temporary credentials
+	SessionToken string
+}
+
+// S3Config is the configuration of a backend that keeps objects in an S3-compatible object store
+type S3Config struct {
+	// Endpoint is the URL of the server, for example "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is put before every key, so that a store can share a bucket with other data. It can be empty.
+	Prefix      string
+	Credentials S3Credentials
+}
+
+// S3Backend keeps objects in a bucket of an S3-compatible object store, using path-style URLs (endpoint/bucket/key).
+// Locks and put-if-absent use conditional writes ("If-None-Match: *"), so the server must support them.
+type S3Backend struct {
+	config      S3Config
+	endpointURL *url.URL
+	client      *http.Client
+	nowProvider func() time.Time
+}
+
+func NewS3Backend(config S3Config) (*S3Backend, errorsx.Error) {
+	endpointURL, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "endpoint", config.Endpoint)
+	}
+
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, errorsx.Errorf("the S3 endpoint must be a http or https URL, but was %q", config.Endpoint)
+	}
+
+	if config.Bucket == "" {
+		return nil, errorsx.Errorf("no S3 bucket given")
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	return &S3Backend{
+		config:      config,
+		endpointURL: endpointURL,
+		client:      &http.Client{Timeout: 10 * time.Minute},
+		nowProvider: time.Now,
+	}, nil
+}
+
+func (b *S3Backend) getObjectName(key string) string {
+	if b.config.Prefix == "" {
+		return key
+	}
+
+	return b.config.Prefix + "/" + key
+}
+
+func (b *S3Backend) getURL(objectName string, query url.Values) *url.URL {
+	u := *b.endpointURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.config.Bucket
+	if objectName != "" {
+		u.Path += "/" + objectName
+	}
+	u.RawPath = s3URIEncode(u.Path, false)
+	u.RawQuery = canonicalQueryString(query)
+
+	return &u
+}
+
+// do signs and sends the request
+func (b *S3Backend) do(method string, u *url.URL, body io.ReadSeeker, size int64, payloadSHA256 string, headers map[string]string) (*http.Response, errorsx.Error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	// the URL is parsed again by NewRequest; the path is set back to exactly what is signed
+	req.URL = u
+
+	if body != nil {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+		req.GetBody = func() (io.ReadCloser, error) {
+			_, err := body.Seek(0, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(body), nil
+		}
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	signS3Request(req, b.config.Credentials, b.config.Region, payloadSHA256, b.nowProvider())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return resp, nil
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(http.MethodGet, b.getURL(b.getObjectName(key), nil), nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "key", key)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newS3ResponseError(resp, key)
+	}
+
+	return resp.Body, nil
+}
+
+// GetRange gets part of the object with a ranged GET request
+func (b *S3Backend) GetRange(key string, offset, length int64) (io.ReadCloser, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || length < 0 {
+		return nil, errorsx.Errorf("invalid range (offset %d, length %d) of %q", offset, length, key)
+	}
+
+	if length == 0 {
+		// an empty range can't be requested, so the object is only checked to be long enough
+		objectInfo, err := b.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+		if offset > objectInfo.Size {
+			return nil, errorsx.Errorf("range (offset %d, length %d) is outside of %q, which is %d bytes long", offset, length, key, objectInfo.Size)
+		}
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	headers := map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1),
+	}
+
+	resp, err := b.do(http.MethodGet, b.getURL(b.getObjectName(key), nil), nil, 0, emptyPayloadSHA256, headers)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "key", key)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, newS3ResponseError(resp, key)
+	}
+
+	if resp.ContentLength != length {
+		// the server returns less than asked for if the range goes past the end of the object
+		resp.Body.Close()
+		return nil, errorsx.Errorf("range (offset %d, length %d) is outside of %q. %d bytes were returned", offset, length, key, resp.ContentLength)
+	}
+
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Stat(key string) (*ObjectInfo, errorsx.Error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(http.MethodHead, b.getURL(b.getObjectName(key), nil), nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "key", key)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newS3ResponseError(resp, key)
+	}
+
+	modTime, parseErr := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if parseErr != nil {
+		return nil, errorsx.Wrap(parseErr, "key", key)
+	}
+
+	return &ObjectInfo{key, resp.ContentLength, modTime}, nil
+}
+
+func (b *S3Backend) Put(key string, contents io.Reader) errorsx.Error {
+	return b.put(key, contents, nil)
+}
+
+func (b *S3Backend) PutIfAbsent(key string, contents io.Reader) errorsx.Error {
+	return b.put(key, contents, map[string]string{"If-None-Match": "*"})
+}
+
+func (b *S3Backend) put(key string, contents io.Reader, headers map[string]string) errorsx.Error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	body, size, payloadSHA256, cleanup, err := spoolBody(contents)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+	defer cleanup()
+
+	resp, err := b.do(http.MethodPut, b.getURL(b.getObjectName(key), nil), body, size, payloadSHA256, headers)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newS3ResponseError(resp, key)
+	}
+
+	return nil
+}
+
+// spoolBody reads the whole body, so that it's size and hash are known before it is sent.
+// Small bodies are kept in memory, larger ones in a temporary file. cleanup must be called when the body is no longer needed.
+func spoolBody(contents io.Reader) (io.ReadSeeker, int64, string, func(), errorsx.Error) {
+	hasher := sha256.New()
+	buf := bytes.NewBuffer(nil)
+
+	size, err := io.Copy(io.MultiWriter(buf, hasher), io.LimitReader(contents, maxInMemoryPutSize+1))
+	if err != nil {
+		return nil, 0, "", nil, errorsx.Wrap(err)
+	}
+
+	if size <= maxInMemoryPutSize {
+		return bytes.NewReader(buf.Bytes()), size, hex.EncodeToString(hasher.Sum(nil)), func() {}, nil
+	}
+
+	tempFile, err := os.CreateTemp("", "intelligent-store-s3-upload-")
+	if err != nil {
+		return nil, 0, "", nil, errorsx.Wrap(err)
+	}
+
+	cleanup := func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}
+
+	_, err = tempFile.Write(buf.Bytes())
+	if err != nil {
+		cleanup()
+		return nil, 0, "", nil, errorsx.Wrap(err)
+	}
+
+	remainingSize, err := io.Copy(io.MultiWriter(tempFile, hasher), contents)
+	if err != nil {
+		cleanup()
+		return nil, 0, "", nil, errorsx.Wrap(err)
+	}
+
+	_, err = tempFile.Seek(0, io.SeekStart)
+	if err != nil {
+		cleanup()
+		return nil, 0, "", nil, errorsx.Wrap(err)
+	}
+
+	return tempFile, size + remainingSize, hex.EncodeToString(hasher.Sum(nil)), cleanup, nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) List(prefix string) ([]*ObjectInfo, errorsx.Error) {
+	objectNamePrefix := prefix
+	if b.config.Prefix != "" {
+		objectNamePrefix = b.config.Prefix + "/" + prefix
+	}
+
+	objectInfos := []*ObjectInfo{}
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", objectNamePrefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		result, err := b.listPage(query)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "prefix", prefix)
+		}
+
+		for _, content := range result.Contents {
+			key := strings.TrimPrefix(content.Key, b.getObjectName(""))
+			objectInfos = append(objectInfos, &ObjectInfo{key, content.Size, content.LastModified})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+
+		if result.NextContinuationToken == "" {
+			return nil, errorsx.Errorf("S3 listing was truncated, but there was no continuation token. Prefix: %q", prefix)
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+
+	// S3 lists keys in order of their UTF-8 bytes, so they are already sorted
+	return objectInfos, nil
+}
+
+func (b *S3Backend) listPage(query url.Values) (*s3ListBucketResult, errorsx.Error) {
+	u := b.getURL("", query)
+
+	resp, err := b.do(http.MethodGet, u, nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return nil, errorsx.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newS3ResponseError(resp, "")
+	}
+
+	result := new(s3ListBucketResult)
+	decodeErr := xml.NewDecoder(resp.Body).Decode(result)
+	if decodeErr != nil {
+		return nil, errorsx.Wrap(decodeErr)
+	}
+
+	return result, nil
+}
+
+func (b *S3Backend) Delete(key string) errorsx.Error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(http.MethodDelete, b.getURL(b.getObjectName(key), nil), nil, 0, emptyPayloadSHA256, nil)
+	if err != nil {
+		return errorsx.Wrap(err, "key", key)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return newS3ResponseError(resp, key)
+	}
+}
+
+func (b *S3Backend) String() string {
+	return fmt.Sprintf("s3://%s/%s", b.config.Bucket, b.config.Prefix)
+}
+
+type s3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// newS3ResponseError makes an error from a response that wasn't successful.
+// "Not found" responses have ErrNotFound as their cause, and failed conditional writes have ErrAlreadyExists as their cause.
+func newS3ResponseError(resp *http.Response, key string) errorsx.Error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errorsx.Wrap(ErrNotFound, "key", key)
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 is returned when another conditional write to the same key is in progress
+		return errorsx.Wrap(ErrAlreadyExists, "key", key)
+	}
+
+	errorResponse := new(s3ErrorResponse)
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	xmlErr := xml.Unmarshal(b, errorResponse)
+	if xmlErr != nil || errorResponse.Code == "" {
+		return errorsx.Errorf("unexpected response from S3 server: %s. Key: %q. Body: %q", resp.Status, key, string(b))
+	}
+
+	return errorsx.Errorf("error from S3 server: %s: %s (%s). Key: %q", resp.Status, errorResponse.Code, errorResponse.Message, key)
+}
@@ -0,0 +1,136 @@
+package storagebackend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Requests to S3-compatible servers are signed with AWS Signature Version 4.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+
+const (
+	s3SigningAlgorithm  = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat     = "20060102T150405Z"
+	s3ScopeDateFormat   = "20060102"
+	s3ServiceName       = "s3"
+	s3ScopeTerminator   = "aws4_request"
+	emptyPayloadSHA256  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	contentSHA256Header = "X-Amz-Content-Sha256"
+	amzDateHeader       = "X-Amz-Date"
+	securityTokenHeader = "X-Amz-Security-Token"
+)
+
+// signS3Request adds the headers that authenticate the request. payloadSHA256 is the hex encoded SHA-256 of the request body.
+func signS3Request(req *http.Request, credentials S3Credentials, region, payloadSHA256 string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3AmzDateFormat)
+	scopeDate := now.Format(s3ScopeDateFormat)
+
+	req.Header.Set(amzDateHeader, amzDate)
+	req.Header.Set(contentSHA256Header, payloadSHA256)
+	if credentials.SessionToken != "" {
+		req.Header.Set(securityTokenHeader, credentials.SessionToken)
+	}
+
+	headerNames := []string{"host"}
+	for name := range req.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") || lowerName == "if-none-match" || lowerName == "content-type" {
+			headerNames = append(headerNames, lowerName)
+		}
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := strings.TrimSpace(req.Header.Get(name))
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadSHA256,
+	}, "\n")
+
+	scope := strings.Join([]string{scopeDate, region, s3ServiceName, s3ScopeTerminator}, "/")
+	stringToSign := strings.Join([]string{
+		s3SigningAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), scopeDate)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, s3ServiceName)
+	signingKey = hmacSHA256(signingKey, s3ScopeTerminator)
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgorithm,
+		credentials.AccessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+func canonicalQueryString(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3URIEncode(name, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// s3URIEncode encodes every byte except the unreserved characters. Slashes are only encoded if encodeSlash is true.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isUnreserved := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~'
+		if isUnreserved || (c == '/' && !encodeSlash) {
+			builder.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&builder, "%%%02X", c)
+	}
+
+	return builder.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,317 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeS3Server is a small, in-memory, stand-in for an S3-compatible server, for tests.
+// It supports one bucket, and the operations the storage backend uses: GET, HEAD, PUT (including "If-None-Match: *"), DELETE and ListObjectsV2.
+// Every request must be signed (AWS Signature Version 4) with the server's credentials.
+type FakeS3Server struct {
+	*httptest.Server
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// MaxKeys is the most keys returned in one page of a listing. It can be set low to test paging.
+	MaxKeys int
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	contents     []byte
+	lastModified time.Time
+}
+
+// NewFakeS3Server starts a fake S3 server. It should be closed with Close when the test is finished.
+func NewFakeS3Server(bucket string) *FakeS3Server {
+	server := &FakeS3Server{
+		Bucket:          bucket,
+		Region:          "us-east-1",
+		AccessKeyID:     "test-access-key",
+		SecretAccessKey: "test-secret-key",
+		MaxKeys:         1000,
+		objects:         make(map[string]*fakeObject),
+	}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+
+	return server
+}
+
+// ObjectNames returns the names of all the objects in the bucket, sorted
+func (s *FakeS3Server) ObjectNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// GetObject returns the contents of an object, and whether it exists
+func (s *FakeS3Server) GetObject(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, false
+	}
+
+	return object.contents, true
+}
+
+func (s *FakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	err = s.checkSignature(r, body)
+	if err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucketPrefix := "/" + s.Bucket
+	if r.URL.Path != bucketPrefix && !strings.HasPrefix(r.URL.Path, bucketPrefix+"/") {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+		return
+	}
+
+	objectName := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPrefix), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if objectName == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported on the bucket")
+			return
+		}
+		s.list(w, r)
+		return
+	}
+
+	object, exists := s.objects[objectName]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+			return
+		}
+
+		contents := object.contents
+		statusCode := http.StatusOK
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			var start, end int
+			_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			if err != nil || start > end || start >= len(contents) {
+				writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable")
+				return
+			}
+			if end >= len(contents) {
+				end = len(contents) - 1
+			}
+			contents = contents[start : end+1]
+			statusCode = http.StatusPartialContent
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.Header().Set("Last-Modified", object.lastModified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(statusCode)
+		if r.Method == http.MethodGet {
+			w.Write(contents)
+		}
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "the key already exists")
+			return
+		}
+
+		s.objects[objectName] = &fakeObject{body, time.Now()}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(s.objects, objectName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name        `xml:"ListBucketResult"`
+	Name                  string          `xml:"Name"`
+	Prefix                string          `xml:"Prefix"`
+	KeyCount              int             `xml:"KeyCount"`
+	IsTruncated           bool            `xml:"IsTruncated"`
+	NextContinuationToken string          `xml:"NextContinuationToken,omitempty"`
+	Contents              []listedContent `xml:"Contents"`
+}
+
+type listedContent struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (s *FakeS3Server) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	// the continuation token is the last key of the previous page
+	startAfter := r.URL.Query().Get("continuation-token")
+
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) && name > startAfter {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := listBucketResult{Name: s.Bucket, Prefix: prefix}
+	if len(names) > s.MaxKeys {
+		names = names[:s.MaxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = names[len(names)-1]
+	}
+
+	for _, name := range names {
+		object := s.objects[name]
+		result.Contents = append(result.Contents, listedContent{
+			Key:          name,
+			Size:         len(object.contents),
+			LastModified: object.lastModified.UTC().Format(time.RFC3339),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// checkSignature re-calculates the request signature, and checks it matches the one on the request
+func (s *FakeS3Server) checkSignature(r *http.Request, body []byte) error {
+	authorization := r.Header.Get("Authorization")
+	const algorithmPrefix = "AWS4-HMAC-SHA256 "
+	if !strings.HasPrefix(authorization, algorithmPrefix) {
+		return fmt.Errorf("missing or unsupported Authorization header: %q", authorization)
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, algorithmPrefix), ",") {
+		nameAndValue := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(nameAndValue) != 2 {
+			return fmt.Errorf("malformed Authorization header: %q", authorization)
+		}
+		fields[nameAndValue[0]] = nameAndValue[1]
+	}
+
+	credentialParts := strings.Split(fields["Credential"], "/")
+	if len(credentialParts) != 5 || credentialParts[0] != s.AccessKeyID || credentialParts[2] != s.Region {
+		return fmt.Errorf("unknown credential: %q", fields["Credential"])
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	bodyHash := sha256.Sum256(body)
+	if payloadHash != hex.EncodeToString(bodyHash[:]) {
+		return fmt.Errorf("the X-Amz-Content-Sha256 header does not match the body")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(credentialParts[1:], "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		r.Header.Get("X-Amz-Date"),
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range credentialParts[1:] {
+		key = hmacSum(key, part)
+	}
+
+	expectedSignature := hex.EncodeToString(hmacSum(key, stringToSign))
+	if !hmac.Equal([]byte(expectedSignature), []byte(fields["Signature"])) {
+		return fmt.Errorf("the request signature does not match")
+	}
+
+	return nil
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, escape(name)+"="+escape(value))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+
+	b := bytes.NewBuffer(nil)
+	xml.NewEncoder(b).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+	w.Write(b.Bytes())
+}
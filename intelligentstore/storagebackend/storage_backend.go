@@ -0,0 +1,85 @@
+package storagebackend
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+)
+
+var (
+	// ErrNotFound is returned when there is no object at a key. It is os.ErrNotExist, so os.IsNotExist can be used to check for it.
+	ErrNotFound = os.ErrNotExist
+	// ErrAlreadyExists is returned by PutIfAbsent when there is already an object at the key. It is os.ErrExist, so os.IsExist can be used to check for it.
+	ErrAlreadyExists = os.ErrExist
+)
+
+// Backend is where the files of a store are kept.
+// Files are addressed by keys, which are slash-separated paths relative to the root of the store data, for example "objects/ab/cdef.gz".
+// Backends don't have directories or renames, so that a store can be kept in object storage as well as on a filesystem.
+type Backend interface {
+	// Get opens the object at the key. If there is no object at the key, the cause of the error is ErrNotFound.
+	Get(key string) (io.ReadCloser, errorsx.Error)
+	// GetRange opens length bytes of the object at the key, starting at offset.
+	// If the range goes past the end of the object, an error is returned.
+	GetRange(key string, offset, length int64) (io.ReadCloser, errorsx.Error)
+	// Stat gets information about the object at the key. If there is no object at the key, the cause of the error is ErrNotFound.
+	Stat(key string) (*ObjectInfo, errorsx.Error)
+	// Put writes the object at the key, replacing any object already there.
+	// Readers see either the old object or the new object, never a partly written one.
+	Put(key string, contents io.Reader) errorsx.Error
+	// PutIfAbsent writes the object at the key, only if there is no object there yet. If there is, the cause of the error is ErrAlreadyExists.
+	// The check and the write are a single operation, so it can be used to take locks.
+	PutIfAbsent(key string, contents io.Reader) errorsx.Error
+	// List lists the objects whose keys start with the prefix, sorted by key
+	List(prefix string) ([]*ObjectInfo, errorsx.Error)
+	// Delete deletes the object at the key. Deleting a key that has no object is not an error.
+	Delete(key string) errorsx.Error
+	// String describes where the backend keeps its objects, for messages to the user
+	String() string
+}
+
+// IncompleteFileRemover is implemented by backends that can be left with incomplete files after a crash, which are not objects and so are not listed or deleted through the Backend.
+type IncompleteFileRemover interface {
+	// RemoveIncompleteFiles removes the incomplete files last modified before the given time, and returns how many there were
+	RemoveIncompleteFiles(modifiedBefore time.Time, dryRun bool) (int64, errorsx.Error)
+}
+
+// ObjectInfo is information about an object in a backend
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Name is the last part of the key
+func (o *ObjectInfo) Name() string {
+	return o.Key[strings.LastIndex(o.Key, "/")+1:]
+}
+
+// validateKey checks a key is a relative path that stays inside the store
+func validateKey(key string) errorsx.Error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return errorsx.Errorf("invalid key: %q", key)
+	}
+
+	for _, fragment := range strings.Split(key, "/") {
+		if fragment == "" || fragment == "." || fragment == ".." {
+			return errorsx.Errorf("invalid key: %q", key)
+		}
+	}
+
+	return nil
+}
+
+// limitedReadCloser reads part of a file, and closes the whole file
+type limitedReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *limitedReadCloser) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,327 @@
+package storagebackend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/storagebackend/s3test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackend checks a backend behaves as described by the Backend interface. The backend should be empty.
+func testBackend(t *testing.T, backend Backend) {
+	readKey := func(t *testing.T, key string) []byte {
+		reader, err := backend.Get(key)
+		require.Nil(t, err)
+		defer reader.Close()
+
+		b, readErr := io.ReadAll(reader)
+		require.NoError(t, readErr)
+		return b
+	}
+
+	listKeys := func(t *testing.T, prefix string) []string {
+		objectInfos, err := backend.List(prefix)
+		require.Nil(t, err)
+
+		keys := []string{}
+		for _, objectInfo := range objectInfos {
+			keys = append(keys, objectInfo.Key)
+		}
+		return keys
+	}
+
+	t.Run("missing keys", func(t *testing.T) {
+		_, err := backend.Get("objects/ab/missing.gz")
+		assert.True(t, os.IsNotExist(errorsx.Cause(err)))
+
+		_, err = backend.Stat("objects/ab/missing.gz")
+		assert.Equal(t, ErrNotFound, errorsx.Cause(err))
+
+		assert.Equal(t, []string{}, listKeys(t, "objects/"))
+
+		err = backend.Delete("objects/ab/missing.gz")
+		assert.Nil(t, err)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "/abs", "a/../../b", "a//b", "dir/"} {
+			err := backend.Put(key, strings.NewReader("x"))
+			assert.Error(t, err, "key: %q", key)
+		}
+	})
+
+	t.Run("put, get, stat and list", func(t *testing.T) {
+		largeContents := bytes.Repeat([]byte("0123456789"), maxInMemoryPutSize/10+1)
+
+		require.Nil(t, backend.Put("store_metadata/buckets-data.json", strings.NewReader("[]")))
+		require.Nil(t, backend.Put("objects/ab/cdef.gz", strings.NewReader("contents 1")))
+		require.Nil(t, backend.Put("objects/ab/cdff.raw", strings.NewReader("")))
+		require.Nil(t, backend.Put("objects/ac/0123.zst", bytes.NewReader(largeContents)))
+		require.Nil(t, backend.Put("objectsbutnotreally", strings.NewReader("x")))
+
+		assert.Equal(t, []byte("contents 1"), readKey(t, "objects/ab/cdef.gz"))
+		assert.Equal(t, []byte{}, readKey(t, "objects/ab/cdff.raw"))
+		assert.Equal(t, largeContents, readKey(t, "objects/ac/0123.zst"))
+
+		objectInfo, err := backend.Stat("objects/ac/0123.zst")
+		require.Nil(t, err)
+		assert.Equal(t, "objects/ac/0123.zst", objectInfo.Key)
+		assert.Equal(t, "0123.zst", objectInfo.Name())
+		assert.Equal(t, int64(len(largeContents)), objectInfo.Size)
+
+		assert.Equal(t, []string{"objects/ab/cdef.gz", "objects/ab/cdff.raw", "objects/ac/0123.zst"}, listKeys(t, "objects/"))
+		assert.Equal(t, []string{"objects/ab/cdef.gz", "objects/ab/cdff.raw"}, listKeys(t, "objects/ab/"))
+		assert.Equal(t, []string{"objects/ab/cdef.gz"}, listKeys(t, "objects/ab/cde"))
+		assert.Equal(t, []string{"objects/ab/cdef.gz", "objects/ab/cdff.raw", "objects/ac/0123.zst", "objectsbutnotreally"}, listKeys(t, "objects"))
+		assert.Len(t, listKeys(t, ""), 5)
+
+		objectInfos, err := backend.List("objects/ab/cdef")
+		require.Nil(t, err)
+		require.Len(t, objectInfos, 1)
+		assert.Equal(t, int64(len("contents 1")), objectInfos[0].Size)
+	})
+
+	t.Run("get range", func(t *testing.T) {
+		readRange := func(t *testing.T, offset, length int64) []byte {
+			reader, err := backend.GetRange("objects/ab/cdef.gz", offset, length)
+			require.Nil(t, err)
+			defer reader.Close()
+
+			b, readErr := io.ReadAll(reader)
+			require.NoError(t, readErr)
+			return b
+		}
+
+		assert.Equal(t, []byte("contents"), readRange(t, 0, 8))
+		assert.Equal(t, []byte("1"), readRange(t, 9, 1))
+		assert.Equal(t, []byte{}, readRange(t, 3, 0))
+
+		_, err := backend.GetRange("objects/ab/cdef.gz", 5, 10)
+		assert.Error(t, err)
+
+		_, err = backend.GetRange("objects/ab/missing.gz", 0, 1)
+		assert.True(t, os.IsNotExist(errorsx.Cause(err)))
+	})
+
+	t.Run("put replaces", func(t *testing.T) {
+		require.Nil(t, backend.Put("store_metadata/buckets-data.json", strings.NewReader(`[{"id": 1}]`)))
+		assert.Equal(t, []byte(`[{"id": 1}]`), readKey(t, "store_metadata/buckets-data.json"))
+		assert.Equal(t, []string{"store_metadata/buckets-data.json"}, listKeys(t, "store_metadata/"))
+	})
+
+	t.Run("put if absent", func(t *testing.T) {
+		require.Nil(t, backend.PutIfAbsent("locks/store_lock.json", strings.NewReader("first")))
+
+		err := backend.PutIfAbsent("locks/store_lock.json", strings.NewReader("second"))
+		assert.Equal(t, ErrAlreadyExists, errorsx.Cause(err))
+		assert.Equal(t, []byte("first"), readKey(t, "locks/store_lock.json"))
+
+		require.Nil(t, backend.Delete("locks/store_lock.json"))
+		require.Nil(t, backend.PutIfAbsent("locks/store_lock.json", strings.NewReader("third")))
+		assert.Equal(t, []byte("third"), readKey(t, "locks/store_lock.json"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.Nil(t, backend.Delete("objects/ab/cdef.gz"))
+
+		_, err := backend.Get("objects/ab/cdef.gz")
+		assert.Equal(t, ErrNotFound, errorsx.Cause(err))
+		assert.Equal(t, []string{"objects/ab/cdff.raw"}, listKeys(t, "objects/ab/"))
+	})
+}
+
+func Test_FilesystemBackend(t *testing.T) {
+	fs := mockfs.NewMockFs()
+	require.Nil(t, fs.MkdirAll("/store/.backup_data", 0700))
+
+	backend := NewFilesystemBackend(fs, "/store/.backup_data")
+	testBackend(t, backend)
+
+	t.Run("files are laid out by key", func(t *testing.T) {
+		b, err := fs.ReadFile("/store/.backup_data/objects/ab/cdff.raw")
+		require.NoError(t, err)
+		assert.Equal(t, []byte{}, b)
+	})
+
+	t.Run("incomplete files and directories are not listed", func(t *testing.T) {
+		require.Nil(t, fs.MkdirAll("/store/.backup_data/objects/ff/emptydir", 0700))
+		require.Nil(t, fs.WriteFile("/store/.backup_data/objects/ff/"+incompleteFilePrefix+"123-abc.gz", []byte("partial"), 0600))
+
+		objectInfos, err := backend.List("objects/ff/")
+		require.Nil(t, err)
+		assert.Len(t, objectInfos, 0)
+	})
+
+	t.Run("failed writes leave nothing at the key", func(t *testing.T) {
+		failingReader := io.MultiReader(strings.NewReader("partial contents"), iotest.ErrReader(errors.New("disk full")))
+		err := backend.PutIfAbsent("objects/ee/abcd.gz", failingReader)
+		require.NotNil(t, err)
+
+		_, statErr := backend.Stat("objects/ee/abcd.gz")
+		assert.Equal(t, ErrNotFound, errorsx.Cause(statErr))
+
+		fileInfos, readDirErr := fs.ReadDir("/store/.backup_data/objects/ee")
+		require.NoError(t, readDirErr)
+		assert.Len(t, fileInfos, 0)
+	})
+
+	t.Run("files are moved into place without hard links only if absent", func(t *testing.T) {
+		filePath := "/store/.backup_data/locks/bucket_lock.json"
+		require.Nil(t, fs.MkdirAll("/store/.backup_data/locks", 0700))
+
+		firstIncompleteFilePath := getIncompleteFilePath(filePath)
+		require.Nil(t, fs.WriteFile(firstIncompleteFilePath, []byte("first"), 0600))
+		secondIncompleteFilePath := getIncompleteFilePath(filePath)
+		require.Nil(t, fs.WriteFile(secondIncompleteFilePath, []byte("second"), 0600))
+
+		require.Nil(t, backend.moveIntoPlaceIfAbsent(firstIncompleteFilePath, filePath))
+
+		err := backend.moveIntoPlaceIfAbsent(secondIncompleteFilePath, filePath)
+		assert.Equal(t, ErrAlreadyExists, errorsx.Cause(err))
+		require.Nil(t, fs.Remove(secondIncompleteFilePath))
+
+		b, readErr := fs.ReadFile(filePath)
+		require.NoError(t, readErr)
+		assert.Equal(t, []byte("first"), b)
+	})
+
+	t.Run("remove incomplete files", func(t *testing.T) {
+		incompleteFilePath := "/store/.backup_data/objects/ff/" + incompleteFilePrefix + "123-abc.gz"
+		fileInfo, err := fs.Stat(incompleteFilePath)
+		require.NoError(t, err)
+
+		// files modified since are left alone, as they could still be being written to
+		count, removeErr := backend.RemoveIncompleteFiles(fileInfo.ModTime(), false)
+		require.Nil(t, removeErr)
+		assert.Equal(t, int64(0), count)
+
+		count, removeErr = backend.RemoveIncompleteFiles(fileInfo.ModTime().Add(time.Second), true)
+		require.Nil(t, removeErr)
+		assert.Equal(t, int64(1), count)
+
+		_, err = fs.Stat(incompleteFilePath)
+		require.NoError(t, err)
+
+		count, removeErr = backend.RemoveIncompleteFiles(fileInfo.ModTime().Add(time.Second), false)
+		require.Nil(t, removeErr)
+		assert.Equal(t, int64(1), count)
+
+		_, err = fs.Stat(incompleteFilePath)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func Test_FilesystemBackend_osFs(t *testing.T) {
+	basePath := t.TempDir()
+
+	backend := NewFilesystemBackend(gofs.NewOsFs(), basePath)
+	require.NotNil(t, backend.linkFunc)
+	testBackend(t, backend)
+
+	t.Run("no incomplete files are left", func(t *testing.T) {
+		fileInfos, err := os.ReadDir(filepath.Join(basePath, "locks"))
+		require.NoError(t, err)
+		require.Len(t, fileInfos, 1)
+		assert.Equal(t, "store_lock.json", fileInfos[0].Name())
+
+		fileInfos, err = os.ReadDir(filepath.Join(basePath, "objects", "ab"))
+		require.NoError(t, err)
+		require.Len(t, fileInfos, 1)
+		assert.Equal(t, "cdff.raw", fileInfos[0].Name())
+	})
+}
+
+func Test_S3Backend(t *testing.T) {
+	newBackend := func(t *testing.T, server *s3test.FakeS3Server, prefix string) *S3Backend {
+		backend, err := NewS3Backend(S3Config{
+			Endpoint: server.URL,
+			Region:   server.Region,
+			Bucket:   server.Bucket,
+			Prefix:   prefix,
+			Credentials: S3Credentials{
+				AccessKeyID:     server.AccessKeyID,
+				SecretAccessKey: server.SecretAccessKey,
+			},
+		})
+		require.Nil(t, err)
+		return backend
+	}
+
+	t.Run("without prefix", func(t *testing.T) {
+		server := s3test.NewFakeS3Server("backups")
+		defer server.Close()
+		// small pages, so that paging through listings is tested
+		server.MaxKeys = 2
+
+		testBackend(t, newBackend(t, server, ""))
+	})
+
+	t.Run("with prefix", func(t *testing.T) {
+		server := s3test.NewFakeS3Server("backups")
+		defer server.Close()
+
+		require.Nil(t, newBackend(t, server, "other-data").Put("objects/ab/cdef.gz", strings.NewReader("not in this store")))
+
+		backend := newBackend(t, server, "/my-store/")
+		testBackend(t, backend)
+
+		assert.Contains(t, server.ObjectNames(), "my-store/objects/ab/cdff.raw")
+		assert.Equal(t, "s3://backups/my-store", backend.String())
+	})
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		server := s3test.NewFakeS3Server("backups")
+		defer server.Close()
+
+		backend := newBackend(t, server, "")
+		backend.config.Credentials.SecretAccessKey = "wrong"
+
+		err := backend.Put("objects/ab/cdef.gz", strings.NewReader("x"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SignatureDoesNotMatch")
+		assert.Len(t, server.ObjectNames(), 0)
+	})
+
+	t.Run("real server", func(t *testing.T) {
+		// set these to run the tests against a real S3-compatible server (for example a local MinIO). A new prefix is used for each run.
+		endpoint := os.Getenv("INTELLIGENT_STORE_TEST_S3_ENDPOINT")
+		if endpoint == "" {
+			t.Skip("INTELLIGENT_STORE_TEST_S3_ENDPOINT not set")
+		}
+
+		backend, err := NewS3Backend(S3Config{
+			Endpoint: endpoint,
+			Region:   os.Getenv("INTELLIGENT_STORE_TEST_S3_REGION"),
+			Bucket:   os.Getenv("INTELLIGENT_STORE_TEST_S3_BUCKET"),
+			Prefix:   fmt.Sprintf("intelligent-store-test-%d", time.Now().UnixNano()),
+			Credentials: S3Credentials{
+				AccessKeyID:     os.Getenv("INTELLIGENT_STORE_TEST_S3_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("INTELLIGENT_STORE_TEST_S3_SECRET_ACCESS_KEY"),
+			},
+		})
+		require.Nil(t, err)
+
+		testBackend(t, backend)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewS3Backend(S3Config{Endpoint: "ftp://example.com", Bucket: "backups"})
+		assert.Error(t, err)
+
+		_, err = NewS3Backend(S3Config{Endpoint: "http://localhost:9000"})
+		assert.Error(t, err)
+	})
+}
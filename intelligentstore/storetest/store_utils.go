@@ -56,7 +56,7 @@ func CreateRevision(
 		mapOfHashes[descriptorWithContents.Descriptor.Hash] = descriptorWithContents
 	}
 
-	hashes, err := tx.ProcessUploadHashesAndGetRequiredHashes(relativePathsWithHashes)
+	hashes, err := store.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, relativePathsWithHashes)
 	require.Nil(t, err)
 
 	for _, hash := range hashes {
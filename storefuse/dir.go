@@ -20,10 +20,18 @@ type Dir struct {
 	fs         *StoreFS
 	dirEntries []fuse.Dirent
 	name       string
+	// fileInfo is the directory in the revision. It is nil for the directories above the revisions (the buckets and revisions)
+	fileInfo *intelligentstore.FileInfo
 }
 
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	a.Mode = os.ModeDir | 0700
+	if d.fileInfo == nil {
+		a.Mode = os.ModeDir | 0700
+		return nil
+	}
+
+	a.Mode = os.ModeDir | d.fileInfo.FileMode.Perm()
+	a.Mtime = d.fileInfo.ModTime
 	return nil
 }
 
@@ -54,6 +62,7 @@ func (d *Dir) lookupRevisionsDir(bucket *intelligentstore.Bucket) (fs.Node, erro
 		d.fs,
 		dirEntries,
 		pathInFs,
+		nil,
 	}, nil
 }
 
@@ -100,6 +109,7 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 			fileDescriptor,
 		}, nil
 	case intelligentstore.FileTypeDir:
+		// the directory is either recorded in the revision, or made up from the paths of the files in it (for revisions from before directories were recorded)
 		dirFileDescriptor := fileDescriptor.(*intelligentstore.DirectoryFileDescriptor)
 		var dirEntries []fuse.Dirent
 		dirEntryDescriptors, err := d.fs.dal.RevisionDAL.ReadDir(bucket, revision, dirFileDescriptor.GetFileInfo().RelativePath)
@@ -132,6 +142,7 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 			d.fs,
 			dirEntries,
 			pathInFs,
+			dirFileDescriptor.GetFileInfo(),
 		}, nil
 	default:
 		return nil, fmt.Errorf("unknown type: %s at %q", fileDescriptor.GetFileInfo().Type, fileDescriptor.GetFileInfo().RelativePath)
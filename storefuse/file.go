@@ -39,6 +39,11 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 	}
 
 	regularDescriptor := (f.descriptor).(*intelligentstore.RegularFileDescriptor)
+	if len(regularDescriptor.Chunks) != 0 {
+		resp.Data, err = f.readChunks(regularDescriptor.Chunks, req.Offset, req.Size)
+		return err
+	}
+
 	object, err := f.dal.GetObjectByHash(regularDescriptor.Hash)
 	if nil != err {
 		return err
@@ -57,3 +62,46 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 	resp.Data = fileBytes[req.Offset:(req.Offset + int64(amountOfBytesToRead))]
 	return nil
 }
+
+// readChunks reads the requested range of a file stored as chunks, only reading the chunks that hold that range
+func (f *File) readChunks(chunks []*intelligentstore.Chunk, offset int64, size int) ([]byte, error) {
+	var data []byte
+	end := offset + int64(size)
+
+	var chunkStart int64
+	for _, chunk := range chunks {
+		if chunkStart >= end {
+			break
+		}
+
+		chunkEnd := chunkStart + chunk.Size
+		if chunkEnd > offset {
+			object, err := f.dal.GetObjectByHash(chunk.Hash)
+			if nil != err {
+				return nil, err
+			}
+
+			chunkBytes, readErr := io.ReadAll(object)
+			object.Close()
+			if nil != readErr {
+				return nil, readErr
+			}
+
+			from := int64(0)
+			if offset > chunkStart {
+				from = offset - chunkStart
+			}
+
+			to := int64(len(chunkBytes))
+			if end < chunkEnd {
+				to = end - chunkStart
+			}
+
+			data = append(data, chunkBytes[from:to]...)
+		}
+
+		chunkStart = chunkEnd
+	}
+
+	return data, nil
+}
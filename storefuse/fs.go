@@ -42,6 +42,7 @@ func (fs *StoreFS) Root() (fs.Node, error) {
 		fs,
 		dirEntries,
 		"/",
+		nil,
 	}, nil
 }
 
@@ -17,7 +17,20 @@ func NewStoreFUSE(dal *dal.IntelligentStoreDAL) *StoreFUSE {
 	return &StoreFUSE{dal}
 }
 
+// Mount mounts the store at the path, and serves it until it is unmounted.
+// A shared lock is held while it is mounted, so that maintenance (like garbage collection or pruning) doesn't remove the revisions and objects being read.
 func (f *StoreFUSE) Mount(onPath string) errorsx.Error {
+	sharedLock, lockErr := f.dal.LockDAL.AcquireSharedLock("lock from mounting the store at " + onPath)
+	if nil != lockErr {
+		return lockErr
+	}
+	defer func() {
+		releaseErr := f.dal.LockDAL.ReleaseSharedLock(sharedLock)
+		if releaseErr != nil {
+			log.Printf("failed to release shared lock after unmounting. Error: %q\n", releaseErr)
+		}
+	}()
+
 	conn, err := fuse.Mount(onPath)
 	if nil != err {
 		return errorsx.Wrap(err)
@@ -29,11 +29,9 @@ type BucketService struct {
 	logger *logpkg.Logger
 	store  *dal.IntelligentStoreDAL
 	http.Handler
-	openTransactionsMap
+	openTransactions *openTransactionRegistry
 }
 
-type openTransactionsMap map[string]*intelligentstore.Transaction
-
 type subDirInfo struct {
 	Name string `json:"name"`
 }
@@ -41,15 +39,20 @@ type subDirInfo struct {
 // NewBucketService creates a new BucketService and a router for handling requests.
 func NewBucketService(logger *logpkg.Logger, store *dal.IntelligentStoreDAL) *BucketService {
 	router := chi.NewRouter()
-	bucketService := &BucketService{logger, store, router, make(openTransactionsMap)}
+	openTransactions := newOpenTransactionRegistry(store, time.Now, openTransactionIdleTimeout)
+	bucketService := &BucketService{logger, store, router, openTransactions}
+
+	go openTransactions.expireIdlePeriodically(openTransactionExpiryInterval)
 
 	router.Get("/", bucketService.handleGetAllBuckets)
+	router.Post("/", bucketService.handleCreateBucket)
 	router.Get("/{bucketName}", bucketService.handleGetBucket)
 	router.Post("/{bucketName}/upload", bucketService.handleCreateRevision)
 	router.Post("/{bucketName}/upload/{revisionTs}/symlinks", bucketService.handleUploadSymlinks)
 	router.Post("/{bucketName}/upload/{revisionTs}/hashes", bucketService.handleUploadHashes)
 	router.Post("/{bucketName}/upload/{revisionTs}/file", bucketService.handleUploadFile)
 	router.Get("/{bucketName}/upload/{revisionTs}/commit", bucketService.handleCommitTransaction)
+	router.Delete("/{bucketName}/upload/{revisionTs}", bucketService.handleAbortTransaction)
 
 	router.Get("/{bucketName}/{revisionTs}", bucketService.handleGetRevision)
 	router.Get("/{bucketName}/{revisionTs}/file", bucketService.handleGetFileContents)
@@ -63,6 +66,7 @@ type bucketSummary struct {
 
 type revisionInfoWithFiles struct {
 	LastRevisionTs intelligentstore.RevisionVersion  `json:"revisionTs"`
+	Info           *intelligentstore.RevisionInfo    `json:"info"`
 	Files          []intelligentstore.FileDescriptor `json:"files"`
 	Dirs           []*subDirInfo                     `json:"dirs"`
 }
@@ -109,8 +113,14 @@ func (s *BucketService) handleGetAllBuckets(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// revisionWithInfo is a revision and its info. The info is nil for revisions committed before revision infos were recorded.
+type revisionWithInfo struct {
+	*intelligentstore.Revision
+	Info *intelligentstore.RevisionInfo `json:"info"`
+}
+
 type handleGetBucketResponse struct {
-	Revisions []*intelligentstore.Revision `json:"revisions"`
+	Revisions []*revisionWithInfo `json:"revisions"`
 }
 
 func (s *BucketService) handleGetBucket(w http.ResponseWriter, r *http.Request) {
@@ -118,7 +128,7 @@ func (s *BucketService) handleGetBucket(w http.ResponseWriter, r *http.Request)
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, err.Error(), 404)
 			return
 		}
@@ -132,15 +142,48 @@ func (s *BucketService) handleGetBucket(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(revisions) == 0 {
-		revisions = make([]*intelligentstore.Revision, 0)
-	}
-
 	sort.Slice(revisions, func(i int, j int) bool {
 		return revisions[i].VersionTimestamp < revisions[j].VersionTimestamp
 	})
 
-	render.JSON(w, r, handleGetBucketResponse{revisions})
+	revisionsWithInfo := make([]*revisionWithInfo, 0, len(revisions))
+	for _, revision := range revisions {
+		revisionInfo, err := s.store.RevisionDAL.GetRevisionInfo(revision)
+		if nil != err {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		revisionsWithInfo = append(revisionsWithInfo, &revisionWithInfo{revision, revisionInfo})
+	}
+
+	render.JSON(w, r, handleGetBucketResponse{revisionsWithInfo})
+}
+
+type createBucketRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *BucketService) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
+	var request createBucketRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if nil != err {
+		http.Error(w, fmt.Sprintf("couldn't decode the request body. Error: %s", err), 400)
+		return
+	}
+
+	bucket, err := s.store.BucketDAL.CreateBucket(request.Name)
+	if nil != err {
+		if errorsx.Cause(err) == dal.ErrBucketNameAlreadyTaken {
+			http.Error(w, err.Error(), 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, bucket)
 }
 
 func (s *BucketService) getRevision(bucketName, revisionTsString string) (*intelligentstore.Revision, *HTTPError) {
@@ -157,7 +200,7 @@ func (s *BucketService) getRevision(bucketName, revisionTsString string) (*intel
 	var revision *intelligentstore.Revision
 	if revisionTsString == "latest" {
 		revision, err = s.store.RevisionDAL.GetLatestRevision(bucket)
-		if dal.ErrNoRevisionsForBucket == err {
+		if dal.ErrNoRevisionsForBucket == errorsx.Cause(err) {
 			return nil, NewHTTPError(err, 404)
 		}
 	} else {
@@ -178,10 +221,39 @@ func (s *BucketService) getRevision(bucketName, revisionTsString string) (*intel
 	return revision, nil
 }
 
+// acquireSharedLock takes a shared lock for a request that reads revisions or objects, so that maintenance (like garbage collection or pruning) doesn't remove them while they are being read.
+// If the lock can't be taken, the error is written to the response, and nil is returned.
+func (s *BucketService) acquireSharedLock(w http.ResponseWriter, text string) *dal.SharedLock {
+	sharedLock, err := s.store.LockDAL.AcquireSharedLock(text)
+	if nil != err {
+		if errorsx.Cause(err) == dal.ErrLockAlreadyTaken {
+			http.Error(w, "the store is locked for maintenance. Error: "+err.Error(), http.StatusServiceUnavailable)
+			return nil
+		}
+		http.Error(w, err.Error(), 500)
+		return nil
+	}
+
+	return sharedLock
+}
+
+func (s *BucketService) releaseSharedLock(sharedLock *dal.SharedLock) {
+	err := s.store.LockDAL.ReleaseSharedLock(sharedLock)
+	if nil != err {
+		log.Printf("failed to release shared lock. Error: %q\n", err)
+	}
+}
+
 func (s *BucketService) handleGetRevision(w http.ResponseWriter, r *http.Request) {
 	bucketName := chi.URLParam(r, "bucketName")
 	revisionTsString := chi.URLParam(r, "revisionTs")
 
+	sharedLock := s.acquireSharedLock(w, "lock from reading a revision over the web server")
+	if nil == sharedLock {
+		return
+	}
+	defer s.releaseSharedLock(sharedLock)
+
 	revision, revErr := s.getRevision(bucketName, revisionTsString)
 	if nil != revErr {
 		http.Error(w, revErr.Error(), revErr.StatusCode)
@@ -196,8 +268,15 @@ func (s *BucketService) handleGetRevision(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	revisionInfo, err := s.store.RevisionDAL.GetRevisionInfo(revision)
+	if err != nil {
+		errorsx.HTTPError(w, s.logger, errorsx.Wrap(err), http.StatusInternalServerError)
+		return
+	}
+
 	data := revisionInfoWithFiles{
 		LastRevisionTs: revision.VersionTimestamp,
+		Info:           revisionInfo,
 		Files:          []intelligentstore.FileDescriptor{},
 		Dirs:           []*subDirInfo{},
 	}
@@ -206,7 +285,7 @@ func (s *BucketService) handleGetRevision(w http.ResponseWriter, r *http.Request
 		switch descriptor.GetFileInfo().Type {
 		case intelligentstore.FileTypeDir:
 			data.Dirs = append(data.Dirs, &subDirInfo{Name: descriptor.GetFileInfo().RelativePath.Name()})
-		case intelligentstore.FileTypeRegular, intelligentstore.FileTypeSymlink:
+		case intelligentstore.FileTypeRegular, intelligentstore.FileTypeSymlink, intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
 			data.Files = append(data.Files, descriptor)
 		default:
 			http.Error(w, fmt.Sprintf("unhandled file type: %q", descriptor.GetFileInfo().Type), http.StatusInternalServerError)
@@ -224,7 +303,7 @@ func (s *BucketService) handleCreateRevision(w http.ResponseWriter, r *http.Requ
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
 			return
 		}
@@ -266,35 +345,136 @@ func (s *BucketService) handleCreateRevision(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		fileInfos = append(
-			fileInfos,
-			intelligentstore.NewFileInfo(
-				fileType,
-				intelligentstore.NewRelativePath(fileInfoProto.GetRelativePath()),
-				time.Unix(fileInfoProto.GetModTime(), 0), // FIXME is this right?
-				fileInfoProto.GetSize(),
-				os.FileMode(fileInfoProto.GetMode()),
-			),
+		fileInfo := intelligentstore.NewFileInfo(
+			fileType,
+			intelligentstore.NewRelativePath(fileInfoProto.GetRelativePath()),
+			time.Unix(fileInfoProto.GetModTime(), int64(fileInfoProto.GetModTimeNanos())),
+			fileInfoProto.GetSize(),
+			os.FileMode(fileInfoProto.GetMode()),
 		)
+		fileInfo.HardLinkGroup = fileInfoProto.GetHardLinkGroup()
+
+		deviceNumberProto := fileInfoProto.GetDeviceNumber()
+		if nil != deviceNumberProto {
+			fileInfo.DeviceNumber = intelligentstore.NewDeviceNumber(deviceNumberProto.GetMajor(), deviceNumberProto.GetMinor())
+		}
+
+		for _, holeProto := range fileInfoProto.GetHoles() {
+			fileInfo.Holes = append(fileInfo.Holes, intelligentstore.NewFileRange(holeProto.GetOffset(), holeProto.GetLength()))
+		}
+
+		ownershipProto := fileInfoProto.GetOwnership()
+		if nil != ownershipProto {
+			fileInfo.Ownership = intelligentstore.NewFileOwnership(ownershipProto.GetUid(), ownershipProto.GetGid())
+		}
+
+		for _, extendedAttributeProto := range fileInfoProto.GetExtendedAttributes() {
+			if nil == fileInfo.ExtendedAttributes {
+				fileInfo.ExtendedAttributes = make(intelligentstore.ExtendedAttributes)
+			}
+			fileInfo.ExtendedAttributes[extendedAttributeProto.GetName()] = extendedAttributeProto.GetValue()
+		}
+
+		fileInfos = append(fileInfos, fileInfo)
 	}
 
-	transaction, err := s.store.TransactionDAL.CreateTransaction(bucket, fileInfos)
+	// a transaction on the bucket that is still open here, but that no request is using, is from an upload that was interrupted. It is rolled back, so this one can resume it.
+	// A transaction that a request is using is still being uploaded to, so it is left alone, and this one can't be opened yet.
+	err = s.openTransactions.rollbackBucket(bucket)
 	if nil != err {
+		if errorsx.Cause(err) == errTransactionInUse {
+			http.Error(w, "there is a transaction on the bucket that is still being uploaded to. Error: "+err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "couldn't roll back the open transaction on the bucket. Error: "+err.Error(), 500)
+		return
+	}
+
+	if r.URL.Query().Get("resume") == "false" {
+		err = s.store.TransactionDAL.DiscardInterruptedTransaction(bucket)
+		if nil != err {
+			http.Error(w, "couldn't discard the interrupted transaction on the bucket. Error: "+err.Error(), 500)
+			return
+		}
+	}
+
+	// files that look unchanged are hashed again if the client asks for them to be checked
+	transactionOptions := dal.TransactionOptions{
+		Checksum: r.URL.Query().Get("checksum") == "true",
+		DryRun:   r.URL.Query().Get("dryRun") == "true",
+		Source: intelligentstore.RevisionSource{
+			Message:      r.URL.Query().Get("message"),
+			Tags:         r.URL.Query()["tag"],
+			Hostname:     r.URL.Query().Get("hostname"),
+			SourcePath:   r.URL.Query().Get("sourcePath"),
+			UploaderType: intelligentstore.UploaderTypeWeb,
+		},
+	}
+
+	rehashOlderThanString := r.URL.Query().Get("rehashOlderThan")
+	if rehashOlderThanString != "" {
+		transactionOptions.RehashOlderThan, err = time.ParseDuration(rehashOlderThanString)
+		if nil != err {
+			http.Error(w, fmt.Sprintf("couldn't convert '%s' to a duration. Error: '%s'", rehashOlderThanString, err), 400)
+			return
+		}
+	}
+
+	var transaction *intelligentstore.Transaction
+	revisionVersionString := r.URL.Query().Get("revisionVersion")
+	if revisionVersionString == "" {
+		transaction, err = s.store.TransactionDAL.CreateTransactionWithOptions(bucket, fileInfos, transactionOptions)
+	} else {
+		// revisions replicated from another store keep their version
+		var revisionVersion int64
+		revisionVersion, err = strconv.ParseInt(revisionVersionString, 10, 64)
+		if nil != err {
+			http.Error(w, fmt.Sprintf("couldn't convert '%s' to a revision version. Error: '%s'", revisionVersionString, err), 400)
+			return
+		}
+
+		// and their info, which is sent as JSON ("null" if the revision doesn't have one)
+		var revisionInfo *intelligentstore.RevisionInfo
+		revisionInfoString := r.URL.Query().Get("revisionInfo")
+		if revisionInfoString != "" {
+			err = json.Unmarshal([]byte(revisionInfoString), &revisionInfo)
+			if nil != err {
+				http.Error(w, fmt.Sprintf("couldn't decode the revision info. Error: '%s'", err), 400)
+				return
+			}
+		}
+
+		transaction, err = s.store.TransactionDAL.CreateTransactionForRevisionVersion(bucket, intelligentstore.RevisionVersion(revisionVersion), fileInfos, revisionInfo)
+	}
+	if nil != err {
+		if errorsx.Cause(err) == dal.ErrRevisionAlreadyExists {
+			http.Error(w, err.Error(), 409)
+			return
+		}
 		http.Error(w, "couldn't start a transaction. Error: "+err.Error(), 500)
 		return
 	}
 
-	transactionString := fmt.Sprintf("%s__%d", bucket.BucketName, transaction.Revision.VersionTimestamp)
-	s.openTransactionsMap[transactionString] = transaction
+	s.openTransactions.add(transaction)
 
 	var relativePaths []string
 	for _, relativePath := range transaction.GetRelativePathsRequired() {
 		relativePaths = append(relativePaths, string(relativePath))
 	}
 
+	var knownHashes []*protofiles.RelativePathAndHashProto
+	for relativePath, hash := range transaction.KnownHashes {
+		knownHashes = append(knownHashes, &protofiles.RelativePathAndHashProto{
+			RelativePath: string(relativePath),
+			Hash:         string(hash),
+		})
+	}
+
 	openTxReponse := &protofiles.OpenTxResponse{
 		RevisionID:            int64(transaction.Revision.VersionTimestamp),
 		RequiredRelativePaths: relativePaths,
+		HashAlgorithm:         string(transaction.HashAlgorithm),
+		KnownHashes:           knownHashes,
 	}
 
 	responseBytes, err := proto.Marshal(openTxReponse)
@@ -319,6 +499,16 @@ func fileTypeProtoToFileType(protoFileType protofiles.FileType) (intelligentstor
 		return intelligentstore.FileTypeRegular, nil
 	case protofiles.FileType_SYMLINK:
 		return intelligentstore.FileTypeSymlink, nil
+	case protofiles.FileType_DIRECTORY:
+		return intelligentstore.FileTypeDir, nil
+	case protofiles.FileType_FIFO:
+		return intelligentstore.FileTypeFIFO, nil
+	case protofiles.FileType_CHAR_DEVICE:
+		return intelligentstore.FileTypeCharDevice, nil
+	case protofiles.FileType_BLOCK_DEVICE:
+		return intelligentstore.FileTypeBlockDevice, nil
+	case protofiles.FileType_SOCKET:
+		return intelligentstore.FileTypeSocket, nil
 	default:
 		return intelligentstore.FileTypeUnknown, errors.New("didn't recognise proto file type: " + protoFileType.String())
 	}
@@ -332,7 +522,7 @@ func (s *BucketService) handleUploadFile(w http.ResponseWriter, r *http.Request)
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
 			return
 		}
@@ -340,11 +530,13 @@ func (s *BucketService) handleUploadFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	transaction := s.openTransactionsMap[bucket.BucketName+"__"+revisionTsString]
-	if nil == transaction {
+	openTx := s.openTransactions.acquire(bucket.BucketName, revisionTsString)
+	if nil == openTx {
 		http.Error(w, fmt.Sprintf("there is no open transaction for bucket %s and revisionTs %s", bucket.BucketName, revisionTsString), 400)
 		return
 	}
+	defer s.openTransactions.release(openTx)
+	transaction := openTx.transaction
 
 	bodyBytes, err := ioutil.ReadAll(r.Body)
 	if nil != err {
@@ -378,7 +570,7 @@ func (s *BucketService) handleCommitTransaction(w http.ResponseWriter, r *http.R
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
 			return
 		}
@@ -386,18 +578,71 @@ func (s *BucketService) handleCommitTransaction(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	transaction := s.openTransactionsMap[bucketName+"__"+revisionTsString]
-	if nil == transaction {
+	// the transaction is taken out of the open transactions while it is committed, so no other request can use it at the same time
+	openTx, err := s.openTransactions.take(bucket.BucketName, revisionTsString)
+	if nil != err {
+		if errorsx.Cause(err) == errTransactionInUse {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if nil == openTx {
 		http.Error(w, fmt.Sprintf("there is no open transaction for bucket %s and revisionTs %s", bucket.BucketName, revisionTsString), 400)
 		return
 	}
 
-	err = s.store.TransactionDAL.Commit(transaction)
+	err = s.store.TransactionDAL.Commit(openTx.transaction)
 	if nil != err {
+		s.openTransactions.putBack(openTx)
 		http.Error(w, "failed to commit transaction. Error: "+err.Error(), 500)
 		return
 	}
-	s.openTransactionsMap[bucketName+"__"+revisionTsString] = nil
+}
+
+// handleAbortTransaction aborts an open transaction. Unlike a transaction that was interrupted, it isn't resumed by the next upload into the bucket
+func (s *BucketService) handleAbortTransaction(w http.ResponseWriter, r *http.Request) {
+	bucketName := chi.URLParam(r, "bucketName")
+	revisionTsString := chi.URLParam(r, "revisionTs")
+
+	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
+	if nil != err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
+			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	openTx, err := s.openTransactions.take(bucket.BucketName, revisionTsString)
+	if nil != err {
+		if errorsx.Cause(err) == errTransactionInUse {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if nil == openTx {
+		http.Error(w, fmt.Sprintf("there is no open transaction for bucket %s and revisionTs %s", bucket.BucketName, revisionTsString), 404)
+		return
+	}
+
+	err = s.store.TransactionDAL.Abort(openTx.transaction)
+	if nil != err {
+		s.openTransactions.putBack(openTx)
+		http.Error(w, "failed to abort transaction. Error: "+err.Error(), 500)
+		return
+	}
+}
+
+// handleGetOpenTransactions gets the transactions opened by clients that are still open, with their progress
+func (s *BucketService) handleGetOpenTransactions(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, s.openTransactions.list())
 }
 
 func (s *BucketService) handleGetFileContents(w http.ResponseWriter, r *http.Request) {
@@ -407,6 +652,13 @@ func (s *BucketService) handleGetFileContents(w http.ResponseWriter, r *http.Req
 	revisionTsString := chi.URLParam(r, "revisionTs")
 
 	relativePath := intelligentstore.NewRelativePath(r.URL.Query().Get("relativePath"))
+
+	sharedLock := s.acquireSharedLock(w, "lock from downloading a file over the web server")
+	if nil == sharedLock {
+		return
+	}
+	defer s.releaseSharedLock(sharedLock)
+
 	revision, revErr := s.getRevision(bucketName, revisionTsString)
 	if nil != revErr {
 		http.Error(w, revErr.Error(), revErr.StatusCode)
@@ -415,7 +667,7 @@ func (s *BucketService) handleGetFileContents(w http.ResponseWriter, r *http.Req
 
 	file, err := s.store.RevisionDAL.GetFileContentsInRevision(revision.Bucket, revision, relativePath)
 	if nil != err {
-		if err == dal.ErrNoFileWithThisRelativePathInRevision {
+		if errorsx.Cause(err) == dal.ErrNoFileWithThisRelativePathInRevision {
 			http.Error(w, fmt.Sprintf("couldn't get '%s'", relativePath), 404)
 			return
 		}
@@ -439,7 +691,7 @@ func (s *BucketService) handleUploadHashes(w http.ResponseWriter, r *http.Reques
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
 			return
 		}
@@ -447,11 +699,13 @@ func (s *BucketService) handleUploadHashes(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	transaction := s.openTransactionsMap[bucketName+"__"+revisionTsString]
-	if nil == transaction {
+	openTx := s.openTransactions.acquire(bucket.BucketName, revisionTsString)
+	if nil == openTx {
 		http.Error(w, fmt.Sprintf("there is no open transaction for bucket %s and revisionTs %s", bucket.BucketName, revisionTsString), 400)
 		return
 	}
+	defer s.openTransactions.release(openTx)
+	transaction := openTx.transaction
 
 	body, err := ioutil.ReadAll(r.Body)
 	if nil != err {
@@ -474,7 +728,7 @@ func (s *BucketService) handleUploadHashes(w http.ResponseWriter, r *http.Reques
 		})
 	}
 
-	hashes, err := transaction.ProcessUploadHashesAndGetRequiredHashes(relativePathsWithHashes)
+	hashes, err := s.store.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(transaction, relativePathsWithHashes)
 	if nil != err {
 		http.Error(w, fmt.Sprintf("couldn't process upload hashes and get required uploads. Error: %s", err.Error()), 500)
 		return
@@ -513,7 +767,7 @@ func (s *BucketService) handleUploadSymlinks(w http.ResponseWriter, r *http.Requ
 
 	bucket, err := s.store.BucketDAL.GetBucketByName(bucketName)
 	if nil != err {
-		if dal.ErrBucketDoesNotExist == err {
+		if dal.ErrBucketDoesNotExist == errorsx.Cause(err) {
 			http.Error(w, fmt.Sprintf("couldn't find bucket '%s'. Error: %s", bucketName, err), 404)
 			return
 		}
@@ -521,11 +775,13 @@ func (s *BucketService) handleUploadSymlinks(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	transaction := s.openTransactionsMap[bucketName+"__"+revisionTsString]
-	if nil == transaction {
+	openTx := s.openTransactions.acquire(bucket.BucketName, revisionTsString)
+	if nil == openTx {
 		http.Error(w, fmt.Sprintf("there is no open transaction for bucket %s and revisionTs %s", bucket.BucketName, revisionTsString), 400)
 		return
 	}
+	defer s.openTransactions.release(openTx)
+	transaction := openTx.transaction
 
 	body, err := ioutil.ReadAll(r.Body)
 	if nil != err {
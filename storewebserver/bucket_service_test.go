@@ -100,6 +100,9 @@ func Test_handleGetRevision(t *testing.T) {
 
 	assert.Equal(t, "folder-1", revInfoWithFiles.Dirs[0].Name)
 
+	require.NotNil(t, revInfoWithFiles.Info)
+	assert.Equal(t, int64(4), revInfoWithFiles.Info.NewFiles)
+
 	require.Len(t, revInfoWithFiles.Files, 2)
 
 	assert.True(t, ((revInfoWithFiles.Files[0].GetFileInfo().RelativePath == testFiles[0].Descriptor.RelativePath && revInfoWithFiles.Files[1].GetFileInfo().RelativePath == testFiles[1].Descriptor.RelativePath) ||
@@ -126,6 +129,20 @@ func Test_handleGetRevision(t *testing.T) {
 	relativePathsAreRecieved := testFiles[2].Descriptor.RelativePath == receivedRelativePath0 && testFiles[3].Descriptor.RelativePath == receivedRelativePath1 ||
 		testFiles[2].Descriptor.RelativePath == receivedRelativePath1 && testFiles[3].Descriptor.RelativePath == receivedRelativePath0
 	assert.True(t, relativePathsAreRecieved)
+
+	// the bucket listing has the info of each revision
+	r3 := &http.Request{Method: "GET", URL: &url.URL{Path: "/docs"}}
+	w3 := httptest.NewRecorder()
+
+	bucketService.ServeHTTP(w3, r3)
+	require.Equal(t, 200, w3.Code)
+
+	var bucketResponse handleGetBucketResponse
+	err = json.NewDecoder(w3.Body).Decode(&bucketResponse)
+	require.Nil(t, err)
+	require.Len(t, bucketResponse.Revisions, 1)
+	assert.Equal(t, revInfoWithFiles.LastRevisionTs, bucketResponse.Revisions[0].VersionTimestamp)
+	assert.Equal(t, revInfoWithFiles.Info, bucketResponse.Revisions[0].Info)
 }
 
 func Test_handleCreateRevision(t *testing.T) {
@@ -175,7 +192,35 @@ func Test_handleCreateRevision(t *testing.T) {
 	require.Nil(t, err)
 
 	assert.Equal(t, int64(946782245), openTxResponse.GetRevisionID())
+	assert.Equal(t, string(intelligentstore.HashAlgorithmSHA512), openTxResponse.GetHashAlgorithm())
 	require.Len(t, openTxResponse.GetRequiredRelativePaths(), 1)
+
+	// while a request is using the open transaction, it is not rolled back for a new one
+	openTx := bucketService.openTransactions.acquire("docs", "946782245")
+	require.NotNil(t, openTx)
+
+	w2 := httptest.NewRecorder()
+	bucketService.ServeHTTP(w2, &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: "/docs/upload"},
+		Body:   ioutil.NopCloser(bytes.NewBuffer(openTxRequestBytes)),
+	})
+	assert.Equal(t, http.StatusConflict, w2.Code)
+
+	bucketService.openTransactions.release(openTx)
+
+	// once it is idle, it is rolled back and resumed
+	w3 := httptest.NewRecorder()
+	bucketService.ServeHTTP(w3, &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: "/docs/upload"},
+		Body:   ioutil.NopCloser(bytes.NewBuffer(openTxRequestBytes)),
+	})
+	require.Equal(t, 200, w3.Code)
+
+	err = proto.Unmarshal(w3.Body.Bytes(), &openTxResponse)
+	require.Nil(t, err)
+	assert.Equal(t, int64(946782245), openTxResponse.GetRevisionID())
 }
 
 func Test_handleUploadFile(t *testing.T) {
@@ -506,5 +551,117 @@ func Test_handleGetFileContents(t *testing.T) {
 		require.Equal(t, 200, wExists.Code)
 
 		assert.Equal(t, fileContents, wExists.Body.String())
+
+		// the shared lock taken while the file was read is released
+		locks, err := mockStore.Store.LockDAL.ListLocks()
+		require.Nil(t, err)
+		assert.Empty(t, locks)
+	})
+}
+
+func Test_handleCreateBucket(t *testing.T) {
+	logger := logpkg.NewLogger(os.Stderr, logpkg.LogLevelInfo)
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	bucketService := NewBucketService(logger, mockStore.Store)
+
+	// the bucket doesn't exist yet
+	r1 := &http.Request{Method: "GET", URL: &url.URL{Path: "/docs"}}
+	w1 := httptest.NewRecorder()
+
+	bucketService.ServeHTTP(w1, r1)
+
+	assert.Equal(t, 404, w1.Code)
+
+	r2 := &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: "/"},
+		Body:   ioutil.NopCloser(bytes.NewBufferString(`{"name":"docs"}`)),
+	}
+	w2 := httptest.NewRecorder()
+
+	bucketService.ServeHTTP(w2, r2)
+
+	assert.Equal(t, 201, w2.Code)
+	assert.Equal(t, `{"id":1,"name":"docs"}`, strings.TrimSuffix(w2.Body.String(), "\n"))
+
+	// the name is already taken
+	r3 := &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: "/"},
+		Body:   ioutil.NopCloser(bytes.NewBufferString(`{"name":"docs"}`)),
+	}
+	w3 := httptest.NewRecorder()
+
+	bucketService.ServeHTTP(w3, r3)
+
+	assert.Equal(t, 409, w3.Code)
+}
+
+func Test_handleAbortTransaction(t *testing.T) {
+	logger := logpkg.NewLogger(os.Stderr, logpkg.LogLevelInfo)
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	bucketService := NewBucketService(logger, mockStore.Store)
+
+	openTxRequestBytes, err := proto.Marshal(&protofiles.OpenTxRequest{
+		FileInfos: []*protofiles.FileInfoProto{
+			{RelativePath: "a.txt", ModTime: 0, Size: 1, FileType: protofiles.FileType_REGULAR},
+		},
 	})
+	require.Nil(t, err)
+
+	openTxW := httptest.NewRecorder()
+	bucketService.ServeHTTP(openTxW, &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: "/docs/upload"},
+		Body:   ioutil.NopCloser(bytes.NewBuffer(openTxRequestBytes)),
+	})
+	require.Equal(t, http.StatusOK, openTxW.Code)
+
+	var openTxResponse protofiles.OpenTxResponse
+	err = proto.Unmarshal(openTxW.Body.Bytes(), &openTxResponse)
+	require.Nil(t, err)
+
+	getOpenTransactions := func() []*OpenTransactionInformation {
+		w := httptest.NewRecorder()
+		bucketService.handleGetOpenTransactions(w, &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/transactions"}})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var infos []*OpenTransactionInformation
+		err := json.Unmarshal(w.Body.Bytes(), &infos)
+		require.Nil(t, err)
+
+		return infos
+	}
+
+	infos := getOpenTransactions()
+	require.Len(t, infos, 1)
+	assert.Equal(t, "docs", infos[0].BucketName)
+	assert.Equal(t, intelligentstore.RevisionVersion(openTxResponse.GetRevisionID()), infos[0].RevisionVersion)
+	assert.Equal(t, 1, infos[0].RequiredFiles)
+
+	abortURL := &url.URL{Path: fmt.Sprintf("/docs/upload/%d", openTxResponse.GetRevisionID())}
+
+	abortW := httptest.NewRecorder()
+	bucketService.ServeHTTP(abortW, &http.Request{Method: http.MethodDelete, URL: abortURL})
+	require.Equal(t, http.StatusOK, abortW.Code)
+
+	assert.Empty(t, getOpenTransactions())
+
+	// the transaction isn't open any more
+	abortAgainW := httptest.NewRecorder()
+	bucketService.ServeHTTP(abortAgainW, &http.Request{Method: http.MethodDelete, URL: abortURL})
+	assert.Equal(t, http.StatusNotFound, abortAgainW.Code)
+
+	// and the bucket isn't locked
+	tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+	require.Nil(t, err)
+	require.Nil(t, mockStore.Store.TransactionDAL.Abort(tx))
+
+	// a bucket that doesn't exist isn't found
+	abortNoBucketW := httptest.NewRecorder()
+	bucketService.ServeHTTP(abortNoBucketW, &http.Request{Method: http.MethodDelete, URL: &url.URL{Path: fmt.Sprintf("/not-a-bucket/upload/%d", openTxResponse.GetRevisionID())}})
+	assert.Equal(t, http.StatusNotFound, abortNoBucketW.Code)
+	assert.Contains(t, abortNoBucketW.Body.String(), "couldn't find bucket 'not-a-bucket'")
 }
@@ -0,0 +1,243 @@
+package storewebserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+const (
+	// openTransactionIdleTimeout is how long a transaction can go without a request from its client before it is rolled back
+	openTransactionIdleTimeout = 30 * time.Minute
+	// openTransactionExpiryInterval is how often the open transactions are checked for ones that have been idle for too long
+	openTransactionExpiryInterval = time.Minute
+)
+
+// errTransactionInUse is returned when a transaction can't be taken or rolled back, because a request is using it
+var errTransactionInUse = errors.New("the transaction is being used by another request")
+
+// openTransaction is a transaction opened by a client of the web server
+type openTransaction struct {
+	transaction  *intelligentstore.Transaction
+	openedAt     time.Time
+	lastActivity time.Time
+	// activeRequests is how many requests are using the transaction at the moment. A transaction isn't expired while it is in use (e.g. while a large file is being uploaded)
+	activeRequests int
+}
+
+// OpenTransactionInformation describes a transaction opened by a client of the web server, and its progress
+type OpenTransactionInformation struct {
+	BucketName       string                           `json:"bucketName"`
+	RevisionVersion  intelligentstore.RevisionVersion `json:"revisionVersion"`
+	Stage            string                           `json:"stage"`
+	OpenedAt         time.Time                        `json:"openedAt"`
+	LastActivity     time.Time                        `json:"lastActivity"`
+	ExpiresAt        time.Time                        `json:"expiresAt"`
+	RequiredFiles    int                              `json:"requiredFiles"`
+	RequiredContents int                              `json:"requiredContents"`
+	UploadedContents int                              `json:"uploadedContents"`
+}
+
+// openTransactionRegistry keeps the transactions opened by clients of the web server, between their requests.
+// Transactions that are idle for longer than the idle timeout are rolled back, so a client that disappears doesn't keep its bucket locked.
+type openTransactionRegistry struct {
+	store        *dal.IntelligentStoreDAL
+	nowProvider  dal.NowProvider
+	idleTimeout  time.Duration
+	mu           *sync.Mutex
+	transactions map[string]*openTransaction
+}
+
+func newOpenTransactionRegistry(store *dal.IntelligentStoreDAL, nowProvider dal.NowProvider, idleTimeout time.Duration) *openTransactionRegistry {
+	return &openTransactionRegistry{
+		store,
+		nowProvider,
+		idleTimeout,
+		&sync.Mutex{},
+		make(map[string]*openTransaction),
+	}
+}
+
+func openTransactionKey(bucketName, revisionTsString string) string {
+	return bucketName + "__" + revisionTsString
+}
+
+// add registers a newly opened transaction
+func (r *openTransactionRegistry) add(transaction *intelligentstore.Transaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.nowProvider()
+	key := openTransactionKey(transaction.Revision.BucketName, fmt.Sprintf("%d", transaction.Revision.VersionTimestamp))
+	r.transactions[key] = &openTransaction{
+		transaction:  transaction,
+		openedAt:     now,
+		lastActivity: now,
+	}
+}
+
+// acquire gets an open transaction for a request. It returns nil if there is no open transaction for the bucket and revision.
+// The transaction isn't expired until the request releases it.
+func (r *openTransactionRegistry) acquire(bucketName, revisionTsString string) *openTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	openTx := r.transactions[openTransactionKey(bucketName, revisionTsString)]
+	if openTx == nil {
+		return nil
+	}
+
+	openTx.activeRequests++
+	openTx.lastActivity = r.nowProvider()
+
+	return openTx
+}
+
+// release marks the end of a request using the transaction
+func (r *openTransactionRegistry) release(openTx *openTransaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	openTx.activeRequests--
+	openTx.lastActivity = r.nowProvider()
+}
+
+// take unregisters the open transaction for the bucket and revision, and returns it, so that it can be committed or aborted without another request using it at the same time.
+// It returns nil if there is no open transaction for them. If a request is using the transaction, it is left open, and the cause of the error is errTransactionInUse.
+func (r *openTransactionRegistry) take(bucketName, revisionTsString string) (*openTransaction, errorsx.Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := openTransactionKey(bucketName, revisionTsString)
+	openTx := r.transactions[key]
+	if openTx == nil {
+		return nil, nil
+	}
+
+	if openTx.activeRequests > 0 {
+		return nil, errorsx.Wrap(errTransactionInUse, "bucketName", bucketName, "revisionTs", revisionTsString)
+	}
+
+	delete(r.transactions, key)
+
+	return openTx, nil
+}
+
+// putBack registers a transaction that was taken again, e.g. because committing it failed, so that the client can retry, or it is rolled back once it is idle
+func (r *openTransactionRegistry) putBack(openTx *openTransaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	openTx.lastActivity = r.nowProvider()
+	key := openTransactionKey(openTx.transaction.Revision.BucketName, fmt.Sprintf("%d", openTx.transaction.Revision.VersionTimestamp))
+	r.transactions[key] = openTx
+}
+
+// rollbackBucket rolls back the transactions still open on the bucket, e.g. because the client uploading them was interrupted.
+// Their state is kept, so the next transaction on the bucket resumes them.
+// If a request is using one of them, none are rolled back, and the cause of the error is errTransactionInUse.
+func (r *openTransactionRegistry) rollbackBucket(bucket *intelligentstore.Bucket) errorsx.Error {
+	r.mu.Lock()
+	for _, openTx := range r.transactions {
+		if openTx.transaction.Revision.Bucket.ID == bucket.ID && openTx.activeRequests > 0 {
+			r.mu.Unlock()
+			return errorsx.Wrap(errTransactionInUse, "bucketName", bucket.BucketName)
+		}
+	}
+
+	var transactions []*intelligentstore.Transaction
+	for key, openTx := range r.transactions {
+		if openTx.transaction.Revision.Bucket.ID == bucket.ID {
+			transactions = append(transactions, openTx.transaction)
+			delete(r.transactions, key)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, transaction := range transactions {
+		err := r.store.TransactionDAL.Rollback(transaction)
+		if nil != err {
+			return errorsx.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// expireIdle rolls back the transactions that haven't been used for longer than the idle timeout, and returns how many it rolled back.
+// Their state is kept, so the client can resume them.
+func (r *openTransactionRegistry) expireIdle() int {
+	now := r.nowProvider()
+
+	r.mu.Lock()
+	var expiredTransactions []*intelligentstore.Transaction
+	for key, openTx := range r.transactions {
+		if openTx.activeRequests == 0 && now.Sub(openTx.lastActivity) > r.idleTimeout {
+			expiredTransactions = append(expiredTransactions, openTx.transaction)
+			delete(r.transactions, key)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, transaction := range expiredTransactions {
+		slog.Info("rolling back idle transaction",
+			"bucket", transaction.Revision.BucketName,
+			"revisionVersion", transaction.Revision.VersionTimestamp,
+		)
+
+		err := r.store.TransactionDAL.Rollback(transaction)
+		if nil != err {
+			slog.Error("failed to roll back idle transaction", "error", err)
+		}
+	}
+
+	return len(expiredTransactions)
+}
+
+// expireIdlePeriodically checks for idle transactions every interval, until the process ends
+func (r *openTransactionRegistry) expireIdlePeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		r.expireIdle()
+	}
+}
+
+// list describes the open transactions, ordered by bucket name and revision version
+func (r *openTransactionRegistry) list() []*OpenTransactionInformation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := []*OpenTransactionInformation{}
+	for _, openTx := range r.transactions {
+		transaction := openTx.transaction
+		uploaded, required := transaction.GetUploadProgress()
+
+		infos = append(infos, &OpenTransactionInformation{
+			BucketName:       transaction.Revision.BucketName,
+			RevisionVersion:  transaction.Revision.VersionTimestamp,
+			Stage:            transaction.GetStage().String(),
+			OpenedAt:         openTx.openedAt,
+			LastActivity:     openTx.lastActivity,
+			ExpiresAt:        openTx.lastActivity.Add(r.idleTimeout),
+			RequiredFiles:    len(transaction.GetRelativePathsRequired()),
+			RequiredContents: required,
+			UploadedContents: uploaded,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].BucketName != infos[j].BucketName {
+			return infos[i].BucketName < infos[j].BucketName
+		}
+		return infos[i].RevisionVersion < infos[j].RevisionVersion
+	})
+
+	return infos
+}
@@ -0,0 +1,99 @@
+package storewebserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_openTransactionRegistry_expireIdle(t *testing.T) {
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	now := time.Date(2000, 1, 2, 3, 4, 5, 6, time.UTC)
+	registry := newOpenTransactionRegistry(mockStore.Store, func() time.Time {
+		return now
+	}, time.Minute*30)
+
+	tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+	require.Nil(t, err)
+
+	registry.add(tx)
+
+	// a transaction being used by a request isn't expired
+	openTx := registry.acquire("docs", "946782245")
+	require.NotNil(t, openTx)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0, registry.expireIdle())
+
+	// nor is it taken or rolled back
+	takenTx, err := registry.take("docs", "946782245")
+	assert.Equal(t, errTransactionInUse, errorsx.Cause(err))
+	assert.Nil(t, takenTx)
+
+	err = registry.rollbackBucket(bucket)
+	assert.Equal(t, errTransactionInUse, errorsx.Cause(err))
+	require.Len(t, registry.list(), 1)
+
+	registry.release(openTx)
+
+	infos := registry.list()
+	require.Len(t, infos, 1)
+	assert.Equal(t, "docs", infos[0].BucketName)
+	assert.Equal(t, "Awaiting File Hashes", infos[0].Stage)
+	assert.Equal(t, now.Add(time.Minute*30), infos[0].ExpiresAt)
+
+	now = now.Add(time.Minute * 20)
+	assert.Equal(t, 0, registry.expireIdle())
+
+	now = now.Add(time.Minute * 20)
+	assert.Equal(t, 1, registry.expireIdle())
+	assert.Empty(t, registry.list())
+	assert.Nil(t, registry.acquire("docs", "946782245"))
+
+	// the lock on the bucket was released, and the transaction can be resumed
+	resumedTx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+	require.Nil(t, err)
+	assert.Equal(t, intelligentstore.RevisionVersion(946782245), resumedTx.Revision.VersionTimestamp)
+}
+
+func Test_openTransactionRegistry_take(t *testing.T) {
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	bucket := mockStore.CreateBucket(t, "docs")
+
+	registry := newOpenTransactionRegistry(mockStore.Store, testNowProvider, time.Minute*30)
+
+	tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+	require.Nil(t, err)
+
+	registry.add(tx)
+
+	openTx, err := registry.take("docs", "946782245")
+	require.Nil(t, err)
+	require.NotNil(t, openTx)
+	assert.Equal(t, tx, openTx.transaction)
+
+	// while it is taken (e.g. being committed), other requests can't use it, or take it again
+	assert.Nil(t, registry.acquire("docs", "946782245"))
+
+	takenAgainTx, err := registry.take("docs", "946782245")
+	require.Nil(t, err)
+	assert.Nil(t, takenAgainTx)
+
+	// if committing it fails, it can be put back and used again
+	registry.putBack(openTx)
+	require.Len(t, registry.list(), 1)
+
+	acquiredTx := registry.acquire("docs", "946782245")
+	require.NotNil(t, acquiredTx)
+	registry.release(acquiredTx)
+
+	require.Nil(t, mockStore.Store.TransactionDAL.Rollback(tx))
+}
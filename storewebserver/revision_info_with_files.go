@@ -10,6 +10,7 @@ import (
 func (r *revisionInfoWithFiles) UnmarshalJSON(b []byte) error {
 	type revInfoWithFilesIntermediateType struct {
 		LastRevisionTs intelligentstore.RevisionVersion `json:"revisionTs"`
+		Info           *intelligentstore.RevisionInfo   `json:"info"`
 		Files          []json.RawMessage                `json:"files"`
 		Dirs           []*subDirInfo                    `json:"dirs"`
 	}
@@ -21,6 +22,7 @@ func (r *revisionInfoWithFiles) UnmarshalJSON(b []byte) error {
 	}
 
 	r.LastRevisionTs = revInfoIntermediate.LastRevisionTs
+	r.Info = revInfoIntermediate.Info
 	r.Dirs = revInfoIntermediate.Dirs
 
 	for _, rawMessage := range revInfoIntermediate.Files {
@@ -47,6 +49,14 @@ func (r *revisionInfoWithFiles) UnmarshalJSON(b []byte) error {
 				return err
 			}
 
+			r.Files = append(r.Files, descriptor)
+		case intelligentstore.FileTypeFIFO, intelligentstore.FileTypeCharDevice, intelligentstore.FileTypeBlockDevice, intelligentstore.FileTypeSocket:
+			var descriptor *intelligentstore.SpecialFileDescriptor
+			err = json.Unmarshal(rawMessage, &descriptor)
+			if nil != err {
+				return err
+			}
+
 			r.Files = append(r.Files, descriptor)
 		default:
 			return fmt.Errorf("unknown file type: %d", fileInfo.Type)
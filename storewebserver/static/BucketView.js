@@ -18,6 +18,22 @@ define([
           "{{/revisionTimestamps}}",
         "</select>",
       "</p>",
+      "{{#revisionInfo}}",
+        "<p class='revision-info'>",
+          "{{uploaderType}} backup from {{source}}",
+          "<br/>",
+          "{{newFiles}} new, {{changedFiles}} changed, {{unchangedFiles}} unchanged, {{deletedFiles}} deleted. ",
+          "{{bytesUploaded}} bytes uploaded in {{durationSeconds}}s",
+          "{{#if tags}}",
+            "<br/>",
+            "Tags: {{tags}}",
+          "{{/if}}",
+          "{{#if message}}",
+            "<br/>",
+            "{{message}}",
+          "{{/if}}",
+        "</p>",
+      "{{/revisionInfo}}",
       "<p>",
         "<a href='{{homeURL}}'>",
           "<i class='fa fa-fw fa-home'></i>",
@@ -68,6 +84,27 @@ define([
     });
   };
 
+  // revisions committed before revision infos were recorded don't have one
+  function getRevisionInfoViewModel(revisionInfo) {
+    if (!revisionInfo) {
+      return null;
+    }
+
+    return {
+      uploaderType: revisionInfo.uploaderType || "unknown",
+      source: (revisionInfo.hostname || "unknown host") + ":" + (revisionInfo.sourcePath || ""),
+      newFiles: revisionInfo.newFiles,
+      changedFiles: revisionInfo.changedFiles,
+      unchangedFiles: revisionInfo.unchangedFiles,
+      deletedFiles: revisionInfo.deletedFiles,
+      bytesUploaded: revisionInfo.bytesUploaded,
+      // the duration is in nanoseconds
+      durationSeconds: Math.round(revisionInfo.duration / 1e9),
+      tags: (revisionInfo.tags || []).join(", "),
+      message: revisionInfo.message
+    };
+  }
+
   function getExtension(fileName) {
     var extension = fileName;
     var lastIndexOfDot = fileName.lastIndexOf(".");
@@ -109,10 +146,11 @@ define([
 
           $container.html(template({
             bucketName: data.bucketName,
+            revisionInfo: getRevisionInfoViewModel(data.info),
             revisionTimestamps: bucket.revisions.map(function(revision) {
               return {
                 versionTimestamp: revision.versionTimestamp,
-                timestampDisplayString: new Date(revision.versionTimestamp * 1000).toString(),
+                timestampDisplayString: new Date(revision.versionTimestamp * 1000).toString() + ((revision.info && revision.info.message) ? " - " + revision.info.message : ""),
                 selected: (revisionStr === (revision.versionTimestamp + "")) ? "selected" : ""
               };
             }).sort(function(a, b) {
@@ -140,6 +178,18 @@ define([
                 case Filetype.SYMLINK:
                   description = "symlink to " + file.dest;
                   break;
+                case Filetype.FIFO:
+                  description = "named pipe (FIFO)";
+                  break;
+                case Filetype.CHAR_DEVICE:
+                  description = "character device " + file.deviceNumber.major + ":" + file.deviceNumber.minor;
+                  break;
+                case Filetype.BLOCK_DEVICE:
+                  description = "block device " + file.deviceNumber.major + ":" + file.deviceNumber.minor;
+                  break;
+                case Filetype.SOCKET:
+                  description = "socket";
+                  break;
               }
 
               return {
@@ -1,5 +1,10 @@
 define({
   UNKNOWN: 0,
   REGULAR: 1,
-  SYMLINK: 2
+  SYMLINK: 2,
+  DIRECTORY: 3,
+  FIFO: 4,
+  CHAR_DEVICE: 5,
+  BLOCK_DEVICE: 6,
+  SOCKET: 7
 });
@@ -31,8 +31,12 @@ func NewStoreWebServer(logger *logpkg.Logger, store *dal.IntelligentStoreDAL) (*
 	storeHandler := &StoreWebServer{store, router}
 
 	router.Get("/api/search", storeHandler.handleSearch)
+	router.Get("/api/stats", storeHandler.handleGetStats)
+	router.Get("/api/locks", storeHandler.handleGetLocks)
 
-	router.Mount("/api/buckets/", NewBucketService(logger, store))
+	bucketService := NewBucketService(logger, store)
+	router.Get("/api/transactions", bucketService.handleGetOpenTransactions)
+	router.Mount("/api/buckets/", bucketService)
 	router.Mount("/", staticFilesHandler)
 
 	return storeHandler, nil
@@ -66,3 +70,33 @@ func (s *StoreWebServer) handleSearch(w http.ResponseWriter, r *http.Request) {
 
 	render.JSON(w, r, searchResults)
 }
+
+// handleGetStats gets statistics of the space used by the store, including how each bucket grows, revision by revision
+func (s *StoreWebServer) handleGetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.GetStats(dal.StatsOptions{Revisions: true})
+	if nil != err {
+		http.Error(
+			w,
+			fmt.Sprintf("couldn't get store statistics. Error: %s", err),
+			500,
+		)
+		return
+	}
+
+	render.JSON(w, r, stats)
+}
+
+// handleGetLocks gets the locks currently held on the store, and whether they are stale
+func (s *StoreWebServer) handleGetLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := s.store.LockDAL.ListLocks()
+	if nil != err {
+		http.Error(
+			w,
+			fmt.Sprintf("couldn't get the locks. Error: %s", err),
+			500,
+		)
+		return
+	}
+
+	render.JSON(w, r, locks)
+}
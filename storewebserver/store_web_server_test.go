@@ -0,0 +1,67 @@
+package storewebserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_handleGetStats(t *testing.T) {
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	storeWebServer := &StoreWebServer{store: mockStore.Store}
+
+	bucket := mockStore.CreateBucket(t, "docs")
+	revision := mockStore.CreateRevision(t, bucket, []*intelligentstore.RegularFileDescriptorWithContents{
+		intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", testNowProvider(), dal.FileMode600, []byte("a text")),
+	})
+
+	r := &http.Request{Method: "GET", URL: &url.URL{Path: "/api/stats"}}
+	w := httptest.NewRecorder()
+
+	storeWebServer.handleGetStats(w, r)
+	require.Equal(t, 200, w.Code)
+
+	var stats dal.StoreStats
+	err := json.NewDecoder(w.Body).Decode(&stats)
+	require.Nil(t, err)
+
+	assert.Equal(t, int64(len("a text")), stats.LogicalBytes)
+	require.Len(t, stats.Buckets, 1)
+	assert.Equal(t, "docs", stats.Buckets[0].BucketName)
+	assert.Equal(t, []*dal.RevisionStats{
+		{RevisionVersion: revision.VersionTimestamp, FileCount: 1, LogicalBytes: 6, NewBytes: 6, CumulativeUniqueBytes: 6},
+	}, stats.Buckets[0].Revisions)
+}
+
+func Test_handleGetLocks(t *testing.T) {
+	mockStore := dal.NewMockStore(t, testNowProvider, mockfs.NewMockFs())
+	storeWebServer := &StoreWebServer{store: mockStore.Store}
+
+	bucket := mockStore.CreateBucket(t, "docs")
+	tx, err := mockStore.Store.TransactionDAL.CreateTransaction(bucket, nil)
+	require.Nil(t, err)
+	defer mockStore.Store.TransactionDAL.Rollback(tx)
+
+	r := &http.Request{Method: "GET", URL: &url.URL{Path: "/api/locks"}}
+	w := httptest.NewRecorder()
+
+	storeWebServer.handleGetLocks(w, r)
+	require.Equal(t, 200, w.Code)
+
+	var locks []*dal.LockInformation
+	decodeErr := json.NewDecoder(w.Body).Decode(&locks)
+	require.Nil(t, decodeErr)
+
+	require.Len(t, locks, 1)
+	assert.Equal(t, dal.LockTypeBucket, locks[0].Type)
+	assert.Equal(t, "docs", locks[0].BucketName)
+	assert.False(t, locks[0].IsStale)
+}
@@ -3,6 +3,7 @@ package uploaders
 import (
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +17,12 @@ import (
 
 const WarnOverFileSizeBytes = 1024 * 1024 * 1024 * 4
 
+// reasons for skipping paths when building the file infos map
+const (
+	skipReasonUnknownFileMode = "unknown file mode"
+	skipReasonNoDeviceNumber  = "the device number of the device file couldn't be read"
+)
+
 type FileInfoMap map[intelligentstore.RelativePath]*intelligentstore.FileInfo
 
 func (m FileInfoMap) ToSlice() []*intelligentstore.FileInfo {
@@ -31,13 +38,17 @@ type fileInfoChanDataType struct {
 	FileInfo     *intelligentstore.FileInfo
 }
 
-func BuildFileInfosMap(fs gofs.Fs, backupFromLocation string, includeMatcher, excludeMatcher patternmatcher.Matcher, maxConcurrency uint) (FileInfoMap, errorsx.Error) {
+// BuildFileInfosMap walks the backup location and gets the file infos of the files and directories in it.
+// If fileMetadataReader is nil, the owners, groups and extended attributes of the files are not recorded.
+func BuildFileInfosMap(fs gofs.Fs, backupFromLocation string, includeMatcher, excludeMatcher patternmatcher.Matcher, fileMetadataReader FileMetadataReader, maxConcurrency uint) (FileInfoMap, errorsx.Error) {
 	_, err := fs.Stat(backupFromLocation)
 	if err != nil {
 		return nil, errorsx.Wrap(err)
 	}
 
 	fileInfosMap := make(FileInfoMap)
+	hardLinkedPaths := make(map[fileID][]intelligentstore.RelativePath)
+	skippedPathCounts := make(map[string]int64)
 	var pathsFoundCount int64
 
 	var mu sync.Mutex
@@ -59,30 +70,68 @@ func BuildFileInfosMap(fs gofs.Fs, backupFromLocation string, includeMatcher, ex
 			return errorsx.Wrap(err, "path", path)
 		}
 
-		if osFileInfo.IsDir() {
-			return nil
-		}
-
 		relativePath := fullPathToRelative(backupFromLocation, path)
 
-		if osFileInfo.Size() > WarnOverFileSizeBytes {
-			log.Printf("WARNING: large file found at %q. (Size: %s)\n", relativePath, humanise.HumaniseBytes(osFileInfo.Size()))
-		}
+		var fileInfo *intelligentstore.FileInfo
+		switch {
+		case osFileInfo.IsDir():
+			if relativePath == "" {
+				// the directory being backed up
+				return nil
+			}
 
-		fileType := intelligentstore.FileTypeRegular
+			// directories are recorded, so that empty directories, and the modes and modification times of directories, are kept. Their size is not meaningful
+			fileInfo = intelligentstore.NewFileInfo(intelligentstore.FileTypeDir, relativePath, osFileInfo.ModTime(), 0, osFileInfo.Mode())
+		default:
+			if osFileInfo.Size() > WarnOverFileSizeBytes {
+				log.Printf("WARNING: large file found at %q. (Size: %s)\n", relativePath, humanise.HumaniseBytes(osFileInfo.Size()))
+			}
 
-		if !osFileInfo.Mode().IsRegular() {
-			if osFileInfo.Mode()&os.ModeSymlink != os.ModeSymlink {
+			fileType, ok := fileTypeFromFileMode(osFileInfo.Mode())
+			if !ok {
 				log.Printf("WARNING: Unknown file mode: '%s' at '%s'\n", osFileInfo.Mode(), relativePath)
+				mu.Lock()
+				skippedPathCounts[skipReasonUnknownFileMode]++
+				mu.Unlock()
 				return nil
 			}
-			fileType = intelligentstore.FileTypeSymlink
+
+			fileInfo = intelligentstore.NewFileInfo(fileType, relativePath, osFileInfo.ModTime(), osFileInfo.Size(), osFileInfo.Mode())
+
+			if fileType == intelligentstore.FileTypeRegular {
+				// the holes in sparse files are recorded, so that they can be restored as holes. The hash is still of the full contents, holes included
+				fileInfo.Holes, err = getHoles(path, osFileInfo)
+				if nil != err {
+					return errorsx.Wrap(err, "path", path)
+				}
+			}
+
+			if fileType == intelligentstore.FileTypeCharDevice || fileType == intelligentstore.FileTypeBlockDevice {
+				fileInfo.DeviceNumber, ok = getDeviceNumber(osFileInfo)
+				if !ok {
+					log.Printf("WARNING: couldn't read the device number of the device file at '%s'\n", relativePath)
+					mu.Lock()
+					skippedPathCounts[skipReasonNoDeviceNumber]++
+					mu.Unlock()
+					return nil
+				}
+			}
 		}
 
-		fileInfo := intelligentstore.NewFileInfo(fileType, relativePath, osFileInfo.ModTime(), osFileInfo.Size(), osFileInfo.Mode())
+		if nil != fileMetadataReader {
+			fileInfo.Ownership, fileInfo.ExtendedAttributes, err = fileMetadataReader(path, osFileInfo)
+			if nil != err {
+				return errorsx.Wrap(err, "path", path)
+			}
+		}
+
+		hardLinkedFileID, isHardLinked := getHardLinkedFileID(osFileInfo)
 
 		mu.Lock()
 		fileInfosMap[relativePath] = fileInfo
+		if isHardLinked && fileInfo.Type == intelligentstore.FileTypeRegular {
+			hardLinkedPaths[hardLinkedFileID] = append(hardLinkedPaths[hardLinkedFileID], relativePath)
+		}
 		pathsFoundCount++
 		mu.Unlock()
 
@@ -99,14 +148,50 @@ func BuildFileInfosMap(fs gofs.Fs, backupFromLocation string, includeMatcher, ex
 		return nil, errorsx.Wrap(err)
 	}
 
+	assignHardLinkGroups(fileInfosMap, hardLinkedPaths)
+
 	finished = true
 	log.Printf("finished building file map. %d paths found\n", pathsFoundCount)
+	logSkippedPathCounts(skippedPathCounts)
 
 	// wg.Wait()
 
 	return fileInfosMap, nil
 }
 
+// fileTypeFromFileMode gets the type of a file that isn't a directory from its mode. It returns false if the type isn't one that can be backed up
+func fileTypeFromFileMode(fileMode os.FileMode) (intelligentstore.FileType, bool) {
+	switch {
+	case fileMode.IsRegular():
+		return intelligentstore.FileTypeRegular, true
+	case fileMode&os.ModeSymlink != 0:
+		return intelligentstore.FileTypeSymlink, true
+	case fileMode&os.ModeNamedPipe != 0:
+		return intelligentstore.FileTypeFIFO, true
+	case fileMode&os.ModeSocket != 0:
+		return intelligentstore.FileTypeSocket, true
+	case fileMode&os.ModeCharDevice != 0:
+		return intelligentstore.FileTypeCharDevice, true
+	case fileMode&os.ModeDevice != 0:
+		return intelligentstore.FileTypeBlockDevice, true
+	default:
+		return intelligentstore.FileTypeUnknown, false
+	}
+}
+
+// logSkippedPathCounts logs how many paths were skipped for each reason, if any were
+func logSkippedPathCounts(skippedPathCounts map[string]int64) {
+	var reasons []string
+	for reason := range skippedPathCounts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		log.Printf("skipped %d paths: %s\n", skippedPathCounts[reason], reason)
+	}
+}
+
 func fullPathToRelative(rootPath, fullPath string) intelligentstore.RelativePath {
 	return intelligentstore.NewRelativePath(strings.TrimPrefix(fullPath, rootPath))
 }
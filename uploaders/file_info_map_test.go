@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/goutil/gofs/mockfs"
 	"github.com/jamesrr39/goutil/patternmatcher"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
@@ -46,19 +47,60 @@ func Test_BuildFileInfosMap(t *testing.T) {
 	require.Nil(t, err)
 
 	t.Run("bad path", func(t *testing.T) {
-		_, err = BuildFileInfosMap(fs, "/bad_path", nil, excludes, 1)
+		_, err = BuildFileInfosMap(fs, "/bad_path", nil, excludes, nil, 1)
 		require.NotNil(t, err)
 	})
 
 	t.Run("good path", func(t *testing.T) {
-		fileInfosMap, err := BuildFileInfosMap(fs, "/test", nil, excludes, 1)
+		fileInfosMap, err := BuildFileInfosMap(fs, "/test", nil, excludes, nil, 1)
 		require.Nil(t, err)
 
-		require.Len(t, fileInfosMap, 1)
+		require.Len(t, fileInfosMap, 2)
 		require.Equal(t, fileInfo, fileInfosMap["folder-1/a.txt"])
+
+		dirInfo := fileInfosMap["folder-1"]
+		require.NotNil(t, dirInfo)
+		assert.Equal(t, intelligentstore.FileTypeDir, dirInfo.Type)
+		assert.Equal(t, int64(0), dirInfo.Size)
+		assert.True(t, dirInfo.FileMode.IsDir())
+	})
+
+	t.Run("with file metadata", func(t *testing.T) {
+		fileMetadataReader := func(path string, osFileInfo os.FileInfo) (*intelligentstore.FileOwnership, intelligentstore.ExtendedAttributes, errorsx.Error) {
+			return intelligentstore.NewFileOwnership(1000, 100), intelligentstore.ExtendedAttributes{"user.path": []byte(path)}, nil
+		}
+
+		fileInfosMap, err := BuildFileInfosMap(fs, "/test", nil, excludes, fileMetadataReader, 1)
+		require.Nil(t, err)
+
+		require.Len(t, fileInfosMap, 2)
+		for _, fileInfo := range fileInfosMap {
+			assert.Equal(t, intelligentstore.NewFileOwnership(1000, 100), fileInfo.Ownership)
+			assert.Equal(t, intelligentstore.ExtendedAttributes{"user.path": []byte("/test/" + string(fileInfo.RelativePath))}, fileInfo.ExtendedAttributes)
+		}
 	})
 }
 
+func Test_fileTypeFromFileMode(t *testing.T) {
+	fileModes := map[os.FileMode]intelligentstore.FileType{
+		0644:                                     intelligentstore.FileTypeRegular,
+		os.ModeSymlink | 0777:                    intelligentstore.FileTypeSymlink,
+		os.ModeNamedPipe | 0644:                  intelligentstore.FileTypeFIFO,
+		os.ModeSocket | 0755:                     intelligentstore.FileTypeSocket,
+		os.ModeDevice | os.ModeCharDevice | 0666: intelligentstore.FileTypeCharDevice,
+		os.ModeDevice | 0660:                     intelligentstore.FileTypeBlockDevice,
+	}
+
+	for fileMode, expectedFileType := range fileModes {
+		fileType, ok := fileTypeFromFileMode(fileMode)
+		assert.True(t, ok)
+		assert.Equal(t, expectedFileType, fileType, "file mode: %s", fileMode)
+	}
+
+	_, ok := fileTypeFromFileMode(os.ModeIrregular | 0644)
+	assert.False(t, ok)
+}
+
 func Test_ToSlice(t *testing.T) {
 	relativePath := intelligentstore.NewRelativePath("a.txt")
 	fileInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeRegular, relativePath, time.Unix(0, 0), 0, dal.FileMode600)
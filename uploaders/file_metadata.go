@@ -0,0 +1,12 @@
+package uploaders
+
+import (
+	"os"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// FileMetadataReader reads the owner, group and extended attributes (including POSIX ACLs, on Linux) of the file at the path given.
+// It doesn't follow symlinks.
+type FileMetadataReader func(path string, osFileInfo os.FileInfo) (*intelligentstore.FileOwnership, intelligentstore.ExtendedAttributes, errorsx.Error)
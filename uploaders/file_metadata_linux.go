@@ -0,0 +1,99 @@
+package uploaders
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"golang.org/x/sys/unix"
+)
+
+var _ FileMetadataReader = ReadFileMetadata
+
+// ReadFileMetadata reads the owner, group and extended attributes of a file on the OS filesystem.
+// Filesystems that don't support extended attributes give no extended attributes, rather than an error.
+func ReadFileMetadata(path string, osFileInfo os.FileInfo) (*intelligentstore.FileOwnership, intelligentstore.ExtendedAttributes, errorsx.Error) {
+	stat, ok := osFileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, nil, errorsx.Errorf("no owner information found for %q", path)
+	}
+
+	names, err := listExtendedAttributeNames(path)
+	if err != nil {
+		return nil, nil, errorsx.Wrap(err, "path", path)
+	}
+
+	var extendedAttributes intelligentstore.ExtendedAttributes
+	for _, name := range names {
+		value, err := readExtendedAttribute(path, name)
+		if err != nil {
+			if err == unix.ENODATA {
+				// removed since the names were listed
+				continue
+			}
+			return nil, nil, errorsx.Wrap(err, "path", path, "extended attribute", name)
+		}
+
+		if extendedAttributes == nil {
+			extendedAttributes = make(intelligentstore.ExtendedAttributes)
+		}
+		extendedAttributes[name] = value
+	}
+
+	return intelligentstore.NewFileOwnership(stat.Uid, stat.Gid), extendedAttributes, nil
+}
+
+func listExtendedAttributeNames(path string) ([]string, error) {
+	buf, err := readExtendedAttributeBuffer(func(dest []byte) (int, error) {
+		return unix.Llistxattr(path, dest)
+	})
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(buf), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func readExtendedAttribute(path, name string) ([]byte, error) {
+	return readExtendedAttributeBuffer(func(dest []byte) (int, error) {
+		return unix.Lgetxattr(path, name, dest)
+	})
+}
+
+// readExtendedAttributeBuffer asks for the size of the buffer needed, and then reads into a buffer of that size.
+// If the extended attributes grew in between, it tries again.
+func readExtendedAttributeBuffer(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return nil, nil
+		}
+
+		dest := make([]byte, size)
+		size, err = read(dest)
+		if err != nil {
+			if err == unix.ERANGE {
+				continue
+			}
+			return nil, err
+		}
+
+		return dest[:size], nil
+	}
+}
@@ -0,0 +1,33 @@
+package uploaders
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_ReadFileMetadata(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "a.txt")
+	err := os.WriteFile(filePath, []byte("a"), 0600)
+	require.Nil(t, err)
+
+	err = unix.Lsetxattr(filePath, "user.comment", []byte("a comment"), 0)
+	if err == unix.ENOTSUP {
+		t.Skip("the temp dir filesystem doesn't support user extended attributes")
+	}
+	require.Nil(t, err)
+
+	osFileInfo, err := os.Lstat(filePath)
+	require.Nil(t, err)
+
+	ownership, extendedAttributes, err := ReadFileMetadata(filePath, osFileInfo)
+	require.Nil(t, err)
+
+	assert.Equal(t, intelligentstore.NewFileOwnership(uint32(os.Getuid()), uint32(os.Getgid())), ownership)
+	assert.Equal(t, []byte("a comment"), extendedAttributes["user.comment"])
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package uploaders
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+var _ FileMetadataReader = ReadFileMetadata
+
+// ReadFileMetadata is only implemented on Linux
+func ReadFileMetadata(path string, osFileInfo os.FileInfo) (*intelligentstore.FileOwnership, intelligentstore.ExtendedAttributes, errorsx.Error) {
+	return nil, nil, errorsx.Errorf("recording the owner, group and extended attributes of files is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,40 @@
+package uploaders
+
+import (
+	"sort"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// fileID identifies a file on the system being backed up, so that paths that are hard links to the same file can be found
+type fileID struct {
+	Device uint64
+	Inode  uint64
+}
+
+// assignHardLinkGroups gives the files that are hard links to the same file the same hard link group.
+// Files whose other hard links are outside of the backup aren't in a group.
+// The groups are numbered in the order of their first paths, so that the same files get the same groups in every backup.
+func assignHardLinkGroups(fileInfosMap FileInfoMap, hardLinkedPaths map[fileID][]intelligentstore.RelativePath) {
+	var groups [][]intelligentstore.RelativePath
+	for _, paths := range hardLinkedPaths {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Slice(paths, func(i, j int) bool {
+			return paths[i] < paths[j]
+		})
+		groups = append(groups, paths)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+
+	for i, paths := range groups {
+		for _, path := range paths {
+			fileInfosMap[path].HardLinkGroup = uint64(i + 1)
+		}
+	}
+}
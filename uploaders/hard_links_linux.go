@@ -0,0 +1,16 @@
+package uploaders
+
+import (
+	"os"
+	"syscall"
+)
+
+// getHardLinkedFileID gets the device and inode of a file, if there is more than one hard link to it
+func getHardLinkedFileID(osFileInfo os.FileInfo) (fileID, bool) {
+	stat, ok := osFileInfo.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+
+	return fileID{uint64(stat.Dev), uint64(stat.Ino)}, true
+}
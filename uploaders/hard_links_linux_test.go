@@ -0,0 +1,54 @@
+package uploaders
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/goutil/patternmatcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BuildFileInfosMap_hardLinks(t *testing.T) {
+	outsideDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	for _, fileName := range []string{"a.txt", "d.txt", "z.txt"} {
+		err := os.WriteFile(filepath.Join(backupDir, fileName), []byte(fileName), 0600)
+		require.Nil(t, err)
+	}
+
+	err := os.WriteFile(filepath.Join(outsideDir, "e.txt"), []byte("e.txt"), 0600)
+	require.Nil(t, err)
+
+	err = os.Mkdir(filepath.Join(backupDir, "folder-1"), 0700)
+	require.Nil(t, err)
+
+	links := [][2]string{
+		{filepath.Join(backupDir, "z.txt"), filepath.Join(backupDir, "folder-1", "y.txt")},
+		{filepath.Join(backupDir, "a.txt"), filepath.Join(backupDir, "folder-1", "b.txt")},
+		{filepath.Join(backupDir, "a.txt"), filepath.Join(backupDir, "c.txt")},
+		{filepath.Join(outsideDir, "e.txt"), filepath.Join(backupDir, "e.txt")},
+	}
+	for _, link := range links {
+		err = os.Link(link[0], link[1])
+		require.Nil(t, err)
+	}
+
+	fileInfosMap, err := BuildFileInfosMap(gofs.NewOsFs(), backupDir, nil, &patternmatcher.PatternMatcher{}, nil, 2)
+	require.Nil(t, err)
+
+	// the groups are numbered in the order of their first paths
+	assert.Equal(t, uint64(1), fileInfosMap["a.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(1), fileInfosMap["folder-1/b.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(1), fileInfosMap["c.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(2), fileInfosMap["folder-1/y.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(2), fileInfosMap["z.txt"].HardLinkGroup)
+
+	// d.txt isn't hard linked, and e.txt is only hard linked to a file outside of the backup
+	assert.Equal(t, uint64(0), fileInfosMap["d.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(0), fileInfosMap["e.txt"].HardLinkGroup)
+	assert.Equal(t, uint64(0), fileInfosMap["folder-1"].HardLinkGroup)
+}
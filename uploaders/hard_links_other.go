@@ -0,0 +1,12 @@
+//go:build !linux
+
+package uploaders
+
+import (
+	"os"
+)
+
+// getHardLinkedFileID is only implemented on Linux. Elsewhere, hard links are backed up as separate files
+func getHardLinkedFileID(osFileInfo os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -27,7 +27,10 @@ func (m HashRelativePathMap) ToSlice() []*intelligentstore.RelativePathWithHash
 	return relativePathsWithHashes
 }
 
-func BuildRelativePathsWithHashes(fs gofs.Fs, backupFromLocation string, requiredRelativePaths []intelligentstore.RelativePath) (HashRelativePathMap, errorsx.Error) {
+// BuildRelativePathsWithHashes hashes the files at the relative paths with the hash algorithm, and maps each hash to the relative paths with those contents
+// Files in knownHashes (e.g. from an interrupted upload that is being resumed) aren't hashed again.
+// The holes of sparse files (from fileInfosMap) are hashed as zeros, without being read from disk.
+func BuildRelativePathsWithHashes(fs gofs.Fs, backupFromLocation string, requiredRelativePaths []intelligentstore.RelativePath, fileInfosMap FileInfoMap, hashAlgorithm intelligentstore.HashAlgorithm, knownHashes map[intelligentstore.RelativePath]intelligentstore.Hash) (HashRelativePathMap, errorsx.Error) {
 	hashRelativePathMap := make(HashRelativePathMap)
 	totalRequiredHashes := len(requiredRelativePaths)
 	log.Printf("%d relative paths required\n", totalRequiredHashes)
@@ -47,10 +50,15 @@ func BuildRelativePathsWithHashes(fs gofs.Fs, backupFromLocation string, require
 	}()
 
 	for _, requiredRelativePath := range requiredRelativePaths {
+		knownHash, ok := knownHashes[requiredRelativePath]
+		if ok {
+			hashRelativePathMap[knownHash] = append(hashRelativePathMap[knownHash], requiredRelativePath)
+			continue
+		}
 
 		filePath := filepath.Join(backupFromLocation, string(requiredRelativePath))
 
-		hash, err := calculateHash(fs, filePath)
+		hash, err := calculateHash(fs, filePath, fileInfosMap[requiredRelativePath], hashAlgorithm)
 		if nil != err {
 			return nil, errorsx.Wrap(err, "filePath", filePath)
 		}
@@ -61,14 +69,14 @@ func BuildRelativePathsWithHashes(fs gofs.Fs, backupFromLocation string, require
 	return hashRelativePathMap, nil
 }
 
-func calculateHash(fs gofs.Fs, filePath string) (intelligentstore.Hash, errorsx.Error) {
-	file, err := fs.Open(filePath)
-	if nil != err {
-		return "", errorsx.Wrap(err)
+func calculateHash(fs gofs.Fs, filePath string, fileInfo *intelligentstore.FileInfo, hashAlgorithm intelligentstore.HashAlgorithm) (intelligentstore.Hash, errorsx.Error) {
+	file, openErr := OpenSparseFile(fs, filePath, fileInfo)
+	if nil != openErr {
+		return "", errorsx.Wrap(openErr)
 	}
 	defer file.Close()
 
-	hash, err := intelligentstore.NewHash(file)
+	hash, err := intelligentstore.NewHashWithAlgorithm(file, hashAlgorithm)
 	if nil != err {
 		return "", errorsx.Wrap(err)
 	}
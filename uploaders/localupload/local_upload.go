@@ -20,9 +20,15 @@ type LocalUploader struct {
 	backupFromLocation string
 	includeMatcher,
 	excludeMatcher patternmatcher.Matcher
-	fs             gofs.Fs
-	backupDryRun   bool
-	maxConcurrency uint
+	fs           gofs.Fs
+	backupDryRun bool
+	// discardInterrupted discards an interrupted backup into the bucket, instead of resuming it
+	discardInterrupted bool
+	// transactionOptions decide which files that look unchanged are hashed again
+	transactionOptions dal.TransactionOptions
+	// fileMetadataReader reads the owner, group and extended attributes of files. It is nil if they are not recorded
+	fileMetadataReader uploaders.FileMetadataReader
+	maxConcurrency     uint
 }
 
 // NewLocalUploader connects to the upload store and returns a LocalUploader
@@ -33,8 +39,15 @@ func NewLocalUploader(
 	includeMatcher,
 	excludeMatcher patternmatcher.Matcher,
 	backupDryRun bool,
+	discardInterrupted bool,
+	transactionOptions dal.TransactionOptions,
+	recordFileMetadata bool,
 	maxConcurrency uint,
 ) *LocalUploader {
+	var fileMetadataReader uploaders.FileMetadataReader
+	if recordFileMetadata {
+		fileMetadataReader = uploaders.ReadFileMetadata
+	}
 
 	return &LocalUploader{
 		backupStoreDAL,
@@ -44,13 +57,16 @@ func NewLocalUploader(
 		excludeMatcher,
 		gofs.NewOsFs(),
 		backupDryRun,
+		discardInterrupted,
+		transactionOptions,
+		fileMetadataReader,
 		maxConcurrency,
 	}
 }
 
 // UploadToStore uses the LocalUploader configurations to backup to a store
 func (uploader *LocalUploader) UploadToStore() errorsx.Error {
-	fileInfosMap, err := uploaders.BuildFileInfosMap(uploader.fs, uploader.backupFromLocation, uploader.includeMatcher, uploader.excludeMatcher, uploader.maxConcurrency)
+	fileInfosMap, err := uploaders.BuildFileInfosMap(uploader.fs, uploader.backupFromLocation, uploader.includeMatcher, uploader.excludeMatcher, uploader.fileMetadataReader, uploader.maxConcurrency)
 	if nil != err {
 		return err
 	}
@@ -97,18 +113,23 @@ func (uploader *LocalUploader) UploadToStore() errorsx.Error {
 		return err
 	}
 
-	hashRelativePathMap, err := uploaders.BuildRelativePathsWithHashes(uploader.fs, uploader.backupFromLocation, requiredRelativePathsForHashes)
+	hashRelativePathMap, err := uploaders.BuildRelativePathsWithHashes(uploader.fs, uploader.backupFromLocation, requiredRelativePathsForHashes, fileInfosMap, tx.HashAlgorithm, tx.KnownHashes)
 	if nil != err {
 		return err
 	}
 
-	requiredHashes, err := tx.ProcessUploadHashesAndGetRequiredHashes(hashRelativePathMap.ToSlice())
+	requiredHashes, err := uploader.backupStoreDAL.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, hashRelativePathMap.ToSlice())
 	if nil != err {
 		return err
 	}
 	log.Printf("%d hashes required\n", len(requiredHashes))
 
+	if len(tx.ChangedWithSameFileInfo) != 0 {
+		log.Printf("%d files changed without their modification time or size changing\n", len(tx.ChangedWithSameFileInfo))
+	}
+
 	if uploader.backupDryRun {
+		// the transaction is rolled back without its state being saved, so the next backup doesn't resume it
 		return nil
 	}
 
@@ -118,7 +139,7 @@ func (uploader *LocalUploader) UploadToStore() errorsx.Error {
 			return errorsx.Errorf("couldn't find any paths for hash: '%s'", requiredHash)
 		}
 
-		err = uploader.uploadFile(tx, relativePath[0])
+		err = uploader.uploadFile(tx, fileInfosMap[relativePath[0]])
 		if nil != err {
 			return err
 		}
@@ -140,10 +161,11 @@ func (uploader *LocalUploader) UploadToStore() errorsx.Error {
 	return nil
 }
 
-func (uploader *LocalUploader) uploadFile(tx *intelligentstore.Transaction, relativePath intelligentstore.RelativePath) errorsx.Error {
-	filePath := filepath.Join(uploader.backupFromLocation, string(relativePath))
+// uploadFile backs up the contents of a file. Only the data ranges of sparse files are read from disk.
+func (uploader *LocalUploader) uploadFile(tx *intelligentstore.Transaction, fileInfo *intelligentstore.FileInfo) errorsx.Error {
+	filePath := filepath.Join(uploader.backupFromLocation, string(fileInfo.RelativePath))
 
-	file, err := uploader.fs.Open(filePath)
+	file, err := uploaders.OpenSparseFile(uploader.fs, filePath, fileInfo)
 	if nil != err {
 		return errorsx.Wrap(err, "filepath", filePath)
 	}
@@ -163,7 +185,18 @@ func (uploader *LocalUploader) begin(fileInfos []*intelligentstore.FileInfo) (*i
 		return nil, errorsx.Wrap(err)
 	}
 
-	return uploader.backupStoreDAL.TransactionDAL.CreateTransaction(bucket, fileInfos)
+	if uploader.discardInterrupted {
+		err = uploader.backupStoreDAL.TransactionDAL.DiscardInterruptedTransaction(bucket)
+		if nil != err {
+			return nil, errorsx.Wrap(err)
+		}
+	}
+
+	transactionOptions := uploader.transactionOptions
+	transactionOptions.Source = uploaders.NewRevisionSource(transactionOptions.Source, intelligentstore.UploaderTypeLocal, uploader.backupFromLocation)
+	transactionOptions.DryRun = uploader.backupDryRun
+
+	return uploader.backupStoreDAL.TransactionDAL.CreateTransactionWithOptions(bucket, fileInfos, transactionOptions)
 }
 
 func fullPathToRelative(rootPath, fullPath string) intelligentstore.RelativePath {
@@ -2,11 +2,14 @@ package localupload
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/goutil/gofs/mockfs"
 	"github.com/jamesrr39/goutil/patternmatcher"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
@@ -63,6 +66,9 @@ func Test_UploadToStore(t *testing.T) {
 		excludeMatcher,
 		fs,
 		false,
+		false,
+		dal.TransactionOptions{},
+		nil,
 		1,
 	}
 
@@ -83,13 +89,16 @@ func Test_UploadToStore(t *testing.T) {
 
 	fileDescriptors, err := store.Store.RevisionDAL.GetFilesInRevision(bucket, revision)
 	require.Nil(t, err)
-	require.Len(t, fileDescriptors, 4)
+	// the 4 files and the folder they are in
+	require.Len(t, fileDescriptors, 5)
 
 	fileDescriptorNameMap := make(map[intelligentstore.RelativePath]intelligentstore.FileDescriptor)
 	for _, fileDescriptor := range fileDescriptors {
 		fileDescriptorNameMap[fileDescriptor.GetFileInfo().RelativePath] = fileDescriptor
 	}
 
+	assert.Equal(t, intelligentstore.FileTypeDir, fileDescriptorNameMap["folder1"].GetFileInfo().Type)
+
 	for _, testFile := range testFiles {
 		hash, err := intelligentstore.NewHash(
 			bytes.NewBuffer([]byte(testFile.contents)))
@@ -100,6 +109,114 @@ func Test_UploadToStore(t *testing.T) {
 		assert.Equal(t, hash, fileDescriptor.Hash)
 	}
 
+	revisionInfo, err := store.Store.RevisionDAL.GetRevisionInfo(revision)
+	require.Nil(t, err)
+
+	hostname, hostnameErr := os.Hostname()
+	require.NoError(t, hostnameErr)
+
+	assert.Equal(t, intelligentstore.UploaderTypeLocal, revisionInfo.UploaderType)
+	assert.Equal(t, "/docs", revisionInfo.SourcePath)
+	assert.Equal(t, hostname, revisionInfo.Hostname)
+	assert.Equal(t, int64(5), revisionInfo.NewFiles)
+	assert.Equal(t, int64(len("file a")+len("file b")+len("file 1/a")+len("file 1/c")), revisionInfo.BytesUploaded)
+}
+
+func Test_UploadToStore_resumesInterruptedBackup(t *testing.T) {
+	testFiles := []*testfile{
+		{"a.txt", "file a"},
+		{"b.txt", "file b"},
+	}
+
+	fs := mockfs.NewMockFs()
+	fs.LstatFunc = func(path string) (os.FileInfo, error) {
+		return fs.Stat(path)
+	}
+
+	for _, testFile := range testFiles {
+		err := fs.WriteFile(fmt.Sprintf("/docs/%s", testFile.path), []byte(testFile.contents), 0600)
+		require.Nil(t, err)
+	}
+
+	// count how many times the files being backed up are opened, to hash or upload them
+	var sourceFileOpens int
+	// maxSourceFileOpens interrupts the backup when the files have been opened this many times. It is off if it is 0.
+	var maxSourceFileOpens int
+	openFunc := fs.OpenFunc
+	fs.OpenFunc = func(path string) (gofs.File, error) {
+		if strings.HasPrefix(path, "/docs/") {
+			sourceFileOpens++
+			if maxSourceFileOpens != 0 && sourceFileOpens > maxSourceFileOpens {
+				return nil, errors.New("interrupted")
+			}
+		}
+		return openFunc(path)
+	}
+
+	store := dal.NewMockStore(t, dal.MockNowProvider, fs)
+	bucket := store.CreateBucket(t, "docs")
+
+	newUploader := func(dryRun, discardInterrupted bool) *LocalUploader {
+		return &LocalUploader{
+			store.Store,
+			"docs",
+			"/docs",
+			nil,
+			&patternmatcher.PatternMatcher{},
+			fs,
+			dryRun,
+			discardInterrupted,
+			dal.TransactionOptions{},
+			nil,
+			1,
+		}
+	}
+
+	// a dry run hashes the files, but leaves nothing to be resumed, so the next backup hashes them again
+	err := newUploader(true, false).UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 2, sourceFileOpens)
+
+	sourceFileOpens = 0
+	err = newUploader(true, false).UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 2, sourceFileOpens)
+
+	// a backup interrupted after hashing the files and uploading one of them
+	sourceFileOpens = 0
+	maxSourceFileOpens = 3
+	err = newUploader(false, false).UploadToStore()
+	require.NotNil(t, err)
+	maxSourceFileOpens = 0
+
+	// a dry run resumes it without hashing the files again, and leaves it to be resumed
+	sourceFileOpens = 0
+	err = newUploader(true, false).UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 0, sourceFileOpens)
+
+	// the other file is only opened to upload it
+	sourceFileOpens = 0
+	err = newUploader(false, false).UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 1, sourceFileOpens)
+
+	revisions, err := store.Store.BucketDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	require.Len(t, revisions, 1)
+
+	fileDescriptors, err := store.Store.RevisionDAL.GetFilesInRevision(bucket, revisions[0])
+	require.Nil(t, err)
+	require.Len(t, fileDescriptors, 2)
+
+	for _, fileDescriptor := range fileDescriptors {
+		contents, err := fs.ReadFile(fmt.Sprintf("/docs/%s", fileDescriptor.GetFileInfo().RelativePath))
+		require.Nil(t, err)
+
+		hash, err := intelligentstore.NewHash(bytes.NewReader(contents))
+		require.Nil(t, err)
+		assert.Equal(t, hash, fileDescriptor.(*intelligentstore.RegularFileDescriptor).Hash)
+	}
 }
 
 func mockTimeProvider() time.Time {
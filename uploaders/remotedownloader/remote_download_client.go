@@ -17,6 +17,7 @@ import (
 	"github.com/jamesrr39/goutil/httpextra"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/uploaders"
 )
 
 const FilesFolderName = "files"
@@ -54,10 +55,11 @@ func DownloadRemote(
 	bucket *intelligentstore.Bucket,
 	conf *Config,
 	variablesKeyValues map[string]string,
+	source intelligentstore.RevisionSource,
 ) errorsx.Error {
 	switch conf.Version {
 	case 1:
-		return downloadRemoteConfigV1(httpClient, storeDAL, bucket, conf, variablesKeyValues)
+		return downloadRemoteConfigV1(httpClient, storeDAL, bucket, conf, variablesKeyValues, source)
 	default:
 		return errorsx.Errorf("unknown config version: %d. Perhaps you need a newer version of the store program?", conf.Version)
 	}
@@ -69,6 +71,7 @@ func downloadRemoteConfigV1(
 	bucket *intelligentstore.Bucket,
 	conf *Config,
 	envVariablesKeyValues map[string]string,
+	source intelligentstore.RevisionSource,
 ) errorsx.Error {
 	listingURL := makeDownloadURL(conf.ListingURL, envVariablesKeyValues)
 	req, err := http.NewRequest(http.MethodGet, listingURL, nil)
@@ -136,7 +139,12 @@ func downloadRemoteConfigV1(
 	}
 
 	// stage 1
-	tx, err := storeDAL.TransactionDAL.CreateTransaction(bucket, fileInfos)
+	// the listing URL is recorded before the variables are put in, as they can be secrets
+	transactionOptions := dal.TransactionOptions{
+		Source: uploaders.NewRevisionSource(source, intelligentstore.UploaderTypeRemote, conf.ListingURL),
+	}
+
+	tx, err := storeDAL.TransactionDAL.CreateTransactionWithOptions(bucket, fileInfos, transactionOptions)
 	if err != nil {
 		return errorsx.Wrap(err)
 	}
@@ -153,7 +161,7 @@ func downloadRemoteConfigV1(
 			return errorsx.Errorf("couldn't find entry in relative path map for %q", relativePath)
 		}
 
-		relativePathWithHash, reader, err := downloadRequiredFile(info)
+		relativePathWithHash, reader, err := downloadRequiredFile(info, tx.HashAlgorithm)
 		if err != nil {
 			return errorsx.Wrap(err)
 		}
@@ -180,7 +188,7 @@ func downloadRemoteConfigV1(
 	}
 
 	// find out which hashes are new
-	requiredHashes, err := tx.ProcessUploadHashesAndGetRequiredHashes(relativePathAndHashesList)
+	requiredHashes, err := storeDAL.TransactionDAL.ProcessUploadHashesAndGetRequiredHashes(tx, relativePathAndHashesList)
 	if err != nil {
 		return errorsx.Wrap(err)
 	}
@@ -307,7 +315,7 @@ func makeDownloadURL(downloadURLPattern string, variables map[string]string) str
 	return downloadURLPattern
 }
 
-func downloadRequiredFile(info *downloadFileInfoType) (*intelligentstore.RelativePathWithHash, *bytes.Reader, errorsx.Error) {
+func downloadRequiredFile(info *downloadFileInfoType, hashAlgorithm intelligentstore.HashAlgorithm) (*intelligentstore.RelativePathWithHash, *bytes.Reader, errorsx.Error) {
 	var err error
 	respBody, err := info.GetFileFunc()
 	if err != nil {
@@ -327,7 +335,7 @@ func downloadRequiredFile(info *downloadFileInfoType) (*intelligentstore.Relativ
 	bb := bytes.NewReader(b)
 
 	// calculate hash
-	hash, err := intelligentstore.NewHash(bb)
+	hash, err := intelligentstore.NewHashWithAlgorithm(bb, hashAlgorithm)
 	if err != nil {
 		return nil, nil, errorsx.Wrap(err)
 	}
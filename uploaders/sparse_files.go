@@ -0,0 +1,28 @@
+package uploaders
+
+import (
+	"io"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+type sparseFile struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// OpenSparseFile opens a file for reading its contents. If the file info has holes (see getHoles), only the data ranges are read from disk, and the holes are read as zeros.
+func OpenSparseFile(fs gofs.Fs, filePath string, fileInfo *intelligentstore.FileInfo) (io.ReadSeekCloser, errorsx.Error) {
+	file, err := fs.Open(filePath)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	if fileInfo == nil || len(fileInfo.Holes) == 0 {
+		return file, nil
+	}
+
+	return sparseFile{intelligentstore.NewSparseFileReader(file, fileInfo.Size, fileInfo.Holes), file}, nil
+}
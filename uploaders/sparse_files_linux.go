@@ -0,0 +1,71 @@
+package uploaders
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"golang.org/x/sys/unix"
+)
+
+// whence values for lseek, from <unistd.h>. The vendored golang.org/x/sys/unix doesn't define them
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// getHoles finds the holes in a sparse file, with SEEK_DATA and SEEK_HOLE.
+// Files with as much disk space allocated as their size aren't sparse, so they aren't opened. Filesystems that don't support SEEK_DATA and SEEK_HOLE give no holes.
+func getHoles(path string, osFileInfo os.FileInfo) ([]*intelligentstore.FileRange, errorsx.Error) {
+	stat, ok := osFileInfo.Sys().(*syscall.Stat_t)
+	if !ok || int64(stat.Blocks)*512 >= osFileInfo.Size() {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "path", path)
+	}
+	defer file.Close()
+
+	fd := int(file.Fd())
+	size := osFileInfo.Size()
+
+	var holes []*intelligentstore.FileRange
+	var offset int64
+	for offset < size {
+		dataOffset, err := unix.Seek(fd, offset, seekData)
+		if err != nil {
+			switch err {
+			case unix.ENXIO:
+				// there is no more data, so the rest of the file is a hole
+				dataOffset = size
+			case unix.EINVAL:
+				return nil, nil
+			default:
+				return nil, errorsx.Wrap(err, "path", path)
+			}
+		}
+
+		if dataOffset > size {
+			// the file grew after it was stat'ed
+			dataOffset = size
+		}
+
+		if dataOffset > offset {
+			holes = append(holes, intelligentstore.NewFileRange(offset, dataOffset-offset))
+		}
+
+		if dataOffset == size {
+			break
+		}
+
+		offset, err = unix.Seek(fd, dataOffset, seekHole)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "path", path)
+		}
+	}
+
+	return holes, nil
+}
@@ -0,0 +1,61 @@
+package uploaders
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getHoles(t *testing.T) {
+	const size = 16 * 1024 * 1024
+	dataOffset := int64(4 * 1024 * 1024)
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = 'a'
+	}
+
+	filePath := filepath.Join(t.TempDir(), "a.img")
+	file, err := os.Create(filePath)
+	require.Nil(t, err)
+
+	_, err = file.WriteAt(data, dataOffset)
+	require.Nil(t, err)
+	err = file.Truncate(size)
+	require.Nil(t, err)
+	err = file.Close()
+	require.Nil(t, err)
+
+	osFileInfo, err := os.Lstat(filePath)
+	require.Nil(t, err)
+
+	if osFileInfo.Sys().(*syscall.Stat_t).Blocks*512 >= size {
+		t.Skip("the temp dir filesystem doesn't support sparse files")
+	}
+
+	holes, err := getHoles(filePath, osFileInfo)
+	require.Nil(t, err)
+
+	// filesystems allocate whole blocks, so the data can take up more than was written
+	require.Len(t, holes, 2)
+	assert.Equal(t, int64(0), holes[0].Offset)
+	assert.LessOrEqual(t, holes[0].Length, dataOffset)
+	assert.LessOrEqual(t, dataOffset+int64(len(data)), holes[1].Offset)
+	assert.Equal(t, int64(size), holes[1].Offset+holes[1].Length)
+
+	// files that aren't sparse aren't opened
+	notSparseFilePath := filepath.Join(t.TempDir(), "b.txt")
+	err = os.WriteFile(notSparseFilePath, data, 0600)
+	require.Nil(t, err)
+
+	osFileInfo, err = os.Lstat(notSparseFilePath)
+	require.Nil(t, err)
+
+	holes, err = getHoles(notSparseFilePath, osFileInfo)
+	require.Nil(t, err)
+	assert.Equal(t, []*intelligentstore.FileRange(nil), holes)
+}
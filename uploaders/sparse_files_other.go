@@ -0,0 +1,15 @@
+//go:build !linux
+
+package uploaders
+
+import (
+	"os"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// getHoles is only implemented on Linux. Elsewhere, sparse files are backed up as if they weren't sparse
+func getHoles(path string, osFileInfo os.FileInfo) ([]*intelligentstore.FileRange, errorsx.Error) {
+	return nil, nil
+}
@@ -0,0 +1,38 @@
+package uploaders
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BuildRelativePathsWithHashes_sparseFile(t *testing.T) {
+	fs := mockfs.NewMockFs()
+
+	// the hole isn't zeros on the mock filesystem, so the hash would be different if it was read
+	err := fs.WriteFile("/docs/a.img", []byte("xxxxxxxxdata"), 0600)
+	require.Nil(t, err)
+
+	fileInfo := intelligentstore.NewFileInfo(intelligentstore.FileTypeRegular, "a.img", time.Unix(0, 0), 12, 0600)
+	fileInfo.Holes = []*intelligentstore.FileRange{intelligentstore.NewFileRange(0, 8)}
+
+	hashRelativePathMap, err := BuildRelativePathsWithHashes(
+		fs,
+		"/docs",
+		[]intelligentstore.RelativePath{"a.img"},
+		FileInfoMap{"a.img": fileInfo},
+		intelligentstore.HashAlgorithmSHA512,
+		nil,
+	)
+	require.Nil(t, err)
+
+	expectedHash, err := intelligentstore.NewHashWithAlgorithm(bytes.NewReader(append(make([]byte, 8), []byte("data")...)), intelligentstore.HashAlgorithmSHA512)
+	require.Nil(t, err)
+
+	assert.Equal(t, HashRelativePathMap{expectedHash: {"a.img"}}, hashRelativePathMap)
+}
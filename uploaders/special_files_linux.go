@@ -0,0 +1,21 @@
+package uploaders
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"golang.org/x/sys/unix"
+)
+
+// getDeviceNumber gets the device a character or block device file is for
+func getDeviceNumber(osFileInfo os.FileInfo) (*intelligentstore.DeviceNumber, bool) {
+	stat, ok := osFileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, false
+	}
+
+	rdev := uint64(stat.Rdev)
+
+	return intelligentstore.NewDeviceNumber(unix.Major(rdev), unix.Minor(rdev)), true
+}
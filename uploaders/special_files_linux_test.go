@@ -0,0 +1,44 @@
+package uploaders
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesrr39/goutil/gofs"
+	"github.com/jamesrr39/goutil/patternmatcher"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_BuildFileInfosMap_specialFiles(t *testing.T) {
+	backupDir := t.TempDir()
+
+	err := unix.Mkfifo(filepath.Join(backupDir, "fifo"), 0640)
+	require.Nil(t, err)
+
+	listener, err := net.Listen("unix", filepath.Join(backupDir, "socket"))
+	require.Nil(t, err)
+	defer listener.Close()
+
+	// creating device files needs privileges
+	err = unix.Mknod(filepath.Join(backupDir, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3)))
+	canMakeDevices := err == nil
+	if !canMakeDevices {
+		require.Equal(t, unix.EPERM, err)
+	}
+
+	fileInfosMap, err := BuildFileInfosMap(gofs.NewOsFs(), backupDir, nil, &patternmatcher.PatternMatcher{}, nil, 1)
+	require.Nil(t, err)
+
+	assert.Equal(t, intelligentstore.FileTypeFIFO, fileInfosMap["fifo"].Type)
+	assert.Nil(t, fileInfosMap["fifo"].DeviceNumber)
+	assert.Equal(t, intelligentstore.FileTypeSocket, fileInfosMap["socket"].Type)
+
+	if canMakeDevices {
+		assert.Equal(t, intelligentstore.FileTypeCharDevice, fileInfosMap["null"].Type)
+		assert.Equal(t, intelligentstore.NewDeviceNumber(1, 3), fileInfosMap["null"].DeviceNumber)
+	}
+}
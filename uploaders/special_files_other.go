@@ -0,0 +1,14 @@
+//go:build !linux
+
+package uploaders
+
+import (
+	"os"
+
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
+
+// getDeviceNumber is only implemented on Linux. Elsewhere, device files are skipped
+func getDeviceNumber(osFileInfo os.FileInfo) (*intelligentstore.DeviceNumber, bool) {
+	return nil, false
+}
@@ -1,8 +1,29 @@
 package uploaders
 
-import "github.com/jamesrr39/goutil/errorsx"
+import (
+	"log"
+	"os"
+
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+)
 
 // Uploader is an interface every uploader client should implement
 type Uploader interface {
 	UploadToStore() errorsx.Error
 }
+
+// NewRevisionSource fills in the hostname of this machine, the uploader type and the source path in the source of a revision. The message and tags are kept.
+// If the hostname can't be found, it is left empty.
+func NewRevisionSource(source intelligentstore.RevisionSource, uploaderType intelligentstore.UploaderType, sourcePath string) intelligentstore.RevisionSource {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("couldn't get the hostname of this machine. Error: %q\n", err)
+	}
+
+	source.Hostname = hostname
+	source.UploaderType = uploaderType
+	source.SourcePath = sourcePath
+
+	return source
+}
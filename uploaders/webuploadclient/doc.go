@@ -1,5 +1,6 @@
 package webuploadclient
 
 /*
-webuploadclient uploads a directory to an IntelligentStore web server as a revision
+webuploadclient uploads a directory to an IntelligentStore web server as a revision.
+WebReplicationDestination replicates revisions from another store to a web server.
 */
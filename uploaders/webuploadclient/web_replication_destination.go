@@ -0,0 +1,307 @@
+package webuploadclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jamesrr39/goutil/errorsx"
+	"github.com/jamesrr39/goutil/httpextra"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	protofiles "github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/protobufs/proto_files"
+)
+
+// WebReplicationDestination replicates revisions to a store behind a web server (started with the start-webapp command).
+// Modification times are sent to the server with a precision of a second, like they are when backing up to it.
+type WebReplicationDestination struct {
+	storeURL string
+}
+
+// NewWebReplicationDestination creates a new WebReplicationDestination
+func NewWebReplicationDestination(storeURL string) *WebReplicationDestination {
+	return &WebReplicationDestination{storeURL}
+}
+
+type getBucketResponse struct {
+	Revisions []*intelligentstore.Revision `json:"revisions"`
+}
+
+func (d *WebReplicationDestination) bucketURL(bucketName string) string {
+	return fmt.Sprintf("%s/api/buckets/%s", d.storeURL, url.PathEscape(bucketName))
+}
+
+// GetRevisionVersions gets the versions of the revisions in the bucket on the server
+func (d *WebReplicationDestination) GetRevisionVersions(bucketName string) ([]intelligentstore.RevisionVersion, errorsx.Error) {
+	client := http.Client{Timeout: time.Minute}
+	bucketURL := d.bucketURL(bucketName)
+	resp, err := client.Get(bucketURL)
+	if nil != err {
+		return nil, errorsx.Wrap(err, "url", bucketURL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errorsx.Wrap(dal.ErrBucketDoesNotExist, "bucketName", bucketName)
+	}
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	var response getBucketResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var revisionVersions []intelligentstore.RevisionVersion
+	for _, revision := range response.Revisions {
+		revisionVersions = append(revisionVersions, revision.VersionTimestamp)
+	}
+
+	return revisionVersions, nil
+}
+
+// CreateBucket creates a bucket on the server
+func (d *WebReplicationDestination) CreateBucket(bucketName string) errorsx.Error {
+	requestBytes, err := json.Marshal(map[string]string{"name": bucketName})
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	bucketsURL := d.storeURL + "/api/buckets/"
+	resp, err := client.Post(bucketsURL, "application/json", bytes.NewBuffer(requestBytes))
+	if nil != err {
+		return errorsx.Wrap(err, "url", bucketsURL)
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusCreated, resp.StatusCode)
+	if err != nil {
+		return errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	return nil
+}
+
+// OpenTransaction opens a transaction on the server for a revision with this version. The revision info is sent as JSON in a query parameter.
+func (d *WebReplicationDestination) OpenTransaction(bucketName string, revisionVersion intelligentstore.RevisionVersion, fileInfos []*intelligentstore.FileInfo, revisionInfo *intelligentstore.RevisionInfo) (dal.ReplicationTransaction, errorsx.Error) {
+	openTxRequest := &protofiles.OpenTxRequest{
+		FileInfos: nil,
+	}
+
+	for _, fileInfo := range fileInfos {
+		openTxRequest.FileInfos = append(openTxRequest.FileInfos, fileInfoToProto(fileInfo))
+	}
+
+	openTxRequestBytes, err := proto.Marshal(openTxRequest)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	revisionInfoBytes, err := json.Marshal(revisionInfo)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	query := make(url.Values)
+	query.Set("revisionVersion", strconv.FormatInt(int64(revisionVersion), 10))
+	query.Set("revisionInfo", string(revisionInfoBytes))
+
+	client := http.Client{Timeout: time.Minute}
+	openTxURL := fmt.Sprintf("%s/upload?%s", d.bucketURL(bucketName), query.Encode())
+	resp, err := client.Post(openTxURL, "application/octet-stream", bytes.NewBuffer(openTxRequestBytes))
+	if nil != err {
+		return nil, errorsx.Wrap(err, "url", openTxURL)
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var openTxResponse protofiles.OpenTxResponse
+	err = proto.Unmarshal(respBytes, &openTxResponse)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var requiredRelativePaths []intelligentstore.RelativePath
+	for _, requiredRelativePath := range openTxResponse.GetRequiredRelativePaths() {
+		requiredRelativePaths = append(requiredRelativePaths, intelligentstore.NewRelativePath(requiredRelativePath))
+	}
+
+	hashAlgorithm := intelligentstore.HashAlgorithm(openTxResponse.GetHashAlgorithm())
+	if hashAlgorithm == "" {
+		// servers from before hash algorithms were configurable only support SHA-512
+		hashAlgorithm = intelligentstore.HashAlgorithmSHA512
+	}
+
+	return &webReplicationTransaction{
+		uploadURL:             fmt.Sprintf("%s/upload/%d", d.bucketURL(bucketName), openTxResponse.GetRevisionID()),
+		requiredRelativePaths: requiredRelativePaths,
+		hashAlgorithm:         hashAlgorithm,
+	}, nil
+}
+
+type webReplicationTransaction struct {
+	uploadURL             string
+	requiredRelativePaths []intelligentstore.RelativePath
+	hashAlgorithm         intelligentstore.HashAlgorithm
+}
+
+func (t *webReplicationTransaction) RequiredRelativePaths() []intelligentstore.RelativePath {
+	return t.requiredRelativePaths
+}
+
+func (t *webReplicationTransaction) HashAlgorithm() intelligentstore.HashAlgorithm {
+	return t.hashAlgorithm
+}
+
+func (t *webReplicationTransaction) UploadSymlinks(symlinks []*intelligentstore.SymlinkWithRelativePath) errorsx.Error {
+	uploadSymlinksRequest := &protofiles.UploadSymlinksRequest{}
+	for _, symlink := range symlinks {
+		uploadSymlinksRequest.SymlinksWithRelativePaths = append(
+			uploadSymlinksRequest.SymlinksWithRelativePaths,
+			&protofiles.SymlinkWithRelativePath{
+				RelativePath: string(symlink.RelativePath),
+				Dest:         symlink.Dest,
+			},
+		)
+	}
+
+	_, err := t.post("/symlinks", uploadSymlinksRequest, time.Minute)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+func (t *webReplicationTransaction) UploadHashes(relativePathsWithHashes []*intelligentstore.RelativePathWithHash) ([]intelligentstore.Hash, errorsx.Error) {
+	getRequiredHashesRequest := &protofiles.GetRequiredHashesRequest{}
+	for _, relativePathWithHash := range relativePathsWithHashes {
+		getRequiredHashesRequest.RelativePathsAndHashes = append(
+			getRequiredHashesRequest.RelativePathsAndHashes,
+			&protofiles.RelativePathAndHashProto{
+				RelativePath: string(relativePathWithHash.RelativePath),
+				Hash:         string(relativePathWithHash.Hash),
+			},
+		)
+	}
+
+	respBytes, err := t.post("/hashes", getRequiredHashesRequest, time.Minute)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	var getRequiredHashesResponse protofiles.GetRequiredHashesResponse
+	unmarshalErr := proto.Unmarshal(respBytes, &getRequiredHashesResponse)
+	if nil != unmarshalErr {
+		return nil, errorsx.Wrap(unmarshalErr)
+	}
+
+	var hashes []intelligentstore.Hash
+	for _, hash := range getRequiredHashesResponse.GetHashes() {
+		hashes = append(hashes, intelligentstore.Hash(hash))
+	}
+
+	return hashes, nil
+}
+
+func (t *webReplicationTransaction) UploadContents(contents io.ReadSeeker) errorsx.Error {
+	contentsBytes, err := ioutil.ReadAll(contents)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	_, err = t.post("/file", &protofiles.FileContentsProto{Contents: contentsBytes}, time.Hour)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	return nil
+}
+
+func (t *webReplicationTransaction) Commit() errorsx.Error {
+	client := http.Client{Timeout: time.Minute}
+	commitURL := t.uploadURL + "/commit"
+	resp, err := client.Get(commitURL)
+	if nil != err {
+		return errorsx.Wrap(err, "url", commitURL)
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	return nil
+}
+
+// Rollback aborts the transaction on the server, which releases the bucket lock
+func (t *webReplicationTransaction) Rollback() errorsx.Error {
+	request, err := http.NewRequest(http.MethodDelete, t.uploadURL, nil)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Do(request)
+	if nil != err {
+		return errorsx.Wrap(err, "url", t.uploadURL)
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	return nil
+}
+
+// post sends the message to the path under the transaction's upload URL, and returns the response body
+func (t *webReplicationTransaction) post(path string, message proto.Message, timeout time.Duration) ([]byte, errorsx.Error) {
+	requestBytes, err := proto.Marshal(message)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	client := http.Client{Timeout: timeout}
+	postURL := t.uploadURL + path
+	resp, err := client.Post(postURL, "application/octet-stream", bytes.NewBuffer(requestBytes))
+	if nil != err {
+		return nil, errorsx.Wrap(err, "url", postURL)
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return nil, errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	return respBytes, nil
+}
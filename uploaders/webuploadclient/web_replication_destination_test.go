@@ -0,0 +1,84 @@
+package webuploadclient
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jamesrr39/goutil/gofs/mockfs"
+	"github.com/jamesrr39/goutil/logpkg"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
+	"github.com/jamesrr39/intelligent-backup-store-app/storewebserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WebReplicationDestination(t *testing.T) {
+	logger := logpkg.NewLogger(os.Stderr, logpkg.LogLevelInfo)
+
+	// set up the store to replicate
+	sourceStore := dal.NewMockStore(t, mockTimeProvider, mockfs.NewMockFs())
+	sourceBucket := sourceStore.CreateBucket(t, "docs")
+
+	modTime := time.Unix(1000, 0)
+	sourceRevision := sourceStore.CreateRevision(t, sourceBucket, []*intelligentstore.RegularFileDescriptorWithContents{
+		intelligentstore.NewRegularFileDescriptorWithContents(t, "a.txt", modTime, dal.FileMode600, []byte("file a")),
+		intelligentstore.NewRegularFileDescriptorWithContents(t, "folder1/b.txt", modTime, dal.FileMode755, []byte("file b")),
+	})
+
+	// set up remote store server
+	remoteStore := dal.NewMockStore(t, time.Now, mockfs.NewMockFs())
+
+	webServer, err := storewebserver.NewStoreWebServer(logger, remoteStore.Store)
+	require.NoError(t, err)
+
+	storeServer := httptest.NewServer(webServer)
+	defer storeServer.Close()
+
+	destination := NewWebReplicationDestination(storeServer.URL)
+
+	result, err := sourceStore.Store.Replicate(destination, dal.ReplicateOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"docs"}, result.BucketsCreated)
+	assert.Equal(t, int64(1), result.RevisionsReplicated)
+	assert.Equal(t, int64(2), result.ObjectsTransferred)
+
+	// assertions
+	bucket, err := remoteStore.Store.BucketDAL.GetBucketByName("docs")
+	require.Nil(t, err)
+
+	revisions, err := remoteStore.Store.RevisionDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, sourceRevision.VersionTimestamp, revisions[0].VersionTimestamp)
+
+	descriptor, statErr := remoteStore.Store.RevisionDAL.Stat(bucket, revisions[0], "folder1/b.txt")
+	require.NoError(t, statErr)
+	assert.Equal(t, dal.FileMode755, descriptor.GetFileInfo().FileMode)
+	assert.True(t, modTime.Equal(descriptor.GetFileInfo().ModTime))
+
+	// the revision keeps its info
+	sourceRevisionInfo, err := sourceStore.Store.RevisionDAL.GetRevisionInfo(sourceRevision)
+	require.Nil(t, err)
+	require.NotNil(t, sourceRevisionInfo)
+
+	revisionInfo, err := remoteStore.Store.RevisionDAL.GetRevisionInfo(revisions[0])
+	require.Nil(t, err)
+	assert.Equal(t, sourceRevisionInfo, revisionInfo)
+
+	contents, contentsErr := remoteStore.Store.RevisionDAL.GetFileContentsInRevision(bucket, revisions[0], "folder1/b.txt")
+	require.NoError(t, contentsErr)
+	defer contents.Close()
+
+	b, readErr := ioutil.ReadAll(contents)
+	require.NoError(t, readErr)
+	assert.Equal(t, "file b", string(b))
+
+	// replicating again doesn't send anything
+	result, err = sourceStore.Store.Replicate(destination, dal.ReplicateOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, &dal.ReplicateResult{BucketsCreated: []string{}, RevisionsSkipped: 1}, result)
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/goutil/httpextra"
 	"github.com/jamesrr39/goutil/patternmatcher"
+	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/dal"
 	"github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/intelligentstore"
 	protofiles "github.com/jamesrr39/intelligent-backup-store-app/intelligentstore/protobufs/proto_files"
 	"github.com/jamesrr39/intelligent-backup-store-app/uploaders"
@@ -28,7 +30,23 @@ type WebUploadClient struct {
 	excludeMatcher patternmatcher.Matcher
 	fs             gofs.Fs
 	backupDryRun   bool
-	maxConcurrency uint
+	// discardInterrupted discards an interrupted upload into the bucket, instead of resuming it
+	discardInterrupted bool
+	// transactionOptions decide which files that look unchanged the server asks for the hashes of again
+	transactionOptions dal.TransactionOptions
+	// fileMetadataReader reads the owner, group and extended attributes of files. It is nil if they are not recorded
+	fileMetadataReader uploaders.FileMetadataReader
+	maxConcurrency     uint
+}
+
+// openedTx is what the server returns when a transaction is opened
+type openedTx struct {
+	revisionVersion       intelligentstore.RevisionVersion
+	requiredRelativePaths []intelligentstore.RelativePath
+	// hashAlgorithm is the algorithm the server requires file hashes to be made with
+	hashAlgorithm intelligentstore.HashAlgorithm
+	// knownHashes are the hashes an interrupted upload of the revision already sent, for files that haven't changed since
+	knownHashes map[intelligentstore.RelativePath]intelligentstore.Hash
 }
 
 // NewWebUploadClient creates a new WebUploadClient
@@ -39,8 +57,15 @@ func NewWebUploadClient(
 	includeMatcher patternmatcher.Matcher,
 	excludeMatcher patternmatcher.Matcher,
 	backupDryRun bool,
+	discardInterrupted bool,
+	transactionOptions dal.TransactionOptions,
+	recordFileMetadata bool,
 	maxConcurrency uint,
 ) *WebUploadClient {
+	var fileMetadataReader uploaders.FileMetadataReader
+	if recordFileMetadata {
+		fileMetadataReader = uploaders.ReadFileMetadata
+	}
 
 	return &WebUploadClient{
 		storeURL,
@@ -50,26 +75,30 @@ func NewWebUploadClient(
 		excludeMatcher,
 		gofs.NewOsFs(),
 		backupDryRun,
+		discardInterrupted,
+		transactionOptions,
+		fileMetadataReader,
 		maxConcurrency,
 	}
 }
 
 // UploadToStore backs up a directory on the local machine to the bucket in the store in the WebUploadClient
 func (c *WebUploadClient) UploadToStore() errorsx.Error {
-	fileInfosMap, err := uploaders.BuildFileInfosMap(c.fs, c.folderPath, c.includeMatcher, c.excludeMatcher, c.maxConcurrency)
+	fileInfosMap, err := uploaders.BuildFileInfosMap(c.fs, c.folderPath, c.includeMatcher, c.excludeMatcher, c.fileMetadataReader, c.maxConcurrency)
 	if nil != err {
 		return err
 	}
 
-	revisionVersion, requiredRelativePaths, err := c.openTx(fileInfosMap.ToSlice())
+	tx, err := c.openTx(fileInfosMap.ToSlice())
 	if nil != err {
 		return err
 	}
+	revisionVersion := tx.revisionVersion
 
 	var requiredRegularFileRelativePaths []intelligentstore.RelativePath
 	var requiredSymlinkRelativePaths []intelligentstore.RelativePath
 
-	for _, requiredRelativePath := range requiredRelativePaths {
+	for _, requiredRelativePath := range tx.requiredRelativePaths {
 		fileInfo := fileInfosMap[requiredRelativePath]
 		switch fileInfo.Type {
 		case intelligentstore.FileTypeRegular:
@@ -86,7 +115,7 @@ func (c *WebUploadClient) UploadToStore() errorsx.Error {
 		return err
 	}
 
-	hashRelativePathMap, err := uploaders.BuildRelativePathsWithHashes(c.fs, c.folderPath, requiredRegularFileRelativePaths)
+	hashRelativePathMap, err := uploaders.BuildRelativePathsWithHashes(c.fs, c.folderPath, requiredRegularFileRelativePaths, fileInfosMap, tx.hashAlgorithm, tx.knownHashes)
 	if nil != err {
 		return err
 	}
@@ -97,12 +126,13 @@ func (c *WebUploadClient) UploadToStore() errorsx.Error {
 	}
 
 	if c.backupDryRun {
-		return nil
+		// the server doesn't save the state of a dry run, so aborting it doesn't discard an interrupted upload it resumed
+		return c.abortTx(revisionVersion)
 	}
 
 	for _, requiredHash := range requiredHashes {
 		relativePath := hashRelativePathMap[requiredHash][0]
-		err = c.backupFile(revisionVersion, relativePath)
+		err = c.backupFile(revisionVersion, fileInfosMap[relativePath])
 		if nil != err {
 			return err
 		}
@@ -218,73 +248,174 @@ func (c *WebUploadClient) fetchRequiredHashes(revisionVersion intelligentstore.R
 	return hashes, nil
 }
 
-// openTx opens a transaction with the server and sends a list of files it wants to back up
-func (c *WebUploadClient) openTx(fileInfos []*intelligentstore.FileInfo) (intelligentstore.RevisionVersion, []intelligentstore.RelativePath, errorsx.Error) {
+// fileInfoToProto converts a file info to the protobuf message sent to the server when opening a transaction
+func fileInfoToProto(fileInfo *intelligentstore.FileInfo) *protofiles.FileInfoProto {
+	fileInfoProto := &protofiles.FileInfoProto{
+		RelativePath:  string(fileInfo.RelativePath),
+		ModTime:       fileInfo.ModTime.Unix(),
+		ModTimeNanos:  int32(fileInfo.ModTime.Nanosecond()),
+		Size:          fileInfo.Size,
+		FileType:      protofiles.FileType(fileInfo.Type),
+		Mode:          uint32(fileInfo.FileMode),
+		HardLinkGroup: fileInfo.HardLinkGroup,
+	}
+
+	if nil != fileInfo.Ownership {
+		fileInfoProto.Ownership = &protofiles.FileOwnershipProto{
+			Uid: fileInfo.Ownership.UID,
+			Gid: fileInfo.Ownership.GID,
+		}
+	}
+
+	if nil != fileInfo.DeviceNumber {
+		fileInfoProto.DeviceNumber = &protofiles.DeviceNumberProto{
+			Major: fileInfo.DeviceNumber.Major,
+			Minor: fileInfo.DeviceNumber.Minor,
+		}
+	}
+
+	for _, hole := range fileInfo.Holes {
+		fileInfoProto.Holes = append(fileInfoProto.Holes, &protofiles.FileRangeProto{
+			Offset: hole.Offset,
+			Length: hole.Length,
+		})
+	}
+
+	for name, value := range fileInfo.ExtendedAttributes {
+		fileInfoProto.ExtendedAttributes = append(fileInfoProto.ExtendedAttributes, &protofiles.ExtendedAttributeProto{
+			Name:  name,
+			Value: value,
+		})
+	}
+
+	return fileInfoProto
+}
+
+// openTx opens a transaction with the server and sends a list of files it wants to back up.
+// If an upload into the bucket was interrupted, the server resumes it (unless discardInterrupted is set).
+func (c *WebUploadClient) openTx(fileInfos []*intelligentstore.FileInfo) (*openedTx, errorsx.Error) {
 	openTxRequest := &protofiles.OpenTxRequest{
 		FileInfos: nil,
 	}
 
 	for _, fileInfo := range fileInfos {
-		openTxRequest.FileInfos = append(
-			openTxRequest.FileInfos,
-			&protofiles.FileInfoProto{
-				RelativePath: string(fileInfo.RelativePath),
-				ModTime:      fileInfo.ModTime.Unix(),
-				Size:         fileInfo.Size,
-				FileType:     protofiles.FileType(fileInfo.Type),
-			},
-		)
+		openTxRequest.FileInfos = append(openTxRequest.FileInfos, fileInfoToProto(fileInfo))
 	}
 
 	openTxRequestBodyBytes, err := proto.Marshal(openTxRequest)
 	if nil != err {
-		return 0, nil, errorsx.Wrap(err, "detail", "couldn't unmarshall the open transaction request response")
+		return nil, errorsx.Wrap(err, "detail", "couldn't unmarshall the open transaction request response")
 	}
 
 	openTxClient := http.Client{Timeout: time.Second * 20}
 
+	query := make(url.Values)
+	if c.discardInterrupted {
+		query.Set("resume", "false")
+	}
+	if c.transactionOptions.Checksum {
+		query.Set("checksum", "true")
+	}
+	if c.backupDryRun {
+		query.Set("dryRun", "true")
+	}
+	if c.transactionOptions.RehashOlderThan > 0 {
+		query.Set("rehashOlderThan", c.transactionOptions.RehashOlderThan.String())
+	}
+
+	// the server records the revision as uploaded through the web server, so the uploader type isn't sent
+	source := uploaders.NewRevisionSource(c.transactionOptions.Source, intelligentstore.UploaderTypeWeb, c.folderPath)
+	if source.Message != "" {
+		query.Set("message", source.Message)
+	}
+	for _, tag := range source.Tags {
+		query.Add("tag", tag)
+	}
+	if source.Hostname != "" {
+		query.Set("hostname", source.Hostname)
+	}
+	query.Set("sourcePath", source.SourcePath)
+
 	openTxURL := c.storeURL + "/api/buckets/" + c.bucketName + "/upload"
+	if len(query) != 0 {
+		openTxURL += "?" + query.Encode()
+	}
 	resp, err := openTxClient.Post(
 		openTxURL,
 		"application/octet-stream",
 		bytes.NewBuffer(openTxRequestBodyBytes))
 	if nil != err {
-		return 0, nil, errorsx.Wrap(err, "openTxURL", openTxURL)
+		return nil, errorsx.Wrap(err, "openTxURL", openTxURL)
 	}
 	defer resp.Body.Close()
 
 	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
 	if err != nil {
-		return 0, nil, errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+		return nil, errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
 	}
 
 	// read the response body now; we will need it whether the response was good or bad.
 	respBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return 0, nil, errorsx.Wrap(err)
+		return nil, errorsx.Wrap(err)
 	}
 
 	var openTxResponse protofiles.OpenTxResponse
 	err = proto.Unmarshal(respBytes, &openTxResponse)
 	if nil != err {
-		return 0, nil, errorsx.Wrap(err)
+		return nil, errorsx.Wrap(err)
 	}
 
 	var requiredRelativePaths []intelligentstore.RelativePath
 	for _, wantedHash := range openTxResponse.GetRequiredRelativePaths() {
 		requiredRelativePaths = append(requiredRelativePaths, intelligentstore.NewRelativePath(wantedHash))
 	}
-	log.Printf("created a new version: %d\n", openTxResponse.GetRevisionID())
-	return intelligentstore.RevisionVersion(openTxResponse.GetRevisionID()), requiredRelativePaths, nil
+
+	hashAlgorithm := intelligentstore.HashAlgorithm(openTxResponse.GetHashAlgorithm())
+	if hashAlgorithm == "" {
+		// servers from before hash algorithms were configurable only support SHA-512
+		hashAlgorithm = intelligentstore.HashAlgorithmSHA512
+	}
+
+	err = hashAlgorithm.Validate()
+	if nil != err {
+		return nil, errorsx.Wrap(err)
+	}
+
+	knownHashes := make(map[intelligentstore.RelativePath]intelligentstore.Hash)
+	for _, knownHash := range openTxResponse.GetKnownHashes() {
+		knownHashes[intelligentstore.NewRelativePath(knownHash.GetRelativePath())] = intelligentstore.Hash(knownHash.GetHash())
+	}
+
+	if len(knownHashes) != 0 {
+		log.Printf("resuming version: %d (%d hashes already known)\n", openTxResponse.GetRevisionID(), len(knownHashes))
+	} else {
+		log.Printf("created a new version: %d\n", openTxResponse.GetRevisionID())
+	}
+
+	return &openedTx{
+		intelligentstore.RevisionVersion(openTxResponse.GetRevisionID()),
+		requiredRelativePaths,
+		hashAlgorithm,
+		knownHashes,
+	}, nil
 }
 
-func (c *WebUploadClient) backupFile(revisionStr intelligentstore.RevisionVersion, relativePath intelligentstore.RelativePath) errorsx.Error {
+// backupFile uploads the contents of a file. Only the data ranges of sparse files are read from disk.
+func (c *WebUploadClient) backupFile(revisionStr intelligentstore.RevisionVersion, fileInfo *intelligentstore.FileInfo) errorsx.Error {
+	relativePath := fileInfo.RelativePath
 	log.Printf("BACKING UP %s\n", relativePath)
 
 	client := http.Client{Timeout: time.Hour}
-	fileContents, err := c.fs.ReadFile(filepath.Join(
+	file, openErr := uploaders.OpenSparseFile(c.fs, filepath.Join(
 		c.folderPath,
-		string(relativePath)))
+		string(relativePath)), fileInfo)
+	if nil != openErr {
+		return errorsx.Wrap(openErr, "relativePath", relativePath)
+	}
+	defer file.Close()
+
+	fileContents, err := ioutil.ReadAll(file)
 	if nil != err {
 		return errorsx.Wrap(err, "relativePath", relativePath)
 	}
@@ -317,6 +448,28 @@ func (c *WebUploadClient) backupFile(revisionStr intelligentstore.RevisionVersio
 	return nil
 }
 
+func (c *WebUploadClient) abortTx(revisionStr intelligentstore.RevisionVersion) errorsx.Error {
+	abortTxClient := http.Client{Timeout: time.Second * 20}
+	url := fmt.Sprintf("%s/api/buckets/%s/upload/%d", c.storeURL, c.bucketName, revisionStr)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if nil != err {
+		return errorsx.Wrap(err)
+	}
+
+	resp, err := abortTxClient.Do(req)
+	if nil != err {
+		return errorsx.Wrap(err, "couldn't abort upload transaction")
+	}
+	defer resp.Body.Close()
+
+	err = httpextra.CheckResponseCode(http.StatusOK, resp.StatusCode)
+	if err != nil {
+		return errorsx.Wrap(err, "body", httpextra.GetBodyOrErrorMsg(resp))
+	}
+
+	return nil
+}
+
 func (c *WebUploadClient) commitTx(revisionStr intelligentstore.RevisionVersion) errorsx.Error {
 	commitTxClient := http.Client{Timeout: time.Second * 20}
 	url := fmt.Sprintf("%s/api/buckets/%s/upload/%d/commit", c.storeURL, c.bucketName, revisionStr)
@@ -2,12 +2,17 @@ package webuploadclient
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jamesrr39/goutil/errorsx"
 	"github.com/jamesrr39/goutil/gofs"
 	"github.com/jamesrr39/goutil/gofs/mockfs"
 	"github.com/jamesrr39/goutil/logpkg"
@@ -70,6 +75,11 @@ func Test_UploadToStore(t *testing.T) {
 
 	log.Printf("store URL: %s\n", storeServer.URL)
 
+	// the owner, group and extended attributes are sent to the server
+	fileMetadataReader := func(path string, osFileInfo os.FileInfo) (*intelligentstore.FileOwnership, intelligentstore.ExtendedAttributes, errorsx.Error) {
+		return intelligentstore.NewFileOwnership(1000, 100), intelligentstore.ExtendedAttributes{"user.path": []byte(path)}, nil
+	}
+
 	// create client and upload
 	uploadClient := &WebUploadClient{
 		storeServer.URL,
@@ -79,6 +89,14 @@ func Test_UploadToStore(t *testing.T) {
 		excludeMatcher,
 		fs,
 		false,
+		false,
+		dal.TransactionOptions{
+			Source: intelligentstore.RevisionSource{
+				Message: "first backup",
+				Tags:    []string{"daily", "docs"},
+			},
+		},
+		fileMetadataReader,
 		1,
 	}
 
@@ -94,13 +112,16 @@ func Test_UploadToStore(t *testing.T) {
 
 	fileDescriptors, err := remoteStore.Store.RevisionDAL.GetFilesInRevision(bucket, revision)
 	require.Nil(t, err)
-	require.Len(t, fileDescriptors, 4)
+	// the 4 files and the folder they are in
+	require.Len(t, fileDescriptors, 5)
 
 	fileDescriptorNameMap := make(map[intelligentstore.RelativePath]intelligentstore.FileDescriptor)
 	for _, fileDescriptor := range fileDescriptors {
 		fileDescriptorNameMap[fileDescriptor.GetFileInfo().RelativePath] = fileDescriptor
 	}
 
+	assert.Equal(t, intelligentstore.FileTypeDir, fileDescriptorNameMap["folder1"].GetFileInfo().Type)
+
 	for _, testFile := range testFiles {
 		hash, err := intelligentstore.NewHash(
 			bytes.NewBuffer([]byte(testFile.contents)))
@@ -109,7 +130,130 @@ func Test_UploadToStore(t *testing.T) {
 		assert.Equal(t, testFile.path, fileDescriptorNameMap[testFile.path].GetFileInfo().RelativePath)
 		fileDescriptor := (fileDescriptorNameMap[testFile.path]).(*intelligentstore.RegularFileDescriptor)
 		assert.Equal(t, hash, fileDescriptor.Hash)
+
+		osFileInfo, err := fs.Stat("/docs/" + string(testFile.path))
+		require.Nil(t, err)
+		assert.True(t, osFileInfo.ModTime().Equal(fileDescriptor.ModTime))
+		assert.Equal(t, intelligentstore.NewFileOwnership(1000, 100), fileDescriptor.Ownership)
+		assert.Equal(t, intelligentstore.ExtendedAttributes{"user.path": []byte("/docs/" + string(testFile.path))}, fileDescriptor.ExtendedAttributes)
+	}
+
+	// the message and tags are sent with the hostname and folder, and the server records the upload as a web upload
+	revisionInfo, err := remoteStore.Store.RevisionDAL.GetRevisionInfo(revision)
+	require.Nil(t, err)
+
+	hostname, hostnameErr := os.Hostname()
+	require.NoError(t, hostnameErr)
+
+	assert.Equal(t, intelligentstore.RevisionSource{
+		Message:      "first backup",
+		Tags:         []string{"daily", "docs"},
+		Hostname:     hostname,
+		SourcePath:   "/docs",
+		UploaderType: intelligentstore.UploaderTypeWeb,
+	}, revisionInfo.RevisionSource)
+	assert.Equal(t, int64(5), revisionInfo.NewFiles)
+}
+
+func Test_UploadToStore_resumesInterruptedUpload(t *testing.T) {
+	logger := logpkg.NewLogger(os.Stderr, logpkg.LogLevelInfo)
+
+	fs := mockfs.NewMockFs()
+	fs.LstatFunc = func(path string) (os.FileInfo, error) {
+		return fs.StatFunc(path)
+	}
+
+	testFiles := []*testfile{
+		{"a.txt", "file a"},
+		{"b.txt", "file b"},
+	}
+
+	for _, testFile := range testFiles {
+		err := fs.WriteFile("/docs/"+string(testFile.path), []byte(testFile.contents), 0600)
+		require.Nil(t, err)
+	}
+
+	// files are opened to hash them, and again to upload them. Uploads are interrupted by failing to open any more files once both files have been hashed
+	var openedFiles int
+	var interruptUploads bool
+	openFunc := fs.OpenFunc
+	fs.OpenFunc = func(path string) (gofs.File, error) {
+		if strings.HasPrefix(path, "/docs/") {
+			if interruptUploads && openedFiles == len(testFiles) {
+				return nil, errors.New("interrupted")
+			}
+			openedFiles++
+		}
+		return openFunc(path)
+	}
+
+	remoteStore := dal.NewMockStore(t, mockTimeProvider, mockfs.NewMockFs())
+	bucket := remoteStore.CreateBucket(t, "docs")
+
+	webServer, err := storewebserver.NewStoreWebServer(logger, remoteStore.Store)
+	require.NoError(t, err)
+
+	storeServer := httptest.NewServer(webServer)
+	defer storeServer.Close()
+
+	uploadClient := &WebUploadClient{
+		storeServer.URL,
+		"docs",
+		"/docs",
+		nil,
+		&patternmatcher.PatternMatcher{},
+		fs,
+		false,
+		false,
+		dal.TransactionOptions{},
+		nil,
+		1,
 	}
+
+	// a dry run leaves nothing open or to be resumed on the server
+	uploadClient.backupDryRun = true
+	err = uploadClient.UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 2, openedFiles)
+
+	resp, httpErr := http.Get(storeServer.URL + "/api/transactions")
+	require.NoError(t, httpErr)
+	defer resp.Body.Close()
+	openTransactions, readErr := io.ReadAll(resp.Body)
+	require.NoError(t, readErr)
+	assert.Equal(t, "[]\n", string(openTransactions))
+
+	// the upload is interrupted after the hashes were sent (hashing the files again, as the dry run left nothing to resume), and the transaction is left open on the server
+	openedFiles = 0
+	interruptUploads = true
+	uploadClient.backupDryRun = false
+	err = uploadClient.UploadToStore()
+	require.Error(t, err)
+	assert.Equal(t, 2, openedFiles)
+
+	// a dry run takes over the open transaction, and leaves it to be resumed
+	openedFiles = 0
+	uploadClient.backupDryRun = true
+	err = uploadClient.UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 0, openedFiles)
+
+	// the next upload resumes it, and only opens the files to upload them, without hashing them again
+	openedFiles = 0
+	interruptUploads = false
+	uploadClient.backupDryRun = false
+
+	err = uploadClient.UploadToStore()
+	require.Nil(t, err)
+	assert.Equal(t, 2, openedFiles)
+
+	revisions, err := remoteStore.Store.RevisionDAL.GetRevisions(bucket)
+	require.Nil(t, err)
+	require.Len(t, revisions, 1)
+
+	fileDescriptors, err := remoteStore.Store.RevisionDAL.GetFilesInRevision(bucket, revisions[0])
+	require.Nil(t, err)
+	assert.Len(t, fileDescriptors, 2)
 }
 
 func Test_NewWebUploadClient(t *testing.T) {
@@ -123,6 +267,9 @@ func Test_NewWebUploadClient(t *testing.T) {
 		nil,
 		excludesMatcher,
 		false,
+		false,
+		dal.TransactionOptions{},
+		false,
 		1,
 	)
 